@@ -14,8 +14,15 @@ import type {
   GatewayHealth,
   SessionStarted,
   SessionEnded,
+  SessionAgentExited,
+  SessionExpiring,
+  SessionInputAck,
   SessionError,
   SessionSnapshotEvent,
+  SessionHistoryEvent,
+  SessionSearchEvent,
+  SessionListEvent,
+  SessionRecordingListEvent,
   SSHKeyList,
   AgentsStatus,
   WorkspaceFolders,
@@ -25,7 +32,7 @@ import type {
   AgentInstalled,
   GatewayUpdated,
 } from "@chatcode/protocol";
-import { decodeTerminalFrame } from "@chatcode/protocol";
+import { decodeTerminalFrame, FRAME_KIND_TERMINAL_INPUT } from "@chatcode/protocol";
 import {
   updateGatewayConnected,
   updateGatewayVersion,
@@ -40,7 +47,7 @@ const COMMAND_TIMEOUT_MS = 10_000;
 const IDLE_TIMEOUT_MS = 600_000; // 10 minutes
 const GRACE_PERIOD_MS = 30_000; // 30s reconnect grace
 const SESSION_RECONCILE_END_GRACE_MS = 90_000; // avoid ending sessions right after reconnect
-const SESSION_CONTROL_LEASE_MS = 30_000; // lock input/resize source for 30s since last write
+const SESSION_CONTROL_LEASE_MS = 30_000; // lock input source for 30s since last write
 const TRAFFIC_BUCKET_MS = 10_000;
 const TRAFFIC_BUCKET_COUNT = 6;
 const TRAFFIC_SHORT_WINDOW_MS = 10_000;
@@ -72,6 +79,7 @@ interface SessionController {
 interface SocketAttachment {
   role: "gateway" | "browser";
   sessionId?: string;
+  clientId?: string;
   expectedGatewayId?: string;
   gatewayId?: string;
   vpsId?: string;
@@ -119,8 +127,15 @@ type GatewayEvent =
   | Ack
   | SessionStarted
   | SessionEnded
+  | SessionAgentExited
+  | SessionExpiring
+  | SessionInputAck
   | SessionError
   | SessionSnapshotEvent
+  | SessionHistoryEvent
+  | SessionSearchEvent
+  | SessionListEvent
+  | SessionRecordingListEvent
   | SSHKeyList
   | AgentsStatus
   | WorkspaceFolders
@@ -134,6 +149,10 @@ type GatewayEvent =
 type GatewayCommandResult =
   | Ack
   | SessionSnapshotEvent
+  | SessionHistoryEvent
+  | SessionSearchEvent
+  | SessionListEvent
+  | SessionRecordingListEvent
   | SSHKeyList
   | AgentsStatus
   | WorkspaceFolders
@@ -141,6 +160,10 @@ type GatewayCommandResult =
   | GatewayUpdated
   | GatewayUpdateFailed;
 type GatewayCommandEvent =
+  | SessionHistoryEvent
+  | SessionSearchEvent
+  | SessionListEvent
+  | SessionRecordingListEvent
   | SSHKeyList
   | AgentsStatus
   | WorkspaceFolders
@@ -261,6 +284,11 @@ export class GatewayHub {
   // Session ID per browser WS (for cleanup)
   private browserSessionMap = new Map<WebSocket, string>();
 
+  // Client ID and last reported viewport per browser WS; the gateway sizes
+  // session windows from the viewports of all clients.
+  private browserClientIds = new Map<WebSocket, string>();
+  private browserViewports = new Map<WebSocket, { cols: number; rows: number }>();
+
   // One active input source per session; other sockets are read-only for writes.
   private sessionControllers = new Map<string, SessionController>();
   private controlledSessionBySocket = new Map<WebSocket, string>();
@@ -347,11 +375,14 @@ export class GatewayHub {
     }
 
     if (meta.role === "browser") {
+      const sessionId = meta.sessionId ?? this.browserSessionMap.get(ws);
       if (typeof message !== "string") {
+        if (sessionId) {
+          this.onBrowserBinary(ws, sessionId, new Uint8Array(message));
+        }
         return;
       }
       this.browserTextMessages.record(this.byteLength(message), now);
-      const sessionId = meta.sessionId ?? this.browserSessionMap.get(ws);
       if (!sessionId) {
         return;
       }
@@ -477,6 +508,15 @@ export class GatewayHub {
         await this.onSessionEnded(msg as SessionEnded);
         break;
 
+      case "session.agent_exited":
+      case "session.expiring":
+      case "session.input_ack":
+      case "session.resized":
+        // Notifications only; nothing to track, subscribers just need to be
+        // told.
+        this.fanOutText(msg.session_id, JSON.stringify(msg));
+        break;
+
       case "session.error":
         await this.onSessionError(msg as SessionError);
         break;
@@ -485,6 +525,10 @@ export class GatewayHub {
         this.onSessionSnapshot(msg as SessionSnapshotEvent);
         break;
 
+      case "session.history":
+      case "session.search":
+      case "session.list":
+      case "session.recording.list":
       case "ssh.keys":
       case "agents.status":
       case "workspace.folders":
@@ -527,6 +571,19 @@ export class GatewayHub {
     this.gatewayId = gatewayId;
     this.sessionEndReconcileNotBeforeMs = Date.now() + SESSION_RECONCILE_END_GRACE_MS;
 
+    // The gateway drops output subscriptions and client viewports on each
+    // connect; renew them per browser socket so watched sessions resume
+    // streaming at the right size.
+    for (const [sessionId, subs] of this.subscribers) {
+      for (const sub of subs) {
+        this.sendSubscription("session.subscribe", sessionId);
+        const viewport = this.browserViewports.get(sub);
+        if (viewport) {
+          this.sendViewport(sub, sessionId, viewport.cols, viewport.rows);
+        }
+      }
+    }
+
     // Update D1
     await updateGatewayVersion(this.env.DB, gatewayId, msg.version);
     await updateGatewaySystemInfo(this.env.DB, gatewayId, {
@@ -698,11 +755,17 @@ export class GatewayHub {
           safeSend(entry.sourceSocket, JSON.stringify(msg));
         }
         entry.resolve(msg);
+      } else if (msg.format === "grid") {
+        const browserAck = this.browserAckWaiters.get(msg.request_id);
+        if (browserAck) {
+          safeSend(browserAck.ws, JSON.stringify(msg));
+        }
       }
     }
 
-    // Also fan out to subscribers
-    if (msg.session_id) {
+    // Also fan out to subscribers. Grid snapshots only go to their requester:
+    // viewers' terminals would replay the plain-text content as a new screen.
+    if (msg.session_id && msg.format !== "grid") {
       this.fanOutText(msg.session_id, JSON.stringify(msg));
     }
   }
@@ -711,7 +774,16 @@ export class GatewayHub {
     const requestId = msg.request_id;
 
     const entry = this.pending.get(requestId);
-    if (!entry) return;
+    if (!entry) {
+      // Realtime requests from browsers (session.history, session.search)
+      // get their result directly; the waiter is cleared by the ack that
+      // follows.
+      const browserAck = this.browserAckWaiters.get(requestId);
+      if (browserAck) {
+        safeSend(browserAck.ws, JSON.stringify(msg));
+      }
+      return;
+    }
 
     this.pending.delete(requestId);
 
@@ -785,12 +857,15 @@ export class GatewayHub {
     const pair = new WebSocketPair();
     const [client, server] = [pair[0], pair[1]];
     this.state.acceptWebSocket(server, ["browser", `session:${sessionId}`]);
+    const clientId = crypto.randomUUID();
+    this.browserClientIds.set(server, clientId);
     try {
-      server.serializeAttachment({ role: "browser", sessionId } satisfies SocketAttachment);
+      server.serializeAttachment({ role: "browser", sessionId, clientId } satisfies SocketAttachment);
     } catch {
       // ignore
     }
     this.upsertBrowserSocket(server, sessionId, Date.now());
+    this.sendSubscription("session.subscribe", sessionId);
     this.cleanupIdleSockets();
 
     if (!this.gatewaySocket) {
@@ -837,8 +912,22 @@ export class GatewayHub {
     // Realtime relay (fire-and-forget)
     switch (msg.type) {
       case "session.input":
-      case "session.resize":
-        if (msg.type === "session.resize" && this.isStagingDebug()) {
+      case "session.paste":
+      case "session.keys":
+        if (!this.tryAcquireSessionControl(sessionId, ws)) {
+          this.sendReadOnlyError(ws, msg);
+          return;
+        }
+        if (typeof msg.request_id === "string" && msg.request_id.length > 0) {
+          this.trackBrowserAck(msg.request_id, ws);
+        }
+        this.sendRealtime(data);
+        break;
+
+      case "session.resize": {
+        // Every socket reports its viewport, read-only ones included; the
+        // gateway's resize policy picks the window size.
+        if (this.isStagingDebug()) {
           console.info("GatewayHub browser session.resize", {
             gatewayId: this.gatewayId,
             vpsId: this.vpsId,
@@ -848,24 +937,21 @@ export class GatewayHub {
             rows: typeof msg.rows === "number" ? msg.rows : null,
           });
         }
-        if (!this.tryAcquireSessionControl(sessionId, ws)) {
-          if (msg.type === "session.resize" && this.isStagingDebug()) {
-            console.warn("GatewayHub rejected session.resize (read-only)", {
-              gatewayId: this.gatewayId,
-              vpsId: this.vpsId,
-              sessionId,
-            });
-          }
-          this.sendReadOnlyError(ws, msg);
+        if (typeof msg.cols !== "number" || typeof msg.rows !== "number") {
+          this.sendProtocolError(ws, "invalid_payload", "session.resize requires cols and rows");
           return;
         }
         if (typeof msg.request_id === "string" && msg.request_id.length > 0) {
           this.trackBrowserAck(msg.request_id, ws);
         }
-        this.sendRealtime(data);
+        this.browserViewports.set(ws, { cols: msg.cols, rows: msg.rows });
+        this.sendRealtime(JSON.stringify({ ...msg, client_id: this.browserClientId(ws) }));
         break;
+      }
 
       case "session.snapshot":
+      case "session.history":
+      case "session.search":
         if (typeof msg.request_id === "string" && msg.request_id.length > 0) {
           this.trackBrowserAck(msg.request_id, ws);
         }
@@ -889,6 +975,36 @@ export class GatewayHub {
     this.maybeWarnSessionTraffic(sessionId, now);
   }
 
+  /**
+   * Relays binary terminal input frames to the gateway. Only input frames for
+   * the socket's own session are accepted; the gateway acks them with
+   * session.input_ack, which is fanned out to the session's subscribers.
+   */
+  private onBrowserBinary(ws: WebSocket, sessionId: string, data: Uint8Array): void {
+    if (data.byteLength > MAX_TEXT_PAYLOAD) {
+      this.sendProtocolError(ws, "payload_too_large", "payload exceeds maximum size");
+      return;
+    }
+    const frame = decodeTerminalFrame(data);
+    if (!frame || frame.kind !== FRAME_KIND_TERMINAL_INPUT || frame.sessionId !== sessionId) {
+      this.sendProtocolError(ws, "invalid_payload", "unexpected binary frame");
+      return;
+    }
+    if (!this.tryAcquireSessionControl(sessionId, ws)) {
+      this.sendProtocolError(ws, "read_only", "session is read-only (active input in another connection)");
+      return;
+    }
+
+    const now = Date.now();
+    this.lastActivity.set(ws, now);
+    const traffic = this.getSessionTraffic(sessionId, now);
+    traffic.browserMessages.record(data.byteLength, now);
+    if (this.gatewaySocket) {
+      safeSendBinary(this.gatewaySocket, data);
+    }
+    this.maybeWarnSessionTraffic(sessionId, now);
+  }
+
   private upsertBrowserSocket(ws: WebSocket, sessionId: string, lastSeenMs: number): void {
     if (!this.subscribers.has(sessionId)) {
       this.subscribers.set(sessionId, new Set());
@@ -900,14 +1016,16 @@ export class GatewayHub {
 
   private removeBrowserSocket(ws: WebSocket, sessionId: string): void {
     const subs = this.subscribers.get(sessionId);
-    if (subs) {
-      subs.delete(ws);
-      if (subs.size === 0) {
-        this.subscribers.delete(sessionId);
-      }
+    if (subs?.delete(ws)) {
+      this.sendSubscription("session.unsubscribe", sessionId, this.browserClientId(ws));
+    }
+    if (subs?.size === 0) {
+      this.subscribers.delete(sessionId);
     }
     this.lastActivity.delete(ws);
     this.browserSessionMap.delete(ws);
+    this.browserClientIds.delete(ws);
+    this.browserViewports.delete(ws);
     this.releaseSessionControl(ws, sessionId);
 
     const requestIds = this.browserAckBySocket.get(ws);
@@ -925,13 +1043,62 @@ export class GatewayHub {
 
   /**
    * Fire-and-forget relay to gateway (no ack tracking).
-   * Used for session.input, session.resize, session.ack.
+   * Used for session.input, session.paste, session.keys, session.resize, session.ack,
+   * session.subscribe and session.unsubscribe.
    */
   private sendRealtime(data: string): void {
     if (!this.gatewaySocket) return;
     safeSend(this.gatewaySocket, data);
   }
 
+  /**
+   * Tells the gateway a browser socket started or stopped watching a session;
+   * it streams output frames only for sessions with subscribers.
+   */
+  private sendSubscription(
+    type: "session.subscribe" | "session.unsubscribe",
+    sessionId: string,
+    clientId?: string,
+  ): void {
+    this.sendRealtime(
+      JSON.stringify({
+        type,
+        schema_version: "1",
+        request_id: `${type.slice("session.".length)}-${Date.now()}-${crypto.randomUUID().slice(0, 8)}`,
+        session_id: sessionId,
+        client_id: clientId,
+      }),
+    );
+  }
+
+  /** Re-reports a browser socket's last viewport after a gateway reconnect. */
+  private sendViewport(ws: WebSocket, sessionId: string, cols: number, rows: number): void {
+    this.sendRealtime(
+      JSON.stringify({
+        type: "session.resize",
+        schema_version: "1",
+        request_id: `resize-${Date.now()}-${crypto.randomUUID().slice(0, 8)}`,
+        session_id: sessionId,
+        client_id: this.browserClientId(ws),
+        cols,
+        rows,
+      }),
+    );
+  }
+
+  /**
+   * Returns the ID the gateway knows a browser socket by. Sockets restored
+   * after hibernation keep the ID from their attachment.
+   */
+  private browserClientId(ws: WebSocket): string {
+    let clientId = this.browserClientIds.get(ws);
+    if (!clientId) {
+      clientId = this.readAttachment(ws)?.clientId ?? crypto.randomUUID();
+      this.browserClientIds.set(ws, clientId);
+    }
+    return clientId;
+  }
+
   /**
    * Ack-tracked command relay to gateway.
    * Returns promise that resolves on ack or rejects on timeout/disconnect.
@@ -1250,12 +1417,14 @@ export class GatewayHub {
         return null;
       }
       const sessionId = (raw as { sessionId?: unknown }).sessionId;
+      const clientId = (raw as { clientId?: unknown }).clientId;
       const expectedGatewayId = (raw as { expectedGatewayId?: unknown }).expectedGatewayId;
       const gatewayId = (raw as { gatewayId?: unknown }).gatewayId;
       const vpsId = (raw as { vpsId?: unknown }).vpsId;
       return {
         role,
         sessionId: typeof sessionId === "string" ? sessionId : undefined,
+        clientId: typeof clientId === "string" ? clientId : undefined,
         expectedGatewayId: typeof expectedGatewayId === "string" ? expectedGatewayId : undefined,
         gatewayId: typeof gatewayId === "string" ? gatewayId : undefined,
         vpsId: typeof vpsId === "string" ? vpsId : undefined,
@@ -375,9 +375,10 @@ export async function handleSessionUpdate(
 
 /**
  * GET /vps/:id/sessions/:sid/snapshot – Request and return terminal snapshot.
+ * `?format=grid` returns the visible pane as rows of cells instead of ANSI text.
  */
 export async function handleSessionSnapshot(
-  _request: Request,
+  request: Request,
   env: Env,
   auth: AuthContext,
   vpsId: string,
@@ -405,11 +406,17 @@ export async function handleSessionSnapshot(
   const doId = env.GATEWAY_HUB.idFromName(gateway.id);
   const stub = env.GATEWAY_HUB.get(doId);
 
+  const format = new URL(request.url).searchParams.get("format");
+  if (format !== null && format !== "ansi" && format !== "grid") {
+    return jsonResponse({ error: "format must be ansi or grid" }, 400);
+  }
+
   const cmd = {
     type: "session.snapshot",
     schema_version: "1",
     request_id: newRequestId(`snap-${sessionId}`),
     session_id: sessionId,
+    ...(format ? { format } : {}),
   };
 
   const cmdResp = await stub.fetch(
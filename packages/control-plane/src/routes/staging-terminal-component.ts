@@ -474,7 +474,7 @@ export const STAGING_TERMINAL_COMPONENT_SCRIPT = `
             return;
           }
 
-          if (msg.type === "session.snapshot" && msg.session_id === activeSessionId && typeof msg.content === "string") {
+          if (msg.type === "session.snapshot" && msg.session_id === activeSessionId && msg.format !== "grid" && typeof msg.content === "string") {
             const rowsHint = Number.isFinite(msg.rows) && msg.rows > 0 ? msg.rows : 80;
             const normalizedContent = String(msg.content).replace(/\\r\\n/g, "\\n").replace(/\\r/g, "\\n");
             const contentLines = normalizedContent.split("\\n");
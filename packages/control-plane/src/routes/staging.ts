@@ -286,6 +286,8 @@ ${STAGING_TERMINAL_SECTION}
         <option value="session.snapshot">session.snapshot</option>
         <option value="session.resize">session.resize</option>
         <option value="session.input">session.input</option>
+        <option value="session.paste">session.paste</option>
+        <option value="session.keys">session.keys</option>
         <option value="session.end">session.end</option>
         <option value="ssh.authorize">ssh.authorize</option>
         <option value="ssh.revoke">ssh.revoke</option>
@@ -295,7 +297,7 @@ ${STAGING_TERMINAL_SECTION}
         <option value="gateway.update">gateway.update</option>
       </select>
       <input id="schema-session-id" placeholder="session_id" style="min-width: 240px" />
-      <input id="schema-input-text" placeholder="input text for session.input" style="min-width: 260px" />
+      <input id="schema-input-text" placeholder="input text for session.input/paste, key names for session.keys" style="min-width: 260px" />
       <input id="schema-cols" type="number" min="1" value="120" placeholder="cols" style="width:90px" />
       <input id="schema-rows" type="number" min="1" value="32" placeholder="rows" style="width:90px" />
       <select id="schema-agent">
@@ -446,12 +448,17 @@ ${STAGING_TERMINAL_SECTION}
         cmd.rows = Number(schemaRows.value || 32);
       }
 
-      if (type === "session.input") {
+      if (type === "session.input" || type === "session.paste") {
         cmd.session_id = sessionId;
         const text = schemaInputText.value || "\\n";
         cmd.data = utf8ToBase64(text);
       }
 
+      if (type === "session.keys") {
+        cmd.session_id = sessionId;
+        cmd.keys = (schemaInputText.value || "Enter").trim().split(/\\s+/);
+      }
+
       if (type === "agents.install") {
         cmd.agent = schemaAgent.value || "claude-code";
       }
@@ -732,7 +739,7 @@ ${STAGING_TERMINAL_SECTION}
       const destination = buildSessionAttachDestination(vps, ip, label);
       if (!destination) return "";
       const tmuxName = "vibe-" + sessionId;
-      return "ssh " + destination + " -t 'env TMUX_TMPDIR=/tmp/chatcode tmux attach -t " + tmuxName + "'";
+      return "ssh " + destination + " -t 'env TMUX_TMPDIR=/tmp/chatcode tmux -L chatcode attach -t " + tmuxName + "'";
     }
 
     function buildSessionAttachDestination(vps, ip, label) {
@@ -1,6 +1,6 @@
 import { afterEach, describe, expect, it, vi } from "vitest";
 import { GatewayHub } from "../src/durables/GatewayHub";
-import { encodeTerminalFrame } from "@chatcode/protocol";
+import { encodeTerminalFrame, encodeTerminalInputFrame } from "@chatcode/protocol";
 
 const mocks = vi.hoisted(() => ({
   updateGatewayConnected: vi.fn(async () => {}),
@@ -77,7 +77,7 @@ function makeAttachedSocket(
 }
 
 describe("GatewayHub", () => {
-  it("allows one active writer per session and makes other sockets read-only for input/resize", () => {
+  it("allows one active writer per session and makes other sockets read-only for input", () => {
     const hub = makeHub();
     const gatewaySend = vi.fn();
     const ws1Send = vi.fn();
@@ -111,12 +111,11 @@ describe("GatewayHub", () => {
       ws2,
       "ses-1",
       JSON.stringify({
-        type: "session.resize",
+        type: "session.input",
         schema_version: "1",
         request_id: "req-2",
         session_id: "ses-1",
-        cols: 120,
-        rows: 30,
+        data: "bHMK",
       }),
     );
 
@@ -128,6 +127,55 @@ describe("GatewayHub", () => {
     expect(ws2Send.mock.calls[0][0]).toContain("read-only");
   });
 
+  it("relays viewports of every socket with a client id", () => {
+    const hub = makeHub();
+    const gatewaySend = vi.fn();
+    const writer = makeSocket();
+    const viewer = makeAttachedSocket({ role: "browser", sessionId: "ses-1", clientId: "client-viewer" });
+
+    (hub as unknown as { gatewaySocket: WebSocket | null }).gatewaySocket = makeSocket(gatewaySend);
+    const onBrowserText = (
+      hub as unknown as {
+        onBrowserText: (ws: WebSocket, sessionId: string, data: string) => void;
+      }
+    ).onBrowserText.bind(hub);
+
+    onBrowserText(
+      writer,
+      "ses-1",
+      JSON.stringify({
+        type: "session.input",
+        schema_version: "1",
+        request_id: "req-1",
+        session_id: "ses-1",
+        data: "Cg==",
+      }),
+    );
+    onBrowserText(
+      viewer,
+      "ses-1",
+      JSON.stringify({
+        type: "session.resize",
+        schema_version: "1",
+        request_id: "req-2",
+        session_id: "ses-1",
+        cols: 120,
+        rows: 30,
+      }),
+    );
+
+    expect(gatewaySend).toHaveBeenCalledTimes(2);
+    expect(JSON.parse(gatewaySend.mock.calls[1][0] as string)).toEqual({
+      type: "session.resize",
+      schema_version: "1",
+      request_id: "req-2",
+      session_id: "ses-1",
+      client_id: "client-viewer",
+      cols: 120,
+      rows: 30,
+    });
+  });
+
   it("allows writer takeover after input lease timeout", () => {
     vi.useFakeTimers();
     vi.setSystemTime(new Date("2026-03-04T00:00:00.000Z"));
@@ -400,6 +448,94 @@ describe("GatewayHub", () => {
     ).toBe(0);
   });
 
+  it("forwards session.history to the requesting browser before its ack", () => {
+    const hub = makeHub();
+    const gatewaySend = vi.fn();
+    const browserSend = vi.fn();
+    const browserWs = makeSocket(browserSend);
+
+    (hub as unknown as { gatewaySocket: WebSocket | null }).gatewaySocket = makeSocket(gatewaySend);
+
+    (
+      hub as unknown as {
+        onBrowserText: (ws: WebSocket, sessionId: string, data: string) => void;
+      }
+    ).onBrowserText(
+      browserWs,
+      "ses-1",
+      JSON.stringify({
+        type: "session.history",
+        schema_version: "1",
+        request_id: "req-history-1",
+        session_id: "ses-1",
+        end: -1,
+      }),
+    );
+    expect(gatewaySend).toHaveBeenCalledOnce();
+
+    (
+      hub as unknown as {
+        onCommandEvent: (msg: Record<string, unknown>) => void;
+      }
+    ).onCommandEvent({
+      type: "session.history",
+      schema_version: "1",
+      request_id: "req-history-1",
+      session_id: "ses-1",
+      start: -2,
+      end: -1,
+      history_size: 2,
+      content: "a\nb\n",
+    });
+
+    expect(browserSend).toHaveBeenCalledOnce();
+    expect(browserSend.mock.calls[0][0]).toContain("\"type\":\"session.history\"");
+    expect(
+      (hub as unknown as { browserAckWaiters: Map<string, unknown> }).browserAckWaiters.size,
+    ).toBe(1);
+  });
+
+  it("sends grid snapshots only to the requesting browser", () => {
+    const hub = makeHub();
+    const requesterSend = vi.fn();
+    const viewerSend = vi.fn();
+    const requester = makeSocket(requesterSend);
+    const viewer = makeSocket(viewerSend);
+
+    (hub as unknown as { subscribers: Map<string, Set<WebSocket>> }).subscribers.set(
+      "ses-1",
+      new Set([requester, viewer]),
+    );
+    (
+      hub as unknown as {
+        browserAckWaiters: Map<string, { ws: WebSocket; timeout: ReturnType<typeof setTimeout> }>;
+      }
+    ).browserAckWaiters.set("req-grid-1", {
+      ws: requester,
+      timeout: setTimeout(() => {}, 0),
+    });
+
+    (
+      hub as unknown as {
+        onSessionSnapshot: (msg: Record<string, unknown>) => void;
+      }
+    ).onSessionSnapshot({
+      type: "session.snapshot",
+      schema_version: "1",
+      request_id: "req-grid-1",
+      session_id: "ses-1",
+      format: "grid",
+      content: "$",
+      cols: 80,
+      rows: 24,
+      grid: [[{ ch: "$" }]],
+    });
+
+    expect(requesterSend).toHaveBeenCalledOnce();
+    expect(requesterSend.mock.calls[0][0]).toContain("\"format\":\"grid\"");
+    expect(viewerSend).not.toHaveBeenCalled();
+  });
+
   it("sends schema-versioned protocol error on invalid browser payload", () => {
     const hub = makeHub();
     const wsSend = vi.fn();
@@ -463,6 +599,80 @@ describe("GatewayHub", () => {
     ).toBe(0);
   });
 
+  it("relays binary input frames for the socket's session to the gateway", () => {
+    const hub = makeHub();
+    const gatewaySend = vi.fn();
+    const browserSend = vi.fn();
+    const browserWs = makeAttachedSocket({ role: "browser", sessionId: "ses-1" }, browserSend);
+
+    (hub as unknown as { gatewaySocket: WebSocket | null }).gatewaySocket = makeSocket(gatewaySend);
+
+    const input = encodeTerminalInputFrame("ses-1", 0n, new TextEncoder().encode("ls\r"));
+    hub.webSocketMessage(browserWs, input.buffer);
+    hub.webSocketMessage(browserWs, encodeTerminalInputFrame("ses-2", 1n, new Uint8Array([1])).buffer);
+    hub.webSocketMessage(browserWs, encodeTerminalFrame("ses-1", 1n, new Uint8Array([1])).buffer);
+
+    expect(gatewaySend).toHaveBeenCalledOnce();
+    expect(new Uint8Array(gatewaySend.mock.calls[0][0])).toEqual(input);
+    expect(browserSend).toHaveBeenCalledTimes(2);
+    expect(browserSend.mock.calls[0][0]).toContain("invalid_payload");
+  });
+
+  it("resubscribes browser sockets with their viewports on gateway.hello and unsubscribes them on removal", async () => {
+    const hub = makeHub();
+    const gatewaySend = vi.fn();
+    const browserA = makeSocket();
+    const browserB = makeSocket();
+    const internals = hub as unknown as {
+      gatewaySocket: WebSocket | null;
+      upsertBrowserSocket: (ws: WebSocket, sessionId: string, lastSeenMs: number) => void;
+      removeBrowserSocket: (ws: WebSocket, sessionId: string) => void;
+      onGatewayHello: (msg: Record<string, unknown>) => Promise<void>;
+      onBrowserText: (ws: WebSocket, sessionId: string, data: string) => void;
+    };
+
+    internals.gatewaySocket = makeSocket(gatewaySend);
+    internals.upsertBrowserSocket(browserA, "ses-1", Date.now());
+    internals.upsertBrowserSocket(browserB, "ses-1", Date.now());
+    internals.onBrowserText(
+      browserB,
+      "ses-1",
+      JSON.stringify({ type: "session.resize", schema_version: "1", session_id: "ses-1", cols: 90, rows: 30 }),
+    );
+    const clientB = JSON.parse(gatewaySend.mock.calls[0][0] as string).client_id;
+    gatewaySend.mockClear();
+
+    await internals.onGatewayHello({
+      type: "gateway.hello",
+      schema_version: "1",
+      gateway_id: "gw-1",
+      version: "0.1.0",
+      hostname: "test-host",
+      system_info: {
+        os: "linux",
+        arch: "amd64",
+        cpus: 4,
+        ram_total_bytes: 1024,
+        disk_total_bytes: 1024,
+      },
+    });
+
+    const sent = () => gatewaySend.mock.calls.map((call) => JSON.parse(call[0] as string));
+    expect(sent()).toEqual([
+      expect.objectContaining({ type: "session.subscribe", session_id: "ses-1" }),
+      expect.objectContaining({ type: "session.subscribe", session_id: "ses-1" }),
+      expect.objectContaining({ type: "session.resize", session_id: "ses-1", client_id: clientB, cols: 90, rows: 30 }),
+    ]);
+    expect(sent()[0].request_id).not.toBe(sent()[1].request_id);
+
+    gatewaySend.mockClear();
+    internals.removeBrowserSocket(browserB, "ses-1");
+    internals.removeBrowserSocket(browserB, "ses-1");
+    expect(sent()).toEqual([
+      expect.objectContaining({ type: "session.unsubscribe", session_id: "ses-1", client_id: clientB }),
+    ]);
+  });
+
   it("reports rolling GatewayHub traffic counters in status", async () => {
     vi.useFakeTimers();
     vi.setSystemTime(new Date("2026-03-12T10:00:00.000Z"));
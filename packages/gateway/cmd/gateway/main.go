@@ -7,6 +7,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -16,17 +17,22 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf8"
 
 	"github.com/tractorfm/chatcode/packages/gateway/internal/agents"
+	"github.com/tractorfm/chatcode/packages/gateway/internal/cgroup"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/config"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/files"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/health"
+	"github.com/tractorfm/chatcode/packages/gateway/internal/profile"
+	"github.com/tractorfm/chatcode/packages/gateway/internal/recording"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/session"
 	sshkeys "github.com/tractorfm/chatcode/packages/gateway/internal/ssh"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/update"
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/workspace"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/ws"
 )
@@ -39,8 +45,19 @@ var (
 
 const schemaVersion = "1"
 
+// Binary frame kinds, see packages/protocol/schema/frames.json.
+const (
+	frameKindTerminalOutput byte = 0x01
+	frameKindTerminalInput  byte = 0x02
+)
+
 const maxCommandFrameBytes = 1 << 20 // 1 MiB
 const maxSnapshotBytes = 900 * 1024  // bounded below 1 MiB payload ceiling
+const defaultHistoryLines = 1000
+const defaultSearchContext = 2
+const searchTimeout = 10 * time.Second
+const maxConcurrentSearches = 4
+const outputCatchUpInterval = time.Second // check streams for dropped last frames
 
 var requestIDRegexp = regexp.MustCompile(`"request_id"\s*:\s*"([^"]+)"`)
 
@@ -66,9 +83,62 @@ func main() {
 		sessions: session.NewManager(cfg.MaxSessions),
 		health:   health.NewCollector("/"),
 		updater:  update.NewUpdater(cfg.BinaryPath, log),
+		recordings: recording.NewStore(
+			filepath.Join(cfg.DataDir, "recordings"),
+			cfg.RecordingMaxFileBytes,
+			cfg.RecordingMaxTotalBytes,
+		),
+		profiles: profile.NewStore(filepath.Join(cfg.DataDir, "profiles.json")),
+
+		streamNext:  make(map[string]uint64),
+		subscribers: make(map[string]int),
+		groups:      make(map[string]*cgroup.Group),
+		searches:    make(chan struct{}, maxConcurrentSearches),
+	}
+	g.sessions.SetEnvPolicy(session.EnvPolicy{
+		Allowlist: cfg.SessionEnvAllowlist,
+		Defaults:  cfg.SessionEnv,
+	})
+	backend, err := session.ParseBackendKind(cfg.SessionBackend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		os.Exit(1)
+	}
+	g.sessions.SetBackend(backend)
+	// The gateway's tmux server reads a config of its own rather than the
+	// user's ~/.tmux.conf.
+	tmuxConfig := filepath.Join(cfg.DataDir, "tmux.conf")
+	if err := session.WriteTmuxConfig(tmuxConfig); err != nil {
+		log.Warn("tmux config not written", "err", err)
+		tmuxConfig = ""
+	}
+	g.sessions.SetTmuxServer(session.TmuxServer{Socket: cfg.TmuxSocket, ConfigFile: tmuxConfig})
+	captureMode, err := session.ParseCaptureMode(cfg.CaptureMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		os.Exit(1)
+	}
+	g.sessions.SetCaptureMode(captureMode)
+	resizePolicy, err := session.ParseResizePolicy(cfg.ResizePolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		os.Exit(1)
 	}
-	g.sessions.SetOnSessionExit(func(sessionID string) {
-		g.onSessionExit(sessionID)
+	g.sessions.SetResizePolicy(resizePolicy)
+	g.sessions.SetPolicy(session.Policy{
+		IdleTimeout:    cfg.SessionIdleTimeout,
+		MaxLifetime:    cfg.SessionMaxLifetime,
+		EndOnAgentExit: cfg.SessionEndOnAgentExit,
+	})
+	g.sessions.SetExpiryWarning(cfg.SessionExpiryWarning)
+	g.sessions.SetOnSessionExit(func(sessionID string, status session.ExitStatus) {
+		g.onSessionExit(sessionID, status)
+	})
+	g.sessions.SetOnAgentExit(func(sessionID string, code int) {
+		g.onAgentExit(sessionID, code)
+	})
+	g.sessions.SetOnSessionExpiring(func(sessionID string, reason session.EndReason, at time.Time) {
+		g.onSessionExpiring(sessionID, reason, at)
 	})
 	g.sshMgr, err = sshkeys.NewManager()
 	if err != nil {
@@ -76,17 +146,43 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Session cgroups need the gateway (and the tmux server it starts) moved
+	// out of the service cgroup, so this runs before any tmux command.
+	if g.cgroups, err = cgroup.Setup(); err != nil {
+		log.Info("session resource limits unavailable", "err", err)
+	}
+
 	// Output channel: session output chunks → WS sender goroutine
 	g.outputCh = make(chan session.OutputChunk, 256)
 
 	// Recover existing tmux sessions after daemon restart so contexts survive.
-	// These are session IDs previously created by control-plane (vibe-ses-*).
+	// These are session IDs previously created by control-plane (vibe-ses-*),
+	// on the gateway's tmux server or, from older gateways, the default one.
 	recovered, err := g.sessions.Recover(g.outputCh)
 	if err != nil {
 		g.log.Warn("session recovery failed", "err", err)
-	} else if len(recovered) > 0 {
+	}
+	if len(recovered) > 0 {
 		g.log.Info("recovered sessions", "count", len(recovered))
 	}
+	for _, sessionID := range recovered {
+		// Recordings end with the gateway process; continue in a new file.
+		if s := g.sessions.Get(sessionID); s != nil && s.Summary().Recording {
+			g.resumeRecording(s)
+		}
+		// Cgroups outlive the gateway process together with the panes.
+		if g.cgroups != nil {
+			if grp, ok := g.cgroups.Open(sessionID); ok {
+				g.trackGroup(sessionID, grp)
+				if s := g.sessions.Get(sessionID); s != nil {
+					s.SetCgroupProcs(grp.ProcsPath())
+				}
+			}
+		}
+	}
+	if g.cgroups != nil {
+		g.cgroups.Prune(recovered)
+	}
 
 	// Start SSH expiry watcher
 	sshkeys.StartExpiryWatcher(ctx, g.sshMgr, 5*time.Minute, log)
@@ -119,7 +215,7 @@ func main() {
 		cfg.AuthToken,
 		target,
 		g.onTextFrame,
-		nil, // gateway doesn't receive binary frames
+		g.onBinaryFrame,
 		log,
 	)
 
@@ -139,16 +235,38 @@ func main() {
 
 // gateway holds all subsystem state.
 type gateway struct {
-	cfg      *config.Config
-	log      *slog.Logger
-	wsClient *ws.Client
-	sessions *session.Manager
-	sshMgr   *sshkeys.Manager
-	health   *health.Collector
-	updater  *update.Updater
-	files    *files.Handler
-	outputCh chan session.OutputChunk
+	cfg           *config.Config
+	log           *slog.Logger
+	wsClient      *ws.Client
+	sessions      *session.Manager
+	sshMgr        *sshkeys.Manager
+	health        *health.Collector
+	updater       *update.Updater
+	files         *files.Handler
+	outputCh      chan session.OutputChunk
 	workspaceRoot string
+	recordings    *recording.Store
+	profiles      *profile.Store
+	cgroups       *cgroup.Manager // nil without cgroup v2 delegation
+
+	// groups holds the cgroup of each session while resource limits are
+	// available.
+	groupsMu sync.Mutex
+	groups   map[string]*cgroup.Group
+
+	// streamNext holds the next output seq to forward per session on the
+	// current connection. Sessions without an entry have no subscribers or
+	// have not been resumed (replay or snapshot) yet, so their live frames
+	// are held back.
+	streamMu   sync.Mutex
+	streamNext map[string]uint64
+	// subscribers counts the session.subscribe commands of each session
+	// not yet matched by a session.unsubscribe on the current connection.
+	subscribers map[string]int
+
+	// searches holds a slot for each session.search running off the read
+	// loop.
+	searches chan struct{}
 }
 
 func resolveWorkspaceRoot() (string, error) {
@@ -163,19 +281,86 @@ func resolveWorkspaceRoot() (string, error) {
 	return filepath.Join(cleanHome, "workspace"), nil
 }
 
-func (g *gateway) onSessionExit(sessionID string) {
+func (g *gateway) onSessionExit(sessionID string, status session.ExitStatus) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	g.log.Info("session exited", "session_id", sessionID, "reason", status.Reason, "exit_code", status.Code, "exit_known", status.Known)
+	g.forgetOutputStream(sessionID)
+	g.releaseGroup(sessionID)
+	g.sendEvent(ctx, sessionEndedEvent(sessionID, status))
+}
+
+func (g *gateway) onAgentExit(sessionID string, code int) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	g.log.Info("session exited", "session_id", sessionID)
+	g.log.Info("agent exited", "session_id", sessionID, "exit_code", code)
 	g.sendEvent(ctx, map[string]any{
-		"type":           "session.ended",
-		"schema_version": schemaVersion,
-		"session_id":     sessionID,
+		"type":       "session.agent_exited",
+		"session_id": sessionID,
+		"exit_code":  code,
+	})
+}
+
+func (g *gateway) onSessionExpiring(sessionID string, reason session.EndReason, at time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	g.log.Info("session expiring", "session_id", sessionID, "reason", reason, "expires_at", at)
+	g.sendEvent(ctx, map[string]any{
+		"type":       "session.expiring",
+		"session_id": sessionID,
+		"reason":     string(reason),
+		"expires_at": at.UTC().Format(time.RFC3339),
 	})
 }
 
+// createSessionGroup creates the cgroup a new session's panes start in. It
+// returns nil when the session runs unconfined, e.g. without cgroup
+// delegation.
+func (g *gateway) createSessionGroup(sessionID string, limits cgroup.Limits) *cgroup.Group {
+	if g.cgroups == nil {
+		return nil
+	}
+	grp, err := g.cgroups.Create(sessionID, limits)
+	if err != nil {
+		g.log.Warn("create session cgroup failed", "session_id", sessionID, "err", err)
+		return nil
+	}
+	return grp
+}
+
+func (g *gateway) trackGroup(sessionID string, grp *cgroup.Group) {
+	g.groupsMu.Lock()
+	defer g.groupsMu.Unlock()
+	g.groups[sessionID] = grp
+}
+
+func (g *gateway) sessionGroup(sessionID string) *cgroup.Group {
+	g.groupsMu.Lock()
+	defer g.groupsMu.Unlock()
+	return g.groups[sessionID]
+}
+
+// releaseGroup removes the cgroup of an ended session. Groups still holding
+// stray processes are kept until the next gateway start prunes them.
+func (g *gateway) releaseGroup(sessionID string) {
+	g.groupsMu.Lock()
+	grp, ok := g.groups[sessionID]
+	delete(g.groups, sessionID)
+	g.groupsMu.Unlock()
+	if !ok {
+		return
+	}
+	if err := grp.Remove(); err != nil {
+		g.log.Debug("remove session cgroup failed", "session_id", sessionID, "err", err)
+	}
+}
+
 // runWSWithHello wraps ws.Client.Run to send gateway.hello on each (re)connect.
+// Output subscriptions and client viewports start empty on each connection;
+// the control plane renews them after the hello.
 // Since nhooyr.io/websocket doesn't expose an onConnect hook, we run the client
 // in a loop and detect reconnects by watching the Connected() state change.
 func (g *gateway) runWSWithHello(ctx context.Context) {
@@ -194,9 +379,14 @@ func (g *gateway) runWSWithHello(ctx context.Context) {
 			case <-time.After(100 * time.Millisecond):
 				now := g.wsClient.Connected()
 				if now && !wasConnected {
+					// Reset before the hello, so subscriptions sent in reply
+					// are kept.
+					g.resetOutputStreams()
 					g.sendHello(ctx)
 					g.sendHealth(ctx)
-					g.sendSnapshots(ctx)
+				}
+				if !now && wasConnected {
+					g.resetOutputStreams()
 				}
 				wasConnected = now
 			}
@@ -239,40 +429,101 @@ func buildHelloEvent(cfg *config.Config, hostname string, info health.SystemInfo
 	return hello
 }
 
-func (g *gateway) sendSnapshots(ctx context.Context) {
-	for _, s := range g.sessions.List() {
-		sess := g.sessions.Get(s.SessionID)
-		if sess == nil {
-			continue
-		}
-		content, cols, rows, cursorX, cursorY, cursorVisible, alternateOn, err := sess.Snapshot()
-		if err != nil {
-			continue
-		}
-		content = trimSnapshotTail(content, maxSnapshotBytes)
-		evt := map[string]any{
-			"type":       "session.snapshot",
-			"session_id": s.SessionID,
-			"content":    content,
-			"cols":       cols,
-			"rows":       rows,
-		}
-		if cursorX >= 0 {
-			evt["cursor_x"] = cursorX
-		}
-		if cursorY >= 0 {
-			evt["cursor_y"] = cursorY
+// resumeOutputStream brings a session stream up to date when it gets its
+// first subscriber. Frames the client has not acknowledged are replayed from
+// the session's buffer; when that is not possible a snapshot is sent
+// instead. It must be called with streamMu held.
+func (g *gateway) resumeOutputStream(ctx context.Context, sessionID string, sess *session.Session) {
+	replay, err := sess.Replay()
+	if err == nil {
+		for _, chunk := range replay.Frames {
+			if err := g.sendTerminalFrame(ctx, chunk); err != nil {
+				g.log.Warn("replay output failed", "session_id", sessionID, "err", err)
+				return
+			}
 		}
-		if cursorVisible == 0 {
-			evt["cursor_visible"] = false
-		} else if cursorVisible == 1 {
-			evt["cursor_visible"] = true
+		g.streamNext[sessionID] = replay.NextSeq
+		g.log.Info("replayed session output", "session_id", sessionID, "frames", len(replay.Frames))
+		return
+	}
+
+	overrun := errors.Is(err, session.ErrReplayOverrun)
+	if overrun {
+		g.log.Info("replay buffer overrun, sending snapshot", "session_id", sessionID)
+	}
+	if next, ok := g.sendSnapshot(ctx, sessionID, sess, overrun); ok {
+		g.streamNext[sessionID] = next
+	} else {
+		g.streamNext[sessionID] = sess.NextSeq()
+	}
+}
+
+// sendSnapshot sends a snapshot of the session. It returns the seq of the
+// first output frame the snapshot does not reflect, and false when no
+// snapshot could be taken.
+func (g *gateway) sendSnapshot(ctx context.Context, sessionID string, sess *session.Session, replayOverrun bool) (uint64, bool) {
+	content, cols, rows, cursorX, cursorY, cursorVisible, alternateOn, nextSeq, err := sess.Snapshot()
+	if err != nil {
+		return 0, false
+	}
+	content = trimSnapshotTail(content, maxSnapshotBytes)
+	evt := map[string]any{
+		"type":       "session.snapshot",
+		"session_id": sessionID,
+		"content":    content,
+		"cols":       cols,
+		"rows":       rows,
+	}
+	if cursorX >= 0 {
+		evt["cursor_x"] = cursorX
+	}
+	if cursorY >= 0 {
+		evt["cursor_y"] = cursorY
+	}
+	if cursorVisible == 0 {
+		evt["cursor_visible"] = false
+	} else if cursorVisible == 1 {
+		evt["cursor_visible"] = true
+	}
+	if cursorVisible >= 0 {
+		evt["alternate_on"] = alternateOn
+	}
+	if replayOverrun {
+		evt["replay_overrun"] = true
+	}
+	g.sendEvent(ctx, evt)
+	return nextSeq, true
+}
+
+// resetOutputStreams drops every subscription and client viewport; called
+// when the connection drops and again when it is back.
+func (g *gateway) resetOutputStreams() {
+	g.streamMu.Lock()
+	defer g.streamMu.Unlock()
+	for sessionID := range g.subscribers {
+		if s := g.sessions.Get(sessionID); s != nil {
+			s.SetSubscribed(false)
 		}
-		if cursorVisible >= 0 {
-			evt["alternate_on"] = alternateOn
+	}
+	for _, sum := range g.sessions.List() {
+		if s := g.sessions.Get(sum.SessionID); s != nil {
+			s.ClearViewports()
 		}
-		g.sendEvent(ctx, evt)
 	}
+	clear(g.streamNext)
+	clear(g.subscribers)
+}
+
+func (g *gateway) subscriberCount(sessionID string) int {
+	g.streamMu.Lock()
+	defer g.streamMu.Unlock()
+	return g.subscribers[sessionID]
+}
+
+func (g *gateway) forgetOutputStream(sessionID string) {
+	g.streamMu.Lock()
+	defer g.streamMu.Unlock()
+	delete(g.streamNext, sessionID)
 }
 
 // onTextFrame dispatches incoming JSON commands from the control plane.
@@ -303,14 +554,42 @@ func (g *gateway) onTextFrame(ctx context.Context, raw json.RawMessage) {
 		err = g.handleSessionCreate(ctx, raw)
 	case "session.input":
 		err = g.handleSessionInput(ctx, raw)
+	case "session.paste":
+		err = g.handleSessionPaste(ctx, raw)
+	case "session.keys":
+		err = g.handleSessionKeys(ctx, raw)
 	case "session.resize":
 		err = g.handleSessionResize(ctx, raw)
 	case "session.end":
 		err = g.handleSessionEnd(ctx, raw)
+	case "session.restart":
+		err = g.handleSessionRestart(ctx, raw)
 	case "session.ack":
 		err = g.handleSessionAck(ctx, raw)
+	case "session.subscribe":
+		err = g.handleSessionSubscribe(ctx, raw)
+	case "session.unsubscribe":
+		err = g.handleSessionUnsubscribe(ctx, raw)
 	case "session.snapshot":
 		err = g.handleSessionSnapshot(ctx, raw)
+	case "session.history":
+		err = g.handleSessionHistory(ctx, raw)
+	case "session.search":
+		err = g.handleSessionSearch(ctx, raw)
+	case "session.list":
+		err = g.handleSessionList(ctx, raw)
+	case "session.recording.list":
+		err = g.handleRecordingList(ctx, raw)
+	case "session.recording.download":
+		err = g.handleRecordingDownload(ctx, raw)
+	case "session.recording.delete":
+		err = g.handleRecordingDelete(ctx, raw)
+	case "profile.save":
+		err = g.handleProfileSave(ctx, raw)
+	case "profile.list":
+		err = g.handleProfileList(ctx, raw)
+	case "profile.delete":
+		err = g.handleProfileDelete(ctx, raw)
 	case "ssh.authorize":
 		err = g.handleSSHAuthorize(ctx, raw)
 	case "ssh.revoke":
@@ -347,6 +626,54 @@ func (g *gateway) onTextFrame(ctx context.Context, raw json.RawMessage) {
 	}
 }
 
+// onBinaryFrame handles incoming binary frames. The control plane sends
+// terminal input this way to avoid the JSON/base64 round trip per keystroke.
+func (g *gateway) onBinaryFrame(ctx context.Context, data []byte) {
+	if len(data) > maxCommandFrameBytes {
+		g.log.Warn("binary frame too large", "bytes", len(data), "max_bytes", maxCommandFrameBytes)
+		return
+	}
+	if len(data) == 0 || data[0] != frameKindTerminalInput {
+		g.log.Warn("unsupported binary frame", "bytes", len(data))
+		return
+	}
+	sessionID, seq, payload, err := decodeInputFrame(data)
+	if err != nil {
+		g.log.Warn("invalid terminal input frame", "err", err)
+		return
+	}
+	s := g.sessions.Get(sessionID)
+	if s == nil {
+		g.sendInputAck(ctx, sessionID, session.InputAck{
+			Seq: seq,
+			Err: fmt.Errorf("session %q not found", sessionID),
+		})
+		return
+	}
+	s.QueueInput(seq, payload, func(ack session.InputAck) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		g.sendInputAck(ctx, sessionID, ack)
+	})
+}
+
+// sendInputAck reports the input written to a session so the sender can
+// detect lost keystrokes.
+func (g *gateway) sendInputAck(ctx context.Context, sessionID string, ack session.InputAck) {
+	evt := map[string]any{
+		"type":       "session.input_ack",
+		"session_id": sessionID,
+		"seq":        ack.Seq,
+	}
+	if ack.Dropped > 0 {
+		evt["dropped"] = ack.Dropped
+	}
+	if ack.Err != nil {
+		evt["error"] = ack.Err.Error()
+	}
+	g.sendEvent(ctx, evt)
+}
+
 func (g *gateway) sendAck(ctx context.Context, requestID string, ok bool, errMsg string) {
 	ack := map[string]any{
 		"type":           "ack",
@@ -364,20 +691,44 @@ func (g *gateway) sendAck(ctx context.Context, requestID string, ok bool, errMsg
 
 func (g *gateway) handleSessionCreate(ctx context.Context, raw json.RawMessage) error {
 	var cmd struct {
-		RequestID   string `json:"request_id"`
-		SessionID   string `json:"session_id"`
-		Name        string `json:"name"`
-		Workdir     string `json:"workdir"`
-		Agent       string `json:"agent"`
-		AgentConfig *struct {
-			ClaudeMD string `json:"claude_md"`
-			AgentsMD string `json:"agents_md"`
-		} `json:"agent_config"`
-		Env map[string]string `json:"env"`
+		RequestID   string               `json:"request_id"`
+		SessionID   string               `json:"session_id"`
+		Name        string               `json:"name"`
+		Workdir     string               `json:"workdir"`
+		Agent       string               `json:"agent"`
+		AgentConfig *profile.AgentConfig `json:"agent_config"`
+		Profile     string               `json:"profile"`
+		Env         map[string]string    `json:"env"`
+		Record      *bool                `json:"record"`
+		Policy      *struct {
+			IdleTimeoutSeconds *int64 `json:"idle_timeout_seconds"`
+			MaxLifetimeSeconds *int64 `json:"max_lifetime_seconds"`
+			EndOnAgentExit     *bool  `json:"end_on_agent_exit"`
+		} `json:"policy"`
+		Limits *struct {
+			CPUCores    float64 `json:"cpu_cores"`
+			MemoryBytes int64   `json:"memory_bytes"`
+			PIDs        int64   `json:"pids"`
+		} `json:"limits"`
+		Backend string `json:"backend"`
 	}
 	if err := json.Unmarshal(raw, &cmd); err != nil {
 		return err
 	}
+	if cmd.Profile != "" {
+		p, err := g.profiles.Get(cmd.Profile)
+		if err != nil {
+			return err
+		}
+		// Fields set in the command override the profile.
+		p = p.Override(profile.Profile{
+			Agent:       cmd.Agent,
+			Workdir:     cmd.Workdir,
+			Env:         cmd.Env,
+			AgentConfig: cmd.AgentConfig,
+		})
+		cmd.Agent, cmd.Workdir, cmd.Env, cmd.AgentConfig = p.Agent, p.Workdir, p.Env, p.AgentConfig
+	}
 
 	opts := session.Options{
 		SessionID: cmd.SessionID,
@@ -387,9 +738,62 @@ func (g *gateway) handleSessionCreate(ctx context.Context, raw json.RawMessage)
 		Env:       cmd.Env,
 		OutputCh:  g.outputCh,
 	}
+	policy := g.cfg.InstructionsPolicy
 	if cmd.AgentConfig != nil {
 		opts.ClaudeMD = cmd.AgentConfig.ClaudeMD
 		opts.AgentsMD = cmd.AgentConfig.AgentsMD
+		if cmd.AgentConfig.InstructionsPolicy != "" {
+			policy = cmd.AgentConfig.InstructionsPolicy
+		}
+		agentOpts, err := agentOptions(cmd.AgentConfig)
+		if err != nil {
+			return err
+		}
+		opts.AgentOptions = agentOpts
+	}
+	instructionsPolicy, err := session.ParseInstructionsPolicy(policy)
+	if err != nil {
+		return err
+	}
+	opts.InstructionsPolicy = instructionsPolicy
+
+	if cmd.Backend != "" {
+		if opts.Backend, err = session.ParseBackendKind(cmd.Backend); err != nil {
+			return err
+		}
+	}
+
+	if p := cmd.Policy; p != nil {
+		// Fields left out keep the gateway default; 0 disables a limit.
+		policy := session.Policy{
+			IdleTimeout:    g.cfg.SessionIdleTimeout,
+			MaxLifetime:    g.cfg.SessionMaxLifetime,
+			EndOnAgentExit: g.cfg.SessionEndOnAgentExit,
+		}
+		if p.IdleTimeoutSeconds != nil {
+			if *p.IdleTimeoutSeconds < 0 {
+				return fmt.Errorf("policy.idle_timeout_seconds must be >= 0")
+			}
+			policy.IdleTimeout = time.Duration(*p.IdleTimeoutSeconds) * time.Second
+		}
+		if p.MaxLifetimeSeconds != nil {
+			if *p.MaxLifetimeSeconds < 0 {
+				return fmt.Errorf("policy.max_lifetime_seconds must be >= 0")
+			}
+			policy.MaxLifetime = time.Duration(*p.MaxLifetimeSeconds) * time.Second
+		}
+		if p.EndOnAgentExit != nil {
+			policy.EndOnAgentExit = *p.EndOnAgentExit
+		}
+		opts.Policy = &policy
+	}
+
+	var limits cgroup.Limits
+	if l := cmd.Limits; l != nil {
+		if l.CPUCores < 0 || l.MemoryBytes < 0 || l.PIDs < 0 {
+			return fmt.Errorf("limits must be >= 0")
+		}
+		limits = cgroup.Limits{CPUCores: l.CPUCores, MemoryBytes: l.MemoryBytes, PIDs: l.PIDs}
 	}
 
 	if cmd.Agent != "" && cmd.Agent != "none" {
@@ -402,26 +806,455 @@ func (g *gateway) handleSessionCreate(ctx context.Context, raw json.RawMessage)
 		}
 	}
 
+	// The session cgroup is created before the panes; an existing session
+	// must not have its group reused and then removed on failure.
+	if g.sessions.Get(cmd.SessionID) != nil {
+		return fmt.Errorf("session %q already exists", cmd.SessionID)
+	}
+
+	record := g.cfg.RecordSessions
+	if cmd.Record != nil {
+		record = *cmd.Record
+	}
+	var rec *recording.Recorder
+	if record {
+		title := cmd.Name
+		if title == "" {
+			title = cmd.SessionID
+		}
+		opts.Record = func(cols, rows int) (session.Recorder, error) {
+			var err error
+			rec, err = g.recordings.Start(cmd.SessionID, title, cols, rows)
+			if err != nil {
+				return nil, err
+			}
+			return rec, nil
+		}
+	}
+
+	grp := g.createSessionGroup(cmd.SessionID, limits)
+	if grp != nil {
+		opts.CgroupProcs = grp.ProcsPath()
+	}
+
 	s, err := g.sessions.Create(opts)
 	if err != nil {
+		if rec != nil {
+			rec.Discard()
+		}
+		if grp != nil {
+			_ = grp.Remove()
+		}
+		return err
+	}
+	limitsApplied := grp != nil
+	if grp != nil {
+		g.trackGroup(cmd.SessionID, grp)
+	}
+	if g.subscriberCount(cmd.SessionID) > 0 {
+		s.SetSubscribed(true)
+	}
+
+	evt := map[string]any{
+		"type":       "session.started",
+		"request_id": cmd.RequestID,
+		"session_id": cmd.SessionID,
+	}
+	if record {
+		evt["recording"] = true
+	}
+	if cmd.Limits != nil {
+		evt["limits_applied"] = limitsApplied
+	}
+	if files := s.InstructionFiles(); len(files) > 0 {
+		evt["instruction_files"] = instructionFilesPayload(files)
+	}
+	g.sendEvent(ctx, evt)
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+func (g *gateway) handleSessionInput(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+		Data      string `json:"data"` // base64
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	s := g.sessions.Get(cmd.SessionID)
+	if s == nil {
+		return fmt.Errorf("session %q not found", cmd.SessionID)
+	}
+	data, err := base64.StdEncoding.DecodeString(cmd.Data)
+	if err != nil {
+		return fmt.Errorf("decode input: %w", err)
+	}
+	if err := s.Input(data); err != nil {
 		return err
 	}
-	_ = s
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
 
+func (g *gateway) handleSessionPaste(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+		Data      string `json:"data"` // base64
+		Bracketed *bool  `json:"bracketed"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	s := g.sessions.Get(cmd.SessionID)
+	if s == nil {
+		return fmt.Errorf("session %q not found", cmd.SessionID)
+	}
+	data, err := base64.StdEncoding.DecodeString(cmd.Data)
+	if err != nil {
+		return fmt.Errorf("decode paste: %w", err)
+	}
+	bracketed := cmd.Bracketed == nil || *cmd.Bracketed
+	if err := s.Paste(data, bracketed); err != nil {
+		return err
+	}
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+func (g *gateway) handleSessionKeys(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string   `json:"request_id"`
+		SessionID string   `json:"session_id"`
+		Keys      []string `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	s := g.sessions.Get(cmd.SessionID)
+	if s == nil {
+		return fmt.Errorf("session %q not found", cmd.SessionID)
+	}
+	if err := s.Keys(cmd.Keys); err != nil {
+		return err
+	}
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+func (g *gateway) handleSessionResize(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+		ClientID  string `json:"client_id"`
+		Cols      int    `json:"cols"`
+		Rows      int    `json:"rows"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	s := g.sessions.Get(cmd.SessionID)
+	if s == nil {
+		return fmt.Errorf("session %q not found", cmd.SessionID)
+	}
+	if g.cfg.CPURL == config.CPURLStaging {
+		g.log.Info("session resize command", "session_id", cmd.SessionID, "client_id", cmd.ClientID, "cols", cmd.Cols, "rows", cmd.Rows)
+	}
+	cols, rows, changed, err := s.SetViewport(cmd.ClientID, cmd.Cols, cmd.Rows)
+	if err != nil {
+		return err
+	}
+	// A client whose viewport the policy overrode needs the size too.
+	if changed || cols != cmd.Cols || rows != cmd.Rows {
+		g.sendResized(ctx, cmd.SessionID, s, cols, rows)
+	}
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+// sendResized tells every client of a session the window size its resize
+// policy settled on.
+func (g *gateway) sendResized(ctx context.Context, sessionID string, s *session.Session, cols, rows int) {
+	g.sendEvent(ctx, map[string]any{
+		"type":       "session.resized",
+		"session_id": sessionID,
+		"cols":       cols,
+		"rows":       rows,
+		"policy":     s.ResizePolicy().String(),
+	})
+}
+
+func (g *gateway) handleSessionEnd(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	s := g.sessions.Get(cmd.SessionID)
+	if err := g.sessions.End(cmd.SessionID); err != nil {
+		return err
+	}
+	g.forgetOutputStream(cmd.SessionID)
+	g.releaseGroup(cmd.SessionID)
+	var status session.ExitStatus
+	if s != nil {
+		status = s.ExitStatus()
+	}
+	g.sendEvent(ctx, sessionEndedEvent(cmd.SessionID, status))
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+func (g *gateway) handleSessionRestart(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+		Agent     string `json:"agent"`
+		Resume    bool   `json:"resume"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	s := g.sessions.Get(cmd.SessionID)
+	if s == nil {
+		return fmt.Errorf("session %q not found", cmd.SessionID)
+	}
+	if cmd.Agent != "" && cmd.Agent != "none" {
+		installed, err := agents.IsInstalled(agents.AgentName(cmd.Agent))
+		if err != nil {
+			return err
+		}
+		if !installed {
+			return fmt.Errorf("%s is not installed. Run agents.install first.", cmd.Agent)
+		}
+	}
+
+	pid, err := s.Restart(session.RestartOptions{Agent: cmd.Agent, Resume: cmd.Resume})
+	if err != nil {
+		return err
+	}
+	evt := map[string]any{
+		"type":       "session.restarted",
+		"request_id": cmd.RequestID,
+		"session_id": cmd.SessionID,
+		"pid":        pid,
+	}
+	if agent := s.Summary().Agent; agent != "" {
+		evt["agent"] = agent
+	}
+	g.sendEvent(ctx, evt)
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+func (g *gateway) handleSessionAck(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+		Seq       uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	if cmd.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	// Acks for sessions that already ended are harmless; ignore them.
+	if s := g.sessions.Get(cmd.SessionID); s != nil {
+		s.Ack(cmd.Seq)
+		s.Watch()
+	}
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+// handleSessionSubscribe counts a client watching the session. The first
+// subscriber resumes the session's output stream with a replay of the frames
+// after the last ack, or a snapshot.
+func (g *gateway) handleSessionSubscribe(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	if cmd.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	g.streamMu.Lock()
+	g.subscribers[cmd.SessionID]++
+	if g.subscribers[cmd.SessionID] == 1 {
+		if s := g.sessions.Get(cmd.SessionID); s != nil {
+			s.SetSubscribed(true)
+			g.resumeOutputStream(ctx, cmd.SessionID, s)
+		} else if _, ok := g.streamNext[cmd.SessionID]; !ok {
+			// Subscribed ahead of session.create: stream live from the
+			// first frame.
+			g.streamNext[cmd.SessionID] = 0
+		}
+	}
+	g.streamMu.Unlock()
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+// handleSessionUnsubscribe drops a subscriber and its viewport. Without
+// subscribers, output is no longer forwarded; it stays in the session's
+// replay buffer.
+func (g *gateway) handleSessionUnsubscribe(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+		ClientID  string `json:"client_id"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	if cmd.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	g.streamMu.Lock()
+	if g.subscribers[cmd.SessionID] > 1 {
+		g.subscribers[cmd.SessionID]--
+	} else if _, ok := g.subscribers[cmd.SessionID]; ok {
+		delete(g.subscribers, cmd.SessionID)
+		delete(g.streamNext, cmd.SessionID)
+		if s := g.sessions.Get(cmd.SessionID); s != nil {
+			s.SetSubscribed(false)
+		}
+	}
+	g.streamMu.Unlock()
+	if s := g.sessions.Get(cmd.SessionID); s != nil && cmd.ClientID != "" {
+		cols, rows, changed, err := s.DropViewport(cmd.ClientID)
+		if err != nil {
+			return err
+		}
+		if changed {
+			g.sendResized(ctx, cmd.SessionID, s, cols, rows)
+		}
+	}
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+func (g *gateway) handleSessionSnapshot(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		SessionID string `json:"session_id"`
+		Format    string `json:"format"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	s := g.sessions.Get(cmd.SessionID)
+	if s == nil {
+		return fmt.Errorf("session %q not found", cmd.SessionID)
+	}
+	// A snapshot means a client is about to show the session.
+	s.Watch()
+	switch cmd.Format {
+	case "", "ansi":
+	case "grid":
+		return g.sendGridSnapshot(ctx, cmd.RequestID, cmd.SessionID, s)
+	default:
+		return fmt.Errorf("unsupported snapshot format %q", cmd.Format)
+	}
+	content, cols, rows, cursorX, cursorY, cursorVisible, alternateOn, _, err := s.Snapshot()
+	if err != nil {
+		return err
+	}
+	if g.cfg.CPURL == config.CPURLStaging {
+		attrs := []any{
+			"session_id", cmd.SessionID,
+			"cols", cols,
+			"rows", rows,
+			"cursor_x", cursorX,
+			"cursor_y", cursorY,
+			"cursor_visible", cursorVisible,
+		}
+		if cursorVisible >= 0 {
+			attrs = append(attrs, "alternate_on", alternateOn)
+		}
+		g.log.Info("session snapshot", attrs...)
+	}
+	content = trimSnapshotTail(content, maxSnapshotBytes)
+	evt := map[string]any{
+		"type":       "session.snapshot",
+		"request_id": cmd.RequestID,
+		"session_id": cmd.SessionID,
+		"content":    content,
+		"cols":       cols,
+		"rows":       rows,
+	}
+	if cursorX >= 0 {
+		evt["cursor_x"] = cursorX
+	}
+	if cursorY >= 0 {
+		evt["cursor_y"] = cursorY
+	}
+	if cursorVisible == 0 {
+		evt["cursor_visible"] = false
+	} else if cursorVisible == 1 {
+		evt["cursor_visible"] = true
+	}
+	if cursorVisible >= 0 {
+		evt["alternate_on"] = alternateOn
+	}
+	g.sendEvent(ctx, evt)
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+// sendGridSnapshot answers a snapshot request in the grid format: the visible
+// pane as rows of cells, each row without its trailing blank cells, plus the
+// pane text for consumers that only need plain text.
+func (g *gateway) sendGridSnapshot(ctx context.Context, requestID, sessionID string, s *session.Session) error {
+	grid, err := s.GridSnapshot()
+	if err != nil {
+		return err
+	}
+	rows := make([][]vt.Cell, len(grid.Lines))
+	for i, line := range grid.Lines {
+		rows[i] = vt.TrimRight(line)
+	}
+	rawRows, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	content := grid.Text()
+	if len(rawRows)+len(content) > maxSnapshotBytes {
+		return fmt.Errorf("grid snapshot exceeds max size (%d bytes)", maxSnapshotBytes)
+	}
 	g.sendEvent(ctx, map[string]any{
-		"type":       "session.started",
-		"request_id": cmd.RequestID,
-		"session_id": cmd.SessionID,
+		"type":           "session.snapshot",
+		"request_id":     requestID,
+		"session_id":     sessionID,
+		"format":         "grid",
+		"content":        content,
+		"cols":           grid.Cols,
+		"rows":           grid.Rows,
+		"cursor_x":       grid.CursorX,
+		"cursor_y":       grid.CursorY,
+		"cursor_visible": grid.CursorVisible,
+		"alternate_on":   grid.AlternateOn,
+		"grid":           json.RawMessage(rawRows),
 	})
-	g.sendAck(ctx, cmd.RequestID, true, "")
+	g.sendAck(ctx, requestID, true, "")
 	return nil
 }
 
-func (g *gateway) handleSessionInput(ctx context.Context, raw json.RawMessage) error {
+func (g *gateway) handleSessionHistory(ctx context.Context, raw json.RawMessage) error {
 	var cmd struct {
 		RequestID string `json:"request_id"`
 		SessionID string `json:"session_id"`
-		Data      string `json:"data"` // base64
+		Start     *int   `json:"start"`
+		End       *int   `json:"end"`
+		StripANSI bool   `json:"strip_ansi"`
 	}
 	if err := json.Unmarshal(raw, &cmd); err != nil {
 		return err
@@ -430,42 +1263,120 @@ func (g *gateway) handleSessionInput(ctx context.Context, raw json.RawMessage) e
 	if s == nil {
 		return fmt.Errorf("session %q not found", cmd.SessionID)
 	}
-	data, err := base64.StdEncoding.DecodeString(cmd.Data)
-	if err != nil {
-		return fmt.Errorf("decode input: %w", err)
+	// Without a range, return the most recent scrollback lines above the
+	// visible pane.
+	end := -1
+	if cmd.End != nil {
+		end = *cmd.End
 	}
-	if err := s.Input(data); err != nil {
+	start := end - defaultHistoryLines + 1
+	if cmd.Start != nil {
+		start = *cmd.Start
+	}
+	page, err := s.History(start, end, !cmd.StripANSI)
+	if err != nil {
 		return err
 	}
+	content, dropped := trimHistoryHead(page.Content, maxSnapshotBytes)
+	evt := map[string]any{
+		"type":         "session.history",
+		"request_id":   cmd.RequestID,
+		"session_id":   cmd.SessionID,
+		"start":        page.Start + dropped,
+		"end":          page.End,
+		"history_size": page.HistorySize,
+		"content":      content,
+	}
+	if dropped > 0 {
+		evt["truncated"] = true
+	}
+	g.sendEvent(ctx, evt)
 	g.sendAck(ctx, cmd.RequestID, true, "")
 	return nil
 }
 
-func (g *gateway) handleSessionResize(ctx context.Context, raw json.RawMessage) error {
+func (g *gateway) handleSessionSearch(ctx context.Context, raw json.RawMessage) error {
 	var cmd struct {
-		RequestID string `json:"request_id"`
-		SessionID string `json:"session_id"`
-		Cols      int    `json:"cols"`
-		Rows      int    `json:"rows"`
+		RequestID     string `json:"request_id"`
+		SessionID     string `json:"session_id"`
+		Query         string `json:"query"`
+		Regex         bool   `json:"regex"`
+		CaseSensitive bool   `json:"case_sensitive"`
+		Context       *int   `json:"context"`
+		MaxResults    int    `json:"max_results"`
 	}
 	if err := json.Unmarshal(raw, &cmd); err != nil {
 		return err
 	}
+	select {
+	case g.searches <- struct{}{}:
+	default:
+		return fmt.Errorf("too many searches in progress, retry later")
+	}
 	s := g.sessions.Get(cmd.SessionID)
 	if s == nil {
+		<-g.searches
 		return fmt.Errorf("session %q not found", cmd.SessionID)
 	}
-	if g.cfg.CPURL == config.CPURLStaging {
-		g.log.Info("session resize command", "session_id", cmd.SessionID, "cols", cmd.Cols, "rows", cmd.Rows)
+	opts := session.SearchOptions{
+		Query:         cmd.Query,
+		Regex:         cmd.Regex,
+		CaseSensitive: cmd.CaseSensitive,
+		Context:       defaultSearchContext,
+		MaxResults:    cmd.MaxResults,
 	}
-	if err := s.Resize(cmd.Cols, cmd.Rows); err != nil {
-		return err
+	if cmd.Context != nil {
+		opts.Context = *cmd.Context
 	}
-	g.sendAck(ctx, cmd.RequestID, true, "")
+	// A search reads up to the whole history and may take seconds, so it
+	// runs off the read loop and acknowledges itself.
+	go func() {
+		defer func() { <-g.searches }()
+		searchCtx, cancel := context.WithTimeout(ctx, searchTimeout)
+		defer cancel()
+		res, err := s.Search(searchCtx, opts)
+		if err != nil {
+			g.log.Error("session search failed", "err", err, "session_id", cmd.SessionID)
+			g.sendAck(ctx, cmd.RequestID, false, err.Error())
+			return
+		}
+		g.sendSearchResult(ctx, cmd.RequestID, cmd.SessionID, res)
+	}()
 	return nil
 }
 
-func (g *gateway) handleSessionEnd(ctx context.Context, raw json.RawMessage) error {
+func (g *gateway) sendSearchResult(ctx context.Context, requestID, sessionID string, res session.SearchResult) {
+	matches := make([]map[string]any, 0, len(res.Matches))
+	for _, m := range res.Matches {
+		match := map[string]any{
+			"line": m.Line,
+			"text": m.Text,
+		}
+		if len(m.Before) > 0 {
+			match["before"] = m.Before
+		}
+		if len(m.After) > 0 {
+			match["after"] = m.After
+		}
+		matches = append(matches, match)
+	}
+	evt := map[string]any{
+		"type":         "session.search",
+		"request_id":   requestID,
+		"session_id":   sessionID,
+		"history_size": res.HistorySize,
+		"matches":      matches,
+	}
+	if res.Truncated {
+		evt["truncated"] = true
+	}
+	g.sendEvent(ctx, evt)
+	g.sendAck(ctx, requestID, true, "")
+}
+
+// ----- Recording handlers -----
+
+func (g *gateway) handleRecordingList(ctx context.Context, raw json.RawMessage) error {
 	var cmd struct {
 		RequestID string `json:"request_id"`
 		SessionID string `json:"session_id"`
@@ -473,92 +1384,165 @@ func (g *gateway) handleSessionEnd(ctx context.Context, raw json.RawMessage) err
 	if err := json.Unmarshal(raw, &cmd); err != nil {
 		return err
 	}
-	if err := g.sessions.End(cmd.SessionID); err != nil {
+
+	infos, err := g.recordings.List(cmd.SessionID)
+	if err != nil {
 		return err
 	}
+	items := make([]map[string]any, 0, len(infos))
+	for _, info := range infos {
+		items = append(items, map[string]any{
+			"recording_id": info.ID,
+			"session_id":   info.SessionID,
+			"started_at":   info.StartedAt.Format(time.RFC3339),
+			"part":         info.Part,
+			"size":         info.Size,
+			"active":       info.Active,
+		})
+	}
+
 	g.sendEvent(ctx, map[string]any{
-		"type":       "session.ended",
-		"session_id": cmd.SessionID,
+		"type":       "session.recording.list",
+		"request_id": cmd.RequestID,
+		"recordings": items,
 	})
-	g.sendAck(ctx, cmd.RequestID, true, "")
 	return nil
 }
 
-func (g *gateway) handleSessionAck(ctx context.Context, raw json.RawMessage) error {
+func (g *gateway) handleRecordingDownload(ctx context.Context, raw json.RawMessage) error {
 	var cmd struct {
-		RequestID string `json:"request_id"`
-		SessionID string `json:"session_id"`
-		Seq       uint64 `json:"seq"`
+		RequestID   string `json:"request_id"`
+		TransferID  string `json:"transfer_id"`
+		RecordingID string `json:"recording_id"`
 	}
 	if err := json.Unmarshal(raw, &cmd); err != nil {
 		return err
 	}
-	if cmd.SessionID == "" {
-		return fmt.Errorf("session_id is required")
+	path, err := g.recordings.Path(cmd.RecordingID)
+	if err != nil {
+		return err
 	}
-	// Accepted for protocol compatibility; M1 replay behavior is snapshot-based.
-	g.sendAck(ctx, cmd.RequestID, true, "")
+	go func() {
+		if err := g.files.SendFile(ctx, cmd.TransferID, path); err != nil {
+			g.log.Error("recording download failed", "err", err, "transfer_id", cmd.TransferID)
+			g.sendAck(ctx, cmd.RequestID, false, err.Error())
+			return
+		}
+		g.sendAck(ctx, cmd.RequestID, true, "")
+	}()
 	return nil
 }
 
-func (g *gateway) handleSessionSnapshot(ctx context.Context, raw json.RawMessage) error {
+func (g *gateway) handleRecordingDelete(ctx context.Context, raw json.RawMessage) error {
 	var cmd struct {
-		RequestID string `json:"request_id"`
-		SessionID string `json:"session_id"`
+		RequestID   string `json:"request_id"`
+		RecordingID string `json:"recording_id"`
 	}
 	if err := json.Unmarshal(raw, &cmd); err != nil {
 		return err
 	}
-	s := g.sessions.Get(cmd.SessionID)
-	if s == nil {
-		return fmt.Errorf("session %q not found", cmd.SessionID)
+	if err := g.recordings.Delete(cmd.RecordingID); err != nil {
+		return err
 	}
-	content, cols, rows, cursorX, cursorY, cursorVisible, alternateOn, err := s.Snapshot()
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+// resumeRecording starts a new recording for a session recovered after a
+// gateway restart.
+func (g *gateway) resumeRecording(s *session.Session) {
+	sum := s.Summary()
+	err := s.StartRecording(func(cols, rows int) (session.Recorder, error) {
+		rec, err := g.recordings.Start(sum.SessionID, sum.Name, cols, rows)
+		if err != nil {
+			return nil, err
+		}
+		return rec, nil
+	})
 	if err != nil {
+		g.log.Warn("resume recording failed", "session_id", sum.SessionID, "err", err)
+	}
+}
+
+// ----- Profile handlers -----
+
+func (g *gateway) handleProfileSave(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		profile.Profile
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
 		return err
 	}
-	if g.cfg.CPURL == config.CPURLStaging {
-		attrs := []any{
-			"session_id", cmd.SessionID,
-			"cols", cols,
-			"rows", rows,
-			"cursor_x", cursorX,
-			"cursor_y", cursorY,
-			"cursor_visible", cursorVisible,
+	// Reject what session.create would reject now rather than on every
+	// session created from the profile.
+	if c := cmd.AgentConfig; c != nil {
+		if _, err := session.ParseInstructionsPolicy(c.InstructionsPolicy); err != nil {
+			return err
 		}
-		if cursorVisible >= 0 {
-			attrs = append(attrs, "alternate_on", alternateOn)
+		opts, err := agentOptions(c)
+		if err != nil {
+			return err
+		}
+		if err := opts.Validate(cmd.Agent); err != nil {
+			return err
 		}
-		g.log.Info("session snapshot", attrs...)
 	}
-	content = trimSnapshotTail(content, maxSnapshotBytes)
-	evt := map[string]any{
-		"type":       "session.snapshot",
-		"request_id": cmd.RequestID,
-		"session_id": cmd.SessionID,
-		"content":    content,
-		"cols":       cols,
-		"rows":       rows,
+	if err := g.profiles.Save(cmd.Profile); err != nil {
+		return err
 	}
-	if cursorX >= 0 {
-		evt["cursor_x"] = cursorX
+	g.sendAck(ctx, cmd.RequestID, true, "")
+	return nil
+}
+
+func (g *gateway) handleProfileList(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
 	}
-	if cursorY >= 0 {
-		evt["cursor_y"] = cursorY
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
 	}
-	if cursorVisible == 0 {
-		evt["cursor_visible"] = false
-	} else if cursorVisible == 1 {
-		evt["cursor_visible"] = true
+	profiles, err := g.profiles.List()
+	if err != nil {
+		return err
 	}
-	if cursorVisible >= 0 {
-		evt["alternate_on"] = alternateOn
+	g.sendEvent(ctx, map[string]any{
+		"type":       "profile.list",
+		"request_id": cmd.RequestID,
+		"profiles":   profiles,
+	})
+	return nil
+}
+
+func (g *gateway) handleProfileDelete(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+		Name      string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+	if err := g.profiles.Delete(cmd.Name); err != nil {
+		return err
 	}
-	g.sendEvent(ctx, evt)
 	g.sendAck(ctx, cmd.RequestID, true, "")
 	return nil
 }
 
+// agentOptions returns the launch options of an agent_config.
+func agentOptions(c *profile.AgentConfig) (session.AgentOptions, error) {
+	mode, err := session.ParsePermissionMode(c.PermissionMode)
+	if err != nil {
+		return session.AgentOptions{}, err
+	}
+	return session.AgentOptions{
+		Model:          c.Model,
+		PermissionMode: mode,
+		Resume:         c.Resume != nil && *c.Resume,
+		ExtraArgs:      c.ExtraArgs,
+	}, nil
+}
+
 // ----- SSH handlers -----
 
 func (g *gateway) handleSSHAuthorize(ctx context.Context, raw json.RawMessage) error {
@@ -741,6 +1725,28 @@ func (g *gateway) handleAgentsInstall(ctx context.Context, raw json.RawMessage)
 	return nil
 }
 
+func (g *gateway) handleSessionList(ctx context.Context, raw json.RawMessage) error {
+	var cmd struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+
+	details := g.sessions.Details()
+	items := make([]map[string]any, 0, len(details))
+	for _, d := range details {
+		items = append(items, sessionDetailsPayload(d))
+	}
+
+	g.sendEvent(ctx, map[string]any{
+		"type":       "session.list",
+		"request_id": cmd.RequestID,
+		"sessions":   items,
+	})
+	return nil
+}
+
 func (g *gateway) handleAgentsList(ctx context.Context, raw json.RawMessage) error {
 	var cmd struct {
 		RequestID string `json:"request_id"`
@@ -842,24 +1848,100 @@ func (g *gateway) handleGatewayUpdate(ctx context.Context, raw json.RawMessage)
 
 // forwardOutput reads from outputCh and sends binary terminal frames over WS.
 func (g *gateway) forwardOutput(ctx context.Context) {
+	ticker := time.NewTicker(outputCatchUpInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case chunk := <-g.outputCh:
-			frame, err := encodeTerminalFrame(chunk.SessionID, chunk.Seq, chunk.Data)
-			if err != nil {
-				g.log.Warn("encode terminal frame failed", "err", err)
-				continue
-			}
-			if err := g.wsClient.SendBinary(ctx, frame); err != nil {
-				// Not connected – drop frame (output will be recovered via snapshot on reconnect)
-				g.log.Debug("drop terminal frame (not connected)", "session", chunk.SessionID)
-			}
+			g.forwardChunk(ctx, chunk)
+		case <-ticker.C:
+			g.catchUpOutputStreams(ctx)
+		}
+	}
+}
+
+func (g *gateway) forwardChunk(ctx context.Context, chunk session.OutputChunk) {
+	g.streamMu.Lock()
+	defer g.streamMu.Unlock()
+
+	next, ok := g.streamNext[chunk.SessionID]
+	if !ok || chunk.Seq < next {
+		// Stream not resumed on this connection yet, or frame already
+		// replayed. It stays in the session replay buffer until acked.
+		return
+	}
+	if chunk.Seq > next {
+		// Frames in between were dropped from the full output queue,
+		// possibly by the output of another session. Frames are cell
+		// deltas, so the client needs them before this one.
+		g.fillOutputGap(ctx, chunk.SessionID, chunk.Seq)
+		if chunk.Seq < g.streamNext[chunk.SessionID] {
+			return
+		}
+	}
+	if err := g.sendTerminalFrame(ctx, chunk); err != nil {
+		// Not connected – drop frame (replayed from the buffer on reconnect)
+		g.log.Debug("drop terminal frame (not connected)", "session", chunk.SessionID)
+		return
+	}
+	g.streamNext[chunk.SessionID] = chunk.Seq + 1
+}
+
+// catchUpOutputStreams sends the frames of streams whose latest frames were
+// dropped from the output queue, which no later frame would bring up.
+func (g *gateway) catchUpOutputStreams(ctx context.Context) {
+	g.streamMu.Lock()
+	defer g.streamMu.Unlock()
+
+	for sessionID, next := range g.streamNext {
+		sess := g.sessions.Get(sessionID)
+		if sess == nil {
+			continue
+		}
+		if upTo := sess.NextSeq(); next < upTo {
+			g.fillOutputGap(ctx, sessionID, upTo)
+		}
+	}
+}
+
+// fillOutputGap sends the frames of a stream from its next seq up to, not
+// including, upTo from the session's replay buffer, or a snapshot when they
+// are no longer buffered. It must be called with streamMu held.
+func (g *gateway) fillOutputGap(ctx context.Context, sessionID string, upTo uint64) {
+	sess := g.sessions.Get(sessionID)
+	if sess == nil {
+		return
+	}
+	frames, ok := sess.Frames(g.streamNext[sessionID])
+	if !ok {
+		g.log.Info("output frames lost, sending snapshot", "session_id", sessionID)
+		if next, ok := g.sendSnapshot(ctx, sessionID, sess, true); ok {
+			g.streamNext[sessionID] = next
+		}
+		return
+	}
+	for _, frame := range frames {
+		if frame.Seq >= upTo {
+			return
+		}
+		if err := g.sendTerminalFrame(ctx, frame); err != nil {
+			return
 		}
+		g.streamNext[sessionID] = frame.Seq + 1
 	}
 }
 
+func (g *gateway) sendTerminalFrame(ctx context.Context, chunk session.OutputChunk) error {
+	frame, err := encodeTerminalFrame(chunk.SessionID, chunk.Seq, chunk.Data)
+	if err != nil {
+		g.log.Warn("encode terminal frame failed", "err", err)
+		return err
+	}
+	return g.wsClient.SendBinary(ctx, frame)
+}
+
 // runHealthTicker sends gateway.health on the configured interval.
 func (g *gateway) runHealthTicker(ctx context.Context) {
 	// Warm up CPU baseline
@@ -894,10 +1976,17 @@ func (g *gateway) sendHealth(ctx context.Context) {
 	activeSessions := g.sessions.List()
 	sessions := make([]map[string]any, 0, len(activeSessions))
 	for _, s := range activeSessions {
-		sessions = append(sessions, map[string]any{
-			"session_id":       s.SessionID,
-			"last_activity_at": s.LastActivityAt.Format(time.RFC3339),
-		})
+		payload := sessionSummaryPayload(s)
+		if grp := g.sessionGroup(s.SessionID); grp != nil {
+			payload["usage"] = usagePayload(grp.Usage())
+		}
+		if s.PollInterval > 0 {
+			payload["poll_rate_hz"] = float64(time.Second) / float64(s.PollInterval)
+		}
+		if n := g.subscriberCount(s.SessionID); n > 0 {
+			payload["subscribers"] = n
+		}
+		sessions = append(sessions, payload)
 	}
 	g.sendEvent(ctx, map[string]any{
 		"type":             "gateway.health",
@@ -909,6 +1998,7 @@ func (g *gateway) sendHealth(ctx context.Context) {
 		"disk_used_bytes":  m.DiskUsedBytes,
 		"disk_total_bytes": m.DiskTotalBytes,
 		"uptime_seconds":   m.UptimeSeconds,
+		"tmux_execs":       session.TmuxExecs(),
 		"active_sessions":  sessions,
 	})
 }
@@ -941,8 +2031,98 @@ func trimSnapshotTail(content string, maxBytes int) string {
 	return prefix + string(tail)
 }
 
+// trimHistoryHead drops whole lines from the start of content until it fits
+// maxBytes and returns the number of lines dropped, so the page keeps the
+// lines closest to the end of the requested range.
+func trimHistoryHead(content string, maxBytes int) (string, int) {
+	dropped := 0
+	for maxBytes > 0 && len(content) > maxBytes {
+		i := strings.IndexByte(content, '\n')
+		if i < 0 {
+			return "", dropped + 1
+		}
+		content = content[i+1:]
+		dropped++
+	}
+	return content, dropped
+}
+
 // ----- Helpers -----
 
+func sessionEndedEvent(sessionID string, status session.ExitStatus) map[string]any {
+	evt := map[string]any{
+		"type":       "session.ended",
+		"session_id": sessionID,
+	}
+	if status.Known {
+		evt["exit_code"] = status.Code
+	}
+	if status.Reason != "" {
+		evt["reason"] = string(status.Reason)
+	}
+	return evt
+}
+
+func sessionSummaryPayload(s session.Summary) map[string]any {
+	out := map[string]any{
+		"session_id":       s.SessionID,
+		"name":             s.Name,
+		"last_activity_at": s.LastActivityAt.Format(time.RFC3339),
+	}
+	if s.Agent != "" {
+		out["agent"] = s.Agent
+	}
+	if s.Workdir != "" {
+		out["workdir"] = s.Workdir
+	}
+	if !s.CreatedAt.IsZero() {
+		out["created_at"] = s.CreatedAt.Format(time.RFC3339)
+	}
+	if s.Backend != "" {
+		out["backend"] = string(s.Backend)
+	}
+	return out
+}
+
+func sessionDetailsPayload(d session.Details) map[string]any {
+	out := sessionSummaryPayload(d.Summary)
+	out["recovered"] = d.Recovered
+	if d.Recording {
+		out["recording"] = true
+	}
+	if d.Cols > 0 && d.Rows > 0 {
+		out["cols"] = d.Cols
+		out["rows"] = d.Rows
+	}
+	if len(d.PanePIDs) > 0 {
+		out["pane_pids"] = d.PanePIDs
+	}
+	if d.CurrentCommand != "" {
+		out["current_command"] = d.CurrentCommand
+	}
+	return out
+}
+
+func usagePayload(u cgroup.Usage) map[string]any {
+	return map[string]any{
+		"cpu_usage_usec": u.CPUUsageUsec,
+		"memory_bytes":   u.MemoryBytes,
+		"pids":           u.PIDs,
+	}
+}
+
+func instructionFilesPayload(files []session.InstructionFile) []map[string]any {
+	out := make([]map[string]any, 0, len(files))
+	for _, f := range files {
+		out = append(out, map[string]any{
+			"name":   f.Name,
+			"path":   f.Path,
+			"action": f.Action,
+		})
+	}
+	return out
+}
+
 // encodeTerminalFrame packs PTY output into a binary protocol frame.
 // Layout: [kind:1][session_id_len:1][session_id:N][seq:8][payload:M]
 func encodeTerminalFrame(sessionID string, seq uint64, payload []byte) ([]byte, error) {
@@ -952,7 +2132,7 @@ func encodeTerminalFrame(sessionID string, seq uint64, payload []byte) ([]byte,
 	}
 	buf := make([]byte, 1+1+len(idBytes)+8+len(payload))
 	offset := 0
-	buf[offset] = 0x01
+	buf[offset] = frameKindTerminalOutput
 	offset++
 	buf[offset] = byte(len(idBytes))
 	offset++
@@ -967,6 +2147,29 @@ func encodeTerminalFrame(sessionID string, seq uint64, payload []byte) ([]byte,
 	return buf, nil
 }
 
+// decodeInputFrame unpacks a terminal input frame sent by the control plane.
+// Layout: [kind:1][session_id_len:1][session_id:N][seq:8][payload:M]
+func decodeInputFrame(data []byte) (sessionID string, seq uint64, payload []byte, err error) {
+	if len(data) < 2 {
+		return "", 0, nil, fmt.Errorf("frame too short")
+	}
+	if data[0] != frameKindTerminalInput {
+		return "", 0, nil, fmt.Errorf("unexpected frame kind 0x%02x", data[0])
+	}
+	idLen := int(data[1])
+	offset := 2
+	if len(data) < offset+idLen+8 {
+		return "", 0, nil, fmt.Errorf("frame truncated")
+	}
+	sessionID = string(data[offset : offset+idLen])
+	offset += idLen
+	for i := 0; i < 8; i++ {
+		seq = seq<<8 | uint64(data[offset+i])
+	}
+	offset += 8
+	return sessionID, seq, data[offset:], nil
+}
+
 func newLogger(level string) *slog.Logger {
 	var l slog.Level
 	switch level {
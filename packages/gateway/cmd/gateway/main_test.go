@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/tractorfm/chatcode/packages/gateway/internal/config"
 	"github.com/tractorfm/chatcode/packages/gateway/internal/health"
+	"github.com/tractorfm/chatcode/packages/gateway/internal/session"
 )
 
 func TestBuildHelloEventIncludesBYOFields(t *testing.T) {
@@ -127,3 +132,131 @@ func TestResolveWorkspaceRootKeepsWorkspaceHome(t *testing.T) {
 		t.Fatalf("resolveWorkspaceRoot = %q, want %q", got, "/home/vibe/workspace")
 	}
 }
+
+func TestSessionSummaryPayloadIncludesMetadata(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := sessionSummaryPayload(session.Summary{
+		SessionID:      "ses-1",
+		Name:           "api refactor",
+		Agent:          "codex",
+		Workdir:        "/home/vibe/workspace/api",
+		CreatedAt:      created,
+		LastActivityAt: created.Add(time.Minute),
+	})
+	want := map[string]any{
+		"session_id":       "ses-1",
+		"name":             "api refactor",
+		"agent":            "codex",
+		"workdir":          "/home/vibe/workspace/api",
+		"created_at":       "2026-01-02T03:04:05Z",
+		"last_activity_at": "2026-01-02T03:05:05Z",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("sessionSummaryPayload = %#v, want %#v", got, want)
+	}
+
+	legacy := sessionSummaryPayload(session.Summary{SessionID: "ses-2", Name: "ses-2"})
+	for _, key := range []string{"agent", "workdir", "created_at"} {
+		if _, ok := legacy[key]; ok {
+			t.Fatalf("expected %q to be omitted for sessions without metadata, got %#v", key, legacy)
+		}
+	}
+}
+
+func TestSessionEndedEventExitCode(t *testing.T) {
+	got := sessionEndedEvent("ses-1", session.ExitStatus{Code: 0, Known: true})
+	if code, ok := got["exit_code"]; !ok || code != 0 {
+		t.Fatalf("expected exit_code 0 for a clean exit, got %#v", got)
+	}
+
+	got = sessionEndedEvent("ses-1", session.ExitStatus{})
+	if _, ok := got["exit_code"]; ok {
+		t.Fatalf("expected exit_code to be omitted when unknown, got %#v", got)
+	}
+}
+
+func TestSessionEndedEventReason(t *testing.T) {
+	got := sessionEndedEvent("ses-1", session.ExitStatus{Reason: session.EndReasonIdleTimeout})
+	if got["reason"] != "idle_timeout" {
+		t.Fatalf("reason = %#v, want idle_timeout", got["reason"])
+	}
+}
+
+func TestSessionDetailsPayload(t *testing.T) {
+	got := sessionDetailsPayload(session.Details{
+		Summary:        session.Summary{SessionID: "ses-1", Name: "ses-1"},
+		Cols:           120,
+		Rows:           40,
+		PanePIDs:       []int{4242},
+		CurrentCommand: "claude",
+		Recovered:      true,
+	})
+	if got["cols"] != 120 || got["rows"] != 40 || got["current_command"] != "claude" || got["recovered"] != true {
+		t.Fatalf("unexpected payload: %#v", got)
+	}
+	if !reflect.DeepEqual(got["pane_pids"], []int{4242}) {
+		t.Fatalf("pane_pids = %#v, want [4242]", got["pane_pids"])
+	}
+
+	gone := sessionDetailsPayload(session.Details{Summary: session.Summary{SessionID: "ses-2"}})
+	for _, key := range []string{"cols", "rows", "pane_pids", "current_command"} {
+		if _, ok := gone[key]; ok {
+			t.Fatalf("expected %q to be omitted when tmux could not be queried, got %#v", key, gone)
+		}
+	}
+}
+
+func TestDecodeInputFrame(t *testing.T) {
+	frame, err := encodeTerminalFrame("ses-1", 258, []byte("ls\r"))
+	if err != nil {
+		t.Fatalf("encodeTerminalFrame: %v", err)
+	}
+	frame[0] = frameKindTerminalInput
+	sessionID, seq, payload, err := decodeInputFrame(frame)
+	if err != nil {
+		t.Fatalf("decodeInputFrame: %v", err)
+	}
+	if sessionID != "ses-1" || seq != 258 || string(payload) != "ls\r" {
+		t.Fatalf("decoded %q, %d, %q", sessionID, seq, payload)
+	}
+
+	if _, _, _, err := decodeInputFrame(frame[:10]); err == nil {
+		t.Fatal("expected truncated frame to fail")
+	}
+	frame[0] = frameKindTerminalOutput
+	if _, _, _, err := decodeInputFrame(frame); err == nil {
+		t.Fatal("expected output frame to be rejected")
+	}
+}
+
+func TestTrimHistoryHeadDropsOldestLines(t *testing.T) {
+	content := "line 1\nline 2\nline 3\n"
+	got, dropped := trimHistoryHead(content, 14)
+	if got != "line 2\nline 3\n" || dropped != 1 {
+		t.Fatalf("trimHistoryHead = %q, %d", got, dropped)
+	}
+	if got, dropped := trimHistoryHead(content, 0); got != content || dropped != 0 {
+		t.Fatalf("trimHistoryHead without limit = %q, %d", got, dropped)
+	}
+}
+
+func TestSessionSearchRejectedWhenSlotsFull(t *testing.T) {
+	g := &gateway{
+		sessions: session.NewManager(1),
+		searches: make(chan struct{}, 1),
+	}
+	raw := []byte(`{"request_id":"req-1","session_id":"ses-missing","query":"x"}`)
+
+	// A rejected lookup gives its slot back.
+	if err := g.handleSessionSearch(context.Background(), raw); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("err = %v, want session not found", err)
+	}
+	if len(g.searches) != 0 {
+		t.Fatalf("search slots in use = %d, want 0", len(g.searches))
+	}
+
+	g.searches <- struct{}{}
+	if err := g.handleSessionSearch(context.Background(), raw); err == nil || !strings.Contains(err.Error(), "too many searches") {
+		t.Fatalf("err = %v, want too many searches", err)
+	}
+}
@@ -434,6 +434,10 @@ EnvironmentFile=${ENV_FILE}
 Restart=on-failure
 RestartSec=5s
 KillMode=process
+# Let the gateway manage the cgroups that hold per-session resource limits.
+# DelegateSubgroup (systemd >= 254) starts it in its own leaf cgroup.
+Delegate=cpu memory pids
+DelegateSubgroup=gateway
 
 # Keep service environment close to host defaults so explicitly-approved sudo
 # commands behave the same as on a normal SSH shell.
@@ -233,6 +233,12 @@ test_service_template_preserves_tmux_children() {
   assert_contains "${SERVICE_TEMPLATE}" "KillMode=process"
 }
 
+test_service_units_delegate_session_cgroups() {
+  assert_contains "${SERVICE_TEMPLATE}" "Delegate=cpu memory pids"
+  assert_contains "${INSTALL_SCRIPT}" "Delegate=cpu memory pids"
+  assert_contains "${INSTALL_SCRIPT}" "DelegateSubgroup=gateway"
+}
+
 test_linux_installer_service_unit_preserves_tmux_children() {
   assert_contains "${INSTALL_SCRIPT}" "KillMode=process"
   assert_contains "${INSTALL_SCRIPT}" "PrivateTmp=false"
@@ -309,6 +315,7 @@ main() {
   test_agent_installers_seed_global_guidance_without_overwrite
   test_installer_prints_darwin_prep_guidance
   test_service_template_preserves_tmux_children
+  test_service_units_delegate_session_cgroups
   test_linux_installer_service_unit_preserves_tmux_children
   echo "[gateway-install.test] PASS"
 }
@@ -0,0 +1,298 @@
+// Package cgroup confines session process trees in cgroup v2 groups to
+// enforce CPU, memory and pids limits.
+//
+// The gateway needs a delegated cgroup subtree (systemd Delegate=yes). It
+// keeps itself and the tmux server in a "gateway" leaf and creates one
+// sibling group per session:
+//
+//	<service cgroup>/gateway         gateway process, tmux server
+//	<service cgroup>/session-<id>    one session's pane processes
+//
+// On hosts without cgroup v2 or delegation Setup fails and the gateway runs
+// sessions without limits.
+package cgroup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	mountPoint    = "/sys/fs/cgroup"
+	gatewayLeaf   = "gateway"
+	groupPrefix   = "session-"
+	cpuPeriodUs   = 100000
+	directoryMode = 0o755
+)
+
+// controllers are the controllers enabled for session groups.
+var controllers = []string{"cpu", "memory", "pids"}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// ErrUnavailable is returned by Setup when cgroup v2 is not mounted or the
+// gateway's cgroup is not delegated to it.
+var ErrUnavailable = errors.New("cgroup v2 delegation unavailable")
+
+// Limits caps the resources of one session. Zero values mean no limit.
+type Limits struct {
+	// CPUCores is the CPU bandwidth in cores, e.g. 1.5.
+	CPUCores float64
+	// MemoryBytes caps memory; the kernel OOM-kills within the session
+	// when it is exceeded.
+	MemoryBytes int64
+	// PIDs caps the number of processes and threads.
+	PIDs int64
+}
+
+// IsZero reports whether l sets no limit.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Usage is the current resource usage of a session group.
+type Usage struct {
+	CPUUsageUsec uint64
+	MemoryBytes  uint64
+	PIDs         uint64
+}
+
+// Manager creates session groups under the gateway's delegated cgroup.
+type Manager struct {
+	base    string
+	enabled map[string]bool
+}
+
+// Setup prepares the gateway's cgroup for session groups: it moves the
+// processes of the service cgroup into the gateway leaf and enables the
+// cpu, memory and pids controllers for its children.
+func Setup() (*Manager, error) {
+	if _, err := os.Stat(filepath.Join(mountPoint, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%w: cgroup v2 is not mounted at %s", ErrUnavailable, mountPoint)
+	}
+	raw, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	self, ok := parseProcCgroup(string(raw))
+	if !ok {
+		return nil, fmt.Errorf("%w: no unified hierarchy entry in /proc/self/cgroup", ErrUnavailable)
+	}
+	if self == "/" {
+		return nil, fmt.Errorf("%w: gateway runs in the root cgroup", ErrUnavailable)
+	}
+	base := filepath.Join(mountPoint, self)
+	// With systemd's DelegateSubgroup=gateway the gateway already starts in
+	// the leaf.
+	if filepath.Base(base) == gatewayLeaf {
+		base = filepath.Dir(base)
+	}
+	m := &Manager{base: base, enabled: make(map[string]bool)}
+	if err := m.prepare(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return m, nil
+}
+
+func (m *Manager) prepare() error {
+	leaf := filepath.Join(m.base, gatewayLeaf)
+	if err := os.MkdirAll(leaf, directoryMode); err != nil {
+		return err
+	}
+	// cgroup v2 only allows controllers for children of a group without
+	// processes of its own.
+	pids, err := readPIDs(filepath.Join(m.base, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := writeFile(filepath.Join(leaf, "cgroup.procs"), strconv.Itoa(pid)); err != nil && !isGone(err) {
+			return fmt.Errorf("move pid %d to %s: %w", pid, leaf, err)
+		}
+	}
+
+	raw, err := os.ReadFile(filepath.Join(m.base, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := make(map[string]bool)
+	for _, c := range strings.Fields(string(raw)) {
+		available[c] = true
+	}
+	var enable []string
+	for _, c := range controllers {
+		if available[c] {
+			enable = append(enable, "+"+c)
+			m.enabled[c] = true
+		}
+	}
+	if len(enable) == 0 {
+		return fmt.Errorf("none of %s are delegated", strings.Join(controllers, ", "))
+	}
+	return writeFile(filepath.Join(m.base, "cgroup.subtree_control"), strings.Join(enable, " "))
+}
+
+// Create creates (or reuses) the group of a session and applies l.
+// Limits whose controller is not delegated are skipped.
+func (m *Manager) Create(sessionID string, l Limits) (*Group, error) {
+	g := m.group(sessionID)
+	if err := os.MkdirAll(g.path, directoryMode); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	if err := m.apply(g, l); err != nil {
+		_ = os.Remove(g.path)
+		return nil, err
+	}
+	return g, nil
+}
+
+// Open returns the existing group of a session, e.g. after a gateway
+// restart.
+func (m *Manager) Open(sessionID string) (*Group, bool) {
+	g := m.group(sessionID)
+	if fi, err := os.Stat(g.path); err != nil || !fi.IsDir() {
+		return nil, false
+	}
+	return g, true
+}
+
+// Prune removes the groups of sessions other than keep, e.g. left behind by
+// sessions that ended while the gateway was down. Groups that still hold
+// processes are kept.
+func (m *Manager) Prune(keep []string) {
+	entries, err := os.ReadDir(m.base)
+	if err != nil {
+		return
+	}
+	active := make(map[string]bool, len(keep))
+	for _, sessionID := range keep {
+		active[filepath.Base(m.group(sessionID).path)] = true
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), groupPrefix) || active[e.Name()] {
+			continue
+		}
+		_ = os.Remove(filepath.Join(m.base, e.Name()))
+	}
+}
+
+func (m *Manager) group(sessionID string) *Group {
+	name := groupPrefix + unsafeNameChars.ReplaceAllString(sessionID, "_")
+	return &Group{path: filepath.Join(m.base, name)}
+}
+
+func (m *Manager) apply(g *Group, l Limits) error {
+	if m.enabled["cpu"] {
+		v := "max"
+		if l.CPUCores > 0 {
+			v = strconv.FormatInt(int64(l.CPUCores*cpuPeriodUs), 10)
+		}
+		if err := writeFile(filepath.Join(g.path, "cpu.max"), fmt.Sprintf("%s %d", v, cpuPeriodUs)); err != nil {
+			return fmt.Errorf("set cpu.max: %w", err)
+		}
+	}
+	if m.enabled["memory"] {
+		if err := writeFile(filepath.Join(g.path, "memory.max"), limitValue(l.MemoryBytes)); err != nil {
+			return fmt.Errorf("set memory.max: %w", err)
+		}
+	}
+	if m.enabled["pids"] {
+		if err := writeFile(filepath.Join(g.path, "pids.max"), limitValue(l.PIDs)); err != nil {
+			return fmt.Errorf("set pids.max: %w", err)
+		}
+	}
+	return nil
+}
+
+// Group is the cgroup of one session.
+type Group struct {
+	path string
+}
+
+// ProcsPath returns the group's cgroup.procs file. A process writing its
+// own pid there moves into the group, and everything it forks afterwards
+// starts in it.
+func (g *Group) ProcsPath() string {
+	return filepath.Join(g.path, "cgroup.procs")
+}
+
+// Usage reads the group's current resource usage. Counters of controllers
+// that are not enabled read as zero.
+func (g *Group) Usage() Usage {
+	var u Usage
+	u.MemoryBytes = readUint(filepath.Join(g.path, "memory.current"))
+	u.PIDs = readUint(filepath.Join(g.path, "pids.current"))
+	if raw, err := os.ReadFile(filepath.Join(g.path, "cpu.stat")); err == nil {
+		for _, line := range strings.Split(string(raw), "\n") {
+			if v, ok := strings.CutPrefix(line, "usage_usec "); ok {
+				u.CPUUsageUsec, _ = strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+				break
+			}
+		}
+	}
+	return u
+}
+
+// Remove deletes the group. It fails while processes remain in it.
+func (g *Group) Remove() error {
+	if err := os.Remove(g.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// parseProcCgroup returns the unified hierarchy path from /proc/<pid>/cgroup.
+func parseProcCgroup(raw string) (string, bool) {
+	for _, line := range strings.Split(strings.TrimSpace(raw), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func limitValue(n int64) string {
+	if n <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func readPIDs(path string) ([]int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, f := range strings.Fields(string(raw)) {
+		if pid, err := strconv.Atoi(f); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func readUint(path string) uint64 {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, _ := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+	return v
+}
+
+func writeFile(path, value string) error {
+	return os.WriteFile(path, []byte(value), 0o644)
+}
+
+// isGone reports whether a write to cgroup.procs failed because the process
+// already exited.
+func isGone(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,126 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeHierarchy lays out plain files the way cgroupfs presents them.
+func fakeHierarchy(t *testing.T, controllers string, files map[string]string) *Manager {
+	t.Helper()
+	base := t.TempDir()
+	files["cgroup.controllers"] = controllers
+	for name, content := range files {
+		path := filepath.Join(base, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Manager{base: base, enabled: make(map[string]bool)}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(raw)
+}
+
+func TestPrepareMovesProcessesAndEnablesControllers(t *testing.T) {
+	m := fakeHierarchy(t, "cpuset cpu io memory pids", map[string]string{"cgroup.procs": "4242\n"})
+	if err := m.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if got := readFile(t, filepath.Join(m.base, gatewayLeaf, "cgroup.procs")); got != "4242" {
+		t.Fatalf("gateway leaf procs = %q, want 4242", got)
+	}
+	if got := readFile(t, filepath.Join(m.base, "cgroup.subtree_control")); got != "+cpu +memory +pids" {
+		t.Fatalf("subtree_control = %q", got)
+	}
+}
+
+func TestPrepareFailsWithoutDelegatedControllers(t *testing.T) {
+	m := fakeHierarchy(t, "cpuset io", map[string]string{"cgroup.procs": ""})
+	if err := m.prepare(); err == nil {
+		t.Fatal("expected prepare to fail without cpu, memory or pids")
+	}
+}
+
+func TestCreateAppliesLimits(t *testing.T) {
+	m := fakeHierarchy(t, "cpu memory pids", map[string]string{"cgroup.procs": ""})
+	if err := m.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	g, err := m.Create("ses-1", Limits{CPUCores: 1.5, MemoryBytes: 512 << 20})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if filepath.Base(g.path) != "session-ses-1" {
+		t.Fatalf("group path = %s", g.path)
+	}
+	want := map[string]string{
+		"cpu.max":    "150000 100000",
+		"memory.max": "536870912",
+		"pids.max":   "max",
+	}
+	for name, value := range want {
+		if got := readFile(t, filepath.Join(g.path, name)); got != value {
+			t.Fatalf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if _, ok := m.Open("ses-1"); !ok {
+		t.Fatal("expected Open to find the created group")
+	}
+	if _, ok := m.Open("ses-2"); ok {
+		t.Fatal("expected Open to miss an unknown session")
+	}
+}
+
+func TestCreateSkipsUndelegatedControllers(t *testing.T) {
+	m := fakeHierarchy(t, "memory", map[string]string{"cgroup.procs": ""})
+	if err := m.prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	g, err := m.Create("ses-1", Limits{CPUCores: 2, PIDs: 256})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(g.path, "cpu.max")); !os.IsNotExist(err) {
+		t.Fatal("cpu.max should not be written without the cpu controller")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"memory.current": "1048576\n",
+		"pids.current":   "7\n",
+		"cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := (&Group{path: dir}).Usage()
+	if got != (Usage{CPUUsageUsec: 2500000, MemoryBytes: 1 << 20, PIDs: 7}) {
+		t.Fatalf("Usage = %+v", got)
+	}
+}
+
+func TestParseProcCgroup(t *testing.T) {
+	path, ok := parseProcCgroup("0::/system.slice/chatcode-gateway.service/gateway\n")
+	if !ok || path != "/system.slice/chatcode-gateway.service/gateway" {
+		t.Fatalf("parseProcCgroup = %q, %v", path, ok)
+	}
+	if _, ok := parseProcCgroup(strings.Join([]string{"4:memory:/x", "1:cpu:/"}, "\n")); ok {
+		t.Fatal("expected cgroup v1 entries to be ignored")
+	}
+}
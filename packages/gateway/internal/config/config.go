@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -44,6 +45,77 @@ type Config struct {
 
 	// LogLevel: "debug", "info", "warn", "error". Default "info".
 	LogLevel string `json:"log_level"`
+
+	// InstructionsPolicy controls how CLAUDE.md/AGENTS.md are written into
+	// session workdirs: "keep", "overwrite" or "managed". Default "managed".
+	// session.create may override it per session.
+	InstructionsPolicy string `json:"instructions_policy"`
+
+	// SessionEnvAllowlist, when non-empty, restricts which gateway process
+	// variables sessions inherit (exact names or PREFIX* patterns). PATH,
+	// HOME and the other basics of a login environment are always inherited;
+	// gateway variables (GATEWAY_*) never are.
+	SessionEnvAllowlist []string `json:"session_env_allowlist,omitempty"`
+
+	// SessionEnv holds default environment variables set in every session.
+	// Variables sent with session.create take precedence.
+	SessionEnv map[string]string `json:"session_env,omitempty"`
+
+	// SessionBackend selects what runs new sessions: "tmux" runs each in a
+	// tmux session that survives gateway restarts, "native" on a PTY of the
+	// gateway's own that needs no tmux but ends with the gateway. session.create
+	// may pick either. Default "tmux".
+	SessionBackend string `json:"session_backend"`
+
+	// TmuxSocket is the socket of the gateway's own tmux server: a name in
+	// the tmux socket directory, or a path when it contains a slash. Sessions
+	// found on the default tmux server, where older gateways ran them, are
+	// still recovered. Default "chatcode".
+	TmuxSocket string `json:"tmux_socket"`
+
+	// CaptureMode selects how session output is captured: "poll" diffs
+	// capture-pane screens on a ticker, "stream" forwards the raw pane byte
+	// stream via pipe-pane and falls back to polling if the pipe fails.
+	// Default "poll".
+	CaptureMode string `json:"capture_mode"`
+
+	// ResizePolicy decides the window size of a session viewed by several
+	// clients: "latest-active" follows the client that resized last,
+	// "smallest" and "largest" fit the smallest or largest viewport, and
+	// COLSxROWS pins the size. Default "latest-active".
+	ResizePolicy string `json:"resize_policy"`
+
+	// DataDir holds persistent gateway state such as session recordings.
+	// Default ~/.local/share/chatcode.
+	DataDir string `json:"data_dir"`
+
+	// RecordSessions records every session to an asciicast file unless
+	// session.create says otherwise. Default false.
+	RecordSessions bool `json:"record_sessions"`
+
+	// RecordingMaxFileBytes caps one recording file; longer recordings
+	// continue in a new file. Default 16 MiB, at most MaxRecordingFileBytes.
+	RecordingMaxFileBytes int64 `json:"recording_max_file_bytes"`
+
+	// RecordingMaxTotalBytes caps all recordings together; the oldest are
+	// deleted first. 0 disables the cap. Default 1 GiB.
+	RecordingMaxTotalBytes int64 `json:"recording_max_total_bytes"`
+
+	// SessionIdleTimeout ends sessions without input or output for this
+	// long. 0 disables it. Default 0.
+	SessionIdleTimeout time.Duration `json:"session_idle_timeout"`
+
+	// SessionMaxLifetime ends sessions this long after they were created.
+	// 0 disables it. Default 0.
+	SessionMaxLifetime time.Duration `json:"session_max_lifetime"`
+
+	// SessionEndOnAgentExit ends sessions when their agent exits instead of
+	// leaving the fallback shell running. Default false.
+	SessionEndOnAgentExit bool `json:"session_end_on_agent_exit"`
+
+	// SessionExpiryWarning is how long before a policy ends a session the
+	// gateway sends session.expiring. Default 1m.
+	SessionExpiryWarning time.Duration `json:"session_expiry_warning"`
 }
 
 const (
@@ -51,10 +123,19 @@ const (
 	CPURLStaging       = "wss://cp.staging.chatcode.dev/gw/connect"
 	DefaultMaxSessions = 50
 	HardMaxSessions    = 50
+
+	DefaultRecordingMaxFileBytes  = 16 << 20
+	DefaultRecordingMaxTotalBytes = 1 << 30
+	// MaxRecordingFileBytes keeps recording files within the file transfer
+	// size limit so they can be downloaded.
+	MaxRecordingFileBytes = 20 << 20
 )
 
 var gatewayIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
 
+// pinnedSizePattern matches a pinned ResizePolicy such as 120x40.
+var pinnedSizePattern = regexp.MustCompile(`^[1-9][0-9]*x[1-9][0-9]*$`)
+
 // CPURLSelfHost can be injected at build time for self-host releases:
 //
 //	-X github.com/tractorfm/chatcode/packages/gateway/internal/config.CPURLSelfHost=wss://cp.example.com/gw/connect
@@ -66,7 +147,15 @@ var CPURLSelfHost = ""
 // Required env vars: GATEWAY_ID, GATEWAY_AUTH_TOKEN, GATEWAY_CP_URL.
 // Optional: GATEWAY_HEALTH_INTERVAL, GATEWAY_MAX_SESSIONS, GATEWAY_TEMP_DIR,
 // GATEWAY_BINARY_PATH, GATEWAY_LOG_LEVEL,
-// GATEWAY_BOOTSTRAP_TOKEN.
+// GATEWAY_BOOTSTRAP_TOKEN, GATEWAY_INSTRUCTIONS_POLICY,
+// GATEWAY_SESSION_ENV_ALLOWLIST (comma-separated names),
+// GATEWAY_SESSION_ENV (comma-separated KEY=VALUE pairs),
+// GATEWAY_SESSION_BACKEND, GATEWAY_TMUX_SOCKET, GATEWAY_CAPTURE_MODE,
+// GATEWAY_RESIZE_POLICY, GATEWAY_DATA_DIR,
+// GATEWAY_RECORD_SESSIONS,
+// GATEWAY_RECORDING_MAX_FILE_BYTES, GATEWAY_RECORDING_MAX_TOTAL_BYTES,
+// GATEWAY_SESSION_IDLE_TIMEOUT, GATEWAY_SESSION_MAX_LIFETIME,
+// GATEWAY_SESSION_END_ON_AGENT_EXIT, GATEWAY_SESSION_EXPIRY_WARNING.
 func Load(configFile string) (*Config, error) {
 	cfg := defaults()
 
@@ -92,7 +181,27 @@ func defaults() *Config {
 		TempDir:        "/tmp/chatcode",
 		BinaryPath:     exe,
 		LogLevel:       "info",
+
+		InstructionsPolicy: "managed",
+		SessionBackend:     "tmux",
+		TmuxSocket:         "chatcode",
+		CaptureMode:        "poll",
+		ResizePolicy:       "latest-active",
+
+		DataDir:                defaultDataDir(),
+		RecordingMaxFileBytes:  DefaultRecordingMaxFileBytes,
+		RecordingMaxTotalBytes: DefaultRecordingMaxTotalBytes,
+
+		SessionExpiryWarning: time.Minute,
+	}
+}
+
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return "/var/lib/chatcode"
 	}
+	return filepath.Join(home, ".local", "share", "chatcode")
 }
 
 func loadFile(cfg *Config, path string) error {
@@ -136,6 +245,88 @@ func applyEnv(cfg *Config) {
 	if v := os.Getenv("GATEWAY_LOG_LEVEL"); v != "" {
 		cfg.LogLevel = v
 	}
+	if v := os.Getenv("GATEWAY_INSTRUCTIONS_POLICY"); v != "" {
+		cfg.InstructionsPolicy = v
+	}
+	if v := os.Getenv("GATEWAY_SESSION_ENV_ALLOWLIST"); v != "" {
+		cfg.SessionEnvAllowlist = splitList(v)
+	}
+	if v := os.Getenv("GATEWAY_SESSION_ENV"); v != "" {
+		cfg.SessionEnv = parseEnvPairs(v)
+	}
+	if v := os.Getenv("GATEWAY_SESSION_BACKEND"); v != "" {
+		cfg.SessionBackend = v
+	}
+	if v := os.Getenv("GATEWAY_TMUX_SOCKET"); v != "" {
+		cfg.TmuxSocket = v
+	}
+	if v := os.Getenv("GATEWAY_CAPTURE_MODE"); v != "" {
+		cfg.CaptureMode = v
+	}
+	if v := os.Getenv("GATEWAY_RESIZE_POLICY"); v != "" {
+		cfg.ResizePolicy = v
+	}
+	if v := os.Getenv("GATEWAY_DATA_DIR"); v != "" {
+		cfg.DataDir = v
+	}
+	if v := os.Getenv("GATEWAY_RECORD_SESSIONS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.RecordSessions = b
+		}
+	}
+	if v := os.Getenv("GATEWAY_RECORDING_MAX_FILE_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.RecordingMaxFileBytes = n
+		}
+	}
+	if v := os.Getenv("GATEWAY_RECORDING_MAX_TOTAL_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.RecordingMaxTotalBytes = n
+		}
+	}
+	if v := os.Getenv("GATEWAY_SESSION_IDLE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.SessionIdleTimeout = d
+		}
+	}
+	if v := os.Getenv("GATEWAY_SESSION_MAX_LIFETIME"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.SessionMaxLifetime = d
+		}
+	}
+	if v := os.Getenv("GATEWAY_SESSION_END_ON_AGENT_EXIT"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.SessionEndOnAgentExit = b
+		}
+	}
+	if v := os.Getenv("GATEWAY_SESSION_EXPIRY_WARNING"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.SessionExpiryWarning = d
+		}
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func parseEnvPairs(v string) map[string]string {
+	out := make(map[string]string)
+	for _, item := range splitList(v) {
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		out[key] = value
+	}
+	return out
 }
 
 func (c *Config) validate() error {
@@ -157,6 +348,54 @@ func (c *Config) validate() error {
 	if c.MaxSessions > HardMaxSessions {
 		return fmt.Errorf("GATEWAY_MAX_SESSIONS must be <= %d", HardMaxSessions)
 	}
+	switch c.InstructionsPolicy {
+	case "keep", "overwrite", "managed":
+	default:
+		return fmt.Errorf("GATEWAY_INSTRUCTIONS_POLICY must be one of keep, overwrite, managed")
+	}
+	switch c.SessionBackend {
+	case "tmux", "native":
+	default:
+		return fmt.Errorf("GATEWAY_SESSION_BACKEND must be one of tmux, native")
+	}
+	if c.TmuxSocket == "" {
+		return fmt.Errorf("GATEWAY_TMUX_SOCKET is required")
+	}
+	switch c.CaptureMode {
+	case "poll", "stream":
+	default:
+		return fmt.Errorf("GATEWAY_CAPTURE_MODE must be one of poll, stream")
+	}
+	switch c.ResizePolicy {
+	case "latest-active", "smallest", "largest":
+	default:
+		if !pinnedSizePattern.MatchString(c.ResizePolicy) {
+			return fmt.Errorf("GATEWAY_RESIZE_POLICY must be one of latest-active, smallest, largest or COLSxROWS")
+		}
+	}
+	if c.DataDir == "" {
+		return fmt.Errorf("GATEWAY_DATA_DIR is required")
+	}
+	if c.RecordingMaxFileBytes < 1 || c.RecordingMaxFileBytes > MaxRecordingFileBytes {
+		return fmt.Errorf("GATEWAY_RECORDING_MAX_FILE_BYTES must be between 1 and %d", MaxRecordingFileBytes)
+	}
+	if c.RecordingMaxTotalBytes < 0 {
+		return fmt.Errorf("GATEWAY_RECORDING_MAX_TOTAL_BYTES must be >= 0")
+	}
+	if c.SessionIdleTimeout < 0 {
+		return fmt.Errorf("GATEWAY_SESSION_IDLE_TIMEOUT must be >= 0")
+	}
+	if c.SessionMaxLifetime < 0 {
+		return fmt.Errorf("GATEWAY_SESSION_MAX_LIFETIME must be >= 0")
+	}
+	if c.SessionExpiryWarning < 0 {
+		return fmt.Errorf("GATEWAY_SESSION_EXPIRY_WARNING must be >= 0")
+	}
+	for key := range c.SessionEnv {
+		if strings.HasPrefix(key, "GATEWAY_") {
+			return fmt.Errorf("GATEWAY_SESSION_ENV must not set gateway variable %q", key)
+		}
+	}
 	allowed, err := allowedCPURLs()
 	if err != nil {
 		return err
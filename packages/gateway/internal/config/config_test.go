@@ -1,6 +1,9 @@
 package config
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func resetSelfHostCPURL(t *testing.T) {
 	t.Helper()
@@ -106,3 +109,257 @@ func TestLoadRejectsMaxSessionsAboveHardCap(t *testing.T) {
 		t.Fatal("Load() error = nil, want max sessions validation error")
 	}
 }
+
+func TestLoadDefaultsInstructionsPolicyToManaged(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+	t.Setenv("GATEWAY_INSTRUCTIONS_POLICY", "")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.InstructionsPolicy != "managed" {
+		t.Fatalf("InstructionsPolicy = %q, want %q", cfg.InstructionsPolicy, "managed")
+	}
+}
+
+func TestLoadRejectsUnknownInstructionsPolicy(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+	t.Setenv("GATEWAY_INSTRUCTIONS_POLICY", "append")
+
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load() error = nil, want instructions policy validation error")
+	}
+}
+
+func TestLoadReadsSessionEnvSettings(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+	t.Setenv("GATEWAY_SESSION_ENV_ALLOWLIST", "PATH, HOME,LC_*")
+	t.Setenv("GATEWAY_SESSION_ENV", "EDITOR=vim, NODE_OPTIONS=--max-old-space-size=512")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	wantAllow := []string{"PATH", "HOME", "LC_*"}
+	if len(cfg.SessionEnvAllowlist) != len(wantAllow) {
+		t.Fatalf("SessionEnvAllowlist = %v, want %v", cfg.SessionEnvAllowlist, wantAllow)
+	}
+	for i := range wantAllow {
+		if cfg.SessionEnvAllowlist[i] != wantAllow[i] {
+			t.Fatalf("SessionEnvAllowlist = %v, want %v", cfg.SessionEnvAllowlist, wantAllow)
+		}
+	}
+	if cfg.SessionEnv["EDITOR"] != "vim" || cfg.SessionEnv["NODE_OPTIONS"] != "--max-old-space-size=512" {
+		t.Fatalf("SessionEnv = %v", cfg.SessionEnv)
+	}
+}
+
+func TestLoadRejectsGatewayVarsInSessionEnv(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+	t.Setenv("GATEWAY_SESSION_ENV", "GATEWAY_AUTH_TOKEN=oops")
+
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load() error = nil, want session env validation error")
+	}
+}
+
+func TestLoadCaptureMode(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.CaptureMode != "poll" {
+		t.Fatalf("CaptureMode = %q, want %q", cfg.CaptureMode, "poll")
+	}
+
+	t.Setenv("GATEWAY_CAPTURE_MODE", "stream")
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.CaptureMode != "stream" {
+		t.Fatalf("CaptureMode = %q, want %q", cfg.CaptureMode, "stream")
+	}
+
+	t.Setenv("GATEWAY_CAPTURE_MODE", "control")
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load() error = nil, want capture mode validation error")
+	}
+}
+
+func TestLoadSessionBackend(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.SessionBackend != "tmux" {
+		t.Fatalf("SessionBackend = %q, want %q", cfg.SessionBackend, "tmux")
+	}
+
+	t.Setenv("GATEWAY_SESSION_BACKEND", "native")
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.SessionBackend != "native" {
+		t.Fatalf("SessionBackend = %q, want %q", cfg.SessionBackend, "native")
+	}
+
+	t.Setenv("GATEWAY_SESSION_BACKEND", "screen")
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load() error = nil, want session backend validation error")
+	}
+}
+
+func TestLoadTmuxSocket(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.TmuxSocket != "chatcode" {
+		t.Fatalf("TmuxSocket = %q, want %q", cfg.TmuxSocket, "chatcode")
+	}
+
+	t.Setenv("GATEWAY_TMUX_SOCKET", "/run/chatcode/tmux.sock")
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.TmuxSocket != "/run/chatcode/tmux.sock" {
+		t.Fatalf("TmuxSocket = %q, want %q", cfg.TmuxSocket, "/run/chatcode/tmux.sock")
+	}
+}
+
+func TestLoadResizePolicy(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ResizePolicy != "latest-active" {
+		t.Fatalf("ResizePolicy = %q, want %q", cfg.ResizePolicy, "latest-active")
+	}
+
+	for _, v := range []string{"smallest", "120x40"} {
+		t.Setenv("GATEWAY_RESIZE_POLICY", v)
+		cfg, err = Load("")
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if cfg.ResizePolicy != v {
+			t.Fatalf("ResizePolicy = %q, want %q", cfg.ResizePolicy, v)
+		}
+	}
+
+	t.Setenv("GATEWAY_RESIZE_POLICY", "0x40")
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load() error = nil, want resize policy validation error")
+	}
+}
+
+func TestLoadRecordingSettings(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+	t.Setenv("HOME", "/home/vibe")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RecordSessions {
+		t.Fatal("RecordSessions should default to false")
+	}
+	if cfg.DataDir != "/home/vibe/.local/share/chatcode" {
+		t.Fatalf("DataDir = %q, want default under HOME", cfg.DataDir)
+	}
+	if cfg.RecordingMaxFileBytes != DefaultRecordingMaxFileBytes || cfg.RecordingMaxTotalBytes != DefaultRecordingMaxTotalBytes {
+		t.Fatalf("unexpected recording caps: %d/%d", cfg.RecordingMaxFileBytes, cfg.RecordingMaxTotalBytes)
+	}
+
+	t.Setenv("GATEWAY_RECORD_SESSIONS", "true")
+	t.Setenv("GATEWAY_DATA_DIR", "/srv/chatcode")
+	t.Setenv("GATEWAY_RECORDING_MAX_FILE_BYTES", "1048576")
+	t.Setenv("GATEWAY_RECORDING_MAX_TOTAL_BYTES", "0")
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.RecordSessions || cfg.DataDir != "/srv/chatcode" || cfg.RecordingMaxFileBytes != 1<<20 || cfg.RecordingMaxTotalBytes != 0 {
+		t.Fatalf("env overrides not applied: %+v", cfg)
+	}
+
+	t.Setenv("GATEWAY_RECORDING_MAX_FILE_BYTES", "104857600")
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load() error = nil, want recording file cap validation error")
+	}
+}
+
+func TestLoadSessionPolicySettings(t *testing.T) {
+	resetSelfHostCPURL(t)
+	t.Setenv("GATEWAY_ID", "gw-test")
+	t.Setenv("GATEWAY_AUTH_TOKEN", "auth-test")
+	t.Setenv("GATEWAY_CP_URL", CPURLStaging)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.SessionIdleTimeout != 0 || cfg.SessionMaxLifetime != 0 || cfg.SessionEndOnAgentExit {
+		t.Fatalf("session policies should default to off: %+v", cfg)
+	}
+	if cfg.SessionExpiryWarning != time.Minute {
+		t.Fatalf("SessionExpiryWarning = %v, want 1m", cfg.SessionExpiryWarning)
+	}
+
+	t.Setenv("GATEWAY_SESSION_IDLE_TIMEOUT", "30m")
+	t.Setenv("GATEWAY_SESSION_MAX_LIFETIME", "12h")
+	t.Setenv("GATEWAY_SESSION_END_ON_AGENT_EXIT", "true")
+	t.Setenv("GATEWAY_SESSION_EXPIRY_WARNING", "2m")
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.SessionIdleTimeout != 30*time.Minute || cfg.SessionMaxLifetime != 12*time.Hour ||
+		!cfg.SessionEndOnAgentExit || cfg.SessionExpiryWarning != 2*time.Minute {
+		t.Fatalf("env overrides not applied: %+v", cfg)
+	}
+
+	t.Setenv("GATEWAY_SESSION_IDLE_TIMEOUT", "-1s")
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load() error = nil, want idle timeout validation error")
+	}
+}
@@ -229,7 +229,12 @@ func (h *Handler) Download(ctx context.Context, transferID, path string) error {
 	if err != nil {
 		return err
 	}
+	return h.SendFile(ctx, transferID, safePath)
+}
 
+// SendFile sends a file outside the workspace (e.g. a session recording) as
+// file.content.* events. The caller is responsible for validating path.
+func (h *Handler) SendFile(ctx context.Context, transferID, safePath string) error {
 	f, err := os.Open(safePath)
 	if err != nil {
 		return fmt.Errorf("open file: %w", err)
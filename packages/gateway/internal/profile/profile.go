@@ -0,0 +1,230 @@
+// Package profile stores named session profiles: the agent, workdir, env,
+// launch flags and instructions a team keeps passing to session.create.
+//
+// All profiles are kept in one JSON file, rewritten on every change.
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	fileMode      = 0o600
+	directoryMode = 0o700
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Profile holds session.create fields under a name. Empty fields leave the
+// session.create field alone.
+type Profile struct {
+	Name        string            `json:"name"`
+	Agent       string            `json:"agent,omitempty"`
+	Workdir     string            `json:"workdir,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	AgentConfig *AgentConfig      `json:"agent_config,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// AgentConfig mirrors the agent_config of session.create.
+type AgentConfig struct {
+	ClaudeMD           string   `json:"claude_md,omitempty"`
+	AgentsMD           string   `json:"agents_md,omitempty"`
+	InstructionsPolicy string   `json:"instructions_policy,omitempty"`
+	Model              string   `json:"model,omitempty"`
+	PermissionMode     string   `json:"permission_mode,omitempty"`
+	Resume             *bool    `json:"resume,omitempty"`
+	ExtraArgs          []string `json:"extra_args,omitempty"`
+}
+
+// Override returns p with the fields set in o taking precedence. Env is
+// merged per variable; extra args are replaced as a whole.
+func (p Profile) Override(o Profile) Profile {
+	if o.Agent != "" {
+		p.Agent = o.Agent
+	}
+	if o.Workdir != "" {
+		p.Workdir = o.Workdir
+	}
+	if len(o.Env) > 0 {
+		env := maps.Clone(p.Env)
+		if env == nil {
+			env = make(map[string]string, len(o.Env))
+		}
+		maps.Copy(env, o.Env)
+		p.Env = env
+	}
+	switch {
+	case o.AgentConfig == nil:
+	case p.AgentConfig == nil:
+		p.AgentConfig = o.AgentConfig
+	default:
+		c := *p.AgentConfig
+		oc := o.AgentConfig
+		for _, f := range []struct{ dst, src *string }{
+			{&c.ClaudeMD, &oc.ClaudeMD},
+			{&c.AgentsMD, &oc.AgentsMD},
+			{&c.InstructionsPolicy, &oc.InstructionsPolicy},
+			{&c.Model, &oc.Model},
+			{&c.PermissionMode, &oc.PermissionMode},
+		} {
+			if *f.src != "" {
+				*f.dst = *f.src
+			}
+		}
+		if oc.Resume != nil {
+			c.Resume = oc.Resume
+		}
+		if oc.ExtraArgs != nil {
+			c.ExtraArgs = oc.ExtraArgs
+		}
+		p.AgentConfig = &c
+	}
+	return p
+}
+
+// Store keeps profiles in one file.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore returns a Store backed by the file at path, created on the first
+// Save.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Save adds p or replaces the profile of the same name.
+func (st *Store) Save(p Profile) error {
+	if !validName.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %q", p.Name)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	profiles, err := st.read()
+	if err != nil {
+		return err
+	}
+	p.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	profiles[p.Name] = p
+	return st.write(profiles)
+}
+
+// Get returns the named profile.
+func (st *Store) Get(name string) (Profile, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	profiles, err := st.read()
+	if err != nil {
+		return Profile{}, err
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q not found", name)
+	}
+	return p, nil
+}
+
+// List returns all profiles sorted by name.
+func (st *Store) List() ([]Profile, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	profiles, err := st.read()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Delete removes the named profile.
+func (st *Store) Delete(name string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	profiles, err := st.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	delete(profiles, name)
+	return st.write(profiles)
+}
+
+// read loads the profiles file. Caller must hold st.mu.
+func (st *Store) read() (map[string]Profile, error) {
+	profiles := make(map[string]Profile)
+	raw, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read profiles: %w", err)
+	}
+	var list []Profile
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", st.path, err)
+	}
+	for _, p := range list {
+		profiles[p.Name] = p
+	}
+	return profiles, nil
+}
+
+// write replaces the profiles file, so a crash leaves the old or the new
+// file. Caller must hold st.mu.
+func (st *Store) write(profiles map[string]Profile) error {
+	list := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(st.path)
+	if err := os.MkdirAll(dir, directoryMode); err != nil {
+		return fmt.Errorf("create profiles dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(st.path)+".*")
+	if err != nil {
+		return fmt.Errorf("write profiles: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(fileMode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write profiles: %w", err)
+	}
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write profiles: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write profiles: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), st.path); err != nil {
+		return fmt.Errorf("write profiles: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreSaveListDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "profiles.json")
+	st := NewStore(path)
+
+	if list, err := st.List(); err != nil || len(list) != 0 {
+		t.Fatalf("List on empty store = %v, %v", list, err)
+	}
+	if err := st.Save(Profile{Name: "api", Agent: "codex", Workdir: "/w/api"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := st.Save(Profile{Name: "web", Agent: "claude-code", Env: map[string]string{"PORT": "3000"}}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := st.Save(Profile{Name: "api", Agent: "gemini", Workdir: "/w/api"}); err != nil {
+		t.Fatalf("Save replacing: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != fileMode {
+		t.Fatalf("profiles file = %v, %v; want mode %v", info, err, os.FileMode(fileMode))
+	}
+
+	// A new store reads what the first one wrote.
+	list, err := NewStore(path).List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "api" || list[0].Agent != "gemini" || list[1].Env["PORT"] != "3000" {
+		t.Fatalf("List = %+v", list)
+	}
+	if list[0].UpdatedAt.IsZero() {
+		t.Fatal("expected UpdatedAt to be set")
+	}
+
+	if err := st.Delete("api"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := st.Get("api"); err == nil {
+		t.Fatal("Get of deleted profile succeeded")
+	}
+	if err := st.Delete("api"); err == nil {
+		t.Fatal("Delete of missing profile succeeded")
+	}
+	if p, err := st.Get("web"); err != nil || p.Agent != "claude-code" {
+		t.Fatalf("Get(web) = %+v, %v", p, err)
+	}
+
+	for _, name := range []string{"", "../etc", "has space", "-flag"} {
+		if err := st.Save(Profile{Name: name}); err == nil {
+			t.Fatalf("Save accepted name %q", name)
+		}
+	}
+}
+
+func TestProfileOverride(t *testing.T) {
+	yes, no := true, false
+	p := Profile{
+		Name:    "api",
+		Agent:   "codex",
+		Workdir: "/w/api",
+		Env:     map[string]string{"A": "1", "B": "2"},
+		AgentConfig: &AgentConfig{
+			AgentsMD:  "# api",
+			Model:     "gpt-5-codex",
+			Resume:    &yes,
+			ExtraArgs: []string{"--search"},
+		},
+	}
+	got := p.Override(Profile{
+		Workdir:     "/w/api-v2",
+		Env:         map[string]string{"B": "3"},
+		AgentConfig: &AgentConfig{Model: "o3", Resume: &no},
+	})
+	want := Profile{
+		Name:    "api",
+		Agent:   "codex",
+		Workdir: "/w/api-v2",
+		Env:     map[string]string{"A": "1", "B": "3"},
+		AgentConfig: &AgentConfig{
+			AgentsMD:  "# api",
+			Model:     "o3",
+			Resume:    &no,
+			ExtraArgs: []string{"--search"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Override = %+v (%+v), want %+v (%+v)", got, got.AgentConfig, want, want.AgentConfig)
+	}
+	if p.Env["B"] != "2" || p.AgentConfig.Model != "gpt-5-codex" {
+		t.Fatal("Override modified the profile")
+	}
+	if got := (Profile{Name: "bare"}).Override(Profile{Agent: "gemini"}); got.Agent != "gemini" || got.AgentConfig != nil {
+		t.Fatalf("Override of bare profile = %+v", got)
+	}
+}
@@ -0,0 +1,395 @@
+// Package recording writes session terminal output to asciicast v2 files
+// (https://docs.asciinema.org/manual/asciicast/v2/) and manages the stored
+// recordings.
+//
+// Each recording is a sequence of parts: when a part reaches the per-file
+// size cap, the recorder closes it and continues in a new part with a fresh
+// header. The store deletes the oldest finished parts once the total size of
+// all recordings exceeds its cap.
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileExt       = ".cast"
+	timeLayout    = "20060102T150405Z"
+	defaultCols   = 80
+	defaultRows   = 24
+	defaultTerm   = "xterm-256color"
+	inputMarker   = "input"
+	castVersion   = 2
+	fileMode      = 0o600
+	directoryMode = 0o700
+)
+
+// recordingIDPattern matches recording file names:
+// <session>-<start time>-<part>.cast.
+var recordingIDPattern = regexp.MustCompile(`^(.+)-(\d{8}T\d{6}Z)-(\d{3,})\.cast$`)
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Info describes one stored recording part.
+type Info struct {
+	// ID is the file name, used to address the recording in commands.
+	ID        string
+	SessionID string
+	StartedAt time.Time
+	Part      int
+	Size      int64
+	// Active is true while the part is still being written.
+	Active bool
+
+	modTime time.Time
+}
+
+// Store keeps recordings in one directory.
+type Store struct {
+	dir           string
+	maxFileBytes  int64
+	maxTotalBytes int64
+
+	mu     sync.Mutex
+	active map[string]bool // file names currently written
+}
+
+// NewStore returns a Store writing to dir. maxFileBytes caps a single part;
+// maxTotalBytes caps all recordings together (0 disables the total cap).
+func NewStore(dir string, maxFileBytes, maxTotalBytes int64) *Store {
+	return &Store{
+		dir:           dir,
+		maxFileBytes:  maxFileBytes,
+		maxTotalBytes: maxTotalBytes,
+		active:        make(map[string]bool),
+	}
+}
+
+// Start begins a new recording for a session whose window is cols x rows;
+// a size of 0 falls back to 80x24.
+func (st *Store) Start(sessionID, title string, cols, rows int) (*Recorder, error) {
+	if err := os.MkdirAll(st.dir, directoryMode); err != nil {
+		return nil, fmt.Errorf("create recordings dir: %w", err)
+	}
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultCols, defaultRows
+	}
+	r := &Recorder{
+		store:     st,
+		sessionID: sessionID,
+		title:     title,
+		startedAt: time.Now().UTC(),
+		cols:      cols,
+		rows:      rows,
+	}
+	if err := r.openPart(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// List returns stored recordings, oldest first. A non-empty sessionID limits
+// the result to that session.
+func (st *Store) List(sessionID string) ([]Info, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read recordings dir: %w", err)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var out []Info
+	for _, e := range entries {
+		info, ok := parseID(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		if sessionID != "" && info.SessionID != fileSessionID(sessionID) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		info.Size = fi.Size()
+		info.modTime = fi.ModTime()
+		info.Active = st.active[info.ID]
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].StartedAt.Equal(out[j].StartedAt) {
+			return out[i].StartedAt.Before(out[j].StartedAt)
+		}
+		if out[i].SessionID != out[j].SessionID {
+			return out[i].SessionID < out[j].SessionID
+		}
+		return out[i].Part < out[j].Part
+	})
+	return out, nil
+}
+
+// Path returns the file path of a recording.
+func (st *Store) Path(id string) (string, error) {
+	if _, ok := parseID(id); !ok || filepath.Base(id) != id {
+		return "", fmt.Errorf("invalid recording id %q", id)
+	}
+	path := filepath.Join(st.dir, id)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("recording %q not found", id)
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// Delete removes a finished recording.
+func (st *Store) Delete(id string) error {
+	path, err := st.Path(id)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	active := st.active[id]
+	st.mu.Unlock()
+	if active {
+		return fmt.Errorf("recording %q is still being written", id)
+	}
+	return os.Remove(path)
+}
+
+// prune deletes the oldest finished recordings until the total size fits the
+// cap. Parts being written are never deleted.
+func (st *Store) prune() {
+	if st.maxTotalBytes <= 0 {
+		return
+	}
+	all, err := st.List("")
+	if err != nil {
+		return
+	}
+	// Start times only have second resolution; the last write orders
+	// finished parts reliably.
+	sort.SliceStable(all, func(i, j int) bool { return all[i].modTime.Before(all[j].modTime) })
+	var total int64
+	for _, info := range all {
+		total += info.Size
+	}
+	for _, info := range all {
+		if total <= st.maxTotalBytes {
+			return
+		}
+		if info.Active {
+			continue
+		}
+		if err := os.Remove(filepath.Join(st.dir, info.ID)); err == nil {
+			total -= info.Size
+		}
+	}
+}
+
+func (st *Store) setActive(id string, active bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if active {
+		st.active[id] = true
+	} else {
+		delete(st.active, id)
+	}
+}
+
+// Recorder appends one session's terminal events to its recording. It is
+// safe for concurrent use.
+type Recorder struct {
+	store     *Store
+	sessionID string
+	title     string
+	startedAt time.Time
+
+	mu          sync.Mutex
+	file        *os.File
+	id          string
+	part        int
+	partStart   time.Time
+	written     int64
+	headerBytes int64
+	cols        int
+	rows        int
+	closed      bool
+	err         error
+}
+
+// Output records terminal output.
+func (r *Recorder) Output(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	r.write("o", string(data))
+}
+
+// Resize records a terminal size change.
+func (r *Recorder) Resize(cols, rows int) {
+	if cols <= 0 || rows <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.cols, r.rows = cols, rows
+	r.mu.Unlock()
+	r.write("r", strconv.Itoa(cols)+"x"+strconv.Itoa(rows))
+}
+
+// Input records a marker where input was sent to the session. The input
+// itself is not stored: it may contain passwords or tokens.
+func (r *Recorder) Input() {
+	r.write("m", inputMarker)
+}
+
+// Close finishes the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return r.err
+	}
+	r.closed = true
+	r.closePartLocked()
+	r.store.prune()
+	return r.err
+}
+
+// Discard closes the recording and deletes what was written, e.g. when the
+// session it was started for failed to start.
+func (r *Recorder) Discard() {
+	r.mu.Lock()
+	id := r.id
+	r.mu.Unlock()
+	_ = r.Close()
+	_ = os.Remove(filepath.Join(r.store.dir, id))
+}
+
+func (r *Recorder) write(kind, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.file == nil {
+		return
+	}
+	line := r.eventLine(kind, data)
+	if r.written > r.headerBytes && r.written+int64(len(line)) > r.store.maxFileBytes {
+		r.closePartLocked()
+		r.part++
+		if err := r.openPartLocked(); err != nil {
+			r.err = err
+			return
+		}
+		r.store.prune()
+		line = r.eventLine(kind, data)
+	}
+	n, err := r.file.Write(line)
+	r.written += int64(n)
+	if err != nil {
+		// A full disk should not take the session down; stop recording.
+		r.err = fmt.Errorf("write recording: %w", err)
+		r.closePartLocked()
+	}
+}
+
+func (r *Recorder) eventLine(kind, data string) []byte {
+	elapsed := strconv.FormatFloat(time.Since(r.partStart).Seconds(), 'f', 6, 64)
+	line, _ := json.Marshal([]any{json.Number(elapsed), kind, data})
+	return append(line, '\n')
+}
+
+func (r *Recorder) openPart() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.openPartLocked()
+}
+
+func (r *Recorder) openPartLocked() error {
+	var (
+		id  string
+		f   *os.File
+		err error
+	)
+	// A recording restarted within the same second (e.g. after a gateway
+	// restart) continues with the next free part number.
+	for {
+		id = fmt.Sprintf("%s-%s-%03d%s", fileSessionID(r.sessionID), r.startedAt.Format(timeLayout), r.part, fileExt)
+		f, err = os.OpenFile(filepath.Join(r.store.dir, id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, fileMode)
+		if !os.IsExist(err) {
+			break
+		}
+		r.part++
+	}
+	if err != nil {
+		return fmt.Errorf("create recording: %w", err)
+	}
+	r.partStart = time.Now()
+	header, err := json.Marshal(map[string]any{
+		"version":   castVersion,
+		"width":     r.cols,
+		"height":    r.rows,
+		"timestamp": r.partStart.Unix(),
+		"title":     r.title,
+		"env":       map[string]string{"TERM": defaultTerm},
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	n, err := f.Write(append(header, '\n'))
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("write recording header: %w", err)
+	}
+	r.file = f
+	r.id = id
+	r.written = int64(n)
+	r.headerBytes = r.written
+	r.store.setActive(id, true)
+	return nil
+}
+
+func (r *Recorder) closePartLocked() {
+	if r.file == nil {
+		return
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.file = nil
+	r.store.setActive(r.id, false)
+}
+
+// fileSessionID makes a session ID safe to use in a file name.
+func fileSessionID(sessionID string) string {
+	return unsafeNameChars.ReplaceAllString(sessionID, "_")
+}
+
+func parseID(name string) (Info, bool) {
+	m := recordingIDPattern.FindStringSubmatch(name)
+	if m == nil || strings.ContainsRune(name, filepath.Separator) {
+		return Info{}, false
+	}
+	startedAt, err := time.Parse(timeLayout, m[2])
+	if err != nil {
+		return Info{}, false
+	}
+	part, err := strconv.Atoi(m[3])
+	if err != nil {
+		return Info{}, false
+	}
+	return Info{ID: name, SessionID: m[1], StartedAt: startedAt, Part: part}, true
+}
@@ -0,0 +1,189 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readCast(t *testing.T, path string) (map[string]any, [][]any) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1<<20), 1<<20)
+	if !sc.Scan() {
+		t.Fatalf("%s: missing header", path)
+	}
+	var header map[string]any
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil {
+		t.Fatalf("%s: header: %v", path, err)
+	}
+	var events [][]any
+	for sc.Scan() {
+		var evt []any
+		if err := json.Unmarshal(sc.Bytes(), &evt); err != nil {
+			t.Fatalf("%s: event %q: %v", path, sc.Text(), err)
+		}
+		events = append(events, evt)
+	}
+	return header, events
+}
+
+func TestRecorderWritesAsciicastV2(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStore(dir, 1<<20, 0)
+	r, err := st.Start("ses-1", "api refactor", 132, 43)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	r.Output([]byte("hello\r\n"))
+	r.Resize(120, 40)
+	r.Input()
+	r.Output([]byte("\x1b[1mbold\x1b[0m"))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	infos, err := st.List("ses-1")
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("List = %+v, %v; want one recording", infos, err)
+	}
+	if infos[0].SessionID != "ses-1" || infos[0].Active {
+		t.Fatalf("unexpected info: %+v", infos[0])
+	}
+
+	header, events := readCast(t, filepath.Join(dir, infos[0].ID))
+	if header["version"] != float64(2) || header["width"] != float64(132) || header["height"] != float64(43) {
+		t.Fatalf("unexpected header: %v", header)
+	}
+	if header["title"] != "api refactor" {
+		t.Fatalf("title = %v, want %q", header["title"], "api refactor")
+	}
+	wantKinds := []string{"o", "r", "m", "o"}
+	wantData := []string{"hello\r\n", "120x40", "input", "\x1b[1mbold\x1b[0m"}
+	if len(events) != len(wantKinds) {
+		t.Fatalf("got %d events, want %d: %v", len(events), len(wantKinds), events)
+	}
+	last := -1.0
+	for i, evt := range events {
+		ts, ok := evt[0].(float64)
+		if !ok || ts < last {
+			t.Fatalf("event %d: bad timestamp %v", i, evt[0])
+		}
+		last = ts
+		if evt[1] != wantKinds[i] || evt[2] != wantData[i] {
+			t.Fatalf("event %d = %v, want [%s %q]", i, evt, wantKinds[i], wantData[i])
+		}
+	}
+}
+
+func TestRecorderRotatesAtFileCap(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStore(dir, 512, 0)
+	r, err := st.Start("ses-1", "ses-1", 80, 24)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	r.Resize(100, 30)
+	for i := 0; i < 20; i++ {
+		r.Output([]byte(strings.Repeat("x", 64)))
+	}
+
+	infos, err := st.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(infos) < 2 {
+		t.Fatalf("expected rotation into several parts, got %+v", infos)
+	}
+	for i, info := range infos {
+		if info.Part != i {
+			t.Fatalf("part %d has number %d", i, info.Part)
+		}
+		if active := i == len(infos)-1; info.Active != active {
+			t.Fatalf("part %d active = %v, want %v", i, info.Active, active)
+		}
+		if info.Size > 512 {
+			t.Fatalf("part %d is %d bytes, over the cap", i, info.Size)
+		}
+	}
+	// Later parts start with the current geometry so they play on their own.
+	header, _ := readCast(t, filepath.Join(dir, infos[len(infos)-1].ID))
+	if header["width"] != float64(100) || header["height"] != float64(30) {
+		t.Fatalf("rotated header = %v, want 100x30", header)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestStorePrunesOldestFinishedRecordings(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStore(dir, 1<<20, 600)
+
+	old, err := st.Start("old", "old", 80, 24)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	old.Output([]byte(strings.Repeat("a", 400)))
+	if err := old.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	cur, err := st.Start("new", "new", 80, 24)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	cur.Output([]byte(strings.Repeat("b", 400)))
+	if err := cur.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	infos, err := st.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(infos) != 1 || infos[0].SessionID != "new" {
+		t.Fatalf("expected only the newest recording to remain, got %+v", infos)
+	}
+}
+
+func TestStorePathAndDeleteValidateIDs(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStore(dir, 1<<20, 0)
+	r, err := st.Start("ses/../1", "ses", 0, 0)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	infos, _ := st.List("ses/../1")
+	if len(infos) != 1 || strings.ContainsRune(infos[0].ID, '/') {
+		t.Fatalf("expected a sanitized file name, got %+v", infos)
+	}
+	id := infos[0].ID
+
+	if err := st.Delete(id); err == nil {
+		t.Fatal("expected deleting an active recording to fail")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	for _, bad := range []string{"", "../" + id, "notes.txt"} {
+		if _, err := st.Path(bad); err == nil {
+			t.Fatalf("Path(%q) error = nil, want error", bad)
+		}
+	}
+	if err := st.Delete(id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := st.Path(id); err == nil {
+		t.Fatal("expected deleted recording to be gone")
+	}
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PermissionMode selects how much an agent may do without asking.
+type PermissionMode string
+
+const (
+	// PermissionDefault keeps the agent's own default.
+	PermissionDefault PermissionMode = "default"
+	// PermissionPlan lets the agent read and plan but not change files.
+	PermissionPlan PermissionMode = "plan"
+	// PermissionAcceptEdits lets the agent edit files in the workdir
+	// without asking.
+	PermissionAcceptEdits PermissionMode = "accept-edits"
+	// PermissionBypass lets the agent run anything without asking.
+	PermissionBypass PermissionMode = "bypass"
+)
+
+// ParsePermissionMode validates a permission mode. Empty selects
+// PermissionDefault.
+func ParsePermissionMode(v string) (PermissionMode, error) {
+	switch m := PermissionMode(strings.ToLower(strings.TrimSpace(v))); m {
+	case "":
+		return PermissionDefault, nil
+	case PermissionDefault, PermissionPlan, PermissionAcceptEdits, PermissionBypass:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown permission mode %q", v)
+	}
+}
+
+// AgentOptions selects how the agent CLI is launched. Zero fields keep the
+// agent's own defaults.
+type AgentOptions struct {
+	Model          string         `json:"model,omitempty"`
+	PermissionMode PermissionMode `json:"permission_mode,omitempty"`
+	// Resume continues the agent's last conversation in the workdir. It only
+	// applies to the first launch and is not stored.
+	Resume bool `json:"-"`
+	// ExtraArgs are further flags, each allowed by the agent's extraFlags.
+	ExtraArgs []string `json:"extra_args,omitempty"`
+}
+
+// Validate reports whether the agent accepts o.
+func (o AgentOptions) Validate(agent string) error {
+	_, err := o.args(agent)
+	return err
+}
+
+func (o AgentOptions) isZero() bool {
+	return o.Model == "" && (o.PermissionMode == "" || o.PermissionMode == PermissionDefault) &&
+		!o.Resume && len(o.ExtraArgs) == 0
+}
+
+// agentLaunch is the agent a pane process runs and the options it was
+// started with.
+type agentLaunch struct {
+	agent   string
+	options AgentOptions
+	// args are the flags of the validated options.
+	args []string
+}
+
+func newAgentLaunch(agent string, o AgentOptions) (agentLaunch, error) {
+	args, err := o.args(agent)
+	if err != nil {
+		return agentLaunch{}, err
+	}
+	return agentLaunch{agent: agent, options: o, args: args}, nil
+}
+
+// agentCLI describes how an agent is started.
+type agentCLI struct {
+	binary string
+	// resume makes the agent continue its most recent conversation in the
+	// working directory.
+	resume []string
+	// model is the flag that selects the model.
+	model string
+	// permissions maps the permission modes the agent supports to its
+	// flags. PermissionDefault passes no flag.
+	permissions map[PermissionMode][]string
+	// extraFlags are the flags allowed in AgentOptions.ExtraArgs, mapped to
+	// whether they take a value.
+	extraFlags map[string]bool
+}
+
+var agentCLIs = map[string]agentCLI{
+	"claude-code": {
+		binary: "claude",
+		resume: []string{"--continue"},
+		model:  "--model",
+		permissions: map[PermissionMode][]string{
+			PermissionPlan:        {"--permission-mode", "plan"},
+			PermissionAcceptEdits: {"--permission-mode", "acceptEdits"},
+			PermissionBypass:      {"--permission-mode", "bypassPermissions"},
+		},
+		extraFlags: map[string]bool{
+			"--add-dir":              true,
+			"--allowedTools":         true,
+			"--disallowedTools":      true,
+			"--append-system-prompt": true,
+			"--verbose":              false,
+		},
+	},
+	"codex": {
+		binary: "codex",
+		resume: []string{"resume", "--last"},
+		model:  "--model",
+		permissions: map[PermissionMode][]string{
+			PermissionPlan:        {"--sandbox", "read-only", "--ask-for-approval", "on-request"},
+			PermissionAcceptEdits: {"--full-auto"},
+			PermissionBypass:      {"--dangerously-bypass-approvals-and-sandbox"},
+		},
+		extraFlags: map[string]bool{
+			"--add-dir": true,
+			"--profile": true,
+			"--search":  false,
+		},
+	},
+	"gemini": {
+		binary: "gemini",
+		resume: []string{"--resume", "latest"},
+		model:  "--model",
+		permissions: map[PermissionMode][]string{
+			PermissionAcceptEdits: {"--approval-mode", "auto_edit"},
+			PermissionBypass:      {"--approval-mode", "yolo"},
+		},
+		extraFlags: map[string]bool{
+			"--include-directories": true,
+			"--sandbox":             false,
+			"--checkpointing":       false,
+			"--debug":               false,
+		},
+	},
+	"opencode": {
+		binary: "opencode",
+		resume: []string{"--continue"},
+		model:  "--model",
+		extraFlags: map[string]bool{
+			"--agent": true,
+		},
+	},
+}
+
+// validModel matches model names such as "opus", "gpt-5-codex" or
+// "anthropic/claude-sonnet-4-5". A leading dash would read as a flag.
+var validModel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/@+-]{0,127}$`)
+
+// args returns the agent flags for o, other than resume. Anything the agent
+// does not support or the allowlists reject is an error, so the control
+// plane cannot pass arbitrary flags; the arguments are shell-quoted when the
+// launch command is built.
+func (o AgentOptions) args(agent string) ([]string, error) {
+	cli, ok := agentCLIs[agent]
+	if !ok {
+		if !o.isZero() {
+			return nil, fmt.Errorf("agent options need an agent")
+		}
+		return nil, nil
+	}
+
+	var args []string
+	if o.Model != "" {
+		if !validModel.MatchString(o.Model) {
+			return nil, fmt.Errorf("invalid model %q", o.Model)
+		}
+		args = append(args, cli.model, o.Model)
+	}
+	if o.PermissionMode != "" && o.PermissionMode != PermissionDefault {
+		flags, ok := cli.permissions[o.PermissionMode]
+		if !ok {
+			return nil, fmt.Errorf("%s does not support permission mode %q", agent, o.PermissionMode)
+		}
+		args = append(args, flags...)
+	}
+	for i := 0; i < len(o.ExtraArgs); i++ {
+		arg := o.ExtraArgs[i]
+		flag, value, inline := strings.Cut(arg, "=")
+		takesValue, ok := cli.extraFlags[flag]
+		if !ok {
+			return nil, fmt.Errorf("%s flag %q is not allowed", agent, flag)
+		}
+		switch {
+		case inline && !takesValue:
+			return nil, fmt.Errorf("%s flag %q takes no value", agent, flag)
+		case takesValue && !inline:
+			if i+1 == len(o.ExtraArgs) {
+				return nil, fmt.Errorf("%s flag %q needs a value", agent, flag)
+			}
+			i++
+			value = o.ExtraArgs[i]
+			// A separate value would reach the agent as its own argument,
+			// so one that looks like a flag is rejected.
+			if strings.HasPrefix(value, "-") {
+				return nil, fmt.Errorf("%s flag %q value %q looks like a flag; pass it as %s=VALUE", agent, flag, value, flag)
+			}
+			args = append(args, flag, value)
+		default:
+			args = append(args, arg)
+		}
+		if strings.ContainsFunc(value, isControl) {
+			return nil, fmt.Errorf("%s flag %q has control characters in its value", agent, flag)
+		}
+	}
+	return args, nil
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
@@ -7,30 +7,30 @@ import (
 
 func TestAgentCommand(t *testing.T) {
 	tests := []struct {
-		name   string
-		agent  string
-		want   string
-		parts  []string
+		name  string
+		agent string
+		want  string
+		parts []string
 	}{
 		{
 			name:  "claude",
 			agent: "claude-code",
-			parts: []string{"command -v claude", "claude-code exited", "exec \"${SHELL:-/bin/bash}\""},
+			parts: []string{"command -v claude", "claude-code exited", agentExitOption, "exec \"${SHELL:-/bin/bash}\""},
 		},
 		{
 			name:  "codex",
 			agent: "codex",
-			parts: []string{"command -v codex", "codex exited", "exec \"${SHELL:-/bin/bash}\""},
+			parts: []string{"command -v codex", "codex exited", agentExitOption, "exec \"${SHELL:-/bin/bash}\""},
 		},
 		{
 			name:  "gemini",
 			agent: "gemini",
-			parts: []string{"command -v gemini", "gemini exited", "exec \"${SHELL:-/bin/bash}\""},
+			parts: []string{"command -v gemini", "gemini exited", agentExitOption, "exec \"${SHELL:-/bin/bash}\""},
 		},
 		{
 			name:  "opencode",
 			agent: "opencode",
-			parts: []string{"command -v opencode", "opencode exited", "exec \"${SHELL:-/bin/bash}\""},
+			parts: []string{"command -v opencode", "opencode exited", agentExitOption, "exec \"${SHELL:-/bin/bash}\""},
 		},
 		{name: "none", agent: "none", want: defaultShellCommand},
 		{name: "empty", agent: "", want: defaultShellCommand},
@@ -40,8 +40,8 @@ func TestAgentCommand(t *testing.T) {
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Session{opts: Options{Agent: tt.agent}}
-			got := s.agentCommand()
+			s := &Session{backend: &tmuxBackend{}}
+			got := s.agentCommand(agentLaunch{agent: tt.agent}, false)
 			if tt.want != "" && got != tt.want {
 				t.Fatalf("agentCommand() = %q, want %q", got, tt.want)
 			}
@@ -53,3 +53,96 @@ func TestAgentCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestAgentCommandResume(t *testing.T) {
+	s := &Session{backend: &tmuxBackend{}}
+	if got := s.agentCommand(agentLaunch{agent: "codex"}, true); !strings.Contains(got, "then codex 'resume' '--last';") {
+		t.Fatalf("agentCommand(true) = %q", got)
+	}
+	if got := s.agentCommand(agentLaunch{agent: "none"}, true); got != defaultShellCommand {
+		t.Fatalf("agentCommand(true) without agent = %q", got)
+	}
+}
+
+func TestParsePermissionMode(t *testing.T) {
+	for in, want := range map[string]PermissionMode{
+		"":              PermissionDefault,
+		"plan":          PermissionPlan,
+		" Accept-Edits": PermissionAcceptEdits,
+		"bypass":        PermissionBypass,
+	} {
+		if got, err := ParsePermissionMode(in); err != nil || got != want {
+			t.Fatalf("ParsePermissionMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParsePermissionMode("yolo"); err == nil {
+		t.Fatal("ParsePermissionMode accepted an unknown mode")
+	}
+}
+
+func TestAgentOptionsArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		agent   string
+		opts    AgentOptions
+		want    string
+		wantErr bool
+	}{
+		{name: "none", agent: "claude-code"},
+		{
+			name:  "claude",
+			agent: "claude-code",
+			opts: AgentOptions{
+				Model:          "opus",
+				PermissionMode: PermissionAcceptEdits,
+				ExtraArgs:      []string{"--add-dir", "../shared lib", "--verbose", "--allowedTools=Bash(git *)"},
+			},
+			want: "--model opus --permission-mode acceptEdits --add-dir ../shared lib --verbose --allowedTools=Bash(git *)",
+		},
+		{
+			name:  "codex plan",
+			agent: "codex",
+			opts:  AgentOptions{Model: "gpt-5-codex", PermissionMode: PermissionPlan},
+			want:  "--model gpt-5-codex --sandbox read-only --ask-for-approval on-request",
+		},
+		{
+			name:  "gemini",
+			agent: "gemini",
+			opts:  AgentOptions{PermissionMode: PermissionBypass},
+			want:  "--approval-mode yolo",
+		},
+		{
+			name:  "opencode",
+			agent: "opencode",
+			opts:  AgentOptions{Model: "anthropic/claude-sonnet-4-5", PermissionMode: PermissionDefault},
+			want:  "--model anthropic/claude-sonnet-4-5",
+		},
+		{name: "unsupported mode", agent: "opencode", opts: AgentOptions{PermissionMode: PermissionPlan}, wantErr: true},
+		{name: "flag as model", agent: "codex", opts: AgentOptions{Model: "--yolo"}, wantErr: true},
+		{name: "shell in model", agent: "codex", opts: AgentOptions{Model: "o3;rm -rf ~"}, wantErr: true},
+		{name: "unknown flag", agent: "claude-code", opts: AgentOptions{ExtraArgs: []string{"--dangerously-skip-permissions"}}, wantErr: true},
+		{name: "missing value", agent: "claude-code", opts: AgentOptions{ExtraArgs: []string{"--add-dir"}}, wantErr: true},
+		{name: "flag as value", agent: "claude-code", opts: AgentOptions{ExtraArgs: []string{"--add-dir", "--dangerously-skip-permissions"}}, wantErr: true},
+		{name: "unexpected value", agent: "gemini", opts: AgentOptions{ExtraArgs: []string{"--debug=1"}}, wantErr: true},
+		{name: "control character", agent: "opencode", opts: AgentOptions{ExtraArgs: []string{"--agent", "build\nrm -rf ~"}}, wantErr: true},
+		{name: "shell", agent: "none", opts: AgentOptions{Model: "opus"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tt.opts.args(tt.agent)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%s: args = %q, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, " ") != tt.want {
+			t.Fatalf("%s: args = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	s := &Session{backend: &tmuxBackend{}}
+	agent, _ := newAgentLaunch("codex", AgentOptions{Model: "o3"})
+	if got := s.agentCommand(agent, true); !strings.Contains(got, "then codex 'resume' '--last' '--model' 'o3';") {
+		t.Fatalf("agentCommand(true) = %q", got)
+	}
+}
@@ -0,0 +1,167 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
+)
+
+// BackendKind selects what runs the terminal of a session.
+type BackendKind string
+
+const (
+	// BackendTmux runs each session in a tmux session. Sessions survive
+	// gateway restarts and self-updates.
+	BackendTmux BackendKind = "tmux"
+	// BackendNative runs each session on a PTY owned by the gateway, whose
+	// own terminal emulator keeps the screen. It needs no tmux and forwards
+	// output as it is written, but its sessions end with the gateway.
+	BackendNative BackendKind = "native"
+
+	DefaultBackend = BackendTmux
+)
+
+// ParseBackendKind validates a backend name. Empty selects the default.
+func ParseBackendKind(v string) (BackendKind, error) {
+	switch k := BackendKind(strings.ToLower(strings.TrimSpace(v))); k {
+	case "":
+		return DefaultBackend, nil
+	case BackendTmux, BackendNative:
+		return k, nil
+	default:
+		return "", fmt.Errorf("unknown session backend %q", v)
+	}
+}
+
+// backend runs the pane of one session: a terminal and the process in it.
+// Session holds what is common to all backends (input queueing, replay,
+// recording, exit bookkeeping) and calls the backend for the terminal.
+type backend interface {
+	// start launches the pane and starts delivering its output.
+	start(l launch) error
+	// restart kills the pane process and starts l.command in its place,
+	// keeping the screen and history, and returns the new process ID. What
+	// the old process and its agent reported on exit is cleared.
+	restart(l launch) (pid int, err error)
+	// agentExitReport returns the shell command with which the agent launch
+	// wrapper records "$ec" as the agent exit code.
+	agentExitReport() string
+
+	writeInput(data []byte) error
+	// sendKeys sends keys normalized by normalizeKeys.
+	sendKeys(keys []string) error
+	// paste writes data as pasted text, returning a *PasteError when it
+	// fails part way.
+	paste(data []byte, bracketed bool) error
+	resize(cols, rows int) error
+
+	snapshot() (paneSnapshot, error)
+	grid() (vt.Grid, error)
+	// history returns the lines of the clamped range start..end.
+	history(start, end int, ansi bool) (HistoryPage, error)
+	// scrollback returns all pane lines as plain text and the number of
+	// them above the visible pane.
+	scrollback(ctx context.Context) (content string, historySize int, err error)
+	paneInfo() (paneInfo, error)
+	// status reports whether the pane process is running and what it and
+	// the agent reported on exit so far.
+	status() (paneStatus, sessionLiveness)
+
+	// wake tells the output capture the pane is about to change, after
+	// input.
+	wake()
+	// pollInterval returns how often the pane is polled, or 0 when output
+	// is not polled.
+	pollInterval() time.Duration
+	// stop stops delivering output for good.
+	stop()
+	// destroy removes the pane once its process is gone or has to go.
+	destroy() error
+}
+
+// launch describes the pane a backend starts.
+type launch struct {
+	workdir string
+	// command runs under sh -c.
+	command string
+	// env is the full pane environment; explicit holds the variables set
+	// for the session itself and filtered the host variables the env policy
+	// left out. clientEnv is env without the session variables.
+	env       []string
+	explicit  map[string]string
+	filtered  []string
+	clientEnv []string
+	// cgroupProcs, when set, is the cgroup.procs file the pane process
+	// joins before it runs command.
+	cgroupProcs string
+	// meta is stored by backends whose panes outlive the gateway.
+	meta Metadata
+}
+
+// paneCommand returns the sh -c command of a tmux pane. tmux hands panes the
+// PATH of the client rather than a PATH passed with -e, so a session PATH is
+// set in the command itself.
+func (l launch) paneCommand() string {
+	prefix := unsetPrefix(l.filtered)
+	if path, ok := l.explicit["PATH"]; ok {
+		prefix += "PATH=" + shellQuote(path) + "; export PATH; "
+	}
+	return l.joinCgroup() + prefix + wrapPaneCommand(l.command)
+}
+
+// joinCgroup returns a command prefix that moves the shell into the
+// session cgroup, so the pane and everything it forks start there. A pane
+// that cannot join exits rather than run unconfined.
+func (l launch) joinCgroup() string {
+	if l.cgroupProcs == "" {
+		return ""
+	}
+	return "echo $$ > " + shellQuote(l.cgroupProcs) + " || exit 126; "
+}
+
+// paneSnapshot is the content and terminal state returned by Snapshot.
+type paneSnapshot struct {
+	content                      string
+	cols, rows                   int
+	cursorX, cursorY, cursorFlag int
+	alternateOn                  bool
+	// nextSeq is the seq of the first output frame sent after the captured
+	// state.
+	nextSeq uint64
+}
+
+// restartReset returns a client terminal to the modes a new pane process
+// starts with, in case the killed one left e.g. the alternate screen on.
+const restartReset = "\x1b[?1049l\x1b[?1l\x1b[?2004l\x1b[?25h\x1b[0m"
+
+// paneOutput is where a backend delivers the output of a pane.
+type paneOutput struct {
+	sessionID string
+	seq       *uint64
+	lastAct   *int64
+	ch        chan OutputChunk
+	replay    *replayBuffer
+	recorder  *recorderSlot
+	// watched reports whether a client watches the session, so the pane is
+	// polled at the full rate.
+	watched func() bool
+}
+
+func (o paneOutput) emit(data string) bool {
+	return emitOutput(o.sessionID, o.seq, o.lastAct, o.ch, o.replay, o.recorder, data)
+}
+
+// newBackend returns an unstarted backend of the given kind.
+func newBackend(kind BackendKind, tmux TmuxServer, out paneOutput, captureMode CaptureMode) (backend, error) {
+	switch kind {
+	case BackendNative:
+		return newPTYBackend(out)
+	case BackendTmux, "":
+		return newTmuxBackend(tmux, out, captureMode), nil
+	default:
+		return nil, fmt.Errorf("unknown session backend %q", kind)
+	}
+}
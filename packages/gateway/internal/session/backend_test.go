@@ -0,0 +1,501 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBackendKind(t *testing.T) {
+	for in, want := range map[string]BackendKind{"": DefaultBackend, "tmux": BackendTmux, " Native ": BackendNative} {
+		if got, err := ParseBackendKind(in); err != nil || got != want {
+			t.Fatalf("ParseBackendKind(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseBackendKind("screen"); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+}
+
+func TestKeySequence(t *testing.T) {
+	tests := []struct {
+		key       string
+		appCursor bool
+		want      string
+	}{
+		{key: "Enter", want: "\r"},
+		{key: "C-c", want: "\x03"},
+		{key: "C-[", want: "\x1b"},
+		{key: "M-x", want: "\x1bx"},
+		{key: "S-a", want: "A"},
+		{key: "S-Tab", want: "\x1b[Z"},
+		{key: "BTab", want: "\x1b[Z"},
+		{key: "C-Space", want: "\x00"},
+		{key: "Up", want: "\x1b[A"},
+		{key: "Up", appCursor: true, want: "\x1bOA"},
+		{key: "C-Left", appCursor: true, want: "\x1b[1;5D"},
+		{key: "M-S-End", want: "\x1b[1;4F"},
+		{key: "PageUp", want: "\x1b[5~"},
+		{key: "C-DC", want: "\x1b[3;5~"},
+		{key: "F1", want: "\x1bOP"},
+		{key: "S-F1", want: "\x1b[1;2P"},
+		{key: "F12", want: "\x1b[24~"},
+	}
+	for _, tt := range tests {
+		if got := keySequence(tt.key, tt.appCursor); got != tt.want {
+			t.Errorf("keySequence(%q, %v) = %q, want %q", tt.key, tt.appCursor, got, tt.want)
+		}
+	}
+}
+
+// The conformance tests run the same scenarios against every backend
+// available here.
+func forEachBackend(t *testing.T, fn func(t *testing.T, kind BackendKind)) {
+	for _, kind := range []BackendKind{BackendTmux, BackendNative} {
+		t.Run(string(kind), func(t *testing.T) {
+			if kind == BackendTmux && !hasTmux() {
+				t.Skip("tmux not available")
+			}
+			fn(t, kind)
+		})
+	}
+}
+
+func createBackendSession(t *testing.T, m *Manager, kind BackendKind, opts Options) *Session {
+	t.Helper()
+	opts.SessionID = "conf-" + string(kind) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	opts.Name = "conformance"
+	opts.Backend = kind
+	if opts.Workdir == "" {
+		opts.Workdir = t.TempDir()
+	}
+	if opts.Agent == "" {
+		opts.Agent = "none"
+	}
+	opts.OutputCh = make(chan OutputChunk, 4096)
+	s, err := m.Create(opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = m.End(opts.SessionID) })
+	if got := s.Summary().Backend; got != kind {
+		t.Fatalf("Summary().Backend = %q, want %q", got, kind)
+	}
+	return s
+}
+
+// waitForShell waits until the shell of s runs commands.
+func waitForShell(t *testing.T, s *Session) {
+	t.Helper()
+	if err := s.Input([]byte("echo ready_$((1+1))\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	waitForPane(t, s, "ready_2")
+}
+
+// waitForPane polls the plain text of the pane until it contains want.
+func waitForPane(t *testing.T, s *Session, want string) string {
+	t.Helper()
+	var text string
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		page, err := s.History(-1000, 1000, false)
+		if err == nil && strings.Contains(page.Content, want) {
+			return page.Content
+		}
+		text = page.Content
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("pane never showed %q:\n%s", want, text)
+	return ""
+}
+
+func waitForCommand(t *testing.T, s *Session, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Details().CurrentCommand != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("current command = %q, want %q", s.Details().CurrentCommand, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestBackendConformanceIO(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		s := createBackendSession(t, NewManager(5), kind, Options{})
+		waitForShell(t, s)
+
+		if err := s.Input([]byte("printf '\\033[31mred\\033[0m conf_%s\\n' $((40+2))\n")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		waitForPane(t, s, "red conf_42")
+
+		var out strings.Builder
+		deadline := time.After(5 * time.Second)
+		for !strings.Contains(out.String(), "conf_42") {
+			select {
+			case chunk := <-s.opts.OutputCh:
+				out.Write(chunk.Data)
+			case <-deadline:
+				t.Fatalf("output never carried conf_42: %q", out.String())
+			}
+		}
+
+		content, cols, rows, _, cursorY, cursorVisible, alternate, _, err := s.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot: %v", err)
+		}
+		if !strings.Contains(content, "\x1b[") || !strings.Contains(content, "conf_42") {
+			t.Fatalf("snapshot lacks colored output:\n%q", content)
+		}
+		if cols != 80 || rows != 24 || cursorY < 1 || cursorVisible != 1 || alternate {
+			t.Fatalf("snapshot state = %dx%d cursor row %d visible %d alternate %v", cols, rows, cursorY, cursorVisible, alternate)
+		}
+
+		grid, err := s.GridSnapshot()
+		if err != nil {
+			t.Fatalf("GridSnapshot: %v", err)
+		}
+		if !strings.Contains(grid.Text(), "red conf_42") {
+			t.Fatalf("grid = %q", grid.Text())
+		}
+	})
+}
+
+// A client resuming from a snapshot continues at its nextSeq, so no frame
+// from there on may repeat output the snapshot already shows.
+func TestBackendConformanceSnapshotSeq(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		s := createBackendSession(t, NewManager(5), kind, Options{})
+		waitForShell(t, s)
+
+		if err := s.Input([]byte("printf 'snap_%s\\n' $((6*7))\n")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		var nextSeq uint64
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			content, _, _, _, _, _, _, seq, err := s.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot: %v", err)
+			}
+			if strings.Contains(content, "snap_42") {
+				nextSeq = seq
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("snapshot never showed snap_42:\n%s", content)
+			}
+		}
+
+		var after strings.Builder
+		timeout := time.After(500 * time.Millisecond)
+		for {
+			select {
+			case chunk := <-s.opts.OutputCh:
+				if chunk.Seq >= nextSeq {
+					after.Write(chunk.Data)
+				}
+				continue
+			case <-timeout:
+			}
+			break
+		}
+		if strings.Contains(after.String(), "snap_42") {
+			t.Fatalf("output after seq %d repeats the snapshot: %q", nextSeq, after.String())
+		}
+	})
+}
+
+func TestBackendConformanceKeysAndPaste(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		s := createBackendSession(t, NewManager(5), kind, Options{})
+		waitForShell(t, s)
+
+		if err := s.Input([]byte("sleep 30")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		if err := s.Keys([]string{"Enter"}); err != nil {
+			t.Fatalf("Keys Enter: %v", err)
+		}
+		waitForCommand(t, s, "sleep")
+		if err := s.Keys([]string{"C-c"}); err != nil {
+			t.Fatalf("Keys C-c: %v", err)
+		}
+		if err := s.Paste([]byte("echo pasted_$((1+1))\necho pasted_$((2+1))\n"), true); err != nil {
+			t.Fatalf("Paste: %v", err)
+		}
+		waitForPane(t, s, "pasted_3")
+	})
+}
+
+func TestBackendConformanceResize(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		s := createBackendSession(t, NewManager(5), kind, Options{})
+		waitForShell(t, s)
+
+		if err := s.Resize(100, 30); err != nil {
+			t.Fatalf("Resize: %v", err)
+		}
+		d := s.Details()
+		if d.Cols != 100 || d.Rows != 30 || len(d.PanePIDs) != 1 {
+			t.Fatalf("details = %dx%d pids %v, want 100x30 and one pid", d.Cols, d.Rows, d.PanePIDs)
+		}
+		if err := s.Input([]byte("echo size_$(stty size | tr ' ' x)\n")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		waitForPane(t, s, "size_30x100")
+		grid, err := s.GridSnapshot()
+		if err != nil || grid.Cols != 100 || grid.Rows != 30 {
+			t.Fatalf("grid = %dx%d, %v; want 100x30", grid.Cols, grid.Rows, err)
+		}
+	})
+}
+
+func TestBackendConformanceHistoryAndSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		s := createBackendSession(t, NewManager(5), kind, Options{})
+		waitForShell(t, s)
+
+		if err := s.Input([]byte("seq 1 200; echo seq_$((3*3))\n")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		waitForPane(t, s, "seq_9")
+
+		res, err := s.Search(context.Background(), SearchOptions{Query: "^150$", Regex: true, Context: 1})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(res.Matches) != 1 || res.HistorySize == 0 {
+			t.Fatalf("search = %+v, want one match in the scrollback", res)
+		}
+		m := res.Matches[0]
+		if m.Line >= 0 || m.Before[0] != "149" || m.After[0] != "151" {
+			t.Fatalf("match = %+v", m)
+		}
+
+		page, err := s.History(m.Line, m.Line+1, false)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		if page.Content != "150\n151\n" || page.HistorySize != res.HistorySize {
+			t.Fatalf("history page = %+v", page)
+		}
+	})
+}
+
+func TestBackendConformanceExit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		bin := t.TempDir()
+		if err := os.WriteFile(filepath.Join(bin, "codex"), []byte("#!/bin/sh\nexit 3\n"), 0o755); err != nil {
+			t.Fatalf("write fake agent: %v", err)
+		}
+
+		m := NewManager(5)
+		m.checkInterval = 100 * time.Millisecond
+		agentExits := make(chan int, 1)
+		m.SetOnAgentExit(func(_ string, code int) { agentExits <- code })
+		exits := make(chan ExitStatus, 1)
+		m.SetOnSessionExit(func(_ string, status ExitStatus) { exits <- status })
+		s := createBackendSession(t, m, kind, Options{
+			Agent: "codex",
+			Env:   map[string]string{"PATH": bin + ":" + os.Getenv("PATH")},
+		})
+
+		select {
+		case code := <-agentExits:
+			if code != 3 {
+				t.Fatalf("agent exit code = %d, want 3", code)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for agent exit")
+		}
+		waitForPane(t, s, "codex exited (code 3)")
+
+		if err := s.Input([]byte("exit 7\n")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		select {
+		case status := <-exits:
+			if !status.Known || status.Code != 7 || status.Reason != EndReasonExited {
+				t.Fatalf("exit status = %+v, want code 7", status)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for session exit")
+		}
+	})
+}
+
+func TestBackendConformanceRestart(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		bin := t.TempDir()
+		script := "#!/bin/sh\necho \"codex args:$*\"\nexit $(cat \"$0.code\")\n"
+		if err := os.WriteFile(filepath.Join(bin, "codex"), []byte(script), 0o755); err != nil {
+			t.Fatalf("write fake agent: %v", err)
+		}
+		setCode := func(code string) {
+			if err := os.WriteFile(filepath.Join(bin, "codex.code"), []byte(code), 0o644); err != nil {
+				t.Fatalf("write exit code: %v", err)
+			}
+		}
+		setCode("3")
+
+		m := NewManager(5)
+		m.checkInterval = 100 * time.Millisecond
+		agentExits := make(chan int, 2)
+		m.SetOnAgentExit(func(_ string, code int) { agentExits <- code })
+		s := createBackendSession(t, m, kind, Options{
+			Env: map[string]string{"PATH": bin + ":" + os.Getenv("PATH")},
+		})
+		waitForShell(t, s)
+		// The marker scrolls into the history, which restarts keep.
+		if err := s.Input([]byte("echo before_$((5*5)); seq 1 60; echo seq_$((3*3))\n")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		waitForPane(t, s, "seq_9")
+		oldPID := s.Details().PanePIDs[0]
+
+		pid, err := s.Restart(RestartOptions{Agent: "codex", Resume: true})
+		if err != nil {
+			t.Fatalf("Restart: %v", err)
+		}
+		if pid == oldPID || s.Details().PanePIDs[0] != pid {
+			t.Fatalf("restarted pid = %d, old %d, pane %v", pid, oldPID, s.Details().PanePIDs)
+		}
+		if s.Summary().Agent != "codex" {
+			t.Fatalf("agent = %q, want codex", s.Summary().Agent)
+		}
+		select {
+		case code := <-agentExits:
+			if code != 3 {
+				t.Fatalf("agent exit code = %d, want 3", code)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for agent exit")
+		}
+		text := waitForPane(t, s, "codex exited (code 3)")
+		if !strings.Contains(text, "codex args:resume --last") || !strings.Contains(text, "before_25") {
+			t.Fatalf("pane after restart:\n%s", text)
+		}
+
+		// A second restart reports the agent exit again.
+		setCode("4")
+		if _, err := s.Restart(RestartOptions{}); err != nil {
+			t.Fatalf("Restart: %v", err)
+		}
+		select {
+		case code := <-agentExits:
+			if code != 4 {
+				t.Fatalf("agent exit code = %d, want 4", code)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for second agent exit")
+		}
+		if !s.isAlive() || s.ExitStatus().Known {
+			t.Fatalf("session after restart: alive %v, exit %+v", s.isAlive(), s.ExitStatus())
+		}
+	})
+}
+
+// Pane processes join the session cgroup themselves, before the command
+// they run can fork, and again when a restart replaces them.
+func TestBackendConformanceCgroupJoin(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		procs := filepath.Join(t.TempDir(), "cgroup.procs")
+		s := createBackendSession(t, NewManager(5), kind, Options{CgroupProcs: procs})
+		waitForShell(t, s)
+		joined := func() string {
+			data, err := os.ReadFile(procs)
+			if err != nil {
+				t.Fatalf("read cgroup.procs: %v", err)
+			}
+			return strings.TrimSpace(string(data))
+		}
+		if got, want := joined(), strconv.Itoa(s.Details().PanePIDs[0]); got != want {
+			t.Fatalf("cgroup.procs = %q, want pane pid %s", got, want)
+		}
+
+		pid, err := s.Restart(RestartOptions{})
+		if err != nil {
+			t.Fatalf("Restart: %v", err)
+		}
+		// The pane history still shows the first shell's prompt, so the
+		// file is polled for the new pid.
+		deadline := time.Now().Add(5 * time.Second)
+		for joined() != strconv.Itoa(pid) {
+			if time.Now().After(deadline) {
+				t.Fatalf("cgroup.procs after restart = %q, want pid %d", joined(), pid)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
+
+func TestSessionRestartFailureKeepsAgent(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+	m := NewManager(5)
+	m.checkInterval = time.Hour
+	s := createBackendSession(t, m, BackendTmux, Options{})
+	b := s.backend.(*tmuxBackend)
+	if out, err := b.server.command("kill-session", "-t", b.name).CombinedOutput(); err != nil {
+		t.Fatalf("kill-session: %v: %s", err, out)
+	}
+
+	if _, err := s.Restart(RestartOptions{Agent: "codex"}); err == nil {
+		t.Fatal("Restart of a gone pane succeeded")
+	}
+	if got := s.Summary().Agent; got != "none" {
+		t.Fatalf("agent after failed restart = %q, want none", got)
+	}
+	if files := s.InstructionFiles(); len(files) != 0 {
+		t.Fatalf("instruction files after failed restart = %+v", files)
+	}
+}
+
+func TestBackendConformanceEnd(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kind BackendKind) {
+		m := NewManager(5)
+		s := createBackendSession(t, m, kind, Options{})
+		waitForShell(t, s)
+		if err := s.Input([]byte("vi\n")); err != nil {
+			t.Fatalf("Input: %v", err)
+		}
+		time.Sleep(300 * time.Millisecond)
+
+		if err := m.End(s.opts.SessionID); err != nil {
+			t.Fatalf("End: %v", err)
+		}
+		if s.isAlive() {
+			t.Fatal("session still alive after End")
+		}
+		if st := s.ExitStatus(); st.Reason != EndReasonRequested {
+			t.Fatalf("exit reason = %q, want %q", st.Reason, EndReasonRequested)
+		}
+	})
+}
+
+func TestManagerDefaultBackend(t *testing.T) {
+	m := NewManager(5)
+	m.SetBackend(BackendNative)
+	s, err := m.Create(Options{
+		SessionID: "default-backend-" + time.Now().Format("150405"),
+		Name:      "default-backend",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 64),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	if _, ok := s.backend.(*ptyBackend); !ok || s.Backend() != BackendNative {
+		t.Fatalf("backend = %T (%q), want the manager default", s.backend, s.Backend())
+	}
+}
@@ -0,0 +1,102 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// paneInfoFormat reports window geometry, pane PID and foreground command,
+// one line per pane. Fields are tab-separated because commands may contain
+// spaces.
+const paneInfoFormat = "#{window_width}\t#{window_height}\t#{pane_pid}\t#{pane_current_command}"
+
+// Details is a full view of one session, read from its backend on demand.
+type Details struct {
+	Summary
+	// Cols and Rows are the window size; zero when the backend could not be
+	// queried (e.g. the session is exiting).
+	Cols int
+	Rows int
+	// PanePIDs are the PIDs of the processes started for the panes.
+	PanePIDs []int
+	// CurrentCommand is the foreground command of the first pane.
+	CurrentCommand string
+	// Recovered is true for sessions re-attached after a gateway restart.
+	Recovered bool
+}
+
+type paneInfo struct {
+	cols, rows     int
+	pids           []int
+	currentCommand string
+}
+
+// Details returns the session summary together with live pane information.
+func (s *Session) Details() Details {
+	d := Details{
+		Summary:   s.Summary(),
+		Recovered: s.recovered,
+	}
+	if s.backend == nil {
+		return d
+	}
+	info, err := s.backend.paneInfo()
+	if err != nil {
+		return d
+	}
+	d.Cols, d.Rows = info.cols, info.rows
+	d.PanePIDs = info.pids
+	d.CurrentCommand = info.currentCommand
+	return d
+}
+
+func (b *tmuxBackend) paneInfo() (paneInfo, error) {
+	out, err := b.server.command("list-panes", "-t", b.name, "-F", paneInfoFormat).Output()
+	if err != nil {
+		return paneInfo{}, err
+	}
+	return parsePaneInfoOutput(string(out))
+}
+
+func parsePaneInfoOutput(out string) (paneInfo, error) {
+	var info paneInfo
+	for i, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return paneInfo{}, fmt.Errorf("unexpected pane info %q", line)
+		}
+		pid, err := strconv.Atoi(fields[2])
+		if err != nil || pid <= 0 {
+			return paneInfo{}, fmt.Errorf("unexpected pane pid %q", fields[2])
+		}
+		info.pids = append(info.pids, pid)
+		if i > 0 {
+			continue
+		}
+		info.cols, _ = strconv.Atoi(fields[0])
+		info.rows, _ = strconv.Atoi(fields[1])
+		info.currentCommand = fields[3]
+	}
+	return info, nil
+}
+
+// Details returns full details of all active sessions, ordered by session ID.
+func (m *Manager) Details() []Details {
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.RUnlock()
+
+	// Backends are queried outside the lock so a slow tmux cannot stall
+	// session creation or the watchers.
+	out := make([]Details, 0, len(sessions))
+	for _, s := range sessions {
+		out = append(out, s.Details())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out
+}
@@ -0,0 +1,61 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePaneInfoOutput(t *testing.T) {
+	got, err := parsePaneInfoOutput("120\t40\t4242\tnode server.js\n120\t40\t4243\tbash\n")
+	if err != nil {
+		t.Fatalf("parsePaneInfoOutput: %v", err)
+	}
+	want := paneInfo{cols: 120, rows: 40, pids: []int{4242, 4243}, currentCommand: "node server.js"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsePaneInfoOutput = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"", "120 40 4242 bash", "120\t40\tx\tbash"} {
+		if _, err := parsePaneInfoOutput(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestManagerDetailsReportsPaneState(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "details-" + time.Now().Format("150405"),
+		Name:      "details",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 64),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	if err := s.Resize(100, 30); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+
+	details := m.Details()
+	if len(details) != 1 {
+		t.Fatalf("Details returned %d sessions, want 1", len(details))
+	}
+	d := details[0]
+	if d.SessionID != s.opts.SessionID || d.Name != "details" || d.Recovered {
+		t.Fatalf("unexpected summary fields: %+v", d)
+	}
+	if d.Cols != 100 || d.Rows != 30 {
+		t.Fatalf("geometry = %dx%d, want 100x30", d.Cols, d.Rows)
+	}
+	if len(d.PanePIDs) != 1 || d.CurrentCommand == "" {
+		t.Fatalf("expected pane pid and current command, got %+v", d)
+	}
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// deniedEnvPatterns lists gateway/service variables that must never reach a
+// session pane. Entries ending in "*" match by prefix. The denylist applies in
+// allowlist mode too, so a broad allowlist cannot re-admit gateway secrets.
+var deniedEnvPatterns = []string{
+	"GATEWAY_*", // auth/bootstrap tokens and the rest of the EnvironmentFile
+	"NOTIFY_SOCKET",
+	"INVOCATION_ID",
+	"JOURNAL_STREAM",
+	"MANAGERPID",
+	"SYSTEMD_EXEC_PID",
+}
+
+// tmuxEnvNames select which tmux server a client talks to. They are always
+// inherited so every gateway tmux call reaches the same server; tmux sets its
+// own values inside panes.
+var tmuxEnvNames = []string{"TMUX", "TMUX_TMPDIR"}
+
+// baseEnvNames are inherited in allowlist mode as well: without them shells
+// and agents cannot find binaries, their home directory or the locale.
+var baseEnvNames = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG"}
+
+var shellIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnvPolicy controls which variables from the gateway process environment are
+// inherited by session panes, and which defaults every session receives.
+type EnvPolicy struct {
+	// Allowlist switches to allowlist mode when non-empty: only host variables
+	// matching an entry (exact name, or prefix ending in "*") and those in
+	// baseEnvNames are inherited.
+	Allowlist []string
+	// Defaults are set in every session; per-session Options.Env wins.
+	Defaults map[string]string
+}
+
+// build returns the pane environment for a session and the names of host
+// variables that were filtered out. The filtered names must also be unset in
+// the pane command: a tmux server that is already running hands panes its own
+// global environment rather than the client's.
+func (p EnvPolicy) build(host []string, overrides map[string]string) (env []string, filtered []string) {
+	explicit := p.explicitVars(overrides)
+
+	env = make([]string, 0, len(host)+len(explicit))
+	for _, kv := range host {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			continue
+		}
+		if _, set := explicit[name]; set {
+			continue
+		}
+		if !p.inherits(name) {
+			filtered = append(filtered, name)
+			continue
+		}
+		env = append(env, kv)
+	}
+	for _, name := range sortedKeys(explicit) {
+		env = append(env, name+"="+explicit[name])
+	}
+	return env, filtered
+}
+
+// inherited returns the host variables the policy lets panes inherit,
+// without any variables of the session. tmux clients run with it: the client
+// that starts the tmux server makes its environment the global environment of
+// every later pane.
+func (p EnvPolicy) inherited(host []string) []string {
+	env := make([]string, 0, len(host))
+	for _, kv := range host {
+		name, _, ok := strings.Cut(kv, "=")
+		if ok && name != "" && p.inherits(name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// explicitVars merges gateway defaults with session overrides.
+func (p EnvPolicy) explicitVars(overrides map[string]string) map[string]string {
+	vars := make(map[string]string, len(p.Defaults)+len(overrides))
+	for k, v := range p.Defaults {
+		vars[k] = v
+	}
+	for k, v := range overrides {
+		vars[k] = v
+	}
+	return vars
+}
+
+func (p EnvPolicy) inherits(name string) bool {
+	if matchesEnvPattern(deniedEnvPatterns, name) {
+		return false
+	}
+	if len(p.Allowlist) == 0 || matchesEnvPattern(tmuxEnvNames, name) || matchesEnvPattern(baseEnvNames, name) {
+		return true
+	}
+	return matchesEnvPattern(p.Allowlist, name)
+}
+
+func matchesEnvPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+			continue
+		}
+		if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unsetPrefix returns a shell prefix removing names from the pane
+// environment. Names that are not valid shell identifiers cannot be set by a
+// POSIX shell either and are skipped.
+func unsetPrefix(names []string) string {
+	valid := make([]string, 0, len(names))
+	for _, name := range names {
+		if shellIdentifierPattern.MatchString(name) {
+			valid = append(valid, name)
+		}
+	}
+	if len(valid) == 0 {
+		return ""
+	}
+	sort.Strings(valid)
+	return "unset " + strings.Join(valid, " ") + "; "
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
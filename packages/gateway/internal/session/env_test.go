@@ -0,0 +1,283 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvPolicyDeniesGatewayVariables(t *testing.T) {
+	host := []string{
+		"PATH=/usr/bin",
+		"GATEWAY_AUTH_TOKEN=secret",
+		"GATEWAY_BOOTSTRAP_TOKEN=boot",
+		"INVOCATION_ID=abc",
+		"HOME=/home/vibe",
+	}
+	env, filtered := EnvPolicy{}.build(host, nil)
+
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GATEWAY_") || strings.HasPrefix(kv, "INVOCATION_ID=") {
+			t.Fatalf("denied variable leaked into env: %q", kv)
+		}
+	}
+	if !containsEnv(env, "PATH=/usr/bin") || !containsEnv(env, "HOME=/home/vibe") {
+		t.Fatalf("expected regular host vars to be inherited, got %v", env)
+	}
+	for _, name := range []string{"GATEWAY_AUTH_TOKEN", "GATEWAY_BOOTSTRAP_TOKEN", "INVOCATION_ID"} {
+		if !containsString(filtered, name) {
+			t.Fatalf("expected %s in filtered list, got %v", name, filtered)
+		}
+	}
+}
+
+func TestEnvPolicyAllowlistMode(t *testing.T) {
+	host := []string{
+		"PATH=/usr/bin",
+		"LC_ALL=C.UTF-8",
+		"AWS_SECRET_ACCESS_KEY=nope",
+		"GATEWAY_AUTH_TOKEN=secret",
+	}
+	policy := EnvPolicy{Allowlist: []string{"PATH", "LC_*", "GATEWAY_*"}}
+	env, filtered := policy.build(host, nil)
+
+	if !containsEnv(env, "PATH=/usr/bin") || !containsEnv(env, "LC_ALL=C.UTF-8") {
+		t.Fatalf("expected allowlisted vars, got %v", env)
+	}
+	if containsEnv(env, "AWS_SECRET_ACCESS_KEY=nope") {
+		t.Fatal("non-allowlisted variable should not be inherited")
+	}
+	if containsEnv(env, "GATEWAY_AUTH_TOKEN=secret") {
+		t.Fatal("denylist must win over allowlist")
+	}
+	if !containsString(filtered, "AWS_SECRET_ACCESS_KEY") || !containsString(filtered, "GATEWAY_AUTH_TOKEN") {
+		t.Fatalf("unexpected filtered list: %v", filtered)
+	}
+}
+
+func TestEnvPolicyAllowlistKeepsTmuxServerSelection(t *testing.T) {
+	host := []string{"TMUX_TMPDIR=/run/chatcode", "EDITOR=vi"}
+	env, _ := EnvPolicy{Allowlist: []string{"PATH"}}.build(host, nil)
+	if !containsEnv(env, "TMUX_TMPDIR=/run/chatcode") {
+		t.Fatalf("expected TMUX_TMPDIR to survive allowlist mode, got %v", env)
+	}
+	if containsEnv(env, "EDITOR=vi") {
+		t.Fatalf("expected EDITOR to be filtered in allowlist mode, got %v", env)
+	}
+}
+
+func TestEnvPolicyAllowlistKeepsBaseEnvironment(t *testing.T) {
+	host := []string{"PATH=/usr/bin", "HOME=/home/vibe", "USER=vibe", "SHELL=/bin/bash", "LANG=C.UTF-8", "EDITOR=vi"}
+	env, filtered := EnvPolicy{Allowlist: []string{"LC_*"}}.build(host, nil)
+	for _, kv := range host[:5] {
+		if !containsEnv(env, kv) {
+			t.Fatalf("expected %s to survive an allowlist without it, got %v", kv, env)
+		}
+	}
+	if containsEnv(env, "EDITOR=vi") || !containsString(filtered, "EDITOR") {
+		t.Fatalf("env = %v, filtered = %v; want EDITOR filtered", env, filtered)
+	}
+}
+
+func TestEnvPolicyDefaultsAndOverrides(t *testing.T) {
+	host := []string{"EDITOR=nano", "LANG=C"}
+	policy := EnvPolicy{Defaults: map[string]string{"EDITOR": "vim", "TZ": "UTC"}}
+	env, _ := policy.build(host, map[string]string{"TZ": "Europe/Berlin"})
+
+	if !containsEnv(env, "EDITOR=vim") || containsEnv(env, "EDITOR=nano") {
+		t.Fatalf("expected gateway default to replace host value, got %v", env)
+	}
+	if !containsEnv(env, "TZ=Europe/Berlin") || containsEnv(env, "TZ=UTC") {
+		t.Fatalf("expected session override to win over default, got %v", env)
+	}
+	if !containsEnv(env, "LANG=C") {
+		t.Fatalf("expected host var to remain, got %v", env)
+	}
+}
+
+func TestUnsetPrefix(t *testing.T) {
+	got := unsetPrefix([]string{"GATEWAY_AUTH_TOKEN", "bad-name", "A"})
+	if got != "unset A GATEWAY_AUTH_TOKEN; " {
+		t.Fatalf("unsetPrefix = %q", got)
+	}
+	if got := unsetPrefix(nil); got != "" {
+		t.Fatalf("unsetPrefix(nil) = %q, want empty", got)
+	}
+}
+
+func TestBuildTmuxNewSessionCmdFiltersGatewaySecrets(t *testing.T) {
+	t.Setenv("GATEWAY_AUTH_TOKEN", "leak-canary")
+
+	s := &Session{
+		opts: Options{
+			SessionID: "ses-env",
+			Workdir:   "/tmp",
+			Env:       map[string]string{"VIBECODE_SESSION_ENV": "from-session"},
+		},
+	}
+	b := &tmuxBackend{name: "vibe-ses-env"}
+	s.backend = b
+	cmd := b.newSessionCmd(s.launch(s.agent, false))
+
+	for _, kv := range cmd.Env {
+		if strings.Contains(kv, "leak-canary") {
+			t.Fatalf("gateway token leaked into tmux client env: %q", kv)
+		}
+	}
+	shellCmd := cmd.Args[len(cmd.Args)-1]
+	if !strings.HasPrefix(shellCmd, "unset ") || !strings.Contains(shellCmd, "GATEWAY_AUTH_TOKEN") {
+		t.Fatalf("expected pane command to unset gateway vars, got %q", shellCmd)
+	}
+	if !containsString(cmd.Args, "VIBECODE_SESSION_ENV=from-session") {
+		t.Fatalf("expected session var passed with -e, got %v", cmd.Args)
+	}
+}
+
+func TestSessionPaneDoesNotInheritGatewayToken(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+	t.Setenv("GATEWAY_AUTH_TOKEN", "leak-canary-auth")
+	t.Setenv("GATEWAY_BOOTSTRAP_TOKEN", "leak-canary-boot")
+
+	m := NewManager(5)
+	m.SetEnvPolicy(EnvPolicy{Defaults: map[string]string{"CHATCODE_DEFAULT_ENV": "from-gateway"}})
+	s, err := m.Create(Options{
+		SessionID: "env-" + time.Now().Format("150405"),
+		Name:      "env",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		Env:       map[string]string{"VIBECODE_SESSION_ENV": "from-session"},
+		OutputCh:  make(chan OutputChunk, 64),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+
+	time.Sleep(300 * time.Millisecond)
+	probe := `echo "probe:[${GATEWAY_AUTH_TOKEN:-unset}][${GATEWAY_BOOTSTRAP_TOKEN:-unset}][$CHATCODE_DEFAULT_ENV][$VIBECODE_SESSION_ENV]"; env | grep -c leak-canary` + "\n"
+	if err := s.Input([]byte(probe)); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	want := "probe:[unset][unset][from-gateway][from-session]"
+	deadline := time.Now().Add(5 * time.Second)
+	var content string
+	for time.Now().Before(deadline) {
+		content, _, _, _, _, _, _, _, err = s.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot: %v", err)
+		}
+		if strings.Contains(content, want) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !strings.Contains(content, want) {
+		t.Fatalf("expected %q in pane output, got:\n%s", want, content)
+	}
+	if strings.Contains(content, "leak-canary-") {
+		t.Fatalf("gateway token reached the tmux pane:\n%s", content)
+	}
+}
+
+func TestSessionPaneDoesNotInheritOtherSessionEnv(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+	// The first session starts a fresh tmux server.
+	server := TmuxServer{Socket: filepath.Join(t.TempDir(), "tmux")}
+	defer server.command("kill-server").Run()
+
+	m := NewManager(5)
+	m.SetTmuxServer(server)
+	create := func(id string, env map[string]string) *Session {
+		s, err := m.Create(Options{
+			SessionID: id + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+			Name:      id,
+			Workdir:   t.TempDir(),
+			Agent:     "none",
+			Env:       env,
+			OutputCh:  make(chan OutputChunk, 256),
+		})
+		if err != nil {
+			t.Fatalf("Create %s: %v", id, err)
+		}
+		t.Cleanup(func() { m.End(s.opts.SessionID) })
+		return s
+	}
+	first := create("env-first", map[string]string{
+		"CHATCODE_FIRST_SECRET": "leak-canary-first",
+		"PATH":                  "/opt/chatcode-first:" + os.Getenv("PATH"),
+	})
+	second := create("env-second", nil)
+
+	waitForShell(t, first)
+	waitForShell(t, second)
+	probe := `echo "probe:[${CHATCODE_FIRST_SECRET:-unset}][$(case :$PATH: in *:/opt/chatcode-first:*) echo own;; *) echo host;; esac)-path]"` + "\n"
+	if err := first.Input([]byte(probe)); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	if err := second.Input([]byte(probe)); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	waitForPane(t, first, "probe:[leak-canary-first][own-path]")
+	waitForPane(t, second, "probe:[unset][host-path]")
+}
+
+func TestRecoveredSessionRestartKeepsEnvPolicy(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+	server := TmuxServer{Socket: filepath.Join(t.TempDir(), "tmux")}
+	defer server.command("kill-server").Run()
+
+	m := NewManager(5)
+	m.SetTmuxServer(server)
+	s, err := m.Create(Options{
+		SessionID: "ses-env-recover-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		Name:      "env-recover",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 256),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	s.stopCapture()
+
+	// The gateway comes back with a session default and recovers the
+	// session; its restarted pane gets the default.
+	restarted := NewManager(5)
+	restarted.SetTmuxServer(server)
+	restarted.SetEnvPolicy(EnvPolicy{Defaults: map[string]string{"CHATCODE_GATEWAY_DEFAULT": "from-policy"}})
+	if _, err := restarted.Recover(make(chan OutputChunk, 256)); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	recovered := restarted.Get(s.opts.SessionID)
+	if recovered == nil {
+		t.Fatal("session was not recovered")
+	}
+	defer restarted.End(s.opts.SessionID)
+	if _, err := recovered.Restart(RestartOptions{}); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	waitForShell(t, recovered)
+	if err := recovered.Input([]byte(`echo "probe:[${CHATCODE_GATEWAY_DEFAULT:-unset}]"` + "\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	waitForPane(t, recovered, "probe:[from-policy]")
+}
+
+func containsString(items []string, wanted string) bool {
+	for _, item := range items {
+		if item == wanted {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// agentExitOption is the tmux user option the agent launch wrapper sets to
+// the agent's exit code before it starts the fallback shell.
+const agentExitOption = "@chatcode-agent-exit"
+
+// paneExitOption is the tmux user option the pane wrapper sets to the exit
+// code of the pane command. tmux does not always manage to record
+// pane_dead_status (the pane's pty can close before the child is reaped), so
+// this is the fallback.
+const paneExitOption = "@chatcode-exit"
+
+// paneStatusFormat reports pane liveness, tmux's recorded exit status for a
+// dead pane (kept by remain-on-exit), the exit code recorded by the pane
+// wrapper and the agent exit code set by the launch wrapper.
+const paneStatusFormat = "#{pane_dead}:#{pane_dead_status}:#{pane_dead_signal}:#{" + paneExitOption + "}:#{" + agentExitOption + "}"
+
+// ExitStatus describes how a session's pane process ended.
+type ExitStatus struct {
+	// Code is the exit code. Deaths by signal are reported as 128+signal,
+	// like a shell's $?.
+	Code int
+	// Known is false when no status was recorded, e.g. the tmux session was
+	// killed from outside the gateway.
+	Known bool
+	// Reason says why the session ended.
+	Reason EndReason
+}
+
+type paneStatus struct {
+	dead        bool
+	exit        ExitStatus
+	agentExit   int
+	agentExited bool
+}
+
+// wrapPaneCommand runs cmd in a subshell, so an exec in cmd cannot skip the
+// trailer, and records its exit code in paneExitOption.
+func wrapPaneCommand(cmd string) string {
+	return fmt.Sprintf(
+		`(%s); ec=$?; tmux set-option -q -t "$TMUX_PANE" %s "$ec" >/dev/null 2>&1; exit "$ec"`,
+		cmd,
+		paneExitOption,
+	)
+}
+
+func paneStatusArgs(tmuxName string) []string {
+	return []string{"list-panes", "-t", tmuxName, "-F", paneStatusFormat}
+}
+
+func parsePaneStatusOutput(out string) (paneStatus, error) {
+	line, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	fields := strings.Split(line, ":")
+	if len(fields) != 5 {
+		return paneStatus{}, fmt.Errorf("unexpected pane status %q", line)
+	}
+	var st paneStatus
+	st.dead = fields[0] == "1"
+	if st.dead {
+		if code, err := strconv.Atoi(fields[1]); err == nil {
+			st.exit = ExitStatus{Code: code, Known: true}
+		} else if sig, err := strconv.Atoi(fields[2]); err == nil {
+			st.exit = ExitStatus{Code: 128 + sig, Known: true}
+		} else if code, err := strconv.Atoi(fields[3]); err == nil {
+			st.exit = ExitStatus{Code: code, Known: true}
+		}
+	}
+	if code, err := strconv.Atoi(fields[4]); err == nil {
+		st.agentExit = code
+		st.agentExited = true
+	}
+	return st, nil
+}
+
+// ensureRemainOnExit keeps the tmux session around after its pane process
+// exits so the exit status can be read; the gateway removes it afterwards.
+func (b *tmuxBackend) ensureRemainOnExit() error {
+	cmd := b.server.command("set-option", "-w", "-t", b.name, "remain-on-exit", "on")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux set remain-on-exit: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (s *Session) recordPaneStatus(st paneStatus) {
+	s.exitMu.Lock()
+	defer s.exitMu.Unlock()
+	if st.dead && !s.exitStatus.Known {
+		s.exitStatus = st.exit
+	}
+	if st.agentExited && !s.agentExitSeen {
+		s.agentExitSeen = true
+		s.agentExit = st.agentExit
+		s.agentExitPending = true
+	}
+}
+
+// resetExit forgets what the pane process and agent reported on exit, once
+// the pane process was restarted.
+func (s *Session) resetExit() {
+	s.exitMu.Lock()
+	defer s.exitMu.Unlock()
+	s.exitStatus = ExitStatus{}
+	s.agentExit = 0
+	s.agentExitSeen = false
+	s.agentExitPending = false
+}
+
+// ExitStatus returns the pane process exit status once the session ended.
+func (s *Session) ExitStatus() ExitStatus {
+	s.exitMu.Lock()
+	defer s.exitMu.Unlock()
+	st := s.exitStatus
+	st.Reason = s.endReason
+	if st.Reason == "" {
+		st.Reason = EndReasonExited
+	}
+	return st
+}
+
+// setEndReason records why the session is being ended. The first reason
+// wins.
+func (s *Session) setEndReason(reason EndReason) {
+	s.exitMu.Lock()
+	defer s.exitMu.Unlock()
+	if s.endReason == "" {
+		s.endReason = reason
+	}
+}
+
+// takeAgentExit returns the agent exit code once, after the launch wrapper
+// reported it.
+func (s *Session) takeAgentExit() (code int, ok bool) {
+	s.exitMu.Lock()
+	defer s.exitMu.Unlock()
+	if !s.agentExitPending {
+		return 0, false
+	}
+	s.agentExitPending = false
+	return s.agentExit, true
+}
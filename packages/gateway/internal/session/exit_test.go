@@ -0,0 +1,140 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePaneStatusOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want paneStatus
+	}{
+		{name: "running", out: "0::::\n", want: paneStatus{}},
+		{name: "exited", out: "1:7:::\n", want: paneStatus{dead: true, exit: ExitStatus{Code: 7, Known: true}}},
+		{name: "exited zero", out: "1:0:::\n", want: paneStatus{dead: true, exit: ExitStatus{Code: 0, Known: true}}},
+		{name: "signaled", out: "1::15::\n", want: paneStatus{dead: true, exit: ExitStatus{Code: 143, Known: true}}},
+		{name: "exit from wrapper", out: "1:::7:\n", want: paneStatus{dead: true, exit: ExitStatus{Code: 7, Known: true}}},
+		{name: "dead without status", out: "1::::\n", want: paneStatus{dead: true}},
+		{name: "agent exited", out: "0::::3\n", want: paneStatus{agentExit: 3, agentExited: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePaneStatusOutput(tt.out)
+			if err != nil {
+				t.Fatalf("parsePaneStatusOutput: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("parsePaneStatusOutput(%q) = %+v, want %+v", tt.out, got, tt.want)
+			}
+		})
+	}
+	if _, err := parsePaneStatusOutput("garbage"); err == nil {
+		t.Fatal("expected error for malformed output")
+	}
+}
+
+func TestTakeAgentExitReportsOnce(t *testing.T) {
+	s := &Session{}
+	s.recordPaneStatus(paneStatus{agentExit: 2, agentExited: true})
+	s.recordPaneStatus(paneStatus{agentExit: 2, agentExited: true})
+	if code, ok := s.takeAgentExit(); !ok || code != 2 {
+		t.Fatalf("takeAgentExit = %d, %v; want 2, true", code, ok)
+	}
+	if _, ok := s.takeAgentExit(); ok {
+		t.Fatal("agent exit should be reported only once")
+	}
+}
+
+func TestSessionExitReportsShellExitCode(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	exits := make(chan ExitStatus, 1)
+	m.SetOnSessionExit(func(_ string, status ExitStatus) {
+		exits <- status
+	})
+	s, err := m.Create(Options{
+		SessionID: "exit-" + time.Now().Format("150405"),
+		Name:      "exit",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 64),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer s.backend.destroy()
+
+	time.Sleep(300 * time.Millisecond)
+	if err := s.Input([]byte("exit 7\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	select {
+	case status := <-exits:
+		if !status.Known || status.Code != 7 {
+			t.Fatalf("exit status = %+v, want code 7", status)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for session exit")
+	}
+	if s.isAlive() {
+		t.Fatal("expected dead pane to be cleaned up after reporting its status")
+	}
+}
+
+func TestAgentExitReportedWhileShellKeepsRunning(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	bin := t.TempDir()
+	script := "#!/bin/sh\nexit 3\n"
+	if err := os.WriteFile(filepath.Join(bin, "codex"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake agent: %v", err)
+	}
+
+	m := NewManager(5)
+	agentExits := make(chan int, 1)
+	m.SetOnAgentExit(func(_ string, code int) {
+		agentExits <- code
+	})
+	s, err := m.Create(Options{
+		SessionID: "agent-exit-" + time.Now().Format("150405"),
+		Name:      "agent-exit",
+		Workdir:   t.TempDir(),
+		Agent:     "codex",
+		Env:       map[string]string{"PATH": bin + ":" + os.Getenv("PATH")},
+		OutputCh:  make(chan OutputChunk, 64),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+
+	select {
+	case code := <-agentExits:
+		if code != 3 {
+			t.Fatalf("agent exit code = %d, want 3", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for agent exit")
+	}
+	if !s.isAlive() {
+		t.Fatal("expected fallback shell to keep the session alive")
+	}
+	content, _, _, _, _, _, _, _, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if !strings.Contains(content, "codex exited (code 3)") {
+		t.Fatalf("expected wrapper message in pane, got:\n%s", content)
+	}
+}
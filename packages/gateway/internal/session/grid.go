@@ -0,0 +1,56 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
+)
+
+// GridSnapshot renders the visible pane into a grid of cells with the pane's
+// cursor state. Scrollback is not included.
+func (s *Session) GridSnapshot() (vt.Grid, error) {
+	return s.backend.grid()
+}
+
+func (b *tmuxBackend) grid() (vt.Grid, error) {
+	out, err := b.server.command(gridCaptureArgs(b.name)...).Output()
+	if err != nil {
+		return vt.Grid{}, fmt.Errorf("capture-pane: %w", err)
+	}
+	return renderGridCapture(string(out))
+}
+
+// gridCaptureArgs captures the pane state and the visible pane with escapes
+// in one tmux call, so the cursor matches the captured content.
+func gridCaptureArgs(tmuxName string) []string {
+	return []string{
+		"display-message", "-p", "-t", tmuxName,
+		"#{pane_width} #{pane_height} #{cursor_x} #{cursor_y} #{cursor_flag} #{alternate_on}", ";",
+		"capture-pane", "-e", "-N", "-p", "-t", tmuxName,
+	}
+}
+
+// renderGridCapture replays the output of gridCaptureArgs into a screen.
+func renderGridCapture(out string) (vt.Grid, error) {
+	header, content, _ := strings.Cut(out, "\n")
+	var cols, rows, cursorX, cursorY, cursorFlag, alternate int
+	if _, err := fmt.Sscanf(header, "%d %d %d %d %d %d", &cols, &rows, &cursorX, &cursorY, &cursorFlag, &alternate); err != nil {
+		return vt.Grid{}, fmt.Errorf("parse pane state %q: %w", header, err)
+	}
+
+	screen := vt.New(cols, rows)
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	for i, line := range lines[:min(len(lines), rows)] {
+		// Position each line explicitly: a line that fills the pane width
+		// leaves the cursor waiting to wrap. Colors carry over, as tmux only
+		// emits attribute changes.
+		fmt.Fprintf(screen, "\x1b[%d;1H%s", i+1, line)
+	}
+
+	grid := screen.Grid()
+	grid.CursorX, grid.CursorY = cursorX, cursorY
+	grid.CursorVisible = cursorFlag == 1
+	grid.AlternateOn = alternate == 1
+	return grid, nil
+}
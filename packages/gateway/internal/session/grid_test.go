@@ -0,0 +1,96 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
+)
+
+func TestGridCaptureArgs(t *testing.T) {
+	got := gridCaptureArgs("vibe-ses-1")
+	want := []string{
+		"display-message", "-p", "-t", "vibe-ses-1",
+		"#{pane_width} #{pane_height} #{cursor_x} #{cursor_y} #{cursor_flag} #{alternate_on}", ";",
+		"capture-pane", "-e", "-N", "-p", "-t", "vibe-ses-1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("args = %q, want %q", got, want)
+	}
+}
+
+func TestRenderGridCapture(t *testing.T) {
+	// Captured from tmux: attributes carry over to the next line.
+	out := "8 3 2 1 0 1\n\x1b[31mred\nstill\x1b[39m ok\n\x1b[1m\x1b[44mbold\x1b[0m 中\n"
+	g, err := renderGridCapture(out)
+	if err != nil {
+		t.Fatalf("renderGridCapture: %v", err)
+	}
+	if g.Cols != 8 || g.Rows != 3 || g.CursorX != 2 || g.CursorY != 1 || g.CursorVisible || !g.AlternateOn {
+		t.Fatalf("grid state = %+v", g)
+	}
+	if got := g.Text(); got != "red\nstill ok\nbold 中" {
+		t.Fatalf("text = %q", got)
+	}
+	if c := g.Lines[1][0]; c.FG != vt.Indexed(1) {
+		t.Fatalf("second line fg = %+v, want red", c.FG)
+	}
+	if c := g.Lines[2][0]; c.Attrs != vt.Bold || c.BG != vt.Indexed(4) {
+		t.Fatalf("bold cell = %+v", c)
+	}
+	if c := g.Lines[2][5]; !c.Wide {
+		t.Fatalf("wide cell = %+v", c)
+	}
+
+	if _, err := renderGridCapture("garbage\n"); err == nil {
+		t.Fatal("expected a bad header to fail")
+	}
+}
+
+func TestSessionGridSnapshot(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "grid-" + time.Now().Format("150405"),
+		Name:      "grid",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	time.Sleep(300 * time.Millisecond)
+
+	if err := s.Input([]byte("clear; printf '\\033[32mgrid_%s\\033[0m\\n' ok\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		g, err := s.GridSnapshot()
+		if err != nil {
+			t.Fatalf("GridSnapshot: %v", err)
+		}
+		for _, line := range g.Lines {
+			if len(line) >= 7 && line[0].Char == "g" && line[5].Char == "o" && line[6].Char == "k" {
+				if line[0].FG != vt.Indexed(2) {
+					t.Fatalf("grid_ok fg = %+v, want green", line[0].FG)
+				}
+				if g.Cols != 80 || g.Rows != 24 || len(g.Lines) != 24 {
+					t.Fatalf("grid size = %dx%d with %d lines", g.Cols, g.Rows, len(g.Lines))
+				}
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("grid never showed the output:\n%s", g.Text())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"fmt"
+)
+
+// maxHistoryLines bounds the lines returned by one History call.
+const maxHistoryLines = 10000
+
+// HistoryPage is a range of pane lines. Line numbers follow tmux: 0 is the
+// top line of the visible pane and negative numbers go back into the
+// scrollback, down to -HistorySize. They shift as new output scrolls lines
+// into the history.
+type HistoryPage struct {
+	Start int
+	End   int
+	// HistorySize is the number of scrollback lines above the visible pane.
+	HistorySize int
+	// Content holds lines Start through End, each terminated by "\n".
+	Content string
+}
+
+// History captures pane lines start through end (inclusive), clamped to the
+// available scrollback and the visible pane. Spans longer than 10000 lines
+// are cut at the start. With ansi, colors and attributes are kept as escape
+// sequences; otherwise plain text is returned.
+func (s *Session) History(start, end int, ansi bool) (HistoryPage, error) {
+	if end < start {
+		return HistoryPage{}, fmt.Errorf("invalid line range %d..%d", start, end)
+	}
+	return s.backend.history(start, end, ansi)
+}
+
+func (b *tmuxBackend) history(start, end int, ansi bool) (HistoryPage, error) {
+	out, err := b.server.command("display-message", "-t", b.name, "-p", "#{history_size} #{pane_height}").Output()
+	if err != nil {
+		return HistoryPage{}, fmt.Errorf("tmux display-message: %w", err)
+	}
+	var historySize, height int
+	if _, err := fmt.Sscanf(string(out), "%d %d", &historySize, &height); err != nil {
+		return HistoryPage{}, fmt.Errorf("parse pane size %q: %w", out, err)
+	}
+
+	page := HistoryPage{HistorySize: historySize}
+	page.Start, page.End = clampHistoryRange(start, end, historySize, height)
+	if page.End < page.Start {
+		// The range lies entirely outside the pane's lines.
+		return page, nil
+	}
+	raw, err := b.server.command(historyCaptureArgs(b.name, page.Start, page.End, ansi)...).Output()
+	if err != nil {
+		return HistoryPage{}, fmt.Errorf("capture-pane: %w", err)
+	}
+	page.Content = string(raw)
+	if ansi {
+		page.Content = stripOSC8Hyperlinks(page.Content)
+	}
+	return page, nil
+}
+
+// clampHistoryRange limits start..end to the lines the pane holds and to
+// maxHistoryLines, keeping the end of the range.
+func clampHistoryRange(start, end, historySize, height int) (int, int) {
+	start = max(start, -historySize)
+	end = min(end, height-1)
+	start = max(start, end-maxHistoryLines+1)
+	return start, end
+}
+
+func historyCaptureArgs(tmuxName string, start, end int, ansi bool) []string {
+	args := []string{"capture-pane", "-p", "-t", tmuxName, "-S", fmt.Sprint(start), "-E", fmt.Sprint(end)}
+	if ansi {
+		args = append(args, "-e")
+	}
+	return args
+}
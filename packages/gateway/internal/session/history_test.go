@@ -0,0 +1,97 @@
+package session
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClampHistoryRange(t *testing.T) {
+	tests := []struct {
+		name                string
+		start, end          int
+		historySize, height int
+		wantStart, wantEnd  int
+	}{
+		{name: "inside", start: -100, end: -51, historySize: 500, height: 40, wantStart: -100, wantEnd: -51},
+		{name: "before oldest line", start: -900, end: -1, historySize: 500, height: 40, wantStart: -500, wantEnd: -1},
+		{name: "past visible pane", start: 10, end: 99, historySize: 500, height: 40, wantStart: 10, wantEnd: 39},
+		{name: "too long", start: -50000, end: -1, historySize: 100000, height: 40, wantStart: -maxHistoryLines, wantEnd: -1},
+		{name: "no history", start: -20, end: -1, historySize: 0, height: 40, wantStart: 0, wantEnd: -1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end := clampHistoryRange(tc.start, tc.end, tc.historySize, tc.height)
+			if start != tc.wantStart || end != tc.wantEnd {
+				t.Fatalf("clampHistoryRange = %d..%d, want %d..%d", start, end, tc.wantStart, tc.wantEnd)
+			}
+		})
+	}
+}
+
+func TestHistoryCaptureArgs(t *testing.T) {
+	got := historyCaptureArgs("vibe-ses-1", -200, -101, true)
+	want := []string{"capture-pane", "-p", "-t", "vibe-ses-1", "-S", "-200", "-E", "-101", "-e"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("args = %q, want %q", got, want)
+	}
+	if got := historyCaptureArgs("vibe-ses-1", 0, 9, false); got[len(got)-1] == "-e" {
+		t.Fatalf("args = %q, want no -e when stripping ANSI", got)
+	}
+}
+
+func TestSessionHistoryPagesScrollback(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "history-" + time.Now().Format("150405"),
+		Name:      "history",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	time.Sleep(300 * time.Millisecond)
+
+	if err := s.Input([]byte("printf 'history_line_%d\\n' $(seq 1 300)\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	var page HistoryPage
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		page, err = s.History(-100000, -1, false)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		if strings.Contains(page.Content, "history_line_1\n") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scrollback never held the first line, history_size=%d", page.HistorySize)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if page.Start != -page.HistorySize || page.End != -1 {
+		t.Fatalf("page range = %d..%d, history_size %d", page.Start, page.End, page.HistorySize)
+	}
+	if lines := strings.Count(page.Content, "\n"); lines != page.End-page.Start+1 {
+		t.Fatalf("page has %d lines, want %d", lines, page.End-page.Start+1)
+	}
+
+	// A page of the two oldest lines.
+	older, err := s.History(-page.HistorySize, -page.HistorySize+1, false)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if strings.Count(older.Content, "\n") != 2 {
+		t.Fatalf("older page = %q, want 2 lines", older.Content)
+	}
+}
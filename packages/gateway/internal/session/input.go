@@ -0,0 +1,200 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxInputWriteBytes keeps one send-keys below tmux's 16 KiB command
+	// size limit, which also covers the other arguments.
+	maxInputWriteBytes = 8 << 10
+	// maxPendingInputBytes bounds input queued behind a slow tmux. Frames
+	// beyond it are dropped and reported in the next InputAck.
+	maxPendingInputBytes = 1 << 20
+)
+
+// InputAck reports sequenced input written to the pane.
+type InputAck struct {
+	// Seq is the highest input seq written so far.
+	Seq uint64
+	// Dropped counts input frames lost since the previous ack: seqs that
+	// never arrived and frames rejected because the queue was full.
+	Dropped uint64
+	// Err is set when the write failed; the input up to Seq is lost.
+	Err error
+}
+
+type inputItem struct {
+	data      []byte
+	seq       uint64
+	sequenced bool
+	done      chan error // nil for sequenced frames
+	// write, if set, writes the item on its own instead of coalescing it
+	// with its neighbours, e.g. for pastes.
+	write func([]byte) error
+}
+
+// inputWriter serializes a session's input. Items are written in arrival
+// order by a goroutine that runs while the queue is non-empty; items queued
+// during a write are coalesced into the next one.
+//
+// Sequenced frames carry a per-session seq. seq 0 starts a new stream (e.g.
+// a reconnected client); after that each frame must carry the previous seq
+// plus one. Repeated seqs are ignored so frames can be retransmitted, and
+// skipped seqs are counted as dropped.
+type inputWriter struct {
+	mu      sync.Mutex
+	queue   []inputItem
+	pending int
+	running bool
+	lastSeq uint64
+	dropped uint64
+	onAck   func(InputAck)
+}
+
+// enqueueSequenced queues a sequenced frame. It reports false for repeated
+// seqs, which are ignored.
+func (w *inputWriter) enqueueSequenced(seq uint64, data []byte, write func([]byte) error, onAck func(InputAck)) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case seq == 0:
+		w.lastSeq = 0
+	case seq <= w.lastSeq:
+		return false
+	case seq > w.lastSeq+1:
+		w.dropped += seq - w.lastSeq - 1
+	}
+	w.lastSeq = seq
+	w.onAck = onAck
+	if w.pending+len(data) > maxPendingInputBytes {
+		w.dropped++
+		return true
+	}
+	w.pushLocked(inputItem{data: data, seq: seq, sequenced: true}, write)
+	return true
+}
+
+// enqueueWait queues unsequenced input and waits until it was written.
+func (w *inputWriter) enqueueWait(data []byte, write func([]byte) error) error {
+	return w.wait(inputItem{data: data}, write)
+}
+
+// enqueueOwnWait queues data to be written on its own with own, after the
+// input queued before it, and waits until it was written.
+func (w *inputWriter) enqueueOwnWait(data []byte, own, write func([]byte) error) error {
+	return w.wait(inputItem{data: data, write: own}, write)
+}
+
+func (w *inputWriter) wait(item inputItem, write func([]byte) error) error {
+	item.done = make(chan error, 1)
+	w.mu.Lock()
+	w.pushLocked(item, write)
+	w.mu.Unlock()
+	return <-item.done
+}
+
+func (w *inputWriter) pushLocked(item inputItem, write func([]byte) error) {
+	w.queue = append(w.queue, item)
+	w.pending += len(item.data)
+	if !w.running {
+		w.running = true
+		go w.run(write)
+	}
+}
+
+func (w *inputWriter) run(write func([]byte) error) {
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		// Coalesce queued items up to one send-keys worth; a larger single
+		// item is written on its own and chunked by write.
+		n, size := 1, len(w.queue[0].data)
+		for w.queue[0].write == nil && n < len(w.queue) && w.queue[n].write == nil &&
+			size+len(w.queue[n].data) <= maxInputWriteBytes {
+			size += len(w.queue[n].data)
+			n++
+		}
+		batch := w.queue[:n:n]
+		w.queue = w.queue[n:]
+		w.pending -= size
+		w.mu.Unlock()
+
+		data := make([]byte, 0, size)
+		var (
+			seq       uint64
+			sequenced bool
+		)
+		for _, item := range batch {
+			data = append(data, item.data...)
+			if item.sequenced {
+				seq, sequenced = item.seq, true
+			}
+		}
+		writeBatch := write
+		if batch[0].write != nil {
+			writeBatch = batch[0].write
+		}
+		err := writeBatch(data)
+		for _, item := range batch {
+			if item.done != nil {
+				item.done <- err
+			}
+		}
+		if !sequenced {
+			continue
+		}
+		w.mu.Lock()
+		ack := InputAck{Seq: seq, Dropped: w.dropped, Err: err}
+		w.dropped = 0
+		onAck := w.onAck
+		w.mu.Unlock()
+		if onAck != nil {
+			onAck(ack)
+		}
+	}
+}
+
+// QueueInput queues a sequenced input frame for the pane and returns
+// without waiting for the write. onAck is called after the frame was written.
+func (s *Session) QueueInput(seq uint64, data []byte, onAck func(InputAck)) {
+	if !s.input.enqueueSequenced(seq, data, s.writeInput, onAck) {
+		return
+	}
+	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
+}
+
+// writeInput sends data to the pane as literal keys.
+func (s *Session) writeInput(data []byte) error {
+	if err := s.backend.writeInput(data); err != nil {
+		return err
+	}
+	s.wakeCapture()
+	if r := s.recorder.get(); r != nil {
+		r.Input()
+	}
+	return nil
+}
+
+// inputChunkLen returns the length of the first chunk of data, at most max
+// bytes and not splitting a UTF-8 sequence.
+func inputChunkLen(data []byte, max int) int {
+	if len(data) <= max {
+		return len(data)
+	}
+	n := max
+	for n > max-utf8.UTFMax && n > 0 && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	if n == 0 || !utf8.RuneStart(data[n]) {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,215 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter records writes; the first write blocks until released so
+// tests can queue input behind it.
+type blockingWriter struct {
+	mu      sync.Mutex
+	writes  []string
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (b *blockingWriter) write(data []byte) error {
+	b.mu.Lock()
+	first := len(b.writes) == 0
+	b.writes = append(b.writes, string(data))
+	b.mu.Unlock()
+	if first {
+		close(b.started)
+		<-b.release
+	}
+	return b.err
+}
+
+func (b *blockingWriter) got() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.writes...)
+}
+
+func collectAcks(n int) (func(InputAck), <-chan []InputAck) {
+	var (
+		mu   sync.Mutex
+		acks []InputAck
+	)
+	out := make(chan []InputAck, 1)
+	return func(ack InputAck) {
+		mu.Lock()
+		defer mu.Unlock()
+		acks = append(acks, ack)
+		if len(acks) == n {
+			out <- acks
+		}
+	}, out
+}
+
+func waitAcks(t *testing.T, ch <-chan []InputAck) []InputAck {
+	t.Helper()
+	select {
+	case acks := <-ch:
+		return acks
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for input acks")
+		return nil
+	}
+}
+
+func TestInputWriterCoalescesQueuedFrames(t *testing.T) {
+	var w inputWriter
+	bw := newBlockingWriter()
+	onAck, acks := collectAcks(2)
+
+	w.enqueueSequenced(1, []byte("a"), bw.write, onAck)
+	<-bw.started
+	w.enqueueSequenced(2, []byte("b"), bw.write, onAck)
+	w.enqueueSequenced(3, []byte("c"), bw.write, onAck)
+	close(bw.release)
+
+	got := waitAcks(t, acks)
+	if got[0].Seq != 1 || got[1].Seq != 3 {
+		t.Fatalf("acks = %+v, want seq 1 then 3", got)
+	}
+	if writes := bw.got(); strings.Join(writes, "|") != "a|bc" {
+		t.Fatalf("writes = %q, want [a bc]", writes)
+	}
+}
+
+func TestInputWriterSequenceTracking(t *testing.T) {
+	var w inputWriter
+	bw := newBlockingWriter()
+	onAck, acks := collectAcks(2)
+
+	w.enqueueSequenced(1, []byte("a"), bw.write, onAck)
+	<-bw.started
+	if w.enqueueSequenced(1, []byte("a"), bw.write, onAck) {
+		t.Fatal("expected a repeated seq to be ignored")
+	}
+	w.enqueueSequenced(4, []byte("d"), bw.write, onAck)
+	close(bw.release)
+
+	got := waitAcks(t, acks)
+	if got[1].Seq != 4 || got[0].Dropped+got[1].Dropped != 2 {
+		t.Fatalf("acks = %+v, want seq 4 with 2 dropped", got)
+	}
+	if writes := bw.got(); strings.Join(writes, "|") != "a|d" {
+		t.Fatalf("writes = %q, want [a d]", writes)
+	}
+
+	// seq 0 starts a new stream, e.g. after the client reconnected.
+	for _, seq := range []uint64{0, 1} {
+		onAck, acks = collectAcks(1)
+		w.enqueueSequenced(seq, []byte("x"), bw.write, onAck)
+		if got := waitAcks(t, acks); got[0].Seq != seq || got[0].Dropped != 0 {
+			t.Fatalf("ack after restart = %+v, want seq %d", got[0], seq)
+		}
+	}
+}
+
+func TestInputWriterDropsFramesBeyondQueueLimit(t *testing.T) {
+	var w inputWriter
+	bw := newBlockingWriter()
+	onAck, acks := collectAcks(2)
+
+	w.enqueueSequenced(1, []byte("a"), bw.write, onAck)
+	<-bw.started
+	w.enqueueSequenced(2, bytes.Repeat([]byte("b"), maxPendingInputBytes+1), bw.write, onAck)
+	w.enqueueSequenced(3, []byte("c"), bw.write, onAck)
+	close(bw.release)
+
+	got := waitAcks(t, acks)
+	if got[1].Seq != 3 || got[0].Dropped+got[1].Dropped != 1 {
+		t.Fatalf("acks = %+v, want the oversized frame reported as dropped", got)
+	}
+}
+
+func TestInputWriterReportsWriteErrors(t *testing.T) {
+	var w inputWriter
+	bw := newBlockingWriter()
+	bw.err = errors.New("tmux gone")
+	close(bw.release)
+
+	if err := w.enqueueWait([]byte("x"), bw.write); err == nil {
+		t.Fatal("expected write error")
+	}
+	onAck, acks := collectAcks(1)
+	w.enqueueSequenced(1, []byte("y"), bw.write, onAck)
+	if got := waitAcks(t, acks); got[0].Err == nil {
+		t.Fatalf("ack = %+v, want error", got[0])
+	}
+}
+
+func TestInputChunkLenKeepsRunesWhole(t *testing.T) {
+	data := []byte("abc€def") // € is 3 bytes at offsets 3..5
+	if got := inputChunkLen(data, 4); got != 3 {
+		t.Fatalf("inputChunkLen = %d, want 3", got)
+	}
+	if got := inputChunkLen(data, 6); got != 6 {
+		t.Fatalf("inputChunkLen = %d, want 6", got)
+	}
+	if got := inputChunkLen(data, 100); got != len(data) {
+		t.Fatalf("inputChunkLen = %d, want %d", got, len(data))
+	}
+}
+
+func TestSessionQueueInputWritesLargeInput(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	workdir := t.TempDir()
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "input-" + time.Now().Format("150405"),
+		Name:      "input",
+		Workdir:   workdir,
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	time.Sleep(300 * time.Millisecond)
+
+	if err := s.Input([]byte("cat > out.txt\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Larger than a single send-keys can carry, in lines short enough for
+	// the terminal's canonical mode.
+	long := strings.Repeat(strings.Repeat("x", 63)+"\n", 3*maxInputWriteBytes/64)
+	onAck, acks := collectAcks(1)
+	s.QueueInput(1, []byte(long), onAck)
+	if got := waitAcks(t, acks); got[0].Err != nil || got[0].Seq != 1 {
+		t.Fatalf("ack = %+v", got[0])
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		raw, _ := os.ReadFile(filepath.Join(workdir, "out.txt"))
+		if string(raw) == long {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("out.txt has %d bytes, want %d", len(raw), len(long))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
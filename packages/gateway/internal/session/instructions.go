@@ -0,0 +1,178 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gw "github.com/tractorfm/chatcode/packages/gateway"
+)
+
+// InstructionsPolicy controls how agent instruction files (CLAUDE.md,
+// AGENTS.md) are written into a session workdir before the agent launches.
+type InstructionsPolicy string
+
+const (
+	// InstructionsKeep writes an instruction file only when none exists.
+	InstructionsKeep InstructionsPolicy = "keep"
+	// InstructionsOverwrite replaces any existing instruction file.
+	InstructionsOverwrite InstructionsPolicy = "overwrite"
+	// InstructionsManaged keeps user content and maintains a gateway-owned
+	// block between managed markers.
+	InstructionsManaged InstructionsPolicy = "managed"
+
+	// DefaultInstructionsPolicy is used when neither the gateway config nor the
+	// session.create command selects a policy.
+	DefaultInstructionsPolicy = InstructionsManaged
+)
+
+const (
+	claudeMDFile = "CLAUDE.md"
+	agentsMDFile = "AGENTS.md"
+
+	managedBlockBegin = "<!-- chatcode:begin (managed by the Chatcode gateway; edits inside this block are replaced) -->"
+	managedBlockEnd   = "<!-- chatcode:end -->"
+)
+
+// Actions reported for each instruction file.
+const (
+	InstructionCreated     = "created"
+	InstructionOverwritten = "overwritten"
+	InstructionMerged      = "merged"
+	InstructionUnchanged   = "unchanged"
+	InstructionSkipped     = "skipped"
+)
+
+// InstructionFile describes what happened to one instruction file.
+type InstructionFile struct {
+	Name   string
+	Path   string
+	Action string
+}
+
+type instructionTarget struct {
+	name    string
+	content string
+}
+
+// ParseInstructionsPolicy validates a policy name. Empty selects the default.
+func ParseInstructionsPolicy(v string) (InstructionsPolicy, error) {
+	switch p := InstructionsPolicy(strings.ToLower(strings.TrimSpace(v))); p {
+	case "":
+		return DefaultInstructionsPolicy, nil
+	case InstructionsKeep, InstructionsOverwrite, InstructionsManaged:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown instructions policy %q", v)
+	}
+}
+
+// instructionTargets returns the files to materialize for a session: the
+// agent's native instruction file (with the embedded default unless
+// overridden) plus any file the control plane explicitly supplied.
+func instructionTargets(agent, claudeMD, agentsMD string) []instructionTarget {
+	wantClaude := claudeMD != ""
+	wantAgents := agentsMD != ""
+	switch agent {
+	case "claude-code":
+		wantClaude = true
+	case "codex", "gemini", "opencode":
+		wantAgents = true
+	}
+
+	var targets []instructionTarget
+	if wantClaude {
+		if claudeMD == "" {
+			claudeMD = gw.DefaultClaudeMD
+		}
+		targets = append(targets, instructionTarget{name: claudeMDFile, content: claudeMD})
+	}
+	if wantAgents {
+		if agentsMD == "" {
+			agentsMD = gw.DefaultAgentsMD
+		}
+		targets = append(targets, instructionTarget{name: agentsMDFile, content: agentsMD})
+	}
+	return targets
+}
+
+// writeInstructionFiles materializes instruction files in workdir according
+// to policy. A missing workdir is not an error: tmux falls back to $HOME and
+// there is nowhere sensible to write.
+func writeInstructionFiles(workdir string, policy InstructionsPolicy, targets []instructionTarget) ([]InstructionFile, error) {
+	if workdir == "" || len(targets) == 0 {
+		return nil, nil
+	}
+	if info, err := os.Stat(workdir); err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	if policy == "" {
+		policy = DefaultInstructionsPolicy
+	}
+
+	written := make([]InstructionFile, 0, len(targets))
+	for _, target := range targets {
+		path := filepath.Join(workdir, target.name)
+		action, err := writeInstructionFile(path, policy, target.content)
+		if err != nil {
+			return written, fmt.Errorf("write %s: %w", target.name, err)
+		}
+		written = append(written, InstructionFile{Name: target.name, Path: path, Action: action})
+	}
+	return written, nil
+}
+
+func writeInstructionFile(path string, policy InstructionsPolicy, content string) (string, error) {
+	existing, err := os.ReadFile(path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	var next, action string
+	switch {
+	case !exists && policy == InstructionsManaged:
+		next, action = managedBlock(content), InstructionCreated
+	case !exists:
+		next, action = content, InstructionCreated
+	case policy == InstructionsKeep:
+		return InstructionSkipped, nil
+	case policy == InstructionsOverwrite:
+		next, action = content, InstructionOverwritten
+	default:
+		next, action = mergeManagedBlock(string(existing), content), InstructionMerged
+	}
+
+	if exists && next == string(existing) {
+		return InstructionUnchanged, nil
+	}
+	if err := os.WriteFile(path, []byte(next), 0o644); err != nil {
+		return "", err
+	}
+	return action, nil
+}
+
+func managedBlock(content string) string {
+	return managedBlockBegin + "\n" + strings.TrimRight(content, "\n") + "\n" + managedBlockEnd + "\n"
+}
+
+// mergeManagedBlock replaces the managed block in existing, or appends one
+// when the file has none. Content outside the markers is left untouched.
+func mergeManagedBlock(existing, content string) string {
+	block := managedBlock(content)
+	start := strings.Index(existing, managedBlockBegin)
+	if start >= 0 {
+		if rel := strings.Index(existing[start:], managedBlockEnd); rel >= 0 {
+			end := start + rel + len(managedBlockEnd)
+			if end < len(existing) && existing[end] == '\n' {
+				end++
+			}
+			return existing[:start] + block + existing[end:]
+		}
+	}
+	if strings.TrimSpace(existing) == "" {
+		return block
+	}
+	return strings.TrimRight(existing, "\n") + "\n\n" + block
+}
@@ -0,0 +1,190 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gw "github.com/tractorfm/chatcode/packages/gateway"
+)
+
+func TestParseInstructionsPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    InstructionsPolicy
+		wantErr bool
+	}{
+		{in: "", want: DefaultInstructionsPolicy},
+		{in: "keep", want: InstructionsKeep},
+		{in: " Overwrite ", want: InstructionsOverwrite},
+		{in: "managed", want: InstructionsManaged},
+		{in: "append", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseInstructionsPolicy(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("ParseInstructionsPolicy(%q) error = nil, want error", tt.in)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Fatalf("ParseInstructionsPolicy(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestInstructionTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		agent    string
+		claudeMD string
+		agentsMD string
+		want     []string
+	}{
+		{name: "claude default", agent: "claude-code", want: []string{claudeMDFile}},
+		{name: "codex default", agent: "codex", want: []string{agentsMDFile}},
+		{name: "opencode default", agent: "opencode", want: []string{agentsMDFile}},
+		{name: "shell without overrides", agent: "none"},
+		{name: "shell with override", agent: "", agentsMD: "custom", want: []string{agentsMDFile}},
+		{name: "claude with agents override", agent: "claude-code", agentsMD: "custom", want: []string{claudeMDFile, agentsMDFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := instructionTargets(tt.agent, tt.claudeMD, tt.agentsMD)
+			if len(targets) != len(tt.want) {
+				t.Fatalf("targets = %v, want %v", targets, tt.want)
+			}
+			for i, name := range tt.want {
+				if targets[i].name != name {
+					t.Fatalf("targets[%d] = %q, want %q", i, targets[i].name, name)
+				}
+			}
+		})
+	}
+
+	targets := instructionTargets("claude-code", "", "")
+	if targets[0].content != gw.DefaultClaudeMD {
+		t.Fatal("expected embedded CLAUDE.md default when no override is given")
+	}
+}
+
+func TestWriteInstructionFilesCreatesMissingFile(t *testing.T) {
+	for _, policy := range []InstructionsPolicy{InstructionsKeep, InstructionsOverwrite} {
+		dir := t.TempDir()
+		files, err := writeInstructionFiles(dir, policy, []instructionTarget{{name: claudeMDFile, content: "hello\n"}})
+		if err != nil {
+			t.Fatalf("writeInstructionFiles(%s): %v", policy, err)
+		}
+		if len(files) != 1 || files[0].Action != InstructionCreated {
+			t.Fatalf("files = %+v, want one created entry", files)
+		}
+		if got := readFile(t, filepath.Join(dir, claudeMDFile)); got != "hello\n" {
+			t.Fatalf("%s content = %q, want %q", policy, got, "hello\n")
+		}
+	}
+}
+
+func TestWriteInstructionFilesKeepSkipsExisting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, agentsMDFile)
+	writeFile(t, path, "user content\n")
+
+	files, err := writeInstructionFiles(dir, InstructionsKeep, []instructionTarget{{name: agentsMDFile, content: "gateway\n"}})
+	if err != nil {
+		t.Fatalf("writeInstructionFiles: %v", err)
+	}
+	if files[0].Action != InstructionSkipped {
+		t.Fatalf("action = %q, want %q", files[0].Action, InstructionSkipped)
+	}
+	if got := readFile(t, path); got != "user content\n" {
+		t.Fatalf("content = %q, want user content preserved", got)
+	}
+}
+
+func TestWriteInstructionFilesOverwriteReplacesExisting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, agentsMDFile)
+	writeFile(t, path, "user content\n")
+
+	files, err := writeInstructionFiles(dir, InstructionsOverwrite, []instructionTarget{{name: agentsMDFile, content: "gateway\n"}})
+	if err != nil {
+		t.Fatalf("writeInstructionFiles: %v", err)
+	}
+	if files[0].Action != InstructionOverwritten {
+		t.Fatalf("action = %q, want %q", files[0].Action, InstructionOverwritten)
+	}
+	if got := readFile(t, path); got != "gateway\n" {
+		t.Fatalf("content = %q, want %q", got, "gateway\n")
+	}
+}
+
+func TestWriteInstructionFilesManagedAppendsThenReplacesBlock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, claudeMDFile)
+	writeFile(t, path, "# My project\n")
+
+	files, err := writeInstructionFiles(dir, InstructionsManaged, []instructionTarget{{name: claudeMDFile, content: "v1"}})
+	if err != nil {
+		t.Fatalf("writeInstructionFiles: %v", err)
+	}
+	if files[0].Action != InstructionMerged {
+		t.Fatalf("action = %q, want %q", files[0].Action, InstructionMerged)
+	}
+	want := "# My project\n\n" + managedBlockBegin + "\nv1\n" + managedBlockEnd + "\n"
+	if got := readFile(t, path); got != want {
+		t.Fatalf("content = %q, want %q", got, want)
+	}
+
+	// User edits after the block survive a refresh of the managed content.
+	writeFile(t, path, readFile(t, path)+"\nlocal notes\n")
+	if _, err := writeInstructionFiles(dir, InstructionsManaged, []instructionTarget{{name: claudeMDFile, content: "v2"}}); err != nil {
+		t.Fatalf("writeInstructionFiles: %v", err)
+	}
+	got := readFile(t, path)
+	if strings.Contains(got, "v1") || !strings.Contains(got, "\nv2\n") {
+		t.Fatalf("expected managed block to be replaced, got %q", got)
+	}
+	if !strings.HasPrefix(got, "# My project\n") || !strings.HasSuffix(got, "\nlocal notes\n") {
+		t.Fatalf("expected user content around block to be preserved, got %q", got)
+	}
+	if strings.Count(got, managedBlockBegin) != 1 {
+		t.Fatalf("expected exactly one managed block, got %q", got)
+	}
+
+	files, err = writeInstructionFiles(dir, InstructionsManaged, []instructionTarget{{name: claudeMDFile, content: "v2"}})
+	if err != nil {
+		t.Fatalf("writeInstructionFiles: %v", err)
+	}
+	if files[0].Action != InstructionUnchanged {
+		t.Fatalf("action = %q, want %q", files[0].Action, InstructionUnchanged)
+	}
+}
+
+func TestWriteInstructionFilesSkipsMissingWorkdir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	files, err := writeInstructionFiles(dir, InstructionsOverwrite, []instructionTarget{{name: claudeMDFile, content: "x"}})
+	if err != nil {
+		t.Fatalf("writeInstructionFiles: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("files = %+v, want none for missing workdir", files)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxKeysPerCommand bounds the keys of one Keys call.
+const maxKeysPerCommand = 64
+
+// namedKeys maps lower-cased tmux key names clients may send to their
+// canonical spelling.
+var namedKeys = func() map[string]string {
+	names := []string{
+		"Enter", "Escape", "Tab", "BTab", "BSpace", "Space",
+		"Up", "Down", "Left", "Right",
+		"Home", "End", "PageUp", "PageDown", "PgUp", "PgDn", "PPage", "NPage",
+		"Insert", "IC", "Delete", "DC",
+	}
+	for i := 1; i <= 12; i++ {
+		names = append(names, "F"+strconv.Itoa(i))
+	}
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		m[strings.ToLower(name)] = name
+	}
+	return m
+}()
+
+// keyModifiers are the tmux modifier prefixes a key may carry, each at most
+// once: C- (Ctrl), M- (Meta/Alt) and S- (Shift).
+var keyModifiers = []string{"C-", "M-", "S-"}
+
+// normalizeKeys validates tmux key names such as "C-c", "Escape", "S-Tab"
+// or "PageUp" against the allowlist and returns them in canonical spelling.
+// A key is a named key or a single printable ASCII character, optionally
+// prefixed with modifiers.
+func normalizeKeys(keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys")
+	}
+	if len(keys) > maxKeysPerCommand {
+		return nil, fmt.Errorf("too many keys: %d (max %d)", len(keys), maxKeysPerCommand)
+	}
+	out := make([]string, len(keys))
+	for i, key := range keys {
+		k, ok := normalizeKey(key)
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+		out[i] = k
+	}
+	return out, nil
+}
+
+func normalizeKey(key string) (string, bool) {
+	var prefix strings.Builder
+	seen := make(map[string]bool, len(keyModifiers))
+	rest := key
+	for {
+		matched := false
+		for _, mod := range keyModifiers {
+			// "C--" is Ctrl and the '-' key, so a modifier needs a key
+			// after it.
+			if len(rest) > len(mod) && strings.EqualFold(rest[:len(mod)], mod) {
+				if seen[mod] {
+					return "", false
+				}
+				seen[mod] = true
+				prefix.WriteString(mod)
+				rest = rest[len(mod):]
+				matched = true
+			}
+		}
+		if !matched {
+			break
+		}
+	}
+	if name, ok := namedKeys[strings.ToLower(rest)]; ok {
+		return prefix.String() + name, true
+	}
+	if len(rest) == 1 && rest[0] > ' ' && rest[0] < 0x7f {
+		return prefix.String() + rest, true
+	}
+	return "", false
+}
+
+// Keys sends tmux named keys to the pane, e.g. "C-c" or "Escape", after any
+// input queued before them. Keys outside the allowlist fail the whole call
+// before anything is sent.
+func (s *Session) Keys(keys []string) error {
+	normalized, err := normalizeKeys(keys)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
+	return s.input.enqueueOwnWait(nil, func([]byte) error {
+		if err := s.backend.sendKeys(normalized); err != nil {
+			return err
+		}
+		s.wakeCapture()
+		if r := s.recorder.get(); r != nil {
+			r.Input()
+		}
+		return nil
+	}, s.writeInput)
+}
+
+func namedKeysArgs(tmuxName string, keys []string) []string {
+	// `--` keeps a "-" key from being taken as a flag.
+	return append([]string{"send-keys", "-t", tmuxName, "--"}, keys...)
+}
@@ -0,0 +1,116 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeKeys(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{key: "C-c", want: "C-c", ok: true},
+		{key: "c-c", want: "C-c", ok: true},
+		{key: "escape", want: "Escape", ok: true},
+		{key: "S-Tab", want: "S-Tab", ok: true},
+		{key: "pageup", want: "PageUp", ok: true},
+		{key: "C-M-Left", want: "C-M-Left", ok: true},
+		{key: "F12", want: "F12", ok: true},
+		{key: "C--", want: "C--", ok: true},
+		{key: "-", want: "-", ok: true},
+		{key: "F13"},
+		{key: "C-C-c"},
+		{key: "C-"},
+		{key: "hello"},
+		{key: "é"},
+		{key: " "},
+		{key: ""},
+	}
+	for _, tc := range tests {
+		got, err := normalizeKeys([]string{tc.key})
+		if tc.ok {
+			if err != nil || got[0] != tc.want {
+				t.Errorf("normalizeKeys(%q) = %v, %v; want %q", tc.key, got, err, tc.want)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("normalizeKeys(%q) = %v, want error", tc.key, got)
+		}
+	}
+}
+
+func TestNormalizeKeysLimits(t *testing.T) {
+	if _, err := normalizeKeys(nil); err == nil {
+		t.Fatal("expected an empty key list to fail")
+	}
+	if _, err := normalizeKeys(make([]string, maxKeysPerCommand+1)); err == nil {
+		t.Fatal("expected too many keys to fail")
+	}
+	if _, err := normalizeKeys([]string{"Enter", "rm -rf /"}); err == nil {
+		t.Fatal("expected one invalid key to fail the whole list")
+	}
+}
+
+func TestNamedKeysArgs(t *testing.T) {
+	got := namedKeysArgs("vibe-ses-1", []string{"-", "Enter"})
+	want := []string{"send-keys", "-t", "vibe-ses-1", "--", "-", "Enter"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("args = %q, want %q", got, want)
+	}
+}
+
+func TestSessionKeys(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	workdir := t.TempDir()
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "keys-" + time.Now().Format("150405"),
+		Name:      "keys",
+		Workdir:   workdir,
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	time.Sleep(300 * time.Millisecond)
+
+	if err := s.Input([]byte("cat -v > out.txt\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	for i := 0; ; i++ {
+		if shell, err := s.isForegroundShell(); err == nil && !shell {
+			break
+		}
+		if i == 100 {
+			t.Fatal("cat did not start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := s.Keys([]string{"Escape", "C-v", "C-a", "Enter"}); err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		raw, _ := os.ReadFile(filepath.Join(workdir, "out.txt"))
+		if strings.Contains(string(raw), "^[^A") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("out.txt = %q, want escape and Ctrl-A", raw)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
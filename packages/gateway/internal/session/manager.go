@@ -1,9 +1,10 @@
-// Package session manages tmux-backed PTY sessions.
+// Package session manages PTY sessions, run in tmux or on a PTY of the
+// gateway's own.
 package session
 
 import (
+	"errors"
 	"fmt"
-	"os/exec"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,13 +17,23 @@ type Manager struct {
 	sessions map[string]*Session
 	maxCount int
 
+	envPolicy     EnvPolicy
+	backend       BackendKind
+	tmux          TmuxServer
+	captureMode   CaptureMode
+	resizePolicy  ResizePolicy
+	policy        Policy
+	expiryWarning time.Duration
+
 	checkInterval             time.Duration
 	isAlive                   func(*Session) bool
 	livenessStatus            func(*Session) sessionLiveness
 	endSession                func(*Session) error
-	onSessionExit             func(string)
-	listRecoverableSessionIDs func() ([]string, error)
-	newRecoveredSession       func(string, chan OutputChunk) *Session
+	onSessionExit             func(string, ExitStatus)
+	onAgentExit               func(string, int)
+	onSessionExpiring         func(string, EndReason, time.Time)
+	listRecoverableSessionIDs func(TmuxServer) ([]string, error)
+	newRecoveredSession       func(TmuxServer, string, chan OutputChunk, CaptureMode) *Session
 }
 
 // NewManager creates a Manager with the given session limit.
@@ -30,6 +41,7 @@ func NewManager(maxSessions int) *Manager {
 	return &Manager{
 		sessions:      make(map[string]*Session),
 		maxCount:      maxSessions,
+		tmux:          TmuxServer{Socket: DefaultTmuxSocket},
 		checkInterval: 1 * time.Second,
 		isAlive: func(s *Session) bool {
 			return s.isAlive()
@@ -47,12 +59,86 @@ func NewManager(maxSessions int) *Manager {
 
 // SetOnSessionExit registers a callback fired when a tracked session exits on
 // its own and is removed by the watcher loop.
-func (m *Manager) SetOnSessionExit(fn func(string)) {
+func (m *Manager) SetOnSessionExit(fn func(string, ExitStatus)) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.onSessionExit = fn
 }
 
+// SetOnAgentExit registers a callback fired when a session's agent exits and
+// the launch wrapper falls back to a shell. It receives the agent exit code.
+func (m *Manager) SetOnAgentExit(fn func(string, int)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onAgentExit = fn
+}
+
+// SetOnSessionExpiring registers a callback fired when a session policy is
+// about to end a session. It receives the reason and the time the session
+// will be ended.
+func (m *Manager) SetOnSessionExpiring(fn func(string, EndReason, time.Time)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onSessionExpiring = fn
+}
+
+// SetPolicy sets the lifetime policy of sessions without their own
+// Options.Policy, including recovered ones.
+func (m *Manager) SetPolicy(p Policy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = p
+}
+
+// SetExpiryWarning sets how long before a policy ends a session the expiring
+// callback fires. It is also the grace period between an agent exit and the
+// end of the session under Policy.EndOnAgentExit.
+func (m *Manager) SetExpiryWarning(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expiryWarning = d
+}
+
+// SetEnvPolicy sets the environment policy applied to sessions created after
+// the call.
+func (m *Manager) SetEnvPolicy(p EnvPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.envPolicy = p
+}
+
+// SetBackend sets the backend of sessions created after the call without
+// their own Options.Backend. Recovered sessions are always tmux sessions.
+func (m *Manager) SetBackend(kind BackendKind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backend = kind
+}
+
+// SetTmuxServer sets the tmux server tmux sessions created after the call
+// run on, and the first one Recover looks at.
+func (m *Manager) SetTmuxServer(server TmuxServer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tmux = server
+}
+
+// SetCaptureMode sets how the output of tmux sessions created or recovered
+// after the call is captured.
+func (m *Manager) SetCaptureMode(mode CaptureMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.captureMode = mode
+}
+
+// SetResizePolicy sets how the window of sessions created or recovered after
+// the call follows the viewports of their clients.
+func (m *Manager) SetResizePolicy(p ResizePolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resizePolicy = p
+}
+
 // Create creates and starts a new session. Returns an error if the limit is
 // reached or a session with the same ID already exists.
 func (m *Manager) Create(opts Options) (*Session, error) {
@@ -67,9 +153,27 @@ func (m *Manager) Create(opts Options) (*Session, error) {
 	}
 
 	s := newSession(opts)
+	if s.kind == "" {
+		s.kind = m.backend
+	}
+	s.envPolicy = m.envPolicy
+	s.tmux = m.tmux
+	s.captureMode = m.captureMode
+	s.resizePolicy = m.resizePolicy
 	if err := s.start(); err != nil {
 		return nil, fmt.Errorf("start session %q: %w", opts.SessionID, err)
 	}
+	if err := s.applyResizePolicy(); err != nil {
+		_ = s.kill()
+		return nil, fmt.Errorf("size session %q: %w", opts.SessionID, err)
+	}
+	// Recording starts at the window size the session settled on.
+	if opts.Record != nil {
+		if err := s.StartRecording(opts.Record); err != nil {
+			_ = s.kill()
+			return nil, fmt.Errorf("record session %q: %w", opts.SessionID, err)
+		}
+	}
 	m.sessions[opts.SessionID] = s
 	go m.watchSession(opts.SessionID, s)
 	return s, nil
@@ -91,6 +195,7 @@ func (m *Manager) End(sessionID string) error {
 	if !ok {
 		return fmt.Errorf("session %q not found", sessionID)
 	}
+	s.setEndReason(EndReasonRequested)
 	if err := m.endSession(s); err != nil {
 		return err
 	}
@@ -117,28 +222,56 @@ func (m *Manager) List() []Summary {
 
 // Recover discovers existing tmux-backed sessions and re-attaches them to the in-memory manager.
 // This is used on gateway process restart so user sessions survive daemon restarts.
+// It returns the sessions recovered even when listing a server failed.
+//
+// Sessions of gateways that predate the gateway's own tmux server are found
+// on the user's default server. Their processes cannot move, so they are
+// recovered there and stay there until they end; new sessions start on the
+// gateway's server.
 func (m *Manager) Recover(outputCh chan OutputChunk) ([]string, error) {
-	sessionIDs, err := m.listRecoverableSessionIDs()
-	if err != nil {
-		return nil, err
+	m.mu.RLock()
+	servers := []TmuxServer{m.tmux}
+	m.mu.RUnlock()
+	if !servers[0].legacy() {
+		servers = append(servers, legacyTmux)
+	}
+
+	// A server that cannot be listed must not keep the sessions of the
+	// other one from being recovered.
+	found := make([][]string, len(servers))
+	var errs []error
+	for i, server := range servers {
+		ids, err := m.listRecoverableSessionIDs(server)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		found[i] = ids
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	recovered := make([]string, 0, len(sessionIDs))
-	for _, sessionID := range sessionIDs {
-		if _, exists := m.sessions[sessionID]; exists {
-			continue
-		}
+	var recovered []string
+	for i, server := range servers {
+		for _, sessionID := range found[i] {
+			if _, exists := m.sessions[sessionID]; exists {
+				continue
+			}
 
-		s := m.newRecoveredSession(sessionID, outputCh)
-		m.sessions[sessionID] = s
-		recovered = append(recovered, sessionID)
-		go m.watchSession(sessionID, s)
+			s := m.newRecoveredSession(server, sessionID, outputCh, m.captureMode)
+			s.envPolicy = m.envPolicy
+			s.resizePolicy = m.resizePolicy
+			if err := s.applyResizePolicy(); err != nil {
+				errs = append(errs, fmt.Errorf("size session %q: %w", sessionID, err))
+			}
+			m.sessions[sessionID] = s
+			recovered = append(recovered, sessionID)
+			go m.watchSession(sessionID, s)
+		}
 	}
 
-	return recovered, nil
+	return recovered, errors.Join(errs...)
 }
 
 // Remove is called internally when a session exits on its own.
@@ -152,6 +285,8 @@ func (m *Manager) watchSession(sessionID string, s *Session) {
 	ticker := time.NewTicker(m.checkInterval)
 	defer ticker.Stop()
 	goneChecks := 0
+	var policy policyWatch
+	var restarts uint32
 
 	for range ticker.C {
 		m.mu.RLock()
@@ -160,6 +295,11 @@ func (m *Manager) watchSession(sessionID string, s *Session) {
 		if !ok || current != s {
 			return
 		}
+		if n := atomic.LoadUint32(&s.restarts); n != restarts {
+			// The agent runs again.
+			restarts = n
+			policy.agentExitedAt = time.Time{}
+		}
 		state := sessionLivenessAlive
 		switch {
 		case m.livenessStatus != nil:
@@ -167,9 +307,21 @@ func (m *Manager) watchSession(sessionID string, s *Session) {
 		case m.isAlive != nil && !m.isAlive(s):
 			state = sessionLivenessGone
 		}
+		if code, ok := s.takeAgentExit(); ok {
+			policy.agentExitedAt = time.Now()
+			m.mu.RLock()
+			onAgentExit := m.onAgentExit
+			m.mu.RUnlock()
+			if onAgentExit != nil {
+				onAgentExit(sessionID, code)
+			}
+		}
 		switch state {
 		case sessionLivenessAlive:
 			goneChecks = 0
+			if m.enforcePolicy(sessionID, s, &policy) {
+				return
+			}
 			continue
 		case sessionLivenessUnknown:
 			goneChecks = 0
@@ -186,35 +338,69 @@ func (m *Manager) watchSession(sessionID string, s *Session) {
 			}
 			m.mu.Unlock()
 			s.stopCapture()
+			status := s.ExitStatus()
+			if status.Known {
+				// The dead pane was only kept for its exit status.
+				_ = s.backend.destroy()
+			}
 			if ok && current == s && onExit != nil {
-				onExit(sessionID)
+				onExit(sessionID, status)
 			}
 			return
 		}
 	}
 }
 
-func newRecoveredSession(sessionID string, outputCh chan OutputChunk) *Session {
+func newRecoveredSession(server TmuxServer, sessionID string, outputCh chan OutputChunk, captureMode CaptureMode) *Session {
 	s := &Session{
 		opts: Options{
 			SessionID: sessionID,
 			Name:      sessionID,
 			OutputCh:  outputCh,
 		},
-		tmuxName: "vibe-" + sessionID,
+		kind:        BackendTmux,
+		tmux:        server,
+		captureMode: captureMode,
+		replay:      newReplayBuffer(replayBufferFrames, replayBufferBytes),
+		recovered:   true,
+	}
+	b := newTmuxBackend(server, s.output(), captureMode)
+	s.backend = b
+	// Sessions started by older gateways have no metadata; they keep the
+	// session ID as their name.
+	meta, createdAt, ok := b.loadMetadata()
+	if ok {
+		if meta.Name != "" {
+			s.opts.Name = meta.Name
+		}
+		s.opts.Agent = meta.Agent
+		s.agent = agentLaunch{agent: meta.Agent}
+		s.opts.Workdir = meta.Workdir
+		s.record = meta.Record
+		s.policy = meta.Policy.policy()
+		if o := meta.AgentOptions; o != nil {
+			// Options stored by this gateway were valid when the session
+			// started; drop any a newer one stored and this one rejects.
+			if agent, err := newAgentLaunch(meta.Agent, *o); err == nil {
+				s.opts.AgentOptions = *o
+				s.agent = agent
+			}
+		}
 	}
-	s.capturer = newOutputCapturer(s.tmuxName, s.opts.SessionID, &s.seq, &s.lastActivityAt, s.opts.OutputCh)
-	s.capturer.start()
+	s.createdAt = createdAt
+	// Sessions from older gateways lack remain-on-exit; without it their exit
+	// status is simply unknown, so a failure here is not fatal.
+	_ = b.ensureRemainOnExit()
+	b.startCapture()
 	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
 	return s
 }
 
-func listRecoverableSessionIDs() ([]string, error) {
-	out, err := exec.Command("tmux", "list-sessions", "-F", "#{session_name}").CombinedOutput()
+func listRecoverableSessionIDs(server TmuxServer) ([]string, error) {
+	out, err := server.command("list-sessions", "-F", "#{session_name}").CombinedOutput()
 	if err != nil {
 		// No tmux server means no running sessions to recover.
-		lowOut := strings.ToLower(string(out))
-		if strings.Contains(lowOut, "no server running") || strings.Contains(lowOut, "failed to connect to server") {
+		if tmuxNoServer(out) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("list tmux sessions: %w: %s", err, strings.TrimSpace(string(out)))
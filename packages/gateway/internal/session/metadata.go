@@ -0,0 +1,86 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataOption is the tmux user option holding a session's Metadata.
+// Storing it on the tmux session keeps it alive exactly as long as the
+// session, across gateway restarts and self-updates.
+const metadataOption = "@chatcode-meta"
+
+// Metadata is the part of a session's Options that survives a gateway
+// restart. Env is deliberately not persisted: it may carry credentials and
+// is only needed when the pane is launched.
+type Metadata struct {
+	Name    string `json:"name,omitempty"`
+	Agent   string `json:"agent,omitempty"`
+	Workdir string `json:"workdir,omitempty"`
+	// Record tells a restarted gateway to resume recording the session.
+	Record bool `json:"record,omitempty"`
+	// Policy is set for sessions created with their own lifetime policy;
+	// the others follow the gateway default.
+	Policy *storedPolicy `json:"policy,omitempty"`
+	// AgentOptions lets a restarted gateway restart the agent with the
+	// same flags.
+	AgentOptions *AgentOptions `json:"agent_options,omitempty"`
+}
+
+// metadata returns the metadata of the session with its pane running agent.
+func (s *Session) metadata(agent agentLaunch) Metadata {
+	meta := Metadata{
+		Name:    s.opts.Name,
+		Agent:   agent.agent,
+		Workdir: s.opts.Workdir,
+		Record:  s.record,
+		Policy:  newStoredPolicy(s.policy),
+	}
+	o := agent.options
+	o.Resume = false
+	if !o.isZero() {
+		meta.AgentOptions = &o
+	}
+	return meta
+}
+
+func (b *tmuxBackend) persistMetadata(meta Metadata) error {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	cmd := b.server.command("set-option", "-t", b.name, metadataOption, string(raw))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux set %s: %w: %s", metadataOption, err, out)
+	}
+	return nil
+}
+
+// loadMetadata reads the stored metadata and creation time of the tmux
+// session. ok is false for sessions created before metadata was persisted.
+func (b *tmuxBackend) loadMetadata() (meta Metadata, createdAt time.Time, ok bool) {
+	out, err := b.server.command(
+		"display-message", "-t", b.name, "-p", "#{session_created} #{"+metadataOption+"}",
+	).Output()
+	if err != nil {
+		return Metadata{}, time.Time{}, false
+	}
+	return parseMetadataOutput(string(out))
+}
+
+func parseMetadataOutput(out string) (meta Metadata, createdAt time.Time, ok bool) {
+	created, raw, _ := strings.Cut(strings.TrimSpace(out), " ")
+	if secs, err := strconv.ParseInt(created, 10, 64); err == nil {
+		createdAt = time.Unix(secs, 0)
+	}
+	if raw == "" {
+		return Metadata{}, createdAt, false
+	}
+	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+		return Metadata{}, createdAt, false
+	}
+	return meta, createdAt, true
+}
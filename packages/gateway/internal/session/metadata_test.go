@@ -0,0 +1,63 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMetadataOutput(t *testing.T) {
+	meta, createdAt, ok := parseMetadataOutput(`1767323045 {"name":"api work","agent":"codex","workdir":"/w/api"}` + "\n")
+	if !ok {
+		t.Fatal("expected metadata to parse")
+	}
+	if meta != (Metadata{Name: "api work", Agent: "codex", Workdir: "/w/api"}) {
+		t.Fatalf("meta = %+v", meta)
+	}
+	if !createdAt.Equal(time.Unix(1767323045, 0)) {
+		t.Fatalf("createdAt = %v", createdAt)
+	}
+}
+
+func TestParseMetadataOutputLegacySession(t *testing.T) {
+	_, createdAt, ok := parseMetadataOutput("1767323045 \n")
+	if ok {
+		t.Fatal("expected no metadata for legacy session")
+	}
+	if createdAt.IsZero() {
+		t.Fatal("expected creation time even without metadata")
+	}
+	if _, _, ok := parseMetadataOutput("1767323045 {broken"); ok {
+		t.Fatal("expected malformed metadata to be ignored")
+	}
+}
+
+func TestRecoveredSessionRestoresMetadata(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	workdir := t.TempDir()
+	s, err := m.Create(Options{
+		SessionID: "meta-" + time.Now().Format("150405"),
+		Name:      "my label",
+		Workdir:   workdir,
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 64),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+
+	recovered := newRecoveredSession(s.tmux, s.opts.SessionID, make(chan OutputChunk, 64), CapturePoll)
+	defer recovered.stopCapture()
+
+	got := recovered.Summary()
+	if got.Name != "my label" || got.Agent != "none" || got.Workdir != workdir {
+		t.Fatalf("recovered summary = %+v", got)
+	}
+	if got.CreatedAt.IsZero() {
+		t.Fatal("expected recovered session to report its creation time")
+	}
+}
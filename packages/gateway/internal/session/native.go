@@ -0,0 +1,500 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
+)
+
+const (
+	// nativeTerminal is the TERM of native panes, whose screen the vt
+	// package keeps.
+	nativeTerminal = "xterm-256color"
+	nativeCols     = 80
+	nativeRows     = 24
+)
+
+// ptyBackend runs a session on a PTY of the gateway's own. The pane process
+// is the gateway's child; its output is forwarded as it is read and fed to a
+// vt.Screen, which serves snapshots, history and search like tmux does for
+// tmux sessions.
+type ptyBackend struct {
+	out paneOutput
+	// dir holds the file the agent launch wrapper writes its exit code to.
+	dir string
+
+	mu     sync.Mutex // guards screen; output is sent under it
+	screen *vt.Screen
+
+	// run is the pane process run now; restart replaces it.
+	run         atomic.Pointer[ptyRun]
+	stopped     atomic.Bool
+	destroyOnce sync.Once
+}
+
+// ptyRun is one pane process on a PTY of its own.
+type ptyRun struct {
+	pty *os.File
+	cmd *exec.Cmd
+	// done is closed once the process was reaped, after exit is set.
+	done chan struct{}
+	exit ExitStatus
+	// read is closed once the read loop stopped.
+	read chan struct{}
+}
+
+func newPTYBackend(out paneOutput) (*ptyBackend, error) {
+	dir, err := os.MkdirTemp("", "chatcode-pty-")
+	if err != nil {
+		return nil, fmt.Errorf("pty state dir: %w", err)
+	}
+	screen := vt.New(nativeCols, nativeRows)
+	screen.HistoryLimit = tmuxHistoryLimitLines
+	return &ptyBackend{
+		out:    out,
+		dir:    dir,
+		screen: screen,
+	}, nil
+}
+
+func (b *ptyBackend) start(l launch) error {
+	// Like tmux for a detached pane, the screen answers status queries
+	// while no client is watching; a watching client's terminal answers
+	// them itself.
+	b.screen.Respond = func(reply []byte) {
+		if !b.out.watched() {
+			_, _ = b.run.Load().pty.Write(reply)
+		}
+	}
+	r, err := b.spawn(l)
+	if err != nil {
+		os.RemoveAll(b.dir)
+		return err
+	}
+	b.run.Store(r)
+	return nil
+}
+
+// spawn starts l.command on a new PTY of the current screen size.
+func (b *ptyBackend) spawn(l launch) (*ptyRun, error) {
+	pty, tty, err := openPTY()
+	if err != nil {
+		return nil, fmt.Errorf("open pty: %w", err)
+	}
+	defer tty.Close()
+	b.mu.Lock()
+	cols, rows := b.screen.Size()
+	b.mu.Unlock()
+	if err := setWinsize(pty, cols, rows); err != nil {
+		pty.Close()
+		return nil, fmt.Errorf("set pty size: %w", err)
+	}
+
+	cmd := exec.Command("sh", "-c", l.joinCgroup()+l.command)
+	cmd.Dir = l.workdir
+	cmd.Env = append(l.env, "TERM="+nativeTerminal)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	// The pane process leads a session of its own with the PTY as its
+	// controlling terminal, so job control and Ctrl-C work as in tmux.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		pty.Close()
+		return nil, fmt.Errorf("start pane: %w", err)
+	}
+
+	r := &ptyRun{pty: pty, cmd: cmd, done: make(chan struct{}), read: make(chan struct{})}
+	go b.readLoop(r)
+	go func() {
+		_ = cmd.Wait()
+		r.exit = exitStatusOf(cmd.ProcessState)
+		close(r.done)
+	}()
+	return r, nil
+}
+
+// readLoop feeds the screen and forwards output until the PTY closes: the
+// pane process and everything it started have exited, or the run was
+// replaced or destroyed.
+func (b *ptyBackend) readLoop(r *ptyRun) {
+	defer close(r.read)
+	_ = readBatches(r.pty, func(p []byte) {
+		// The screen and the frames change together, so a snapshot
+		// matches the seq it reports.
+		b.mu.Lock()
+		b.screen.Write(p)
+		if !b.stopped.Load() {
+			b.out.emit(string(p))
+		}
+		b.mu.Unlock()
+	})
+}
+
+// restart hangs up the pane process like closing its terminal does, kills
+// what is left of its process group and starts l.command on a new PTY. The
+// screen, history included, is kept.
+func (b *ptyBackend) restart(l launch) (int, error) {
+	old := b.run.Load()
+	old.pty.Close()
+	select {
+	case <-old.done:
+	case <-time.After(terminationTimeout):
+		_ = syscall.Kill(-old.cmd.Process.Pid, syscall.SIGKILL)
+		<-old.done
+	}
+	<-old.read
+
+	if err := os.Remove(b.agentExitPath()); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("clear agent exit: %w", err)
+	}
+	b.mu.Lock()
+	b.screen.Write([]byte(restartReset + "\r\n"))
+	if !b.stopped.Load() {
+		b.out.emit(restartReset + "\r\n")
+	}
+	b.mu.Unlock()
+
+	r, err := b.spawn(l)
+	if err != nil {
+		return 0, err
+	}
+	b.run.Store(r)
+	return r.cmd.Process.Pid, nil
+}
+
+func exitStatusOf(ps *os.ProcessState) ExitStatus {
+	if ps == nil {
+		return ExitStatus{}
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ExitStatus{Code: 128 + int(ws.Signal()), Known: true}
+	}
+	return ExitStatus{Code: ps.ExitCode(), Known: true}
+}
+
+func (b *ptyBackend) agentExitPath() string {
+	return filepath.Join(b.dir, "agent-exit")
+}
+
+func (b *ptyBackend) agentExitReport() string {
+	return fmt.Sprintf(`printf '%%s' "$ec" > %s 2>/dev/null`, shellQuote(b.agentExitPath()))
+}
+
+func (b *ptyBackend) writeInput(data []byte) error {
+	if _, err := b.run.Load().pty.Write(data); err != nil {
+		return fmt.Errorf("write pty: %w", err)
+	}
+	return nil
+}
+
+func (b *ptyBackend) sendKeys(keys []string) error {
+	b.mu.Lock()
+	appCursor := b.screen.AppCursorKeys()
+	b.mu.Unlock()
+	var seq []byte
+	for _, key := range keys {
+		seq = append(seq, keySequence(key, appCursor)...)
+	}
+	return b.writeInput(seq)
+}
+
+// paste writes data in chunks like tmux paste-buffer does: line feeds become
+// carriage returns, and the whole content is bracketed when asked for and the
+// program enabled bracketed paste.
+func (b *ptyBackend) paste(data []byte, bracketed bool) error {
+	b.mu.Lock()
+	bracketed = bracketed && b.screen.BracketedPaste()
+	b.mu.Unlock()
+	chunks := pasteChunks(data, maxPasteChunkBytes)
+	if len(chunks) == 0 {
+		return nil
+	}
+	if bracketed {
+		if err := b.writeInput([]byte("\x1b[200~")); err != nil {
+			return &PasteError{Chunk: 1, Chunks: len(chunks), Err: err}
+		}
+	}
+	written := 0
+	for i, chunk := range chunks {
+		p := make([]byte, 0, len(chunk))
+		for _, c := range chunk {
+			if c == '\n' {
+				c = '\r'
+			}
+			p = append(p, c)
+		}
+		if err := b.writeInput(p); err != nil {
+			if bracketed {
+				// Do not leave the program in the middle of a paste.
+				_ = b.writeInput([]byte("\x1b[201~"))
+			}
+			return &PasteError{Chunk: i + 1, Chunks: len(chunks), Written: written, Err: err}
+		}
+		written += len(chunk)
+	}
+	if bracketed {
+		if err := b.writeInput([]byte("\x1b[201~")); err != nil {
+			return &PasteError{Chunk: len(chunks), Chunks: len(chunks), Written: written, Err: err}
+		}
+	}
+	return nil
+}
+
+// resize sets the PTY size, which signals the foreground job with SIGWINCH.
+func (b *ptyBackend) resize(cols, rows int) error {
+	if err := setWinsize(b.run.Load().pty, cols, rows); err != nil {
+		return fmt.Errorf("set pty size: %w", err)
+	}
+	b.mu.Lock()
+	b.screen.Resize(cols, rows)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *ptyBackend) snapshot() (paneSnapshot, error) {
+	b.mu.Lock()
+	g := b.screen.Grid()
+	lines := b.screen.Lines(-snapshotHistoryLines, g.Rows-1)
+	nextSeq := atomic.LoadUint64(b.out.seq)
+	b.mu.Unlock()
+
+	snap := paneSnapshot{
+		content:     vt.Render(lines, true),
+		cols:        g.Cols,
+		rows:        g.Rows,
+		cursorX:     g.CursorX,
+		cursorY:     g.CursorY,
+		alternateOn: g.AlternateOn,
+		nextSeq:     nextSeq,
+	}
+	if g.CursorVisible {
+		snap.cursorFlag = 1
+	}
+	return snap, nil
+}
+
+func (b *ptyBackend) grid() (vt.Grid, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.screen.Grid(), nil
+}
+
+func (b *ptyBackend) history(start, end int, ansi bool) (HistoryPage, error) {
+	b.mu.Lock()
+	_, rows := b.screen.Size()
+	page := HistoryPage{HistorySize: b.screen.HistorySize()}
+	page.Start, page.End = clampHistoryRange(start, end, page.HistorySize, rows)
+	lines := b.screen.Lines(page.Start, page.End)
+	b.mu.Unlock()
+
+	page.Content = vt.Render(lines, ansi)
+	return page, nil
+}
+
+func (b *ptyBackend) scrollback(ctx context.Context) (string, int, error) {
+	b.mu.Lock()
+	_, rows := b.screen.Size()
+	historySize := b.screen.HistorySize()
+	lines := b.screen.Lines(-historySize, rows-1)
+	b.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return "", 0, fmt.Errorf("search: %w", ctx.Err())
+	}
+	return vt.Render(lines, false), historySize, nil
+}
+
+func (b *ptyBackend) paneInfo() (paneInfo, error) {
+	b.mu.Lock()
+	cols, rows := b.screen.Size()
+	b.mu.Unlock()
+	r := b.run.Load()
+	pid := r.cmd.Process.Pid
+	info := paneInfo{cols: cols, rows: rows, pids: []int{pid}}
+
+	// The foreground process group of the terminal is led by the job in
+	// front, the shell itself when at its prompt.
+	var pgrp int32
+	if err := ioctl(r.pty, syscall.TIOCGPGRP, unsafe.Pointer(&pgrp)); err == nil && pgrp > 0 {
+		pid = int(pgrp)
+	}
+	if name, err := processName(pid); err == nil {
+		info.currentCommand = name
+	}
+	return info, nil
+}
+
+// status reads the agent exit code the launch wrapper left and whether the
+// pane process was reaped.
+func (b *ptyBackend) status() (paneStatus, sessionLiveness) {
+	var st paneStatus
+	if raw, err := os.ReadFile(b.agentExitPath()); err == nil {
+		if code, err := strconv.Atoi(strings.TrimSpace(string(raw))); err == nil {
+			st.agentExit, st.agentExited = code, true
+		}
+	}
+	r := b.run.Load()
+	select {
+	case <-r.done:
+		st.dead, st.exit = true, r.exit
+		return st, sessionLivenessGone
+	default:
+		return st, sessionLivenessAlive
+	}
+}
+
+// wake is a no-op: output is forwarded as it is read.
+func (b *ptyBackend) wake() {}
+
+func (b *ptyBackend) pollInterval() time.Duration { return 0 }
+
+func (b *ptyBackend) stop() {
+	b.stopped.Store(true)
+}
+
+// destroy closes the PTY, which hangs up the pane's processes like closing a
+// terminal window does.
+func (b *ptyBackend) destroy() error {
+	b.destroyOnce.Do(func() {
+		if r := b.run.Load(); r != nil {
+			r.pty.Close()
+		}
+		os.RemoveAll(b.dir)
+	})
+	return nil
+}
+
+// winsize is struct winsize of <sys/ioctl.h>.
+type winsize struct {
+	rows, cols     uint16
+	xpixel, ypixel uint16
+}
+
+func setWinsize(f *os.File, cols, rows int) error {
+	ws := winsize{rows: uint16(rows), cols: uint16(cols)}
+	return ioctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// ioctl runs an ioctl on f without switching it to blocking mode, so reads
+// keep honoring deadlines and Close.
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// keyCodes are the sequences of tmux named keys without modifiers.
+var keyCodes = map[string]string{
+	"Enter": "\r", "Escape": "\x1b", "Tab": "\t", "BTab": "\x1b[Z", "BSpace": "\x7f", "Space": " ",
+	"Up": "\x1b[A", "Down": "\x1b[B", "Right": "\x1b[C", "Left": "\x1b[D", "Home": "\x1b[H", "End": "\x1b[F",
+	"PageUp": "\x1b[5~", "PgUp": "\x1b[5~", "PPage": "\x1b[5~",
+	"PageDown": "\x1b[6~", "PgDn": "\x1b[6~", "NPage": "\x1b[6~",
+	"Insert": "\x1b[2~", "IC": "\x1b[2~", "Delete": "\x1b[3~", "DC": "\x1b[3~",
+	"F1": "\x1bOP", "F2": "\x1bOQ", "F3": "\x1bOR", "F4": "\x1bOS",
+	"F5": "\x1b[15~", "F6": "\x1b[17~", "F7": "\x1b[18~", "F8": "\x1b[19~",
+	"F9": "\x1b[20~", "F10": "\x1b[21~", "F11": "\x1b[23~", "F12": "\x1b[24~",
+}
+
+// keySequence returns the bytes an xterm sends for a key normalized by
+// normalizeKeys. Modified cursor, editing and function keys use xterm's
+// modifier parameter; otherwise Ctrl maps to control characters and Meta
+// prefixes ESC. With appCursor, unmodified cursor keys use SS3 like in
+// application cursor mode.
+func keySequence(key string, appCursor bool) string {
+	var ctrl, meta, shift bool
+	for len(key) > 2 && key[1] == '-' {
+		switch key[0] {
+		case 'C':
+			ctrl = true
+		case 'M':
+			meta = true
+		case 'S':
+			shift = true
+		}
+		key = key[2:]
+	}
+
+	code, named := keyCodes[key]
+	if named && len(code) > 2 && key != "BTab" {
+		mod := 1
+		if shift {
+			mod++
+		}
+		if meta {
+			mod += 2
+		}
+		if ctrl {
+			mod += 4
+		}
+		final := code[len(code)-1]
+		switch {
+		case mod > 1 && final == '~':
+			return code[:len(code)-1] + ";" + strconv.Itoa(mod) + "~"
+		case mod > 1:
+			return "\x1b[1;" + strconv.Itoa(mod) + string(final)
+		case appCursor && code[1] == '[' && strings.IndexByte("ABCDHF", final) >= 0:
+			return "\x1bO" + string(final)
+		}
+		return code
+	}
+
+	switch {
+	case !named:
+		code = key
+		if shift {
+			code = strings.ToUpper(code)
+		}
+		if ctrl {
+			code = string(ctrlChar(code[0]))
+		}
+	case key == "Tab" && shift:
+		code = "\x1b[Z"
+	case key == "Space" && ctrl:
+		code = "\x00"
+	}
+	if meta {
+		code = "\x1b" + code
+	}
+	return code
+}
+
+// ctrlChar returns the control character Ctrl and c send, or c itself when
+// there is none.
+func ctrlChar(c byte) byte {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return c - 'a' + 1
+	case c >= '@' && c <= '_':
+		return c - '@'
+	case c == '?':
+		return 0x7f
+	case c == '2' || c == ' ':
+		return 0
+	case c >= '3' && c <= '7':
+		return c - '3' + 0x1b
+	case c == '8':
+		return 0x7f
+	}
+	return c
+}
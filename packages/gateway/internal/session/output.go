@@ -1,244 +1,226 @@
 package session
 
 import (
-	"context"
 	"fmt"
-	"os/exec"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
 )
 
 const (
 	batchInterval                = 50 * time.Millisecond
 	pathologicalPollInterval     = 250 * time.Millisecond
-	cursorPollInterval           = 150 * time.Millisecond
 	bufferCapacity               = 64
 	maxPayload                   = 16 * 1024 // 16KB per frame
-	fullRedrawPrefix             = "\x1b[0m\x1b[H\x1b[2J"
 	pathologicalRedrawBytes      = 4 * maxPayload
 	pathologicalRedrawBurstLimit = 3
 	pathologicalRedrawWindow     = 1 * time.Second
 	pathologicalRedrawCooldown   = 500 * time.Millisecond
 )
 
-// outputCapturer reads tmux pipe-pane output and batches it into OutputChunks.
+// outputCapturer polls the tmux pane and sends clients the changes as
+// terminal output.
+//
+// It keeps the screen clients show, as of the output sent so far, and turns
+// each capture into the cell updates that bring that screen up to date (see
+// vt.Diff), rather than repainting the whole pane. Its ticks are scheduled
+// by a pollScheduler.
 type outputCapturer struct {
 	tmuxName  string
+	tmux      TmuxServer
 	sessionID string
 	seq       *uint64
 	lastAct   *int64
 	outCh     chan OutputChunk
+	replay    *replayBuffer
+	recorder  *recorderSlot
+
+	sched *pollScheduler
+	// watched reports whether a client watches the session, which keeps it
+	// polled at the full rate.
+	watched func() bool
+	// captureFn returns the pane state and content in the format of
+	// gridCaptureArgs.
+	captureFn func() (string, error)
+
+	// mu serializes ticks and snapshots and guards the fields below.
+	mu      sync.Mutex
+	lastRaw string
+	// shown is the screen clients show. mainScreen is the main screen kept
+	// under the alternate screen while shown is the alternate screen.
+	shown      vt.Grid
+	mainScreen vt.Grid
+	// resync makes the next tick repaint the whole screen: output was
+	// dropped, so clients may no longer show shown.
+	resync bool
 
-	cancel context.CancelFunc
-	buf    []byte
-	timer  *time.Timer
-	capturePaneFn  func() (string, error)
-	captureStateFn func() (paneState, error)
-
-	lastRawContent        string
-	lastContent           string
-	lastCursorX           int
-	lastCursorY           int
-	lastCursorV           int
-	lastAlternate         int
-	lastCursorAt          time.Time
 	redrawBurstCount      int
 	redrawWindowStart     time.Time
 	redrawSuppressedUntil time.Time
 }
 
-type paneState struct {
-	cursorX     int
-	cursorY     int
-	cursorV     int
-	alternateOn bool
-}
-
 func newOutputCapturer(
 	tmuxName, sessionID string,
 	seq *uint64, lastAct *int64,
 	outCh chan OutputChunk,
+	replay *replayBuffer,
+	recorder *recorderSlot,
 ) *outputCapturer {
 	c := &outputCapturer{
-		tmuxName:     tmuxName,
-		sessionID:    sessionID,
-		seq:          seq,
-		lastAct:      lastAct,
-		outCh:        outCh,
-		lastCursorX:  -1,
-		lastCursorY:  -1,
-		lastCursorV:  -1,
-		lastAlternate: -1,
-		lastCursorAt: time.Time{},
-	}
-	c.capturePaneFn = c.capturePane
-	c.captureStateFn = c.captureState
+		tmuxName:  tmuxName,
+		sessionID: sessionID,
+		seq:       seq,
+		lastAct:   lastAct,
+		outCh:     outCh,
+		replay:    replay,
+		recorder:  recorder,
+		sched:     capturePolls,
+	}
+	c.captureFn = c.capture
 	return c
 }
 
-// start begins capturing output from the tmux session using pipe-pane.
-// tmux pipe-pane pipes all pane output to a command; we use `cat` to a named pipe.
-// A simpler approach for the MVP: poll capture-pane -p on a ticker.
+// start polls the pane with capture-pane.
 func (c *outputCapturer) start() {
-	ctx, cancel := context.WithCancel(context.Background())
-	c.cancel = cancel
-	c.timer = time.NewTimer(batchInterval)
-
-	// Seed initial content so browser's initial snapshot is not duplicated
-	// by the first capture tick after websocket connect/reconnect.
-	if rawContent, err := c.capturePaneFn(); err == nil {
-		c.lastRawContent = rawContent
-		c.lastContent = rawContent
-	}
-	if state, err := c.captureStateFn(); err == nil {
-		c.lastCursorX = state.cursorX
-		c.lastCursorY = state.cursorY
-		c.lastCursorV = state.cursorV
-		c.lastAlternate = boolToInt(state.alternateOn)
-		c.lastCursorAt = time.Now()
-		c.lastContent = normalizeCapturedContent(c.lastContent, state.cursorY, state.cursorV)
+	// Seed the shown screen with the current pane: clients start from a
+	// snapshot of it, so the first tick must not repaint it.
+	if raw, err := c.captureFn(); err == nil {
+		if grid, err := renderGridCapture(raw); err == nil {
+			c.lastRaw = raw
+			c.shown = grid
+		}
 	}
-	go c.pollLoop(ctx)
+	c.sched.add(c)
 }
 
 func (c *outputCapturer) stop() {
-	if c.cancel != nil {
-		c.cancel()
-	}
-	if c.timer != nil {
-		c.timer.Stop()
-	}
+	c.sched.remove(c)
 }
 
-// pollLoop uses tmux capture-pane to read incremental output.
-// We track the last captured content to emit only deltas.
-// This is a simple, reliable approach for the MVP.
-func (c *outputCapturer) pollLoop(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-c.timer.C:
-			c.resetTimer(c.processTick())
-		}
-	}
+func (c *outputCapturer) wake() {
+	c.sched.wake(c)
 }
 
-func (c *outputCapturer) processTick() time.Duration {
+func (c *outputCapturer) pollInterval() time.Duration {
+	return c.sched.interval(c)
+}
+
+func (c *outputCapturer) isWatched() bool {
+	return c.watched != nil && c.watched()
+}
+
+// processTick captures the pane and sends the changes. It reports whether
+// the pane changed since the previous tick.
+func (c *outputCapturer) processTick() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	tickAt := time.Now()
-	rawContent, err := c.capturePaneFn()
-	if err != nil {
-		return c.captureInterval(tickAt)
+	raw, err := c.captureFn()
+	if err != nil || raw == c.lastRaw && !c.resync {
+		return false
 	}
-	if rawContent == c.lastRawContent {
-		return c.processCursorOnlyTick(tickAt)
+	grid, err := renderGridCapture(raw)
+	if err != nil {
+		return false
 	}
 
-	state, stateErr := c.captureStateFn()
-	if stateErr == nil {
-		c.lastCursorAt = tickAt
+	delta, shown, mainScreen := c.delta(grid)
+	if len(delta) > 0 && c.shouldSuppressPathologicalRedraw(len(delta), tickAt) {
+		// Nothing was sent, so the next tick's delta covers this one too.
+		return true
 	}
+	c.show(raw, delta, shown, mainScreen)
+	return true
+}
 
-	content := rawContent
-	if stateErr == nil {
-		content = normalizeCapturedContent(content, state.cursorY, state.cursorV)
-	} else {
-		content = normalizeCapturedContent(content, -1, -1)
-	}
-	delta, redraw := diff(c.lastContent, content)
-	c.lastRawContent = rawContent
-	c.lastContent = content
-	altCtrl := ""
-	alternateTransition := false
-	if stateErr == nil {
-		altCtrl = c.alternateBufferControl(state.alternateOn)
-		alternateTransition = altCtrl != ""
+// snapshot captures the snapshot history in the same tmux call as the
+// visible pane and first sends the changes up to that pane, so the snapshot
+// reflects exactly the frames before its nextSeq.
+func (c *outputCapturer) snapshot() (paneSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	args := append(gridCaptureArgs(c.tmuxName), ";")
+	args = append(append(args, snapshotStateArgs(c.tmuxName)...), ";")
+	args = append(args, snapshotCaptureArgs(c.tmuxName)...)
+	out, err := c.tmux.command(args...).Output()
+	if err != nil {
+		return paneSnapshot{}, fmt.Errorf("capture-pane: %w", err)
 	}
-
-	if alternateTransition {
-		delta = fullRedrawPrefix + content
-		redraw = true
+	raw, state, content, err := splitSnapshotCapture(string(out))
+	if err != nil {
+		return paneSnapshot{}, err
 	}
-
-	if len(delta) == 0 {
-		if stateErr == nil {
-			if visCtrl := c.cursorVisibilityControl(state.cursorV); visCtrl != "" {
-				c.lastCursorV = state.cursorV
-				c.emitDelta(visCtrl)
-			}
-			c.lastAlternate = boolToInt(state.alternateOn)
+	if raw != c.lastRaw || c.resync {
+		grid, err := renderGridCapture(raw)
+		if err != nil {
+			return paneSnapshot{}, err
 		}
-		return c.captureInterval(tickAt)
+		delta, shown, mainScreen := c.delta(grid)
+		c.show(raw, delta, shown, mainScreen)
 	}
 
-	if stateErr == nil {
-		if visCtrl := c.cursorVisibilityControl(state.cursorV); visCtrl != "" {
-			if redraw {
-				delta = visCtrl + delta
-			} else {
-				delta += visCtrl
-			}
-			c.lastCursorV = state.cursorV
+	snap := newPaneSnapshot(content, state)
+	snap.nextSeq = atomic.LoadUint64(c.seq)
+	return snap, nil
+}
+
+// splitSnapshotCapture splits the output of the snapshot capture into the
+// gridCaptureArgs part, the state line and the snapshot content.
+func splitSnapshotCapture(out string) (raw, state, content string, err error) {
+	var cols, rows int
+	if _, err := fmt.Sscanf(out, "%d %d", &cols, &rows); err != nil {
+		return "", "", "", fmt.Errorf("parse pane state: %w", err)
+	}
+	// The pane state line and one line per pane row.
+	end := 0
+	for range rows + 1 {
+		i := strings.IndexByte(out[end:], '\n')
+		if i < 0 {
+			return "", "", "", fmt.Errorf("short pane capture")
 		}
+		end += i + 1
 	}
-	if altCtrl != "" {
-		delta = altCtrl + delta
-	}
-	if redraw && stateErr == nil {
-		c.lastCursorX = state.cursorX
-		c.lastCursorY = state.cursorY
-		delta += cursorMove(state.cursorX, state.cursorY)
-	}
-	if stateErr == nil {
-		c.lastAlternate = boolToInt(state.alternateOn)
-	}
-	if redraw && !alternateTransition && c.shouldSuppressPathologicalRedraw(len(delta), tickAt) {
-		return c.captureInterval(tickAt)
-	}
+	state, content, _ = strings.Cut(out[end:], "\n")
+	return out[:end], state, content, nil
+}
 
-	c.emitDelta(delta)
-	return c.captureInterval(tickAt)
+// show records that clients are sent delta to show raw.
+func (c *outputCapturer) show(raw string, delta []byte, shown, mainScreen vt.Grid) {
+	c.lastRaw = raw
+	c.shown, c.mainScreen = shown, mainScreen
+	c.resync = false
+	c.emitDelta(string(delta))
 }
 
-func (c *outputCapturer) processCursorOnlyTick(tickAt time.Time) time.Duration {
-	// Some full-screen apps move cursor without changing text content.
-	// Emit cursor-only control sequence so remote terminal stays aligned.
-	if !c.lastCursorAt.IsZero() && tickAt.Sub(c.lastCursorAt) < cursorPollInterval {
-		return c.captureInterval(tickAt)
-	}
-	state, stateErr := c.captureStateFn()
-	if stateErr != nil {
-		return c.captureInterval(tickAt)
-	}
-	c.lastCursorAt = tickAt
-	moved := state.cursorX != c.lastCursorX || state.cursorY != c.lastCursorY
-	visCtrl := c.cursorVisibilityControl(state.cursorV)
-	altCtrl := c.alternateBufferControl(state.alternateOn)
-	alternateTransition := altCtrl != ""
-	if !alternateTransition && !moved && visCtrl == "" {
-		return c.captureInterval(tickAt)
-	}
-	c.lastCursorX = state.cursorX
-	c.lastCursorY = state.cursorY
-	c.lastCursorV = state.cursorV
-	c.lastAlternate = boolToInt(state.alternateOn)
-	delta := ""
-	if alternateTransition {
-		delta = altCtrl
-		if visCtrl != "" {
-			delta += visCtrl
-		}
-		delta += fullRedrawPrefix + c.lastContent
-		delta += cursorMove(state.cursorX, state.cursorY)
-	} else {
-		delta = visCtrl
-		if moved {
-			delta += cursorMove(state.cursorX, state.cursorY)
-		}
-	}
-	c.emitDelta(delta)
-	return c.captureInterval(tickAt)
+// delta returns the output that makes clients show grid, and the shown and
+// main screens after it.
+func (c *outputCapturer) delta(grid vt.Grid) ([]byte, vt.Grid, vt.Grid) {
+	from, mainScreen := c.shown, c.mainScreen
+	var out []byte
+	switch {
+	case grid.AlternateOn && !from.AlternateOn:
+		// The alternate screen starts out blank, with the cursor where it was.
+		out = append(out, "\x1b[?1049h"...)
+		mainScreen = from
+		from = vt.Blank(from.Cols, from.Rows)
+		from.CursorX, from.CursorY, from.CursorVisible = mainScreen.CursorX, mainScreen.CursorY, mainScreen.CursorVisible
+	case !grid.AlternateOn && from.AlternateOn:
+		// Leaving it restores the main screen and its cursor position.
+		out = append(out, "\x1b[?1049l"...)
+		visible := from.CursorVisible
+		from, mainScreen = mainScreen, vt.Grid{}
+		from.CursorVisible = visible
+	}
+	if c.resync {
+		from = vt.Grid{}
+	}
+	out = append(out, vt.Diff(from, grid)...)
+	return out, grid, mainScreen
 }
 
 func (c *outputCapturer) shouldSuppressPathologicalRedraw(deltaBytes int, now time.Time) bool {
@@ -276,52 +258,59 @@ func (c *outputCapturer) captureInterval(now time.Time) time.Duration {
 	return batchInterval
 }
 
-func (c *outputCapturer) resetTimer(interval time.Duration) {
-	if c.timer == nil {
-		return
-	}
-	if !c.timer.Stop() {
-		select {
-		case <-c.timer.C:
-		default:
-		}
+func (c *outputCapturer) emitDelta(delta string) {
+	if emitOutput(c.sessionID, c.seq, c.lastAct, c.outCh, c.replay, c.recorder, delta) {
+		c.resync = true
 	}
-	c.timer.Reset(interval)
 }
 
-func (c *outputCapturer) emitDelta(delta string) {
-	if len(delta) == 0 {
-		return
+// emitOutput splits data into ≤maxPayload OutputChunks, records them in the
+// replay buffer and queues them for the websocket sender. The session
+// recorder, if any, gets data unsplit. It reports whether queued frames were
+// dropped to make room.
+func emitOutput(sessionID string, seq *uint64, lastAct *int64, outCh chan OutputChunk, replay *replayBuffer, recorder *recorderSlot, data string) bool {
+	if len(data) == 0 {
+		return false
 	}
-	atomic.StoreInt64(c.lastAct, time.Now().UnixNano())
+	atomic.StoreInt64(lastAct, time.Now().UnixNano())
 
-	// Split into ≤maxPayload chunks
-	for len(delta) > 0 {
-		chunk := delta
+	dropped := false
+	var frames []OutputChunk
+	for len(data) > 0 {
+		chunk := data
 		if len(chunk) > maxPayload {
-			chunk = delta[:maxPayload]
+			chunk = data[:maxPayload]
 		}
-		delta = delta[len(chunk):]
+		data = data[len(chunk):]
 
-		seq := atomic.AddUint64(c.seq, 1) - 1
 		payload := OutputChunk{
-			SessionID: c.sessionID,
-			Seq:       seq,
+			SessionID: sessionID,
+			Seq:       atomic.AddUint64(seq, 1) - 1,
 			Data:      []byte(chunk),
 		}
 
-		enqueueLatest(c.outCh, payload)
+		// Record before enqueueing: frames dropped by the latest-wins queue
+		// are still replayable after a reconnect.
+		if replay != nil {
+			replay.add(payload)
+		}
+		if enqueueLatest(outCh, payload) {
+			dropped = true
+		}
+		frames = append(frames, payload)
 	}
+	// The recorder sees the frames' seqs, so one being attached can tell
+	// them from output its snapshot already shows.
+	recorder.output(frames)
+	return dropped
 }
 
-func cursorMove(cursorX, cursorY int) string {
-	return fmt.Sprintf("\x1b[%d;%dH", cursorY+1, cursorX+1)
-}
-
-func enqueueLatest(outCh chan OutputChunk, payload OutputChunk) {
+// enqueueLatest queues payload, dropping the oldest queued frame when the
+// queue is full. It reports whether a frame was dropped.
+func enqueueLatest(outCh chan OutputChunk, payload OutputChunk) bool {
 	select {
 	case outCh <- payload:
-		return
+		return false
 	default:
 		// Full queue: drop oldest queued frame so newest output wins.
 	}
@@ -335,104 +324,13 @@ func enqueueLatest(outCh chan OutputChunk, payload OutputChunk) {
 	case outCh <- payload:
 	default:
 	}
+	return true
 }
 
-func (c *outputCapturer) capturePane() (string, error) {
-	out, err := exec.Command("tmux", "capture-pane", "-e", "-N", "-t", c.tmuxName, "-p").Output()
-	if err != nil {
-		return "", err
-	}
-	// tmux capture-pane -p always appends a trailing newline; drop it so
-	// redraw payloads do not add a phantom extra row in terminal consumers.
-	return stripOSC8Hyperlinks(strings.TrimSuffix(string(out), "\n")), nil
-}
-
-func (c *outputCapturer) captureState() (paneState, error) {
-	out, err := exec.Command(
-		"tmux", "display-message", "-t", c.tmuxName, "-p", "#{cursor_x} #{cursor_y} #{cursor_flag} #{alternate_on}",
-	).Output()
+func (c *outputCapturer) capture() (string, error) {
+	out, err := c.tmux.command(gridCaptureArgs(c.tmuxName)...).Output()
 	if err != nil {
-		return paneState{}, err
-	}
-	return parsePaneStateOutput(string(out))
-}
-
-func parsePaneStateOutput(out string) (paneState, error) {
-	var state paneState
-	var alternateInt int
-	if _, err := fmt.Sscanf(strings.TrimSpace(out), "%d %d %d %d", &state.cursorX, &state.cursorY, &state.cursorV, &alternateInt); err != nil {
-		return paneState{}, err
-	}
-	state.alternateOn = alternateInt == 1
-	return state, nil
-}
-
-func (c *outputCapturer) cursorVisibilityControl(cursorVisible int) string {
-	if cursorVisible != 0 && cursorVisible != 1 {
-		return ""
-	}
-	if c.lastCursorV == cursorVisible {
-		return ""
-	}
-	if cursorVisible == 0 {
-		return "\x1b[?25l"
+		return "", fmt.Errorf("capture-pane: %w", err)
 	}
-	return "\x1b[?25h"
-}
-
-func (c *outputCapturer) alternateBufferControl(alternateOn bool) string {
-	if c.lastAlternate < 0 {
-		return ""
-	}
-	if c.lastAlternate == boolToInt(alternateOn) {
-		return ""
-	}
-	if alternateOn {
-		return "\x1b[?1049h"
-	}
-	return "\x1b[?1049l"
-}
-
-func boolToInt(v bool) int {
-	if v {
-		return 1
-	}
-	return 0
-}
-
-func normalizeCapturedContent(content string, cursorY int, cursorVisible int) string {
-	lines := strings.Split(content, "\n")
-	keepTrailing := -1
-	if cursorVisible == 1 && cursorY >= 0 && cursorY < len(lines) {
-		keepTrailing = cursorY
-	}
-	for i, line := range lines {
-		if i == keepTrailing {
-			continue
-		}
-		lines[i] = strings.TrimRight(line, " ")
-	}
-	return strings.Join(lines, "\n")
-}
-
-// diff returns the bytes in b that are not a suffix match of a.
-// For the MVP this is a simple heuristic: return content added since last snapshot.
-// In practice tmux capture-pane returns the full visible buffer, so we return
-// everything if it changed, and rely on the xterm.js terminal at the client to
-// handle re-renders correctly. The seq number ensures ordering.
-func diff(old, new string) (string, bool) {
-	if old == "" {
-		return new, false
-	}
-	if old == new {
-		return "", false
-	}
-
-	if strings.HasPrefix(new, old) {
-		return new[len(old):], false
-	}
-
-	// Any non-append change is treated as in-place update and rendered as full
-	// viewport redraw. This avoids duplicated/misaligned output for dynamic UIs.
-	return fullRedrawPrefix + new, true
+	return string(out), nil
 }
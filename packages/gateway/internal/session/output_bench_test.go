@@ -0,0 +1,236 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
+)
+
+// The benchmarks replay captures of typical agent screens, polled at the
+// capture rate, through the cell delta encoder and through the encoder it
+// replaced, which resent the whole pane on any change that was not an
+// append. They report the output bytes per second of screen activity.
+
+const (
+	benchCols, benchRows = 80, 24
+	benchFrames          = 200
+)
+
+// benchFrame is one capture of the pane.
+type benchFrame struct {
+	lines            []string
+	cursorX, cursorY int
+	cursorVisible    bool
+}
+
+func (f benchFrame) raw() string {
+	return paneCapture(benchCols, benchRows, f.cursorX, f.cursorY, f.cursorVisible, false, strings.Join(f.lines, "\n"))
+}
+
+var benchScenarios = []struct {
+	name   string
+	frames func() []benchFrame
+}{
+	{name: "agent spinner", frames: agentSpinnerFrames},
+	{name: "streaming response", frames: streamingResponseFrames},
+	{name: "shell scrolling", frames: shellScrollingFrames},
+	{name: "progress bar", frames: progressBarFrames},
+}
+
+// agentInputBox is the prompt box and hints at the bottom of an agent TUI.
+var agentInputBox = []string{
+	"\x1b[38;5;244m╭──────────────────────────────────────────────────────────────────────────────╮",
+	"│\x1b[39m > \x1b[38;5;244m                                                                           │",
+	"╰──────────────────────────────────────────────────────────────────────────────╯\x1b[39m",
+	"\x1b[2m  ? for shortcuts                                               auto-accept on\x1b[0m",
+}
+
+// agentSpinnerFrames is an agent working: the transcript stands still while
+// a spinner and a timer animate on the status line.
+func agentSpinnerFrames() []benchFrame {
+	spinner := []string{"✻", "✽", "✶", "✳", "✢", "·"}
+	var transcript []string
+	for i := 0; i < benchRows-6; i++ {
+		transcript = append(transcript, fmt.Sprintf("\x1b[32m⏺\x1b[39m Read \x1b[1msrc/module_%02d.go\x1b[22m (%d lines)", i, 40+i*7))
+	}
+	frames := make([]benchFrame, benchFrames)
+	for i := range frames {
+		status := fmt.Sprintf("\x1b[38;5;174m%s Thinking…\x1b[39m \x1b[2m(%ds · ↓ %d tokens · esc to interrupt)\x1b[0m",
+			spinner[i%len(spinner)], i/20, 120+i*3)
+		lines := append(append(append([]string(nil), transcript...), "", status), agentInputBox...)
+		frames[i] = benchFrame{lines: lines, cursorX: 4, cursorY: benchRows - 3, cursorVisible: true}
+	}
+	return frames
+}
+
+// streamingResponseFrames is an agent streaming a reply a few words a frame
+// above a static input box, scrolling the transcript as lines fill up.
+func streamingResponseFrames() []benchFrame {
+	words := strings.Fields("The capture loop polls the pane twenty times a second and compares the " +
+		"new screen with the one clients already show so that only the cells that changed are sent " +
+		"over the websocket instead of the whole pane which matters on slow mobile links")
+	var done []string
+	line := ""
+	frames := make([]benchFrame, benchFrames)
+	for i := range frames {
+		for w := 0; w < 2; w++ {
+			word := words[(i*2+w)%len(words)]
+			if len(line)+len(word)+1 > benchCols-4 {
+				done = append(done, line)
+				line = ""
+			}
+			line += word + " "
+		}
+		body := append(append([]string(nil), done...), line)
+		if n := benchRows - len(agentInputBox); len(body) > n {
+			body = body[len(body)-n:]
+		}
+		for len(body) < benchRows-len(agentInputBox) {
+			body = append(body, "")
+		}
+		frames[i] = benchFrame{lines: append(body, agentInputBox...), cursorX: 4, cursorY: benchRows - 3}
+	}
+	return frames
+}
+
+// shellScrollingFrames is a build printing a few lines a frame, scrolling
+// the whole screen.
+func shellScrollingFrames() []benchFrame {
+	var out []string
+	frames := make([]benchFrame, benchFrames)
+	for i := range frames {
+		for j := 0; j < 3; j++ {
+			n := i*3 + j
+			out = append(out, fmt.Sprintf("\x1b[32mok\x1b[0m  \tgithub.com/example/project/pkg/module%03d\t0.%03ds", n, n%1000))
+		}
+		lines := out
+		if len(lines) > benchRows {
+			lines = lines[len(lines)-benchRows:]
+		}
+		frames[i] = benchFrame{lines: lines, cursorY: len(lines) - 1, cursorVisible: true}
+	}
+	return frames
+}
+
+// progressBarFrames is a download redrawing one progress line in place.
+func progressBarFrames() []benchFrame {
+	var history []string
+	for i := 0; i < 10; i++ {
+		history = append(history, fmt.Sprintf("Fetched layer %d", i))
+	}
+	frames := make([]benchFrame, benchFrames)
+	for i := range frames {
+		done := i * 50 / benchFrames
+		bar := fmt.Sprintf("downloading [\x1b[36m%s\x1b[0m%s] %3d%%", strings.Repeat("#", done), strings.Repeat(" ", 50-done), i*100/benchFrames)
+		lines := append(append([]string(nil), history...), bar)
+		frames[i] = benchFrame{lines: lines, cursorX: len(bar) - 9, cursorY: len(history), cursorVisible: true}
+	}
+	return frames
+}
+
+// legacyDeltas returns the output the replaced encoder sent for frames.
+func legacyDeltas(frames []benchFrame) []string {
+	var deltas []string
+	last, lastX, lastY := "", -1, -1
+	for _, f := range frames {
+		lines := append([]string(nil), f.lines...)
+		for i := range lines {
+			if !f.cursorVisible || i != f.cursorY {
+				lines[i] = strings.TrimRight(lines[i], " ")
+			}
+		}
+		content := strings.Join(lines, "\n")
+		cursor := fmt.Sprintf("\x1b[%d;%dH", f.cursorY+1, f.cursorX+1)
+		delta := ""
+		switch {
+		case content == last:
+			if f.cursorX != lastX || f.cursorY != lastY {
+				delta = cursor
+			}
+		case last != "" && strings.HasPrefix(content, last):
+			delta = content[len(last):]
+		default:
+			delta = "\x1b[0m\x1b[H\x1b[2J" + content + cursor
+		}
+		last, lastX, lastY = content, f.cursorX, f.cursorY
+		deltas = append(deltas, delta)
+	}
+	return deltas
+}
+
+// cellDeltas returns the output outputCapturer sends for frames.
+func cellDeltas(frames []benchFrame) []string {
+	ch := make(chan OutputChunk, bufferCapacity)
+	c := newTestCapturer(ch)
+	var deltas []string
+	for _, f := range frames {
+		raw := f.raw()
+		c.captureFn = func() (string, error) { return raw, nil }
+		c.processTick()
+		var delta strings.Builder
+		for len(ch) > 0 {
+			delta.Write((<-ch).Data)
+		}
+		deltas = append(deltas, delta.String())
+	}
+	return deltas
+}
+
+func totalBytes(deltas []string) int {
+	n := 0
+	// The first frame paints the screen for both encoders.
+	for _, d := range deltas[1:] {
+		n += len(d)
+	}
+	return n
+}
+
+func BenchmarkOutputEncoding(b *testing.B) {
+	encoders := []struct {
+		name   string
+		encode func([]benchFrame) []string
+	}{
+		{name: "legacy", encode: legacyDeltas},
+		{name: "cells", encode: cellDeltas},
+	}
+	for _, sc := range benchScenarios {
+		frames := sc.frames()
+		seconds := float64(len(frames)-1) * batchInterval.Seconds()
+		for _, enc := range encoders {
+			b.Run(sc.name+"/"+enc.name, func(b *testing.B) {
+				n := 0
+				for i := 0; i < b.N; i++ {
+					n = totalBytes(enc.encode(frames))
+				}
+				b.ReportMetric(float64(n)/seconds, "bytes/s")
+			})
+		}
+	}
+}
+
+func TestCellDeltasSaveBytesOnAgentScreens(t *testing.T) {
+	for _, sc := range benchScenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			frames := sc.frames()
+			cells := cellDeltas(frames)
+			client := vt.New(benchCols, benchRows)
+			client.ConvertEOL = true
+			for i, f := range frames {
+				client.Write([]byte(cells[i]))
+				want, err := renderGridCapture(f.raw())
+				if err != nil {
+					t.Fatalf("frame %d: renderGridCapture: %v", i, err)
+				}
+				if got := client.Grid(); !sameScreen(got, want) {
+					t.Fatalf("frame %d: client shows\n%s\nwant\n%s", i, got.Text(), want.Text())
+				}
+			}
+			legacy, sent := totalBytes(legacyDeltas(frames)), totalBytes(cells)
+			if sent >= legacy {
+				t.Fatalf("cell deltas sent %d bytes, legacy encoder %d", sent, legacy)
+			}
+		})
+	}
+}
@@ -1,15 +1,20 @@
 package session
 
 import (
+	"fmt"
 	"testing"
 	"time"
+
+	"github.com/tractorfm/chatcode/packages/gateway/internal/vt"
 )
 
 func TestEnqueueLatestWhenQueueNotFull(t *testing.T) {
 	ch := make(chan OutputChunk, 2)
 	payload := OutputChunk{SessionID: "s1", Seq: 1, Data: []byte("a")}
 
-	enqueueLatest(ch, payload)
+	if enqueueLatest(ch, payload) {
+		t.Fatal("enqueueLatest reported a drop")
+	}
 
 	got := <-ch
 	if got.Seq != payload.Seq {
@@ -23,7 +28,9 @@ func TestEnqueueLatestDropsOldestWhenFull(t *testing.T) {
 	newest := OutputChunk{SessionID: "s1", Seq: 2, Data: []byte("new")}
 
 	ch <- oldest
-	enqueueLatest(ch, newest)
+	if !enqueueLatest(ch, newest) {
+		t.Fatal("enqueueLatest did not report the drop")
+	}
 
 	got := <-ch
 	if got.Seq != newest.Seq {
@@ -31,159 +38,152 @@ func TestEnqueueLatestDropsOldestWhenFull(t *testing.T) {
 	}
 }
 
-func TestDiffAppend(t *testing.T) {
-	old := "line1\nline2\n"
-	newVal := "line1\nline2\nline3\n"
-	got, redraw := diff(old, newVal)
-	if got != "line3\n" {
-		t.Fatalf("diff append = %q, want %q", got, "line3\n")
-	}
-	if redraw {
-		t.Fatalf("diff append unexpectedly requested redraw")
+// paneCapture builds capture output in the format of gridCaptureArgs.
+func paneCapture(cols, rows, cursorX, cursorY int, cursorVisible, alternate bool, content string) string {
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
 	}
+	return fmt.Sprintf("%d %d %d %d %d %d\n%s\n", cols, rows, cursorX, cursorY, flag(cursorVisible), flag(alternate), content)
 }
 
-func TestDiffSlidingWindow(t *testing.T) {
-	old := "line1\nline2\nline3\n"
-	newVal := "line2\nline3\nline4\n"
-	want := fullRedrawPrefix + newVal
-	got, redraw := diff(old, newVal)
-	if got != want {
-		t.Fatalf("diff sliding window = %q, want %q", got, want)
-	}
-	if !redraw {
-		t.Fatalf("diff sliding window should request redraw")
+// sameScreen reports whether two grids look the same: blank cells match
+// whatever their foreground color.
+func sameScreen(a, b vt.Grid) bool {
+	if a.Cols != b.Cols || a.Rows != b.Rows || a.CursorX != b.CursorX || a.CursorY != b.CursorY ||
+		a.CursorVisible != b.CursorVisible || a.AlternateOn != b.AlternateOn {
+		return false
+	}
+	for y := range b.Lines {
+		for x, want := range b.Lines[y] {
+			if got := a.Lines[y][x]; got != want && !(got.IsBlank() && want.IsBlank()) {
+				return false
+			}
+		}
 	}
+	return true
 }
 
-func TestDiffInPlaceLineUpdate(t *testing.T) {
-	old := "progress 10%\n"
-	newVal := "progress 11%\n"
-	want := fullRedrawPrefix + newVal
-	got, redraw := diff(old, newVal)
-	if got != want {
-		t.Fatalf("diff in-place update = %q, want %q", got, want)
-	}
-	if !redraw {
-		t.Fatalf("diff in-place update should request redraw")
-	}
+func newTestCapturer(ch chan OutputChunk) *outputCapturer {
+	var seq uint64
+	var lastAct int64
+	return newOutputCapturer("vibe-ses-test", "ses-test", &seq, &lastAct, ch, nil, nil)
 }
 
-func TestCursorMove(t *testing.T) {
-	got := cursorMove(3, 10)
-	want := "\x1b[11;4H"
-	if got != want {
-		t.Fatalf("cursorMove = %q, want %q", got, want)
-	}
-}
+// TestProcessTickDeltasReproducePane replays the emitted deltas into a client
+// terminal and checks it shows the same screen as the pane after each tick.
+func TestProcessTickDeltasReproducePane(t *testing.T) {
+	const cols, rows = 10, 3
+	frames := []struct {
+		name string
+		raw  string
+	}{
+		{name: "prompt", raw: paneCapture(cols, rows, 2, 0, true, false, "$ \n\n")},
+		{name: "typed command", raw: paneCapture(cols, rows, 4, 0, true, false, "$ ls\n\n")},
+		{name: "command output", raw: paneCapture(cols, rows, 2, 2, true, false, "$ ls\nfile\n$ ")},
+		{name: "typing on last line", raw: paneCapture(cols, rows, 3, 2, true, false, "$ ls\nfile\n$ e")},
+		{name: "scrolled", raw: paneCapture(cols, rows, 0, 2, true, false, "file\n$ echo\n")},
+		{name: "full-width line", raw: paneCapture(cols, rows, 3, 1, true, false, "0123456789\nabc\n")},
+		{name: "wide and colored", raw: paneCapture(cols, rows, 0, 2, true, false, "中文 ok\n\x1b[31mred\x1b[39m\n")},
+		{name: "alternate screen", raw: paneCapture(cols, rows, 1, 1, false, true, "top\n\nbottom")},
+		{name: "cursor moved", raw: paneCapture(cols, rows, 5, 2, false, true, "top\n\nbottom")},
+		{name: "back to main", raw: paneCapture(cols, rows, 2, 2, true, false, "$ vi\n\n$ ")},
+		{name: "resized", raw: paneCapture(cols+2, rows+1, 2, 3, true, false, "$ vi\n\n\n$ ")},
+	}
+
+	ch := make(chan OutputChunk, 64)
+	c := newTestCapturer(ch)
+	client := vt.New(cols, rows)
+	client.ConvertEOL = true
+
+	for i, frame := range frames {
+		c.captureFn = func() (string, error) { return frame.raw, nil }
+		c.processTick()
+		for len(ch) > 0 {
+			data := (<-ch).Data
+			if i == len(frames)-1 {
+				// The client terminal is resized along with the pane.
+				client = vt.New(cols+2, rows+1)
+				client.ConvertEOL = true
+			}
+			client.Write(data)
+		}
 
-func TestCursorVisibilityControl(t *testing.T) {
-	c := &outputCapturer{lastCursorV: -1}
-	if got := c.cursorVisibilityControl(0); got != "\x1b[?25l" {
-		t.Fatalf("cursorVisibilityControl(0) = %q", got)
-	}
-	c.lastCursorV = 0
-	if got := c.cursorVisibilityControl(0); got != "" {
-		t.Fatalf("cursorVisibilityControl(0) should be empty, got %q", got)
-	}
-	if got := c.cursorVisibilityControl(1); got != "\x1b[?25h" {
-		t.Fatalf("cursorVisibilityControl(1) = %q", got)
+		want, err := renderGridCapture(frame.raw)
+		if err != nil {
+			t.Fatalf("frame %d: renderGridCapture: %v", i, err)
+		}
+		if got := client.Grid(); !sameScreen(got, want) {
+			t.Fatalf("frame %d (%s): client shows\n%q cursor %d,%d visible %v alternate %v\nwant\n%q cursor %d,%d visible %v alternate %v",
+				i, frame.name, got.Text(), got.CursorX, got.CursorY, got.CursorVisible, got.AlternateOn,
+				want.Text(), want.CursorX, want.CursorY, want.CursorVisible, want.AlternateOn)
+		}
 	}
 }
 
-func TestParsePaneStateOutput(t *testing.T) {
-	got, err := parsePaneStateOutput("12 8 1 1")
-	if err != nil {
-		t.Fatalf("parsePaneStateOutput returned error: %v", err)
+func TestProcessTickSendsOnlyChangedCells(t *testing.T) {
+	ch := make(chan OutputChunk, 4)
+	c := newTestCapturer(ch)
+	c.captureFn = func() (string, error) {
+		return paneCapture(20, 3, 12, 0, true, false, "progress 10%\n\n"), nil
 	}
-	if got.cursorX != 12 || got.cursorY != 8 || got.cursorV != 1 || !got.alternateOn {
-		t.Fatalf("unexpected pane state: %+v", got)
-	}
-}
+	c.processTick()
+	<-ch
 
-func TestAlternateBufferControl(t *testing.T) {
-	c := &outputCapturer{lastAlternate: 0}
-	if got := c.alternateBufferControl(false); got != "" {
-		t.Fatalf("alternateBufferControl(false) = %q, want empty", got)
+	c.captureFn = func() (string, error) {
+		return paneCapture(20, 3, 12, 0, true, false, "progress 11%\n\n"), nil
 	}
-	if got := c.alternateBufferControl(true); got != "\x1b[?1049h" {
-		t.Fatalf("alternateBufferControl(true) = %q", got)
+	c.processTick()
+	got := <-ch
+	if want := "\x1b[1;11H\x1b[0m1\x1b[1;13H"; string(got.Data) != want {
+		t.Fatalf("delta = %q, want %q", got.Data, want)
 	}
-	c.lastAlternate = 1
-	if got := c.alternateBufferControl(false); got != "\x1b[?1049l" {
-		t.Fatalf("alternateBufferControl(false) = %q", got)
+
+	c.processTick()
+	if len(ch) != 0 {
+		t.Fatalf("unchanged pane sent %q", (<-ch).Data)
 	}
 }
 
 func TestProcessTickEmitsAlternateBufferEnterBeforeRedraw(t *testing.T) {
 	ch := make(chan OutputChunk, 1)
-	var seq uint64
-	var lastAct int64
-	c := &outputCapturer{
-		tmuxName:        "vibe-ses-test",
-		sessionID:       "ses-test",
-		seq:             &seq,
-		lastAct:         &lastAct,
-		outCh:           ch,
-		lastRawContent:  "old",
-		lastContent:     "old",
-		lastCursorV:     1,
-		lastAlternate:   0,
-		capturePaneFn:   func() (string, error) { return "new-screen", nil },
-		captureStateFn:  func() (paneState, error) { return paneState{cursorX: 1, cursorY: 2, cursorV: 1, alternateOn: true}, nil },
+	c := newTestCapturer(ch)
+	c.shown, _ = renderGridCapture(paneCapture(10, 3, 1, 0, true, false, "$ vi"))
+	c.captureFn = func() (string, error) {
+		return paneCapture(10, 3, 1, 2, true, true, "new-screen"), nil
 	}
 
 	c.processTick()
 
 	got := <-ch
-	want := "\x1b[?1049h" + fullRedrawPrefix + "new-screen" + cursorMove(1, 2)
+	want := "\x1b[?1049h\x1b[1H\x1b[0mnew-screen\x1b[3;2H"
 	if string(got.Data) != want {
-		t.Fatalf("delta = %q, want %q", string(got.Data), want)
+		t.Fatalf("delta = %q, want %q", got.Data, want)
 	}
 }
 
-func TestProcessCursorOnlyTickEmitsAlternateBufferTransition(t *testing.T) {
+func TestProcessTickRepaintsAfterDroppedOutput(t *testing.T) {
 	ch := make(chan OutputChunk, 1)
-	var seq uint64
-	var lastAct int64
-	c := &outputCapturer{
-		tmuxName:       "vibe-ses-test",
-		sessionID:      "ses-test",
-		seq:            &seq,
-		lastAct:        &lastAct,
-		outCh:          ch,
-		lastContent:    "existing-screen",
-		lastCursorX:    4,
-		lastCursorY:    5,
-		lastCursorV:    1,
-		lastAlternate:  0,
-		captureStateFn: func() (paneState, error) { return paneState{cursorX: 4, cursorY: 5, cursorV: 1, alternateOn: true}, nil },
-	}
-
-	c.processCursorOnlyTick(time.Unix(1, 0))
-
-	got := <-ch
-	want := "\x1b[?1049h" + fullRedrawPrefix + "existing-screen" + cursorMove(4, 5)
-	if string(got.Data) != want {
-		t.Fatalf("delta = %q, want %q", string(got.Data), want)
+	c := newTestCapturer(ch)
+	c.captureFn = func() (string, error) { return paneCapture(10, 3, 1, 0, true, false, "a"), nil }
+	c.processTick()
+	c.captureFn = func() (string, error) { return paneCapture(10, 3, 2, 0, true, false, "ab"), nil }
+	c.processTick()
+	if !c.resync {
+		t.Fatal("dropping the first frame should request a repaint")
 	}
-}
+	<-ch
 
-func TestNormalizeCapturedContentKeepsCursorLineTrailingSpaces(t *testing.T) {
-	content := "a  \nb   \n"
-	got := normalizeCapturedContent(content, 1, 1)
-	want := "a\nb   \n"
-	if got != want {
-		t.Fatalf("normalizeCapturedContent = %q, want %q", got, want)
+	// The pane is unchanged, but the client missed the first frame.
+	c.processTick()
+	got := <-ch
+	if want := "\x1b[0m\x1b[H\x1b[2Jab\x1b[?25h"; string(got.Data) != want {
+		t.Fatalf("delta = %q, want %q", got.Data, want)
 	}
-}
-
-func TestNormalizeCapturedContentTrimsAllWhenCursorHidden(t *testing.T) {
-	content := "a  \nb   \n"
-	got := normalizeCapturedContent(content, 1, 0)
-	want := "a\nb\n"
-	if got != want {
-		t.Fatalf("normalizeCapturedContent hidden = %q, want %q", got, want)
+	if c.resync {
+		t.Fatal("repaint should clear resync")
 	}
 }
 
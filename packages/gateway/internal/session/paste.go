@@ -0,0 +1,139 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// maxPasteChunkBytes bounds the content written at a time, so a large paste
+// reaches tmux or the pane in steps it can keep up with.
+const maxPasteChunkBytes = 64 << 10
+
+// PasteError reports a paste that failed part way. Chunks before Chunk were
+// written; the rest of the content was not.
+type PasteError struct {
+	// Chunk is the 1-based index of the chunk that failed.
+	Chunk  int
+	Chunks int
+	// Written is the number of content bytes that reached the pane before
+	// the failure. tmux sessions only paste the buffer once it is loaded
+	// in full, so for them it is 0.
+	Written int
+	Err     error
+}
+
+func (e *PasteError) Error() string {
+	return fmt.Sprintf("paste chunk %d/%d failed after %d bytes: %v", e.Chunk, e.Chunks, e.Written, e.Err)
+}
+
+func (e *PasteError) Unwrap() error { return e.Err }
+
+// Paste writes data to the pane as pasted text, in chunks of up to 64 KiB;
+// tmux sessions stream it into a paste buffer rather than command arguments
+// and paste the buffer once. With bracketed, the whole content is wrapped in
+// one pair of bracketed-paste sequences if the application in the pane
+// enabled that mode, so agents take multi-line content as one paste instead
+// of one submission per line.
+//
+// Paste returns once all chunks were pasted, after any input queued before
+// it. A failed chunk is reported as a *PasteError.
+func (s *Session) Paste(data []byte, bracketed bool) error {
+	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
+	return s.input.enqueueOwnWait(data, func(data []byte) error {
+		return s.writePaste(data, bracketed)
+	}, s.writeInput)
+}
+
+func (s *Session) writePaste(data []byte, bracketed bool) error {
+	if err := s.backend.paste(data, bracketed); err != nil {
+		return err
+	}
+	s.wakeCapture()
+	if r := s.recorder.get(); r != nil {
+		r.Input()
+	}
+	return nil
+}
+
+// paste loads data into the session's paste buffer through the stdin of one
+// load-buffer, a chunk at a time, and pastes the buffer with a single
+// paste-buffer.
+func (b *tmuxBackend) paste(data []byte, bracketed bool) error {
+	buffer := pasteBufferName(b.name)
+	chunks := pasteChunks(data, maxPasteChunkBytes)
+	if len(chunks) == 0 {
+		return nil
+	}
+	fail := func(chunk int, err error) error {
+		_ = b.server.command("delete-buffer", "-b", buffer).Run()
+		return &PasteError{Chunk: chunk, Chunks: len(chunks), Err: err}
+	}
+
+	load := b.server.command(loadBufferArgs(buffer)...)
+	var out bytes.Buffer
+	load.Stdout, load.Stderr = &out, &out
+	stdin, err := load.StdinPipe()
+	if err != nil {
+		return fail(1, fmt.Errorf("tmux load-buffer: %w", err))
+	}
+	if err := load.Start(); err != nil {
+		return fail(1, fmt.Errorf("tmux load-buffer: %w", err))
+	}
+	for i, chunk := range chunks {
+		if _, err := stdin.Write(chunk); err != nil {
+			stdin.Close()
+			_ = load.Wait()
+			return fail(i+1, fmt.Errorf("tmux load-buffer: %w: %s", err, out.Bytes()))
+		}
+	}
+	stdin.Close()
+	if err := load.Wait(); err != nil {
+		return fail(len(chunks), fmt.Errorf("tmux load-buffer: %w: %s", err, out.Bytes()))
+	}
+	if out, err := b.server.command(pasteBufferArgs(buffer, b.name, bracketed)...).CombinedOutput(); err != nil {
+		return fail(len(chunks), fmt.Errorf("tmux paste-buffer: %w: %s", err, out))
+	}
+	return nil
+}
+
+// pasteBufferName returns the tmux buffer used for a session's pastes.
+func pasteBufferName(tmuxName string) string {
+	return "chatcode-paste-" + tmuxName
+}
+
+func loadBufferArgs(buffer string) []string {
+	// "-" reads the content from stdin, which has no size limit unlike argv.
+	return []string{"load-buffer", "-b", buffer, "-"}
+}
+
+func pasteBufferArgs(buffer, tmuxName string, bracketed bool) []string {
+	// -d deletes the buffer once pasted; -p only adds bracketed-paste
+	// sequences when the application asked for them.
+	args := []string{"paste-buffer", "-d", "-b", buffer, "-t", tmuxName}
+	if bracketed {
+		args = append(args, "-p")
+	}
+	return args
+}
+
+// pasteChunks splits data into chunks of at most max bytes, preferring to end
+// a chunk after a newline and never splitting a UTF-8 sequence.
+func pasteChunks(data []byte, max int) [][]byte {
+	if len(data) == 0 {
+		return nil
+	}
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := inputChunkLen(data, max)
+		if n < len(data) {
+			if i := bytes.LastIndexByte(data[:n], '\n'); i >= 0 {
+				n = i + 1
+			}
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasteBufferArgs(t *testing.T) {
+	got := pasteBufferArgs("chatcode-paste-vibe-ses-1", "vibe-ses-1", true)
+	want := []string{"paste-buffer", "-d", "-b", "chatcode-paste-vibe-ses-1", "-t", "vibe-ses-1", "-p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("args = %q, want %q", got, want)
+	}
+	if got := pasteBufferArgs("b", "vibe-ses-1", false); got[len(got)-1] == "-p" {
+		t.Fatalf("args = %q, want no -p without bracketed paste", got)
+	}
+}
+
+func TestPasteChunksPreferLineBoundaries(t *testing.T) {
+	data := []byte("aaaa\nbbbb\ncccccccccccc")
+	got := pasteChunks(data, 8)
+	want := []string{"aaaa\n", "bbbb\n", "cccccccc", "cccc"}
+	if len(got) != len(want) {
+		t.Fatalf("chunks = %q, want %q", got, want)
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Fatalf("chunks = %q, want %q", got, want)
+		}
+	}
+	if !bytes.Equal(bytes.Join(got, nil), data) {
+		t.Fatal("chunks do not add up to the input")
+	}
+	if pasteChunks(nil, 8) != nil {
+		t.Fatal("expected no chunks for empty input")
+	}
+}
+
+func TestPasteErrorMessage(t *testing.T) {
+	err := &PasteError{Chunk: 2, Chunks: 3, Written: 65536, Err: os.ErrClosed}
+	if got := err.Error(); !strings.Contains(got, "chunk 2/3") || !strings.Contains(got, "65536 bytes") {
+		t.Fatalf("Error() = %q", got)
+	}
+}
+
+func TestSessionPaste(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	workdir := t.TempDir()
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "paste-" + time.Now().Format("150405"),
+		Name:      "paste",
+		Workdir:   workdir,
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	time.Sleep(300 * time.Millisecond)
+
+	// cat -v makes the bracketed-paste markers visible in the file.
+	if err := s.Input([]byte("printf '\\033[?2004h'; cat -v > out.txt\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+	for i := 0; ; i++ {
+		if shell, err := s.isForegroundShell(); err == nil && !shell {
+			break
+		}
+		if i == 100 {
+			t.Fatal("cat did not start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Spans several chunks.
+	long := strings.Repeat(strings.Repeat("y", 99)+"\n", 2*maxPasteChunkBytes/100)
+	if err := s.Paste([]byte(long), true); err != nil {
+		t.Fatalf("Paste: %v", err)
+	}
+	// End cat, so it flushes the line with the closing marker.
+	if err := s.Input([]byte("\n\x04")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		raw, _ := os.ReadFile(filepath.Join(workdir, "out.txt"))
+		got := string(raw)
+		if strings.HasPrefix(got, "^[[200~") && strings.Contains(got, "^[[201~") {
+			// One paste, however many chunks it took.
+			if strings.Count(got, "^[[200~") != 1 || strings.Count(got, "^[[201~") != 1 ||
+				strings.Count(got, strings.Repeat("y", 99)) != 2*maxPasteChunkBytes/100 {
+				t.Fatalf("out.txt is not one paste of the content: %d bytes, ending %q", len(got), got[max(0, len(got)-20):])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("out.txt has %d bytes, starting %q", len(got), got[:min(len(got), 20)])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EndReason says why a session ended.
+type EndReason string
+
+const (
+	// EndReasonExited means the pane process exited on its own.
+	EndReasonExited EndReason = "exited"
+	// EndReasonRequested means a client ended the session.
+	EndReasonRequested EndReason = "requested"
+	// EndReasonIdleTimeout means the session saw no input or output for
+	// Policy.IdleTimeout.
+	EndReasonIdleTimeout EndReason = "idle_timeout"
+	// EndReasonMaxLifetime means the session reached Policy.MaxLifetime.
+	EndReasonMaxLifetime EndReason = "max_lifetime"
+	// EndReasonAgentExited means the agent exited and Policy.EndOnAgentExit
+	// is set.
+	EndReasonAgentExited EndReason = "agent_exited"
+)
+
+// Policy bounds how long a session may run. Zero values disable a limit.
+type Policy struct {
+	// IdleTimeout ends the session after no input or output for this long.
+	IdleTimeout time.Duration
+	// MaxLifetime ends the session this long after it was created.
+	MaxLifetime time.Duration
+	// EndOnAgentExit ends the session when its agent exits instead of
+	// leaving the fallback shell running.
+	EndOnAgentExit bool
+}
+
+// nextExpiry returns the earliest deadline set by p, and the reason for it.
+// agentExitedAt is zero while the agent runs; sessions whose agent exited
+// end agentGrace later. A zero createdAt (sessions recovered from older
+// gateways) disables MaxLifetime.
+func (p Policy) nextExpiry(createdAt, lastActivity, agentExitedAt time.Time, agentGrace time.Duration) (time.Time, EndReason, bool) {
+	var (
+		deadline time.Time
+		reason   EndReason
+	)
+	consider := func(t time.Time, r EndReason) {
+		if reason == "" || t.Before(deadline) {
+			deadline, reason = t, r
+		}
+	}
+	if p.IdleTimeout > 0 && !lastActivity.IsZero() {
+		consider(lastActivity.Add(p.IdleTimeout), EndReasonIdleTimeout)
+	}
+	if p.MaxLifetime > 0 && !createdAt.IsZero() {
+		consider(createdAt.Add(p.MaxLifetime), EndReasonMaxLifetime)
+	}
+	if p.EndOnAgentExit && !agentExitedAt.IsZero() {
+		consider(agentExitedAt.Add(agentGrace), EndReasonAgentExited)
+	}
+	return deadline, reason, reason != ""
+}
+
+// policyWatch is the per-session policy state kept by the watcher loop.
+type policyWatch struct {
+	agentExitedAt time.Time
+	// warned is set once the expiring callback fired for reason; endAt is
+	// the time announced with it.
+	warned bool
+	reason EndReason
+	endAt  time.Time
+}
+
+// storedPolicy is the form of a per-session Policy kept in Metadata.
+type storedPolicy struct {
+	IdleTimeoutSeconds int64 `json:"idle_timeout_seconds,omitempty"`
+	MaxLifetimeSeconds int64 `json:"max_lifetime_seconds,omitempty"`
+	EndOnAgentExit     bool  `json:"end_on_agent_exit,omitempty"`
+}
+
+func newStoredPolicy(p *Policy) *storedPolicy {
+	if p == nil {
+		return nil
+	}
+	return &storedPolicy{
+		IdleTimeoutSeconds: int64(p.IdleTimeout / time.Second),
+		MaxLifetimeSeconds: int64(p.MaxLifetime / time.Second),
+		EndOnAgentExit:     p.EndOnAgentExit,
+	}
+}
+
+func (sp *storedPolicy) policy() *Policy {
+	if sp == nil {
+		return nil
+	}
+	return &Policy{
+		IdleTimeout:    time.Duration(sp.IdleTimeoutSeconds) * time.Second,
+		MaxLifetime:    time.Duration(sp.MaxLifetimeSeconds) * time.Second,
+		EndOnAgentExit: sp.EndOnAgentExit,
+	}
+}
+
+// enforcePolicy fires the expiring callback once a policy deadline is within
+// the warning period and ends the session when it passes. It reports whether
+// the session was ended. Idle activity after a warning postpones the
+// deadline and re-arms the warning.
+func (m *Manager) enforcePolicy(sessionID string, s *Session, w *policyWatch) bool {
+	m.mu.RLock()
+	p := m.policy
+	warning := m.expiryWarning
+	onExpiring := m.onSessionExpiring
+	m.mu.RUnlock()
+	if s.policy != nil {
+		p = *s.policy
+	}
+
+	var lastActivity time.Time
+	if nanos := atomic.LoadInt64(&s.lastActivityAt); nanos != 0 {
+		lastActivity = time.Unix(0, nanos)
+	}
+	deadline, reason, ok := p.nextExpiry(s.createdAt, lastActivity, w.agentExitedAt, warning)
+	now := time.Now()
+	if !ok || now.Before(deadline.Add(-warning)) {
+		w.warned = false
+		return false
+	}
+	if !w.warned || w.reason != reason {
+		// Never act without the full warning period, e.g. for a recovered
+		// session already past its lifetime.
+		w.warned, w.reason, w.endAt = true, reason, deadline
+		if earliest := now.Add(warning); w.endAt.Before(earliest) {
+			w.endAt = earliest
+		}
+		if onExpiring != nil {
+			onExpiring(sessionID, reason, w.endAt)
+		}
+	}
+	if now.Before(w.endAt) {
+		return false
+	}
+	return m.expire(sessionID, s, reason)
+}
+
+// expire ends a session through the graceful kill path on behalf of a
+// policy. A failed kill is retried on the next check.
+func (m *Manager) expire(sessionID string, s *Session, reason EndReason) bool {
+	s.setEndReason(reason)
+	if err := m.endSession(s); err != nil {
+		return false
+	}
+	m.mu.Lock()
+	current, ok := m.sessions[sessionID]
+	onExit := m.onSessionExit
+	if ok && current == s {
+		delete(m.sessions, sessionID)
+	}
+	m.mu.Unlock()
+	if ok && current == s && onExit != nil {
+		onExit(sessionID, s.ExitStatus())
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicyNextExpiry(t *testing.T) {
+	base := time.Unix(1767323045, 0)
+	tests := []struct {
+		name       string
+		policy     Policy
+		agentExit  time.Time
+		wantOK     bool
+		wantAt     time.Time
+		wantReason EndReason
+	}{
+		{name: "no limits", policy: Policy{}},
+		{
+			name:       "idle",
+			policy:     Policy{IdleTimeout: time.Hour},
+			wantOK:     true,
+			wantAt:     base.Add(10*time.Minute + time.Hour),
+			wantReason: EndReasonIdleTimeout,
+		},
+		{
+			name:       "lifetime before idle",
+			policy:     Policy{IdleTimeout: time.Hour, MaxLifetime: 30 * time.Minute},
+			wantOK:     true,
+			wantAt:     base.Add(30 * time.Minute),
+			wantReason: EndReasonMaxLifetime,
+		},
+		{
+			name:   "agent still running",
+			policy: Policy{EndOnAgentExit: true},
+		},
+		{
+			name:       "agent exited",
+			policy:     Policy{MaxLifetime: time.Hour, EndOnAgentExit: true},
+			agentExit:  base.Add(20 * time.Minute),
+			wantOK:     true,
+			wantAt:     base.Add(21 * time.Minute),
+			wantReason: EndReasonAgentExited,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			at, reason, ok := tc.policy.nextExpiry(base, base.Add(10*time.Minute), tc.agentExit, time.Minute)
+			if ok != tc.wantOK || reason != tc.wantReason || (ok && !at.Equal(tc.wantAt)) {
+				t.Fatalf("nextExpiry = %v, %q, %v; want %v, %q, %v", at, reason, ok, tc.wantAt, tc.wantReason, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestStoredPolicyRoundTrip(t *testing.T) {
+	p := &Policy{IdleTimeout: 30 * time.Minute, MaxLifetime: 8 * time.Hour, EndOnAgentExit: true}
+	raw, err := json.Marshal(Metadata{Name: "x", Policy: newStoredPolicy(p)})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var meta Metadata
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got := meta.Policy.policy(); got == nil || *got != *p {
+		t.Fatalf("policy = %+v, want %+v", got, p)
+	}
+	if newStoredPolicy(nil).policy() != nil {
+		t.Fatal("expected sessions without their own policy to stay without one")
+	}
+}
+
+func TestManagerWatchSessionEndsIdleSessionAfterWarning(t *testing.T) {
+	m := NewManager(5)
+	m.checkInterval = 10 * time.Millisecond
+	m.livenessStatus = func(_ *Session) sessionLiveness { return sessionLivenessAlive }
+	m.endSession = func(_ *Session) error { return nil }
+	m.SetPolicy(Policy{IdleTimeout: 100 * time.Millisecond})
+	m.SetExpiryWarning(60 * time.Millisecond)
+
+	events := make(chan string, 4)
+	var endAt time.Time
+	m.SetOnSessionExpiring(func(_ string, reason EndReason, at time.Time) {
+		endAt = at
+		events <- "expiring:" + string(reason)
+	})
+	m.SetOnSessionExit(func(_ string, status ExitStatus) {
+		events <- "ended:" + string(status.Reason)
+	})
+
+	s := &Session{}
+	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
+	m.sessions["idle"] = s
+	go m.watchSession("idle", s)
+
+	for _, want := range []string{"expiring:idle_timeout", "ended:idle_timeout"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("event = %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	if time.Now().Before(endAt) {
+		t.Fatalf("session ended before the announced time %v", endAt)
+	}
+	if m.Get("idle") != nil {
+		t.Fatal("expected expired session to be removed")
+	}
+}
+
+func TestManagerWatchSessionSessionPolicyOverridesDefault(t *testing.T) {
+	m := NewManager(5)
+	m.checkInterval = 10 * time.Millisecond
+	m.livenessStatus = func(_ *Session) sessionLiveness { return sessionLivenessAlive }
+	m.endSession = func(_ *Session) error { return nil }
+	m.SetPolicy(Policy{IdleTimeout: 20 * time.Millisecond})
+
+	s := &Session{policy: &Policy{}}
+	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
+	m.sessions["kept"] = s
+	go m.watchSession("kept", s)
+
+	time.Sleep(100 * time.Millisecond)
+	if m.Get("kept") == nil {
+		t.Fatal("session without limits should outlive the default idle timeout")
+	}
+}
+
+func TestManagerEndReportsRequestedReason(t *testing.T) {
+	m := NewManager(5)
+	s := &Session{}
+	m.sessions["s1"] = s
+	m.endSession = func(_ *Session) error { return nil }
+
+	if err := m.End("s1"); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	if got := s.ExitStatus().Reason; got != EndReasonRequested {
+		t.Fatalf("reason = %q, want %q", got, EndReasonRequested)
+	}
+}
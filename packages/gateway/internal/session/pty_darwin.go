@@ -0,0 +1,52 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo-terminal pair. The controller side stays in
+// non-blocking mode for the runtime poller.
+func openPTY() (pty, tty *os.File, err error) {
+	pty, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ioctl(pty, syscall.TIOCPTYGRANT, nil); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("grantpt: %w", err)
+	}
+	if err := ioctl(pty, syscall.TIOCPTYUNLK, nil); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("unlockpt: %w", err)
+	}
+	var name [128]byte
+	if err := ioctl(pty, syscall.TIOCPTYGNAME, unsafe.Pointer(&name[0])); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("ptsname: %w", err)
+	}
+	path, _, _ := bytes.Cut(name[:], []byte{0})
+	tty, err = os.OpenFile(string(path), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		pty.Close()
+		return nil, nil, err
+	}
+	return pty, tty, nil
+}
+
+// processName returns the command name of a process. macOS has no procfs,
+// so it asks ps.
+func processName(pid int) (string, error) {
+	out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(strings.TrimSpace(string(out))), nil
+}
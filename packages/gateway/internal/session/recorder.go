@@ -0,0 +1,159 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Recorder receives a copy of a session's terminal events, e.g. to write a
+// recording. Implementations must be safe for concurrent use.
+type Recorder interface {
+	// Output is called with every output batch sent to clients.
+	Output(data []byte)
+	// Resize is called after the tmux window was resized.
+	Resize(cols, rows int)
+	// Input is called after input was sent to the pane.
+	Input()
+	Close() error
+}
+
+// RecorderFunc returns a Recorder for a session whose window is cols x
+// rows.
+type RecorderFunc func(cols, rows int) (Recorder, error)
+
+// recorderSlot holds the session's current Recorder; capturers share it with
+// the session so a recorder can be attached after capture started.
+type recorderSlot struct {
+	// attachMu serializes attach.
+	attachMu sync.Mutex
+
+	mu sync.Mutex
+	r  Recorder
+	// pending collects the frames emitted while a recorder is being
+	// attached, so those after the snapshot it starts from can be replayed
+	// to it.
+	pending   []OutputChunk
+	attaching bool
+}
+
+// set replaces the recorder and closes the previous one.
+func (rs *recorderSlot) set(r Recorder) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	prev := rs.r
+	rs.r = r
+	rs.mu.Unlock()
+	if prev != nil && prev != r {
+		_ = prev.Close()
+	}
+}
+
+func (rs *recorderSlot) get() Recorder {
+	if rs == nil {
+		return nil
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.r
+}
+
+// output records frames, which must already carry their seqs.
+func (rs *recorderSlot) output(frames []OutputChunk) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.attaching {
+		rs.pending = append(rs.pending, frames...)
+	}
+	if rs.r != nil {
+		rs.r.Output(joinFrames(frames))
+	}
+}
+
+// attach starts a recorder from a snapshot of the pane. Output is collected
+// from before the snapshot is taken, so the frames following it reach the
+// recorder after the repaint, none missing and none twice.
+func (rs *recorderSlot) attach(snapshot func() (paneSnapshot, error), newRecorder RecorderFunc) error {
+	rs.attachMu.Lock()
+	defer rs.attachMu.Unlock()
+
+	rs.mu.Lock()
+	rs.attaching = true
+	rs.mu.Unlock()
+	defer func() {
+		rs.mu.Lock()
+		rs.attaching = false
+		rs.pending = nil
+		rs.mu.Unlock()
+	}()
+
+	snap, err := snapshot()
+	if err != nil {
+		return err
+	}
+	r, err := newRecorder(snap.cols, snap.rows)
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	r.Output([]byte(snap.repaint()))
+	for _, frame := range rs.pending {
+		if frame.Seq >= snap.nextSeq {
+			r.Output(frame.Data)
+		}
+	}
+	prev := rs.r
+	rs.r = r
+	rs.mu.Unlock()
+	if prev != nil {
+		_ = prev.Close()
+	}
+	return nil
+}
+
+func joinFrames(frames []OutputChunk) []byte {
+	if len(frames) == 1 {
+		return frames[0].Data
+	}
+	var data []byte
+	for _, frame := range frames {
+		data = append(data, frame.Data...)
+	}
+	return data
+}
+
+// repaint returns output that draws the snapshot on a reset terminal, the
+// way clients restore a session.snapshot.
+func (snap paneSnapshot) repaint() string {
+	var b strings.Builder
+	b.WriteString("\x1bc")
+	if snap.alternateOn {
+		b.WriteString("\x1b[?1049h")
+	}
+	content := strings.TrimSuffix(strings.ReplaceAll(snap.content, "\r\n", "\n"), "\n")
+	b.WriteString(strings.ReplaceAll(content, "\n", "\r\n"))
+	if snap.cursorX >= 0 && snap.cursorY >= 0 {
+		fmt.Fprintf(&b, "\x1b[%d;%dH", snap.cursorY+1, snap.cursorX+1)
+	}
+	switch snap.cursorFlag {
+	case 0:
+		b.WriteString("\x1b[?25l")
+	case 1:
+		b.WriteString("\x1b[?25h")
+	}
+	return b.String()
+}
+
+// StartRecording records the session with a recorder created for its
+// current window size, closing any previous recorder. The recording starts
+// with a repaint of the screen, so it plays back without the output that
+// came before.
+func (s *Session) StartRecording(newRecorder RecorderFunc) error {
+	return s.recorder.attach(s.backend.snapshot, newRecorder)
+}
@@ -0,0 +1,159 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeRecorder struct {
+	mu      sync.Mutex
+	output  strings.Builder
+	resizes []string
+	inputs  int
+	closed  bool
+}
+
+func (r *fakeRecorder) Output(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.output.Write(data)
+}
+
+func (r *fakeRecorder) Resize(cols, rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resizes = append(r.resizes, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *fakeRecorder) Input() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inputs++
+}
+
+func (r *fakeRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func TestRecorderSlotClosesReplacedRecorder(t *testing.T) {
+	var slot recorderSlot
+	first, second := &fakeRecorder{}, &fakeRecorder{}
+	slot.set(first)
+	slot.set(second)
+	if !first.closed || second.closed {
+		t.Fatalf("closed = %v/%v, want only the replaced recorder closed", first.closed, second.closed)
+	}
+	slot.output([]OutputChunk{{Data: []byte("x")}})
+	if second.output.String() != "x" || first.output.String() != "" {
+		t.Fatal("expected output to reach only the current recorder")
+	}
+	slot.set(nil)
+	if !second.closed {
+		t.Fatal("expected clearing the slot to close the recorder")
+	}
+}
+
+func TestRecorderSlotAttachStartsFromSnapshot(t *testing.T) {
+	var slot recorderSlot
+	first := &fakeRecorder{}
+	slot.set(first)
+
+	rec := &fakeRecorder{}
+	var size string
+	err := slot.attach(func() (paneSnapshot, error) {
+		// Output racing the snapshot: seq 4 is on the captured screen,
+		// seq 5 came after it.
+		slot.output([]OutputChunk{{Seq: 4, Data: []byte("shown")}})
+		slot.output([]OutputChunk{{Seq: 5, Data: []byte("after")}})
+		return paneSnapshot{
+			content:    "$ ls\nshown\n",
+			cols:       120,
+			rows:       40,
+			cursorX:    2,
+			cursorY:    1,
+			cursorFlag: 1,
+			nextSeq:    5,
+		}, nil
+	}, func(cols, rows int) (Recorder, error) {
+		size = fmt.Sprintf("%dx%d", cols, rows)
+		return rec, nil
+	})
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	slot.output([]OutputChunk{{Seq: 6, Data: []byte("live")}})
+
+	if size != "120x40" {
+		t.Fatalf("recorder created for %s, want 120x40", size)
+	}
+	want := "\x1bc$ ls\r\nshown\x1b[2;3H\x1b[?25h" + "after" + "live"
+	if got := rec.output.String(); got != want {
+		t.Fatalf("recorded %q, want %q", got, want)
+	}
+	if !first.closed {
+		t.Fatal("expected the replaced recorder to be closed")
+	}
+}
+
+func TestSessionFeedsRecorder(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	rec := &fakeRecorder{}
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "record-" + time.Now().Format("150405"),
+		Name:      "record",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 256),
+		Record:    func(int, int) (Recorder, error) { return rec, nil },
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !s.Summary().Recording {
+		t.Fatal("expected session to report recording")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if err := s.Resize(100, 30); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	if err := s.Input([]byte("echo rec_$((1+1))_done\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec.mu.Lock()
+		done := strings.Contains(rec.output.String(), "rec_2_done")
+		rec.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for recorded output")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := m.End(s.opts.SessionID); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.inputs != 1 || len(rec.resizes) != 1 || rec.resizes[0] != "100x30" {
+		t.Fatalf("inputs = %d, resizes = %v; want 1 input and one 100x30 resize", rec.inputs, rec.resizes)
+	}
+	if !rec.closed {
+		t.Fatal("expected recorder to be closed when the session ends")
+	}
+}
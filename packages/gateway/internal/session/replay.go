@@ -0,0 +1,139 @@
+package session
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	replayBufferFrames = 1024
+	replayBufferBytes  = 4 << 20 // 4 MiB of payload per session
+)
+
+var (
+	// ErrReplayNoAck means the client has not acknowledged any frame the
+	// gateway emitted, so there is no position to resume from.
+	ErrReplayNoAck = errors.New("no acknowledged output to resume from")
+	// ErrReplayOverrun means frames after the last acknowledged seq were
+	// evicted from the replay buffer before they could be acknowledged.
+	ErrReplayOverrun = errors.New("replay buffer overrun")
+)
+
+// Replay is the output a reconnecting client has not acknowledged yet.
+type Replay struct {
+	Frames []OutputChunk
+	// NextSeq is the seq following the replayed frames; live output resumes
+	// from here.
+	NextSeq uint64
+}
+
+// replayBuffer is a bounded ring of emitted output frames. Frames are kept
+// until acknowledged or evicted by newer output.
+type replayBuffer struct {
+	mu sync.Mutex
+
+	frames    []OutputChunk
+	head      int // index of the oldest frame
+	count     int
+	bytes     int
+	maxFrames int
+	maxBytes  int
+
+	nextSeq  uint64 // seq following the newest frame added
+	evicted  uint64 // all seqs below this were evicted or acknowledged
+	ackNext  uint64 // seq following the last acknowledged frame
+	hasAcked bool
+}
+
+func newReplayBuffer(maxFrames, maxBytes int) *replayBuffer {
+	return &replayBuffer{
+		frames:    make([]OutputChunk, maxFrames),
+		maxFrames: maxFrames,
+		maxBytes:  maxBytes,
+	}
+}
+
+func (b *replayBuffer) add(chunk OutputChunk) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.count > 0 && (b.count == b.maxFrames || b.bytes+len(chunk.Data) > b.maxBytes) {
+		b.dropOldest()
+	}
+	b.frames[(b.head+b.count)%b.maxFrames] = chunk
+	b.count++
+	b.bytes += len(chunk.Data)
+	b.nextSeq = chunk.Seq + 1
+}
+
+// ack records seq as the last frame the client received and releases every
+// frame up to and including it. Acks for frames this buffer never emitted
+// (for example from before a gateway restart) are ignored.
+func (b *replayBuffer) ack(seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if seq >= b.nextSeq {
+		return
+	}
+	if b.hasAcked && seq+1 <= b.ackNext {
+		return
+	}
+	b.ackNext = seq + 1
+	b.hasAcked = true
+	for b.count > 0 && b.frames[b.head].Seq <= seq {
+		b.dropOldest()
+	}
+}
+
+// replay returns the frames after the last acknowledged seq.
+func (b *replayBuffer) replay() (Replay, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.hasAcked {
+		return Replay{}, ErrReplayNoAck
+	}
+	if b.evicted > b.ackNext {
+		return Replay{}, ErrReplayOverrun
+	}
+	frames := make([]OutputChunk, 0, b.count)
+	for i := 0; i < b.count; i++ {
+		frame := b.frames[(b.head+i)%b.maxFrames]
+		if frame.Seq >= b.ackNext {
+			frames = append(frames, frame)
+		}
+	}
+	next := b.ackNext
+	if len(frames) > 0 {
+		next = frames[len(frames)-1].Seq + 1
+	}
+	return Replay{Frames: frames, NextSeq: next}, nil
+}
+
+// since returns the buffered frames from seq from on. ok is false when some
+// of them were evicted or acknowledged already.
+func (b *replayBuffer) since(from uint64) (frames []OutputChunk, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if from < b.evicted {
+		return nil, false
+	}
+	for i := 0; i < b.count; i++ {
+		frame := b.frames[(b.head+i)%b.maxFrames]
+		if frame.Seq >= from {
+			frames = append(frames, frame)
+		}
+	}
+	return frames, true
+}
+
+func (b *replayBuffer) dropOldest() {
+	oldest := b.frames[b.head]
+	b.frames[b.head] = OutputChunk{}
+	b.head = (b.head + 1) % b.maxFrames
+	b.count--
+	b.bytes -= len(oldest.Data)
+	b.evicted = oldest.Seq + 1
+}
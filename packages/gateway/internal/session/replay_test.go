@@ -0,0 +1,171 @@
+package session
+
+import (
+	"errors"
+	"testing"
+)
+
+func addFrames(b *replayBuffer, from, to uint64, size int) {
+	for seq := from; seq < to; seq++ {
+		b.add(OutputChunk{SessionID: "s", Seq: seq, Data: make([]byte, size)})
+	}
+}
+
+func frameSeqs(frames []OutputChunk) []uint64 {
+	seqs := make([]uint64, 0, len(frames))
+	for _, f := range frames {
+		seqs = append(seqs, f.Seq)
+	}
+	return seqs
+}
+
+func TestReplayBufferRequiresAck(t *testing.T) {
+	b := newReplayBuffer(8, 1024)
+	addFrames(b, 0, 3, 1)
+	if _, err := b.replay(); !errors.Is(err, ErrReplayNoAck) {
+		t.Fatalf("replay error = %v, want ErrReplayNoAck", err)
+	}
+}
+
+func TestReplayBufferReturnsUnackedFrames(t *testing.T) {
+	b := newReplayBuffer(8, 1024)
+	addFrames(b, 0, 5, 1)
+	b.ack(1)
+
+	replay, err := b.replay()
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if got := frameSeqs(replay.Frames); len(got) != 3 || got[0] != 2 || got[2] != 4 {
+		t.Fatalf("replayed seqs = %v, want [2 3 4]", got)
+	}
+	if replay.NextSeq != 5 {
+		t.Fatalf("NextSeq = %d, want 5", replay.NextSeq)
+	}
+	if b.count != 3 {
+		t.Fatalf("acked frames should be released, count = %d", b.count)
+	}
+}
+
+func TestReplayBufferFullyAcked(t *testing.T) {
+	b := newReplayBuffer(8, 1024)
+	addFrames(b, 0, 3, 1)
+	b.ack(2)
+
+	replay, err := b.replay()
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(replay.Frames) != 0 || replay.NextSeq != 3 {
+		t.Fatalf("replay = %+v, want no frames and NextSeq 3", replay)
+	}
+}
+
+func TestReplayBufferOverrunByFrameCount(t *testing.T) {
+	b := newReplayBuffer(4, 1024)
+	addFrames(b, 0, 2, 1)
+	b.ack(0)
+	addFrames(b, 2, 8, 1) // evicts seq 1..3 before they are acked
+
+	if _, err := b.replay(); !errors.Is(err, ErrReplayOverrun) {
+		t.Fatalf("replay error = %v, want ErrReplayOverrun", err)
+	}
+
+	// A later ack inside the retained window makes replay possible again.
+	b.ack(4)
+	replay, err := b.replay()
+	if err != nil {
+		t.Fatalf("replay after ack: %v", err)
+	}
+	if got := frameSeqs(replay.Frames); len(got) != 3 || got[0] != 5 {
+		t.Fatalf("replayed seqs = %v, want [5 6 7]", got)
+	}
+}
+
+func TestReplayBufferOverrunByBytes(t *testing.T) {
+	b := newReplayBuffer(64, 10)
+	addFrames(b, 0, 1, 4)
+	b.ack(0)
+	addFrames(b, 1, 5, 4) // 16 bytes unacked, only 10 retained
+
+	if b.bytes > 10 {
+		t.Fatalf("retained %d bytes, want <= 10", b.bytes)
+	}
+	if _, err := b.replay(); !errors.Is(err, ErrReplayOverrun) {
+		t.Fatalf("replay error = %v, want ErrReplayOverrun", err)
+	}
+}
+
+func TestReplayBufferIgnoresUnknownAndStaleAcks(t *testing.T) {
+	b := newReplayBuffer(8, 1024)
+	addFrames(b, 0, 4, 1)
+
+	// Ack from a previous gateway process: seq never emitted here.
+	b.ack(100)
+	if _, err := b.replay(); !errors.Is(err, ErrReplayNoAck) {
+		t.Fatalf("replay error = %v, want ErrReplayNoAck", err)
+	}
+
+	b.ack(2)
+	b.ack(1) // out-of-order ack must not move the position backwards
+	replay, err := b.replay()
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if got := frameSeqs(replay.Frames); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("replayed seqs = %v, want [3]", got)
+	}
+}
+
+func TestEmitDeltaRecordsReplayFrames(t *testing.T) {
+	var seq uint64
+	var lastAct int64
+	outCh := make(chan OutputChunk, 1)
+	b := newReplayBuffer(8, 1<<20)
+	c := newOutputCapturer("vibe-s", "s", &seq, &lastAct, outCh, b, nil)
+
+	// Two frames overflow the one-slot queue; both remain replayable.
+	c.emitDelta(string(make([]byte, maxPayload+1)))
+	b.ack(0)
+	replay, err := b.replay()
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if got := frameSeqs(replay.Frames); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("replayed seqs = %v, want [1]", got)
+	}
+}
+
+func TestSharedOutputChannelDropsAreReplayable(t *testing.T) {
+	var seqA, seqB uint64
+	var lastAct int64
+	outCh := make(chan OutputChunk, 2)
+	replayA, replayB := newReplayBuffer(8, 1<<20), newReplayBuffer(8, 1<<20)
+
+	emitOutput("a", &seqA, &lastAct, outCh, replayA, nil, "a0")
+	emitOutput("a", &seqA, &lastAct, outCh, replayA, nil, "a1")
+	// Session b's frame pushes session a's oldest frame out of the full
+	// queue.
+	emitOutput("b", &seqB, &lastAct, outCh, replayB, nil, "b0")
+
+	var queued []OutputChunk
+	for len(outCh) > 0 {
+		queued = append(queued, <-outCh)
+	}
+	if len(queued) != 2 || queued[0].SessionID != "a" || queued[0].Seq != 1 || queued[1].SessionID != "b" {
+		t.Fatalf("queued = %+v, want a:1 and b:0", queued)
+	}
+
+	// A stream of a that expected seq 0 fills the gap from a's buffer.
+	frames, ok := replayA.since(0)
+	if got := frameSeqs(frames); !ok || len(got) != 2 || got[0] != 0 || string(frames[0].Data) != "a0" {
+		t.Fatalf("since(0) = %v, %v; want [0 1]", got, ok)
+	}
+	replayA.ack(0)
+	if _, ok := replayA.since(0); ok {
+		t.Fatal("since returned acknowledged frames")
+	}
+	if frames, ok := replayA.since(1); !ok || len(frames) != 1 {
+		t.Fatalf("since(1) = %v, %v; want [1]", frameSeqs(frames), ok)
+	}
+}
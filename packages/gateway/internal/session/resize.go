@@ -0,0 +1,167 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ResizeMode selects how the window size of a session follows the viewports
+// of the clients watching it.
+type ResizeMode string
+
+const (
+	// ResizeLatestActive uses the viewport of the client that resized last.
+	ResizeLatestActive ResizeMode = "latest-active"
+	// ResizeSmallest fits the window into every viewport.
+	ResizeSmallest ResizeMode = "smallest"
+	// ResizeLargest fills the largest viewport; smaller clients crop.
+	ResizeLargest ResizeMode = "largest"
+	// ResizePinned keeps a fixed size whatever the clients report.
+	ResizePinned ResizeMode = "pinned"
+
+	DefaultResizeMode = ResizeLatestActive
+)
+
+// maxWindowDim bounds the pinned window size to what tmux accepts.
+const maxWindowDim = 10000
+
+// ResizePolicy decides the window size of a session from the viewports of
+// its clients. The zero value is DefaultResizeMode.
+type ResizePolicy struct {
+	Mode ResizeMode
+	// Cols and Rows are the window size under ResizePinned.
+	Cols, Rows int
+}
+
+// ParseResizePolicy parses "latest-active", "smallest", "largest" or a pinned
+// size written as COLSxROWS. Empty selects the default.
+func ParseResizePolicy(v string) (ResizePolicy, error) {
+	switch m := ResizeMode(strings.ToLower(strings.TrimSpace(v))); m {
+	case "":
+		return ResizePolicy{Mode: DefaultResizeMode}, nil
+	case ResizeLatestActive, ResizeSmallest, ResizeLargest:
+		return ResizePolicy{Mode: m}, nil
+	}
+	c, r, ok := strings.Cut(strings.TrimSpace(v), "x")
+	cols, errC := strconv.Atoi(c)
+	rows, errR := strconv.Atoi(r)
+	if !ok || errC != nil || errR != nil {
+		return ResizePolicy{}, fmt.Errorf("unknown resize policy %q", v)
+	}
+	if cols < 1 || rows < 1 || cols > maxWindowDim || rows > maxWindowDim {
+		return ResizePolicy{}, fmt.Errorf("pinned size %q out of range (1-%d)", v, maxWindowDim)
+	}
+	return ResizePolicy{Mode: ResizePinned, Cols: cols, Rows: rows}, nil
+}
+
+// String returns the policy in the form ParseResizePolicy accepts.
+func (p ResizePolicy) String() string {
+	switch p.Mode {
+	case "":
+		return string(DefaultResizeMode)
+	case ResizePinned:
+		return fmt.Sprintf("%dx%d", p.Cols, p.Rows)
+	default:
+		return string(p.Mode)
+	}
+}
+
+// viewport is the terminal size a client reported. seq orders the reports
+// of a session.
+type viewport struct {
+	cols, rows int
+	seq        uint64
+}
+
+// size returns the window size for the given viewports, or ok false when the
+// policy has nothing to go by.
+func (p ResizePolicy) size(viewports map[string]viewport) (cols, rows int, ok bool) {
+	if p.Mode == ResizePinned {
+		return p.Cols, p.Rows, true
+	}
+	var latest uint64
+	for _, v := range viewports {
+		switch {
+		case !ok:
+			cols, rows = v.cols, v.rows
+			latest = v.seq
+		case p.Mode == ResizeSmallest:
+			cols, rows = min(cols, v.cols), min(rows, v.rows)
+		case p.Mode == ResizeLargest:
+			cols, rows = max(cols, v.cols), max(rows, v.rows)
+		case v.seq > latest:
+			cols, rows = v.cols, v.rows
+			latest = v.seq
+		}
+		ok = true
+	}
+	return cols, rows, ok
+}
+
+// SetViewport records the terminal size of a client and sizes the window
+// per the session's resize policy. Clients that do not identify themselves
+// share the empty client ID. It returns the window size and whether it
+// changed.
+func (s *Session) SetViewport(clientID string, cols, rows int) (int, int, bool, error) {
+	if cols < 1 || rows < 1 || cols > maxWindowDim || rows > maxWindowDim {
+		return 0, 0, false, fmt.Errorf("invalid size %dx%d", cols, rows)
+	}
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	if s.viewports == nil {
+		s.viewports = make(map[string]viewport)
+	}
+	s.viewportSeq++
+	s.viewports[clientID] = viewport{cols: cols, rows: rows, seq: s.viewportSeq}
+	return s.applyViewportsLocked()
+}
+
+// DropViewport forgets the viewport of a client that went away and sizes the
+// window for the remaining ones. Without viewports left the window keeps its
+// size.
+func (s *Session) DropViewport(clientID string) (int, int, bool, error) {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	if _, ok := s.viewports[clientID]; !ok {
+		return s.cols, s.rows, false, nil
+	}
+	delete(s.viewports, clientID)
+	return s.applyViewportsLocked()
+}
+
+// ClearViewports forgets every viewport without resizing; clients report
+// them again when they reconnect.
+func (s *Session) ClearViewports() {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	clear(s.viewports)
+}
+
+// ResizePolicy returns the session's resize policy.
+func (s *Session) ResizePolicy() ResizePolicy {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	return s.resizePolicy
+}
+
+// applyResizePolicy sizes the window of a created or recovered session when
+// its policy needs no viewports, as a pinned size does.
+func (s *Session) applyResizePolicy() error {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	_, _, _, err := s.applyViewportsLocked()
+	return err
+}
+
+func (s *Session) applyViewportsLocked() (int, int, bool, error) {
+	cols, rows, ok := s.resizePolicy.size(s.viewports)
+	if !ok || (cols == s.cols && rows == s.rows) {
+		return s.cols, s.rows, false, nil
+	}
+	if err := s.Resize(cols, rows); err != nil {
+		return s.cols, s.rows, false, err
+	}
+	s.cols, s.rows = cols, rows
+	return cols, rows, true, nil
+}
@@ -0,0 +1,139 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseResizePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ResizePolicy
+		wantErr bool
+	}{
+		{in: "", want: ResizePolicy{Mode: DefaultResizeMode}},
+		{in: "smallest", want: ResizePolicy{Mode: ResizeSmallest}},
+		{in: " Largest ", want: ResizePolicy{Mode: ResizeLargest}},
+		{in: "latest-active", want: ResizePolicy{Mode: ResizeLatestActive}},
+		{in: "120x40", want: ResizePolicy{Mode: ResizePinned, Cols: 120, Rows: 40}},
+		{in: "0x40", wantErr: true},
+		{in: "120", wantErr: true},
+		{in: "pinned", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseResizePolicy(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("ParseResizePolicy(%q) error = nil, want error", tt.in)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Fatalf("ParseResizePolicy(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+		if back, err := ParseResizePolicy(got.String()); err != nil || back != got {
+			t.Fatalf("ParseResizePolicy(%q.String()) = %+v, %v", tt.in, back, err)
+		}
+	}
+}
+
+func TestResizePolicySize(t *testing.T) {
+	viewports := map[string]viewport{
+		"laptop": {cols: 200, rows: 50, seq: 1},
+		"phone":  {cols: 60, rows: 70, seq: 2},
+		"tablet": {cols: 120, rows: 40, seq: 3},
+	}
+	tests := []struct {
+		policy     ResizePolicy
+		cols, rows int
+	}{
+		{ResizePolicy{}, 120, 40},
+		{ResizePolicy{Mode: ResizeLatestActive}, 120, 40},
+		{ResizePolicy{Mode: ResizeSmallest}, 60, 40},
+		{ResizePolicy{Mode: ResizeLargest}, 200, 70},
+		{ResizePolicy{Mode: ResizePinned, Cols: 100, Rows: 30}, 100, 30},
+	}
+	for _, tt := range tests {
+		cols, rows, ok := tt.policy.size(viewports)
+		if !ok || cols != tt.cols || rows != tt.rows {
+			t.Fatalf("%s: size = %dx%d, %v; want %dx%d", tt.policy, cols, rows, ok, tt.cols, tt.rows)
+		}
+	}
+	if _, _, ok := (ResizePolicy{Mode: ResizeSmallest}).size(nil); ok {
+		t.Fatal("size without viewports reported ok")
+	}
+}
+
+func TestSessionViewportsFollowPolicy(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	m.SetResizePolicy(ResizePolicy{Mode: ResizeSmallest})
+	s, err := m.Create(Options{
+		SessionID: "resize-" + time.Now().Format("150405"),
+		Name:      "resize",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+
+	check := func(step string, gotCols, gotRows int, changed bool, err error, wantCols, wantRows int, wantChanged bool) {
+		t.Helper()
+		if err != nil || gotCols != wantCols || gotRows != wantRows || changed != wantChanged {
+			t.Fatalf("%s = %dx%d changed %v, %v; want %dx%d changed %v",
+				step, gotCols, gotRows, changed, err, wantCols, wantRows, wantChanged)
+		}
+	}
+	cols, rows, changed, err := s.SetViewport("laptop", 160, 48)
+	check("laptop", cols, rows, changed, err, 160, 48, true)
+	cols, rows, changed, err = s.SetViewport("phone", 70, 60)
+	check("phone", cols, rows, changed, err, 70, 48, true)
+	cols, rows, changed, err = s.SetViewport("laptop", 150, 50)
+	check("laptop again", cols, rows, changed, err, 70, 50, true)
+	cols, rows, changed, err = s.DropViewport("tablet")
+	check("unknown client", cols, rows, changed, err, 70, 50, false)
+	cols, rows, changed, err = s.DropViewport("phone")
+	check("phone gone", cols, rows, changed, err, 150, 50, true)
+
+	d := m.Details()[0]
+	if d.Cols != 150 || d.Rows != 50 {
+		t.Fatalf("window = %dx%d, want 150x50", d.Cols, d.Rows)
+	}
+	if _, _, _, err := s.SetViewport("phone", 0, 20); err == nil {
+		t.Fatal("SetViewport accepted an empty size")
+	}
+}
+
+func TestManagerAppliesPinnedSizeOnCreate(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	m.SetResizePolicy(ResizePolicy{Mode: ResizePinned, Cols: 132, Rows: 40})
+	s, err := m.Create(Options{
+		SessionID: "pinned-" + time.Now().Format("150405"),
+		Name:      "pinned",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+
+	if d := m.Details()[0]; d.Cols != 132 || d.Rows != 40 {
+		t.Fatalf("window = %dx%d before any viewport, want 132x40", d.Cols, d.Rows)
+	}
+	// The first viewport leaves the pinned window alone.
+	if cols, rows, changed, err := s.SetViewport("laptop", 80, 24); err != nil || changed || cols != 132 || rows != 40 {
+		t.Fatalf("SetViewport = %dx%d changed %v, %v; want 132x40 unchanged", cols, rows, changed, err)
+	}
+}
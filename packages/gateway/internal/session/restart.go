@@ -0,0 +1,107 @@
+package session
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// RestartOptions configures Restart.
+type RestartOptions struct {
+	// Agent replaces the session's agent. Empty keeps it; "none" leaves a
+	// plain shell.
+	Agent string
+	// Resume passes the agent the flags that continue its last
+	// conversation in the working directory.
+	Resume bool
+}
+
+// Restart kills the pane process and starts the agent again in the same
+// pane, keeping the session ID, the pane history and the agent options. It
+// returns the process ID of the new pane process. The exit status and agent
+// exit reported by the old process are forgotten. When the restart fails the
+// session keeps its agent.
+func (s *Session) Restart(opts RestartOptions) (int, error) {
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+
+	agent := s.currentAgent()
+	var files []InstructionFile
+	switched := opts.Agent != "" && opts.Agent != agent.agent
+	if switched {
+		var err error
+		files, err = writeInstructionFiles(
+			s.opts.Workdir,
+			s.opts.InstructionsPolicy,
+			instructionTargets(opts.Agent, s.opts.ClaudeMD, s.opts.AgentsMD),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("instruction files: %w", err)
+		}
+		// Models and flags differ between agents; the new one starts with
+		// its defaults.
+		agent = agentLaunch{agent: opts.Agent}
+	}
+
+	// The watcher must not take the old process exiting for the session
+	// ending.
+	s.restarting.Store(true)
+	defer s.restarting.Store(false)
+
+	pid, err := s.backend.restart(s.launch(agent, opts.Resume))
+	if err != nil {
+		return 0, err
+	}
+	s.agentMu.Lock()
+	s.agent = agent
+	if switched {
+		s.instructionFiles = files
+	}
+	s.agentMu.Unlock()
+	s.resetExit()
+	atomic.AddUint32(&s.restarts, 1)
+	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
+	s.wakeCapture()
+	return pid, nil
+}
+
+// restart respawns the pane. tmux keeps the pane history and clears the
+// visible screen.
+func (b *tmuxBackend) restart(l launch) (int, error) {
+	// The exit options are cleared in the same tmux call, so nothing the
+	// new process reports can be lost.
+	args := []string{
+		"set-option", "-qu", "-t", b.name, agentExitOption, ";",
+		"set-option", "-qu", "-t", b.name, paneExitOption, ";",
+		"respawn-pane", "-k", "-t", b.name, "-c", l.workdir,
+	}
+	for _, name := range sortedKeys(l.explicit) {
+		args = append(args, "-e", name+"="+l.explicit[name])
+	}
+	args = append(args, "--", "sh", "-c", l.paneCommand())
+	cmd := b.server.command(args...)
+	// tmux takes PATH from the client for panes it spawns; a session PATH
+	// comes with -e.
+	cmd.Env = append(l.clientEnv, "TERM="+detectTmuxDefaultTerminal())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("tmux respawn-pane: %w: %s", err, out)
+	}
+	if err := b.persistMetadata(l.meta); err != nil {
+		return 0, err
+	}
+
+	// The polling capturer repaints the cleared screen on its own; streamed
+	// clients are told.
+	b.captureMu.Lock()
+	_, streaming := b.capturer.(*streamCapturer)
+	b.captureMu.Unlock()
+	if streaming {
+		b.out.emit(restartReset + "\x1b[H\x1b[2J")
+	}
+
+	info, err := b.paneInfo()
+	if err != nil {
+		return 0, err
+	}
+	return info.pids[0], nil
+}
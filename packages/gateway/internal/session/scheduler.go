@@ -0,0 +1,168 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxIdlePollInterval bounds the back-off of sessions whose pane stopped
+	// changing and that no client watches.
+	maxIdlePollInterval = 2 * time.Second
+	// watchWindow is how long Session.Watch keeps a session polled at the
+	// full rate.
+	watchWindow = 30 * time.Second
+	// inputTickDelay gives the pane program a moment to echo input before
+	// the capture it triggers.
+	inputTickDelay = 5 * time.Millisecond
+)
+
+// pollScheduler runs the capture ticks of all polled sessions from one loop.
+// Sessions that changed on their last tick or are watched by a client are
+// polled every batchInterval; the others back off exponentially up to
+// maxIdlePollInterval until they change, get input or are watched again.
+type pollScheduler struct {
+	mu      sync.Mutex
+	entries map[*outputCapturer]*pollEntry
+	wakeCh  chan struct{}
+	once    sync.Once
+}
+
+type pollEntry struct {
+	interval time.Duration
+	next     time.Time
+	running  bool
+	// woken is set when the session was woken during a running tick, which
+	// may have captured the pane before the input arrived.
+	woken bool
+}
+
+// capturePolls schedules the poll capturers of all sessions.
+var capturePolls = newPollScheduler()
+
+func newPollScheduler() *pollScheduler {
+	return &pollScheduler{
+		entries: make(map[*outputCapturer]*pollEntry),
+		wakeCh:  make(chan struct{}, 1),
+	}
+}
+
+// add schedules c's first tick after batchInterval.
+func (p *pollScheduler) add(c *outputCapturer) {
+	p.once.Do(func() { go p.run() })
+	p.mu.Lock()
+	p.entries[c] = &pollEntry{interval: batchInterval, next: time.Now().Add(batchInterval)}
+	p.mu.Unlock()
+	p.signal()
+}
+
+// remove stops scheduling c. A tick already running completes.
+func (p *pollScheduler) remove(c *outputCapturer) {
+	p.mu.Lock()
+	delete(p.entries, c)
+	p.mu.Unlock()
+}
+
+// wake polls c right away and at the full rate again.
+func (p *pollScheduler) wake(c *outputCapturer) {
+	p.mu.Lock()
+	e, ok := p.entries[c]
+	if ok {
+		e.interval = batchInterval
+		if e.running {
+			e.woken = true
+		} else {
+			e.next = time.Now().Add(inputTickDelay)
+		}
+	}
+	p.mu.Unlock()
+	if ok {
+		p.signal()
+	}
+}
+
+// interval returns how often c is polled now, or 0 if it is not scheduled.
+func (p *pollScheduler) interval(c *outputCapturer) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.entries[c]; ok {
+		return e.interval
+	}
+	return 0
+}
+
+func (p *pollScheduler) signal() {
+	select {
+	case p.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pollScheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		wait := p.startDue(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-p.wakeCh:
+		}
+	}
+}
+
+// startDue starts the ticks that are due at now and returns the time until
+// the next one.
+func (p *pollScheduler) startDue(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wait := time.Hour
+	for c, e := range p.entries {
+		if e.running {
+			continue
+		}
+		if d := e.next.Sub(now); d > 0 {
+			wait = min(wait, d)
+			continue
+		}
+		e.running = true
+		go p.tick(c)
+	}
+	return wait
+}
+
+func (p *pollScheduler) tick(c *outputCapturer) {
+	changed := c.processTick()
+	now := time.Now()
+
+	p.mu.Lock()
+	e, ok := p.entries[c]
+	if ok {
+		e.running = false
+		e.interval = nextPollInterval(e.interval, changed || e.woken || c.isWatched())
+		e.interval = max(e.interval, c.captureInterval(now))
+		e.next = now.Add(e.interval)
+		if e.woken {
+			e.woken = false
+			e.next = now.Add(inputTickDelay)
+		}
+	}
+	p.mu.Unlock()
+	if ok {
+		p.signal()
+	}
+}
+
+// nextPollInterval returns the interval after a tick at interval: the full
+// rate while active, double the interval otherwise.
+func nextPollInterval(interval time.Duration, active bool) time.Duration {
+	if active {
+		return batchInterval
+	}
+	return min(2*interval, maxIdlePollInterval)
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNextPollInterval(t *testing.T) {
+	interval := batchInterval
+	var got []time.Duration
+	for i := 0; i < 7; i++ {
+		interval = nextPollInterval(interval, false)
+		got = append(got, interval)
+	}
+	want := []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond,
+		1600 * time.Millisecond, maxIdlePollInterval, maxIdlePollInterval,
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("intervals = %v, want %v", got, want)
+		}
+	}
+	if got := nextPollInterval(maxIdlePollInterval, true); got != batchInterval {
+		t.Fatalf("active interval = %s, want %s", got, batchInterval)
+	}
+}
+
+// scheduledCapturer returns a capturer registered with p without starting
+// p's loop, so the test drives its ticks.
+func scheduledCapturer(p *pollScheduler, raw *string) *outputCapturer {
+	c := newTestCapturer(make(chan OutputChunk, 64))
+	c.sched = p
+	c.captureFn = func() (string, error) { return *raw, nil }
+	p.entries[c] = &pollEntry{interval: batchInterval, running: true}
+	return c
+}
+
+func TestPollSchedulerBacksOffIdleSessions(t *testing.T) {
+	p := newPollScheduler()
+	raw := paneCapture(10, 3, 0, 0, true, false, "$ ")
+	c := scheduledCapturer(p, &raw)
+
+	p.tick(c)
+	if got := c.pollInterval(); got != batchInterval {
+		t.Fatalf("interval after a change = %s, want %s", got, batchInterval)
+	}
+	for _, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		p.entries[c].running = true
+		p.tick(c)
+		if got := c.pollInterval(); got != want {
+			t.Fatalf("idle interval = %s, want %s", got, want)
+		}
+	}
+
+	raw = paneCapture(10, 3, 3, 0, true, false, "$ ls")
+	p.entries[c].running = true
+	p.tick(c)
+	if got := c.pollInterval(); got != batchInterval {
+		t.Fatalf("interval after output = %s, want %s", got, batchInterval)
+	}
+}
+
+func TestPollSchedulerKeepsWatchedSessionsFast(t *testing.T) {
+	p := newPollScheduler()
+	raw := paneCapture(10, 3, 0, 0, true, false, "$ ")
+	c := scheduledCapturer(p, &raw)
+	c.watched = func() bool { return true }
+
+	for i := 0; i < 3; i++ {
+		p.entries[c].running = true
+		p.tick(c)
+		if got := c.pollInterval(); got != batchInterval {
+			t.Fatalf("watched interval = %s, want %s", got, batchInterval)
+		}
+	}
+}
+
+func TestPollSchedulerWakePollsRightAway(t *testing.T) {
+	p := newPollScheduler()
+	raw := paneCapture(10, 3, 0, 0, true, false, "$ ")
+	c := scheduledCapturer(p, &raw)
+	p.entries[c] = &pollEntry{interval: maxIdlePollInterval, next: time.Now().Add(maxIdlePollInterval)}
+
+	c.wake()
+	e := p.entries[c]
+	if e.interval != batchInterval || time.Until(e.next) > inputTickDelay {
+		t.Fatalf("after wake: interval %s, next in %s", e.interval, time.Until(e.next))
+	}
+
+	// Input during a tick polls again once the tick is done.
+	e.running = true
+	c.wake()
+	p.tick(c)
+	if e.woken || time.Until(e.next) > inputTickDelay {
+		t.Fatalf("after wake during tick: woken %v, next in %s", e.woken, time.Until(e.next))
+	}
+}
+
+func TestPollSchedulerRunsTicks(t *testing.T) {
+	p := newPollScheduler()
+	ch := make(chan OutputChunk, 64)
+	c := newTestCapturer(ch)
+	c.sched = p
+	var ticks atomic.Int32
+	c.captureFn = func() (string, error) {
+		ticks.Add(1)
+		return paneCapture(10, 3, 2, 0, true, false, "$ "), nil
+	}
+
+	p.add(c)
+	defer p.remove(c)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler never ran the capturer")
+	}
+
+	// The idle pane backs off; a wake polls it again right away.
+	time.Sleep(300 * time.Millisecond)
+	before := ticks.Load()
+	c.wake()
+	deadline := time.Now().Add(batchInterval)
+	for ticks.Load() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("wake did not trigger a tick")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTmuxCommandCountsExecs(t *testing.T) {
+	before := TmuxExecs()
+	cmd := legacyTmux.command("-V")
+	if cmd.Args[0] != "tmux" || cmd.Args[1] != "-V" {
+		t.Fatalf("args = %q", cmd.Args)
+	}
+	if got := TmuxExecs(); got != before+1 {
+		t.Fatalf("TmuxExecs = %d, want %d", got, before+1)
+	}
+}
+
+func TestSessionIdlePaneBacksOffUntilWatched(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "poll-" + time.Now().Format("150405"),
+		Name:      "poll",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Summary().PollInterval < 4*batchInterval {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle pane still polled every %s", s.Summary().PollInterval)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s.Watch()
+	if got := s.Summary().PollInterval; got != batchInterval {
+		t.Fatalf("watched pane polled every %s, want %s", got, batchInterval)
+	}
+}
+
+func TestSessionSubscribedKeepsPollingFast(t *testing.T) {
+	s := &Session{}
+	if s.watched() {
+		t.Fatal("new session is watched")
+	}
+	s.SetSubscribed(true)
+	if !s.watched() {
+		t.Fatal("subscribed session is not watched")
+	}
+	s.SetSubscribed(false)
+	if s.watched() {
+		t.Fatal("unsubscribed session is still watched")
+	}
+}
@@ -0,0 +1,149 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchResults = 100
+	maxSearchResults     = 1000
+	maxSearchContext     = 10
+	// maxSearchResultBytes bounds the text returned by one search, so the
+	// result fits a single event.
+	maxSearchResultBytes = 512 << 10
+)
+
+// SearchOptions configures a scrollback search.
+type SearchOptions struct {
+	Query string
+	// Regex treats Query as a Go regular expression instead of literal text.
+	Regex         bool
+	CaseSensitive bool
+	// Context is the number of lines returned before and after each match,
+	// at most 10.
+	Context int
+	// MaxResults caps the matches returned; 0 means the default of 100.
+	MaxResults int
+}
+
+// SearchMatch is one matching line. Line uses the numbering of History.
+type SearchMatch struct {
+	Line   int
+	Text   string
+	Before []string
+	After  []string
+}
+
+// SearchResult holds the matches of a search, oldest first.
+type SearchResult struct {
+	HistorySize int
+	Matches     []SearchMatch
+	// Truncated is set when more lines matched than were returned.
+	Truncated bool
+}
+
+// Search scans the pane's whole scrollback and visible lines for opts.Query.
+// The scan stops when ctx is done.
+func (s *Session) Search(ctx context.Context, opts SearchOptions) (SearchResult, error) {
+	re, err := searchPattern(opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	content, historySize, err := s.backend.scrollback(ctx)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	return searchLines(ctx, content, historySize, re, opts)
+}
+
+func (b *tmuxBackend) scrollback(ctx context.Context) (string, int, error) {
+	out, err := b.server.commandContext(ctx, searchCaptureArgs(b.name)...).Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", 0, fmt.Errorf("search: %w", ctx.Err())
+		}
+		return "", 0, fmt.Errorf("capture-pane: %w", err)
+	}
+	header, content, _ := strings.Cut(string(out), "\n")
+	historySize, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil {
+		return "", 0, fmt.Errorf("parse history size %q: %w", header, err)
+	}
+	return content, historySize, nil
+}
+
+// searchCaptureArgs captures the history size and all pane lines in one
+// tmux call, so the line numbers match the captured content.
+func searchCaptureArgs(tmuxName string) []string {
+	return []string{
+		"display-message", "-p", "-t", tmuxName, "#{history_size}", ";",
+		"capture-pane", "-p", "-t", tmuxName, "-S", "-", "-E", "-",
+	}
+}
+
+func searchPattern(opts SearchOptions) (*regexp.Regexp, error) {
+	if opts.Query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	expr := opts.Query
+	if !opts.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if !opts.CaseSensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+	return re, nil
+}
+
+func searchLines(ctx context.Context, content string, historySize int, re *regexp.Regexp, opts SearchOptions) (SearchResult, error) {
+	limit := opts.MaxResults
+	if limit <= 0 {
+		limit = defaultSearchResults
+	}
+	limit = min(limit, maxSearchResults)
+	around := min(max(opts.Context, 0), maxSearchContext)
+
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+
+	result := SearchResult{HistorySize: historySize}
+	size := 0
+	for i, line := range lines {
+		if i%1000 == 0 && ctx.Err() != nil {
+			return SearchResult{}, fmt.Errorf("search: %w", ctx.Err())
+		}
+		if !re.MatchString(line) {
+			continue
+		}
+		if len(result.Matches) == limit {
+			result.Truncated = true
+			break
+		}
+		m := SearchMatch{
+			Line:   i - historySize,
+			Text:   line,
+			Before: lines[max(i-around, 0):i],
+			After:  lines[i+1 : min(i+1+around, len(lines))],
+		}
+		size += len(m.Text)
+		for _, l := range m.Before {
+			size += len(l)
+		}
+		for _, l := range m.After {
+			size += len(l)
+		}
+		if size > maxSearchResultBytes {
+			result.Truncated = true
+			break
+		}
+		result.Matches = append(result.Matches, m)
+	}
+	return result, nil
+}
@@ -0,0 +1,128 @@
+package session
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchLines(t *testing.T) {
+	content := "build ok\nERROR: disk full\nretrying\nerror: disk full\ndone\n"
+	re, err := searchPattern(SearchOptions{Query: "error:"})
+	if err != nil {
+		t.Fatalf("searchPattern: %v", err)
+	}
+	got, err := searchLines(context.Background(), content, 3, re, SearchOptions{Context: 1})
+	if err != nil {
+		t.Fatalf("searchLines: %v", err)
+	}
+	want := []SearchMatch{
+		{Line: -2, Text: "ERROR: disk full", Before: []string{"build ok"}, After: []string{"retrying"}},
+		{Line: 0, Text: "error: disk full", Before: []string{"retrying"}, After: []string{"done"}},
+	}
+	if !reflect.DeepEqual(got.Matches, want) || got.Truncated || got.HistorySize != 3 {
+		t.Fatalf("searchLines = %+v, want %+v", got, want)
+	}
+}
+
+func TestSearchPatternOptions(t *testing.T) {
+	tests := []struct {
+		opts  SearchOptions
+		line  string
+		match bool
+	}{
+		{opts: SearchOptions{Query: "a.c"}, line: "abc", match: false},
+		{opts: SearchOptions{Query: "a.c", Regex: true}, line: "abc", match: true},
+		{opts: SearchOptions{Query: "Panic", CaseSensitive: true}, line: "panic", match: false},
+		{opts: SearchOptions{Query: `exit \d+`, Regex: true}, line: "EXIT 3", match: true},
+	}
+	for _, tc := range tests {
+		re, err := searchPattern(tc.opts)
+		if err != nil {
+			t.Fatalf("searchPattern(%+v): %v", tc.opts, err)
+		}
+		if got := re.MatchString(tc.line); got != tc.match {
+			t.Errorf("searchPattern(%+v) matches %q = %v, want %v", tc.opts, tc.line, got, tc.match)
+		}
+	}
+	if _, err := searchPattern(SearchOptions{Query: "("}); err != nil {
+		t.Fatalf("literal queries should not be parsed as regex: %v", err)
+	}
+	if _, err := searchPattern(SearchOptions{Query: "(", Regex: true}); err == nil {
+		t.Fatal("expected invalid regex to fail")
+	}
+	if _, err := searchPattern(SearchOptions{}); err == nil {
+		t.Fatal("expected empty query to fail")
+	}
+}
+
+func TestSearchLinesLimits(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 50; i++ {
+		b.WriteString("match " + strconv.Itoa(i) + "\n")
+	}
+	re, _ := searchPattern(SearchOptions{Query: "match"})
+	got, err := searchLines(context.Background(), b.String(), 0, re, SearchOptions{MaxResults: 10})
+	if err != nil {
+		t.Fatalf("searchLines: %v", err)
+	}
+	if len(got.Matches) != 10 || !got.Truncated {
+		t.Fatalf("got %d matches, truncated %v; want 10, true", len(got.Matches), got.Truncated)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := searchLines(ctx, b.String(), 0, re, SearchOptions{}); err == nil {
+		t.Fatal("expected a cancelled search to fail")
+	}
+}
+
+func TestSessionSearchFindsOldOutput(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	s, err := m.Create(Options{
+		SessionID: "search-" + time.Now().Format("150405"),
+		Name:      "search",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 1024),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	time.Sleep(300 * time.Millisecond)
+
+	if err := s.Input([]byte("printf 'needle_%s\\n' found; seq 1 500\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := s.Search(context.Background(), SearchOptions{Query: "^needle_f", Regex: true, Context: 1})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(res.Matches) == 1 && res.Matches[0].Line < 0 {
+			// The matching line scrolled into history; History must agree.
+			page, err := s.History(res.Matches[0].Line, res.Matches[0].Line, false)
+			if err != nil {
+				t.Fatalf("History: %v", err)
+			}
+			if page.Content != "needle_found\n" {
+				t.Fatalf("history line %d = %q", res.Matches[0].Line, page.Content)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("search result = %+v", res)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -3,9 +3,7 @@ package session
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -18,19 +16,10 @@ const (
 	terminationTimeout      = 3 * time.Second
 	forceKillWait           = 500 * time.Millisecond
 	gracefulExitWait        = 750 * time.Millisecond
-	tmuxHistoryLimitLines   = 200000
 	snapshotHistoryLines    = 50000
-	preferredTmuxTerminal   = "tmux-256color"
-	fallbackTmuxTerminal    = "screen-256color"
-	legacyTmuxTerminal      = "screen"
 	defaultShellCommand     = "${SHELL:-/bin/bash}"
 )
 
-var (
-	detectedTmuxTerminal string
-	detectTmuxTermOnce   sync.Once
-)
-
 const osc8Prefix = "\x1b]8;;"
 
 type sessionLiveness int
@@ -45,21 +34,40 @@ const (
 type Options struct {
 	// SessionID is the stable CP-assigned ID.
 	SessionID string
-	// Name is a human-readable label (used as the tmux session name).
+	// Name is a human-readable label; the tmux session is named after
+	// SessionID.
 	Name string
 	// Workdir is the working directory for the session.
 	Workdir string
 	// Agent identifies which AI agent to launch. Empty → plain shell.
 	Agent string
+	// AgentOptions selects the model, permission mode and other launch
+	// flags of the agent.
+	AgentOptions AgentOptions
 	// ClaudeMD overrides the default CLAUDE.md content.
 	ClaudeMD string
 	// AgentsMD overrides the default AGENTS.md content.
 	AgentsMD string
+	// InstructionsPolicy controls how CLAUDE.md/AGENTS.md are written into
+	// Workdir. Empty → DefaultInstructionsPolicy.
+	InstructionsPolicy InstructionsPolicy
 	// Env contains extra environment variables.
 	Env map[string]string
 	// OutputCh receives batched PTY output frames (payload only, not framed).
 	// The caller is responsible for framing and sending over WebSocket.
 	OutputCh chan OutputChunk
+	// Record, when set, creates the recorder of the session's terminal
+	// events once the session runs. The session closes it when it ends.
+	Record RecorderFunc
+	// Policy bounds the session's lifetime. nil → the Manager's policy.
+	Policy *Policy
+	// Backend selects what runs the session's terminal. Empty → the
+	// Manager's backend.
+	Backend BackendKind
+	// CgroupProcs is the cgroup.procs file of the session's cgroup. Pane
+	// processes add themselves to it before running anything, so nothing
+	// they start escapes the group's limits. Empty → no cgroup.
+	CgroupProcs string
 }
 
 // OutputChunk is a batch of PTY output from a session.
@@ -73,132 +81,221 @@ type OutputChunk struct {
 type Summary struct {
 	SessionID      string
 	Name           string
+	Agent          string
+	Workdir        string
+	CreatedAt      time.Time
 	LastActivityAt time.Time
+	// Recording reports whether the session was started with recording
+	// enabled.
+	Recording bool
+	// PollInterval is how often the pane is captured now, or 0 for stream
+	// capture.
+	PollInterval time.Duration
+	// Backend is what runs the session's terminal.
+	Backend BackendKind
 }
 
-// Session represents one tmux-backed PTY session.
+// Session represents one PTY session, run by a tmux or native backend.
 type Session struct {
 	opts Options
 
-	tmuxName string // tmux session name (unique, uses SessionID)
+	kind      BackendKind
+	backend   backend // nil until started
+	envPolicy EnvPolicy
 
 	seq            uint64 // atomic sequence counter for output frames
 	lastActivityAt int64  // unix nano, updated atomically
-
-	capturer *outputCapturer
+	watchedUntil   int64  // unix nano, updated atomically
+	subscribed     int32  // 1 while a client subscribes to output, atomic
+	createdAt      time.Time
+
+	// tmux is the server of a tmux session.
+	tmux        TmuxServer
+	captureMode CaptureMode
+	replay      *replayBuffer
+	recorder    recorderSlot
+	record      bool
+	input       inputWriter
+
+	recovered bool
+	policy    *Policy // nil → Manager.policy
+
+	// agentMu guards the agent the pane runs and the instruction files
+	// written for it, which Restart replaces, and the cgroup new pane
+	// processes join.
+	agentMu          sync.RWMutex
+	agent            agentLaunch
+	instructionFiles []InstructionFile
+	cgroupProcs      string
+
+	// restartMu serializes Restart; restarting is set while the pane process
+	// is being replaced and restarts counts the replacements.
+	restartMu  sync.Mutex
+	restarting atomic.Bool
+	restarts   uint32 // atomic
+
+	// resizeMu guards the client viewports and the window size last set
+	// from them by resizePolicy.
+	resizeMu     sync.Mutex
+	resizePolicy ResizePolicy
+	viewports    map[string]viewport
+	viewportSeq  uint64
+	cols, rows   int
+
+	exitMu           sync.Mutex
+	exitStatus       ExitStatus
+	endReason        EndReason
+	agentExit        int
+	agentExitSeen    bool
+	agentExitPending bool
 }
 
 func newSession(opts Options) *Session {
-	return &Session{
-		opts:     opts,
-		tmuxName: "vibe-" + opts.SessionID,
+	s := &Session{
+		opts:        opts,
+		kind:        opts.Backend,
+		replay:      newReplayBuffer(replayBufferFrames, replayBufferBytes),
+		record:      opts.Record != nil,
+		policy:      opts.Policy,
+		cgroupProcs: opts.CgroupProcs,
 	}
+	return s
 }
 
-// start launches the tmux-backed session and begins output capture.
+// start launches the session's backend and begins output capture.
 func (s *Session) start() error {
-	cmd := s.buildTmuxNewSessionCmd()
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tmux new-session: %w: %s", err, out)
+	agent, err := newAgentLaunch(s.opts.Agent, s.opts.AgentOptions)
+	if err != nil {
+		return err
 	}
-	if err := s.ensureHistoryLimit(); err != nil {
+	files, err := writeInstructionFiles(
+		s.opts.Workdir,
+		s.opts.InstructionsPolicy,
+		instructionTargets(s.opts.Agent, s.opts.ClaudeMD, s.opts.AgentsMD),
+	)
+	if err != nil {
+		return fmt.Errorf("instruction files: %w", err)
+	}
+	s.agentMu.Lock()
+	s.agent = agent
+	s.instructionFiles = files
+	s.agentMu.Unlock()
+
+	b, err := newBackend(s.kind, s.tmux, s.output(), s.captureMode)
+	if err != nil {
 		return err
 	}
-	if err := s.ensureDefaultTerminal(); err != nil {
+	s.backend = b
+	if err := b.start(s.launch(agent, s.opts.AgentOptions.Resume)); err != nil {
 		return err
 	}
-
-	s.capturer = newOutputCapturer(s.tmuxName, s.opts.SessionID, &s.seq, &s.lastActivityAt, s.opts.OutputCh)
-	s.capturer.start()
+	s.createdAt = time.Now()
 
 	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
 	return nil
 }
 
-// buildTmuxNewSessionCmd returns the exec.Cmd to start the tmux session.
-func (s *Session) buildTmuxNewSessionCmd() *exec.Cmd {
-	shellCmd := s.agentCommand()
-
-	args := []string{
-		"new-session",
-		"-d",             // detached
-		"-s", s.tmuxName, // session name
-		"-c", s.opts.Workdir, // start dir
-		"--",
-		"sh", "-c", shellCmd,
-	}
-	cmd := exec.Command("tmux", args...)
-	cmd.Env = append(s.buildEnv(), "TERM="+detectTmuxDefaultTerminal())
-	return cmd
-}
-
-// agentCommand returns the shell command to run inside tmux.
-func (s *Session) agentCommand() string {
-	switch s.opts.Agent {
-	case "claude-code":
-		return buildAgentLaunchCommand("claude-code", "claude")
-	case "codex":
-		return buildAgentLaunchCommand("codex", "codex")
-	case "gemini":
-		return buildAgentLaunchCommand("gemini", "gemini")
-	case "opencode":
-		return buildAgentLaunchCommand("opencode", "opencode")
-	default:
+// launch describes the pane to start with agent. With resume, the agent
+// continues its last conversation.
+func (s *Session) launch(agent agentLaunch, resume bool) launch {
+	env, filtered := s.envPolicy.build(hostEnv(), s.opts.Env)
+	s.agentMu.RLock()
+	cgroupProcs := s.cgroupProcs
+	s.agentMu.RUnlock()
+	return launch{
+		workdir:     s.opts.Workdir,
+		command:     s.agentCommand(agent, resume),
+		env:         env,
+		filtered:    filtered,
+		explicit:    s.envPolicy.explicitVars(s.opts.Env),
+		clientEnv:   s.envPolicy.inherited(hostEnv()),
+		cgroupProcs: cgroupProcs,
+		meta:        s.metadata(agent),
+	}
+}
+
+// SetCgroupProcs sets the cgroup.procs file pane processes started from now
+// on join, for a recovered session whose Options were not kept.
+func (s *Session) SetCgroupProcs(path string) {
+	s.agentMu.Lock()
+	s.cgroupProcs = path
+	s.agentMu.Unlock()
+}
+
+// output returns where the backend delivers the session's output.
+func (s *Session) output() paneOutput {
+	return paneOutput{
+		sessionID: s.opts.SessionID,
+		seq:       &s.seq,
+		lastAct:   &s.lastActivityAt,
+		ch:        s.opts.OutputCh,
+		replay:    s.replay,
+		recorder:  &s.recorder,
+		watched:   s.watched,
+	}
+}
+
+// agentCommand returns the shell command that runs agent in the pane. With
+// resume, the agent continues its last conversation.
+func (s *Session) agentCommand(agent agentLaunch, resume bool) string {
+	cli, ok := agentCLIs[agent.agent]
+	if !ok {
 		return defaultShellCommand
 	}
+	var args []string
+	if resume {
+		args = append(args, cli.resume...)
+	}
+	args = append(args, agent.args...)
+	return buildAgentLaunchCommand(agent.agent, cli.binary, args, s.backend.agentExitReport())
 }
 
-func buildAgentLaunchCommand(agentType, binary string) string {
+// currentAgent returns the agent the pane runs.
+func (s *Session) currentAgent() agentLaunch {
+	s.agentMu.RLock()
+	defer s.agentMu.RUnlock()
+	return s.agent
+}
+
+// buildAgentLaunchCommand runs the agent and falls back to a shell when it
+// exits; report records the agent exit code "$ec" with the backend.
+func buildAgentLaunchCommand(agentType, binary string, args []string, report string) string {
+	run := binary
+	for _, arg := range args {
+		run += " " + shellQuote(arg)
+	}
 	return fmt.Sprintf(
-		`if command -v %[1]s >/dev/null 2>&1; then %[1]s; ec=$?; printf '\n[chatcode] %[2]s exited (code %%s); starting shell.\n' "$ec"; else printf '\n[chatcode] %[2]s is not installed. Run agents.install and retry.\n'; fi; exec "${SHELL:-/bin/bash}"`,
+		`if command -v %[1]s >/dev/null 2>&1; then %[4]s; ec=$?; printf '\n[chatcode] %[2]s exited (code %%s); starting shell.\n' "$ec"; else ec=127; printf '\n[chatcode] %[2]s is not installed. Run agents.install and retry.\n'; fi; %[3]s; exec "${SHELL:-/bin/bash}"`,
 		binary,
 		agentType,
+		report,
+		run,
 	)
 }
 
-// buildEnv merges the host environment with session-specific overrides.
-func (s *Session) buildEnv() []string {
-	base := append([]string(nil), hostEnv()...)
-	for k, v := range s.opts.Env {
-		base = append(base, k+"="+v)
-	}
-	return base
-}
-
-// Input injects keystrokes into the tmux pane.
+// Input injects keystrokes into the pane. It returns once the input was
+// written, after any input queued before it.
 func (s *Session) Input(data []byte) error {
 	atomic.StoreInt64(&s.lastActivityAt, time.Now().UnixNano())
-	cmd := exec.Command("tmux", literalSendKeysArgs(s.tmuxName, string(data))...)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tmux send-keys: %w: %s", err, out)
-	}
-	return nil
-}
-
-func (s *Session) sendKeys(keys ...string) error {
-	args := append([]string{"send-keys", "-t", s.tmuxName}, keys...)
-	cmd := exec.Command("tmux", args...)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tmux send-keys: %w: %s", err, out)
-	}
-	return nil
+	return s.input.enqueueWait(data, s.writeInput)
 }
 
-func (s *Session) sendLiteral(text string) error {
-	cmd := exec.Command("tmux", literalSendKeysArgs(s.tmuxName, text)...)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tmux send-keys: %w: %s", err, out)
+// Backend returns the kind of backend running the session.
+func (s *Session) Backend() BackendKind {
+	if s.kind == "" {
+		return DefaultBackend
 	}
-	return nil
+	return s.kind
 }
 
-// Resize resizes the tmux window.
+// Resize resizes the session's window.
 func (s *Session) Resize(cols, rows int) error {
-	cmd := exec.Command("tmux", "resize-window", "-t", s.tmuxName,
-		"-x", fmt.Sprintf("%d", cols),
-		"-y", fmt.Sprintf("%d", rows))
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tmux resize-window: %w: %s", err, out)
+	if err := s.backend.resize(cols, rows); err != nil {
+		return err
+	}
+	s.wakeCapture()
+	if r := s.recorder.get(); r != nil {
+		r.Resize(cols, rows)
 	}
 	return nil
 }
@@ -206,98 +303,13 @@ func (s *Session) Resize(cols, rows int) error {
 // Snapshot returns the current terminal content and terminal geometry/cursor state.
 // cursorX/cursorY are 0-based positions within the current visible pane.
 // cursorVisible is 1 (visible), 0 (hidden), or -1 when unknown.
-func (s *Session) Snapshot() (content string, cols, rows, cursorX, cursorY, cursorVisible int, alternateOn bool, err error) {
-	// Capture recent pane history with ANSI escapes to preserve color output.
-	out, err := exec.Command("tmux", snapshotCaptureArgs(s.tmuxName)...).Output()
+// nextSeq is the seq of the first output frame the content does not reflect.
+func (s *Session) Snapshot() (content string, cols, rows, cursorX, cursorY, cursorVisible int, alternateOn bool, nextSeq uint64, err error) {
+	snap, err := s.backend.snapshot()
 	if err != nil {
-		return "", 0, 0, -1, -1, -1, false, fmt.Errorf("capture-pane: %w", err)
-	}
-
-	// Get dimensions and cursor position in one tmux call.
-	cols, rows = 80, 24
-	cursorX, cursorY, cursorVisible = -1, -1, -1
-	alternateOn = false
-
-	stateOut, err := exec.Command(
-		"tmux", "display-message", "-t", s.tmuxName, "-p", "#{window_width} #{window_height} #{cursor_x} #{cursor_y} #{cursor_flag} #{alternate_on}",
-	).Output()
-	if err == nil {
-		var alternateInt int
-		fmt.Sscanf(string(stateOut), "%d %d %d %d %d %d", &cols, &rows, &cursorX, &cursorY, &cursorVisible, &alternateInt)
-		alternateOn = alternateInt == 1
-	}
-
-	return stripOSC8Hyperlinks(string(out)), cols, rows, cursorX, cursorY, cursorVisible, alternateOn, nil
-}
-
-func (s *Session) ensureHistoryLimit() error {
-	cmd := exec.Command("tmux", setHistoryLimitArgs(s.tmuxName)...)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tmux set history-limit: %w: %s", err, out)
-	}
-	return nil
-}
-
-func (s *Session) ensureDefaultTerminal() error {
-	cmd := exec.Command("tmux", setDefaultTerminalArgs(detectTmuxDefaultTerminal())...)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tmux set default-terminal: %w: %s", err, out)
-	}
-	return nil
-}
-
-func setHistoryLimitArgs(tmuxName string) []string {
-	return []string{"set-option", "-t", tmuxName, "history-limit", fmt.Sprintf("%d", tmuxHistoryLimitLines)}
-}
-
-func setDefaultTerminalArgs(term string) []string {
-	return []string{"set-option", "-g", "default-terminal", term}
-}
-
-func literalSendKeysArgs(tmuxName, text string) []string {
-	// `--` ensures leading pasted text like `--dangerously-skip-permissions`
-	// is treated as literal input rather than tmux flags.
-	return []string{"send-keys", "-t", tmuxName, "-l", "--", text}
-}
-
-func detectTmuxDefaultTerminal() string {
-	detectTmuxTermOnce.Do(func() {
-		infocmpPath, err := exec.LookPath("infocmp")
-		if err != nil {
-			// If we cannot probe terminfo availability, use the most compatible
-			// term name to avoid unknown-terminal failures in ncurses apps.
-			detectedTmuxTerminal = legacyTmuxTerminal
-			return
-		}
-		detectedTmuxTerminal = selectTmuxDefaultTerminal(func(term string) bool {
-			return exec.Command(infocmpPath, term).Run() == nil
-		}, true)
-	})
-	return detectedTmuxTerminal
-}
-
-func selectTmuxDefaultTerminal(termExists func(string) bool, canProbe bool) string {
-	if !canProbe {
-		return legacyTmuxTerminal
-	}
-	candidates := []string{preferredTmuxTerminal, fallbackTmuxTerminal, legacyTmuxTerminal}
-	for _, term := range candidates {
-		if termExists(term) {
-			return term
-		}
-	}
-	return legacyTmuxTerminal
-}
-
-func snapshotCaptureArgs(tmuxName string) []string {
-	return []string{
-		"capture-pane",
-		"-e",
-		"-N",
-		"-S", fmt.Sprintf("-%d", snapshotHistoryLines),
-		"-t", tmuxName,
-		"-p",
+		return "", 0, 0, -1, -1, -1, false, 0, err
 	}
+	return snap.content, snap.cols, snap.rows, snap.cursorX, snap.cursorY, snap.cursorFlag, snap.alternateOn, snap.nextSeq, nil
 }
 
 func stripOSC8Hyperlinks(content string) string {
@@ -347,10 +359,16 @@ func stripOSC8Hyperlinks(content string) string {
 	return out.String()
 }
 
-// kill terminates the tmux session.
+// kill terminates the session's pane.
 func (s *Session) kill() error {
 	defer s.stopCapture()
-	panePIDs := s.listPanePIDs()
+	// Backends keep the pane after its process is gone; remove it once the
+	// exit status has been observed.
+	defer func() { _ = s.backend.destroy() }()
+	var panePIDs []int
+	if info, err := s.backend.paneInfo(); err == nil {
+		panePIDs = info.pids
+	}
 
 	// First, ask the foreground program/shell to exit gracefully so agents can
 	// flush final output (resume tokens, status lines, etc.) before the session
@@ -362,15 +380,15 @@ func (s *Session) kill() error {
 		return nil
 	}
 
-	// Force underlying pane processes if tmux session is still alive.
+	// Force underlying pane processes if the pane is still alive.
 	s.signalPIDs(panePIDs, syscall.SIGTERM)
 	if s.waitForExit(forceKillWait, 100*time.Millisecond) {
 		return nil
 	}
 	s.signalPIDs(panePIDs, syscall.SIGKILL)
 
-	// Final best-effort tmux kill and exit check.
-	_ = s.killTmuxSession()
+	// Final best-effort kill and exit check.
+	_ = s.backend.destroy()
 	if s.waitForExit(forceKillWait, 100*time.Millisecond) {
 		return nil
 	}
@@ -386,10 +404,10 @@ func (s *Session) requestGracefulExit() error {
 	if isShell, err := s.isForegroundShell(); err == nil && isShell {
 		// Only send literal "exit" when the foreground program is a shell, so
 		// we do not inject text into editors or other interactive programs.
-		if err := s.sendLiteral("exit"); err != nil && s.livenessStatus() == sessionLivenessAlive {
+		if err := s.backend.writeInput([]byte("exit")); err != nil && s.livenessStatus() == sessionLivenessAlive {
 			return err
 		}
-		if err := s.sendKeys("Enter"); err != nil && s.livenessStatus() == sessionLivenessAlive {
+		if err := s.backend.sendKeys([]string{"Enter"}); err != nil && s.livenessStatus() == sessionLivenessAlive {
 			return err
 		}
 		if s.waitForExit(gracefulExitWait, 100*time.Millisecond) {
@@ -400,7 +418,7 @@ func (s *Session) requestGracefulExit() error {
 	// Interrupt first so interactive foreground programs can flush/stop before
 	// we fall back to EOF semantics.
 	if s.isAlive() {
-		if err := s.sendKeys("C-c"); err != nil && s.livenessStatus() == sessionLivenessAlive {
+		if err := s.backend.sendKeys([]string{"C-c"}); err != nil && s.livenessStatus() == sessionLivenessAlive {
 			return err
 		}
 		time.Sleep(150 * time.Millisecond)
@@ -409,7 +427,7 @@ func (s *Session) requestGracefulExit() error {
 	// One EOF fallback helps when the foreground shell/program is waiting for
 	// end-of-input, without aggressively tearing through nested shells.
 	if s.isAlive() {
-		if err := s.sendKeys("C-d"); err != nil && s.livenessStatus() == sessionLivenessAlive {
+		if err := s.backend.sendKeys([]string{"C-d"}); err != nil && s.livenessStatus() == sessionLivenessAlive {
 			return err
 		}
 		if s.waitForExit(gracefulExitWait, 100*time.Millisecond) {
@@ -421,11 +439,11 @@ func (s *Session) requestGracefulExit() error {
 }
 
 func (s *Session) isForegroundShell() (bool, error) {
-	out, err := exec.Command("tmux", "display-message", "-t", s.tmuxName, "-p", "#{pane_current_command}").Output()
+	info, err := s.backend.paneInfo()
 	if err != nil {
 		return false, err
 	}
-	return isShellCommand(string(out)), nil
+	return isShellCommand(info.currentCommand), nil
 }
 
 func isShellCommand(cmd string) bool {
@@ -437,17 +455,6 @@ func isShellCommand(cmd string) bool {
 	}
 }
 
-func (s *Session) killTmuxSession() error {
-	cmd := exec.Command("tmux", "kill-session", "-t", s.tmuxName)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		if s.livenessStatus() != sessionLivenessAlive {
-			return nil
-		}
-		return fmt.Errorf("tmux kill-session: %w: %s", err, out)
-	}
-	return nil
-}
-
 func (s *Session) waitForExit(timeout, interval time.Duration) bool {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
@@ -459,27 +466,6 @@ func (s *Session) waitForExit(timeout, interval time.Duration) bool {
 	return !s.isAlive()
 }
 
-func (s *Session) listPanePIDs() []int {
-	out, err := exec.Command("tmux", "list-panes", "-t", s.tmuxName, "-F", "#{pane_pid}").Output()
-	if err != nil {
-		return nil
-	}
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	pids := make([]int, 0, len(lines))
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		pid, err := strconv.Atoi(line)
-		if err != nil || pid <= 0 {
-			continue
-		}
-		pids = append(pids, pid)
-	}
-	return pids
-}
-
 func (s *Session) signalPIDs(pids []int, sig syscall.Signal) {
 	for _, pid := range pids {
 		proc, err := os.FindProcess(pid)
@@ -490,50 +476,116 @@ func (s *Session) signalPIDs(pids []int, sig syscall.Signal) {
 	}
 }
 
+// stopCapture stops output capture for good and finishes the recording.
 func (s *Session) stopCapture() {
-	if s.capturer != nil {
-		s.capturer.stop()
+	if s.backend != nil {
+		s.backend.stop()
 	}
+	s.recorder.set(nil)
+}
+
+// wakeCapture polls the pane right away after input.
+func (s *Session) wakeCapture() {
+	if s.backend != nil {
+		s.backend.wake()
+	}
+}
+
+// Watch marks the session as watched by a client for watchWindow, keeping
+// its pane polled at the full rate. Clients renew it as they ack output.
+func (s *Session) Watch() {
+	until := time.Now().Add(watchWindow).UnixNano()
+	if prev := atomic.SwapInt64(&s.watchedUntil, until); prev < time.Now().UnixNano() {
+		// The pane may be polled slowly until its next tick.
+		s.wakeCapture()
+	}
+}
+
+// SetSubscribed records whether a client subscribes to the session output.
+// Subscribed sessions are polled at the full rate until unsubscribed.
+func (s *Session) SetSubscribed(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	if prev := atomic.SwapInt32(&s.subscribed, v); on && prev == 0 {
+		s.wakeCapture()
+	}
+}
+
+func (s *Session) watched() bool {
+	return atomic.LoadInt32(&s.subscribed) == 1 ||
+		time.Now().UnixNano() < atomic.LoadInt64(&s.watchedUntil)
 }
 
 func (s *Session) isAlive() bool {
 	return s.livenessStatus() != sessionLivenessGone
 }
 
+// livenessStatus reports whether the pane process is still running. A pane
+// kept after its process exited counts as gone; its exit status and any
+// agent exit reported by the launch wrapper are recorded on the way.
 func (s *Session) livenessStatus() sessionLiveness {
-	out, err := exec.Command("tmux", "has-session", "-t", s.tmuxName).CombinedOutput()
-	if err == nil {
-		return sessionLivenessAlive
-	}
-	return sessionLivenessFromHasSessionOutput(out)
-}
-
-func sessionLivenessFromHasSessionOutput(out []byte) sessionLiveness {
-	lowOut := strings.ToLower(string(out))
-	switch {
-	case strings.Contains(lowOut, "can't find session"):
-		return sessionLivenessGone
-	case strings.Contains(lowOut, "no server running"):
-		return sessionLivenessGone
-	case strings.Contains(lowOut, "failed to connect to server"),
-		strings.Contains(lowOut, "connection refused"),
-		strings.Contains(lowOut, "no such file or directory"):
-		return sessionLivenessUnknown
-	default:
+	if s.restarting.Load() {
 		return sessionLivenessUnknown
 	}
+	st, liveness := s.backend.status()
+	s.recordPaneStatus(st)
+	return liveness
 }
 
 // Summary returns lightweight session metadata.
 func (s *Session) Summary() Summary {
 	nanos := atomic.LoadInt64(&s.lastActivityAt)
+	var pollInterval time.Duration
+	if s.backend != nil {
+		pollInterval = s.backend.pollInterval()
+	}
 	return Summary{
 		SessionID:      s.opts.SessionID,
 		Name:           s.opts.Name,
+		Agent:          s.currentAgent().agent,
+		Workdir:        s.opts.Workdir,
+		CreatedAt:      s.createdAt,
 		LastActivityAt: time.Unix(0, nanos),
+		Recording:      s.record,
+		PollInterval:   pollInterval,
+		Backend:        s.Backend(),
 	}
 }
 
+// InstructionFiles reports the instruction files written when the session started.
+func (s *Session) InstructionFiles() []InstructionFile {
+	s.agentMu.RLock()
+	defer s.agentMu.RUnlock()
+	return append([]InstructionFile(nil), s.instructionFiles...)
+}
+
+// Ack records seq as the last output frame the client received.
+func (s *Session) Ack(seq uint64) {
+	s.replay.ack(seq)
+}
+
+// Replay returns the output frames emitted after the last acknowledged seq.
+// It returns ErrReplayNoAck or ErrReplayOverrun when the client stream cannot
+// be resumed from the buffer and needs a snapshot instead.
+func (s *Session) Replay() (Replay, error) {
+	return s.replay.replay()
+}
+
+// Frames returns the buffered output frames from seq from on. Frames can be
+// dropped from the shared output channel when it is full, by the output of
+// any session; a stream that skipped seqs gets them here. ok is false when
+// they are no longer buffered and the stream needs a snapshot instead.
+func (s *Session) Frames(from uint64) ([]OutputChunk, bool) {
+	return s.replay.since(from)
+}
+
+// NextSeq returns the seq the next output frame will carry.
+func (s *Session) NextSeq() uint64 {
+	return atomic.LoadUint64(&s.seq)
+}
+
 // hostEnv returns the current process environment as a slice of "KEY=VALUE" strings.
 func hostEnv() []string {
 	return os.Environ()
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -67,7 +68,7 @@ func TestManagerWatchSessionCallsExitHookOnce(t *testing.T) {
 	m.livenessStatus = func(_ *Session) sessionLiveness { return sessionLivenessGone }
 
 	calls := make(chan string, 1)
-	m.SetOnSessionExit(func(sessionID string) {
+	m.SetOnSessionExit(func(sessionID string, _ ExitStatus) {
 		calls <- sessionID
 	})
 
@@ -170,10 +171,13 @@ func TestManagerRecoverAddsDiscoveredSessions(t *testing.T) {
 	m := NewManager(5)
 	m.checkInterval = time.Hour
 	m.isAlive = func(_ *Session) bool { return true }
-	m.listRecoverableSessionIDs = func() ([]string, error) {
+	m.listRecoverableSessionIDs = func(server TmuxServer) ([]string, error) {
+		if server.legacy() {
+			return nil, nil
+		}
 		return []string{"ses-a", "ses-b"}, nil
 	}
-	m.newRecoveredSession = func(sessionID string, _ chan OutputChunk) *Session {
+	m.newRecoveredSession = func(_ TmuxServer, sessionID string, _ chan OutputChunk, _ CaptureMode) *Session {
 		return &Session{opts: Options{SessionID: sessionID}}
 	}
 
@@ -195,10 +199,13 @@ func TestManagerRecoverIgnoresCreateLimit(t *testing.T) {
 	m.checkInterval = time.Hour
 	m.isAlive = func(_ *Session) bool { return true }
 	m.sessions["existing"] = &Session{}
-	m.listRecoverableSessionIDs = func() ([]string, error) {
+	m.listRecoverableSessionIDs = func(server TmuxServer) ([]string, error) {
+		if server.legacy() {
+			return nil, nil
+		}
 		return []string{"ses-a", "ses-b"}, nil
 	}
-	m.newRecoveredSession = func(sessionID string, _ chan OutputChunk) *Session {
+	m.newRecoveredSession = func(_ TmuxServer, sessionID string, _ chan OutputChunk, _ CaptureMode) *Session {
 		return &Session{opts: Options{SessionID: sessionID}}
 	}
 
@@ -215,7 +222,75 @@ func TestManagerRecoverIgnoresCreateLimit(t *testing.T) {
 	}
 }
 
-func TestBuildEnvIncludesHostAndSessionVars(t *testing.T) {
+func TestManagerRecoverMigratesLegacySessions(t *testing.T) {
+	m := NewManager(5)
+	m.checkInterval = time.Hour
+	m.isAlive = func(_ *Session) bool { return true }
+	m.SetTmuxServer(TmuxServer{Socket: "chatcode-test"})
+	m.listRecoverableSessionIDs = func(server TmuxServer) ([]string, error) {
+		if server.legacy() {
+			return []string{"ses-old", "ses-both"}, nil
+		}
+		return []string{"ses-new", "ses-both"}, nil
+	}
+	servers := make(map[string]TmuxServer)
+	m.newRecoveredSession = func(server TmuxServer, sessionID string, _ chan OutputChunk, _ CaptureMode) *Session {
+		servers[sessionID] = server
+		return &Session{opts: Options{SessionID: sessionID}}
+	}
+
+	recovered, err := m.Recover(make(chan OutputChunk, 8))
+	if err != nil {
+		t.Fatalf("Recover returned error: %v", err)
+	}
+	if len(recovered) != 3 {
+		t.Fatalf("recovered = %v, want 3 sessions", recovered)
+	}
+	if servers["ses-new"].Socket != "chatcode-test" || servers["ses-both"].Socket != "chatcode-test" {
+		t.Fatalf("gateway server sessions recovered from %+v", servers)
+	}
+	if !servers["ses-old"].legacy() {
+		t.Fatalf("legacy session recovered from %+v", servers["ses-old"])
+	}
+}
+
+func TestManagerRecoverScansEachServer(t *testing.T) {
+	m := NewManager(5)
+	m.checkInterval = time.Hour
+	m.isAlive = func(_ *Session) bool { return true }
+	m.SetTmuxServer(TmuxServer{Socket: "chatcode-test"})
+	m.listRecoverableSessionIDs = func(server TmuxServer) ([]string, error) {
+		if server.legacy() {
+			return []string{"ses-old"}, nil
+		}
+		return nil, errors.New("list tmux sessions: broken")
+	}
+	m.newRecoveredSession = func(_ TmuxServer, sessionID string, _ chan OutputChunk, _ CaptureMode) *Session {
+		return &Session{opts: Options{SessionID: sessionID}}
+	}
+
+	recovered, err := m.Recover(make(chan OutputChunk, 8))
+	if err == nil {
+		t.Fatal("expected the listing error to be returned")
+	}
+	if len(recovered) != 1 || m.Get("ses-old") == nil {
+		t.Fatalf("recovered = %v, want the legacy session", recovered)
+	}
+}
+
+func TestListRecoverableSessionIDsMissingSocket(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+	// A named server that was never started has no socket yet.
+	server := TmuxServer{Socket: filepath.Join(t.TempDir(), "chatcode")}
+	ids, err := listRecoverableSessionIDs(server)
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("listRecoverableSessionIDs = %v, %v; want no sessions", ids, err)
+	}
+}
+
+func TestLaunchEnvIncludesHostAndSessionVars(t *testing.T) {
 	t.Setenv("VIBECODE_TEST_ENV", "from-host")
 
 	s := &Session{
@@ -226,7 +301,7 @@ func TestBuildEnvIncludesHostAndSessionVars(t *testing.T) {
 		},
 	}
 
-	env := s.buildEnv()
+	env := s.launch(agentLaunch{}, false).env
 	if !containsEnv(env, "VIBECODE_TEST_ENV=from-host") {
 		t.Fatal("expected host env variable to be inherited")
 	}
@@ -246,10 +321,11 @@ func TestBuildTmuxNewSessionCmdSetsTERMInEnv(t *testing.T) {
 			Workdir:   "/tmp",
 			Agent:     "claude-code",
 		},
-		tmuxName: "vibe-ses-test",
 	}
+	b := &tmuxBackend{name: "vibe-ses-test"}
+	s.backend = b
 
-	cmd := s.buildTmuxNewSessionCmd()
+	cmd := b.newSessionCmd(s.launch(agentLaunch{agent: s.opts.Agent}, false))
 	if len(cmd.Args) < 2 {
 		t.Fatalf("unexpected tmux args: %v", cmd.Args)
 	}
@@ -295,6 +371,63 @@ func TestSetDefaultTerminalArgs(t *testing.T) {
 	}
 }
 
+func TestTmuxServerArgs(t *testing.T) {
+	tests := []struct {
+		server TmuxServer
+		want   []string
+	}{
+		{TmuxServer{}, []string{"list-sessions"}},
+		{TmuxServer{Socket: "chatcode"}, []string{"-L", "chatcode", "-f", os.DevNull, "list-sessions"}},
+		{TmuxServer{Socket: "/run/chatcode/tmux", ConfigFile: "/data/tmux.conf"}, []string{"-S", "/run/chatcode/tmux", "-f", "/data/tmux.conf", "list-sessions"}},
+	}
+	for _, tt := range tests {
+		if got := tt.server.args([]string{"list-sessions"}); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%+v args = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
+
+func TestGatewayTmuxServerUsesManagedConfig(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "tmux.conf")
+	if err := WriteTmuxConfig(config); err != nil {
+		t.Fatalf("WriteTmuxConfig: %v", err)
+	}
+	server := TmuxServer{Socket: filepath.Join(dir, "sock"), ConfigFile: config}
+	defer server.command("kill-server").Run()
+
+	m := NewManager(5)
+	m.SetTmuxServer(server)
+	s, err := m.Create(Options{
+		SessionID: "ses-server-" + time.Now().Format("150405"),
+		Name:      "server",
+		Workdir:   dir,
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 64),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+
+	out, err := server.command("show-options", "-g", "-v", "status").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "off" {
+		t.Fatalf("status = %q, %v; want off", out, err)
+	}
+	ids, err := listRecoverableSessionIDs(server)
+	if err != nil || len(ids) != 1 || ids[0] != s.opts.SessionID {
+		t.Fatalf("gateway server sessions = %v, %v", ids, err)
+	}
+	legacyIDs, _ := listRecoverableSessionIDs(legacyTmux)
+	if containsString(legacyIDs, s.opts.SessionID) {
+		t.Fatal("session started on the default tmux server")
+	}
+}
+
 func TestLiteralSendKeysArgs(t *testing.T) {
 	args := literalSendKeysArgs("vibe-ses-test", "--dangerously-skip-permissions")
 	want := []string{"send-keys", "-t", "vibe-ses-test", "-l", "--", "--dangerously-skip-permissions"}
@@ -489,7 +622,7 @@ func TestSessionInputAcceptsLeadingDoubleDashPaste(t *testing.T) {
 
 	time.Sleep(200 * time.Millisecond)
 
-	content, _, _, _, _, _, _, err := s.Snapshot()
+	content, _, _, _, _, _, _, _, err := s.Snapshot()
 	if err != nil {
 		t.Fatalf("Snapshot: %v", err)
 	}
@@ -522,7 +655,7 @@ func TestSnapshot(t *testing.T) {
 	s.Input([]byte("echo snap_test\n"))
 	time.Sleep(500 * time.Millisecond)
 
-	content, cols, rows, cursorX, cursorY, cursorVisible, _, err := s.Snapshot()
+	content, cols, rows, cursorX, cursorY, cursorVisible, _, _, err := s.Snapshot()
 	if err != nil {
 		t.Fatalf("Snapshot: %v", err)
 	}
@@ -571,7 +704,7 @@ func TestSnapshotIncludesScrollbackAndANSI(t *testing.T) {
 	}
 	time.Sleep(1200 * time.Millisecond)
 
-	content, _, _, _, _, _, _, err := s.Snapshot()
+	content, _, _, _, _, _, _, _, err := s.Snapshot()
 	if err != nil {
 		t.Fatalf("Snapshot: %v", err)
 	}
@@ -0,0 +1,282 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// streamBatchInterval bounds how long the stream capturer coalesces pane
+// bytes into one frame after the first byte arrives.
+const (
+	streamBatchInterval = 10 * time.Millisecond
+	streamOpenTimeout   = 2 * time.Second
+)
+
+// CaptureMode selects how pane output is captured.
+type CaptureMode string
+
+const (
+	// CapturePoll diffs capture-pane screens on a ticker.
+	CapturePoll CaptureMode = "poll"
+	// CaptureStream forwards the raw pane byte stream via tmux pipe-pane,
+	// falling back to CapturePoll if the pipe cannot be set up or breaks.
+	CaptureStream CaptureMode = "stream"
+
+	DefaultCaptureMode = CapturePoll
+)
+
+// ParseCaptureMode validates a capture mode name. Empty selects the default.
+func ParseCaptureMode(v string) (CaptureMode, error) {
+	switch m := CaptureMode(strings.ToLower(strings.TrimSpace(v))); m {
+	case "":
+		return DefaultCaptureMode, nil
+	case CapturePoll, CaptureStream:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown capture mode %q", v)
+	}
+}
+
+// capturer feeds pane output of one session into its OutputChunk channel.
+type capturer interface {
+	stop()
+	// wake tells the capturer the pane is about to change, after input.
+	wake()
+	// pollInterval returns how often the pane is polled, or 0 when the
+	// capturer does not poll.
+	pollInterval() time.Duration
+	// snapshot captures the pane for Snapshot while no output is sent.
+	snapshot() (paneSnapshot, error)
+}
+
+// streamCapturer forwards every byte the pane program writes, including
+// output that scrolls past between polls and sequences tmux never re-renders
+// (OSC 52, bells, title changes). tmux pipe-pane writes the pane output into
+// a FIFO that this capturer reads.
+type streamCapturer struct {
+	tmuxName  string
+	tmux      TmuxServer
+	sessionID string
+	seq       *uint64
+	lastAct   *int64
+	outCh     chan OutputChunk
+	replay    *replayBuffer
+	recorder  *recorderSlot
+
+	// onBroken is called from the read loop when the pipe closes while the
+	// capturer is still running (pipe command killed, pipe-pane toggled).
+	onBroken func()
+
+	dir      string
+	fifoPath string
+
+	mu      sync.Mutex
+	file    *os.File
+	stopped bool
+
+	// emitMu is held while read output is sent, so a snapshot falls between
+	// two batches.
+	emitMu sync.Mutex
+}
+
+func newStreamCapturer(
+	tmuxName, sessionID string,
+	seq *uint64, lastAct *int64,
+	outCh chan OutputChunk,
+	replay *replayBuffer,
+	recorder *recorderSlot,
+) *streamCapturer {
+	return &streamCapturer{
+		tmuxName:  tmuxName,
+		sessionID: sessionID,
+		seq:       seq,
+		lastAct:   lastAct,
+		outCh:     outCh,
+		replay:    replay,
+		recorder:  recorder,
+	}
+}
+
+func (c *streamCapturer) start() error {
+	dir, err := os.MkdirTemp("", "chatcode-pipe-")
+	if err != nil {
+		return fmt.Errorf("stream capture dir: %w", err)
+	}
+	c.dir = dir
+	c.fifoPath = filepath.Join(dir, "output")
+	if err := syscall.Mkfifo(c.fifoPath, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("mkfifo: %w", err)
+	}
+
+	// Opening the read side blocks until pipe-pane's command opens the FIFO
+	// for writing, so it runs alongside the tmux call.
+	opened := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(c.fifoPath, os.O_RDONLY, 0)
+		if err == nil {
+			c.mu.Lock()
+			if c.stopped {
+				f.Close()
+				err = errors.New("stream capturer stopped")
+			} else {
+				c.file = f
+			}
+			c.mu.Unlock()
+		}
+		opened <- err
+	}()
+
+	if out, err := c.tmux.command(pipePaneArgs(c.tmuxName, c.fifoPath)...).CombinedOutput(); err != nil {
+		c.stop()
+		<-opened
+		return fmt.Errorf("tmux pipe-pane: %w: %s", err, out)
+	}
+	select {
+	case err := <-opened:
+		if err != nil {
+			c.stop()
+			return fmt.Errorf("open stream fifo: %w", err)
+		}
+	case <-time.After(streamOpenTimeout):
+		c.stop()
+		<-opened
+		return fmt.Errorf("open stream fifo: pipe command did not connect within %s", streamOpenTimeout)
+	}
+
+	go c.readLoop(c.file)
+	return nil
+}
+
+// wake is a no-op: the stream carries output as it is written.
+func (c *streamCapturer) wake() {}
+
+func (c *streamCapturer) pollInterval() time.Duration { return 0 }
+
+func (c *streamCapturer) stop() {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return
+	}
+	c.stopped = true
+	f := c.file
+	c.mu.Unlock()
+
+	// Closing the pipe (pipe-pane without a command) ends the writer; the
+	// session may already be gone, so errors are ignored.
+	_ = c.tmux.command("pipe-pane", "-t", c.tmuxName).Run()
+	if f != nil {
+		f.Close()
+	} else if w, err := os.OpenFile(c.fifoPath, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		// Release a reader still blocked in open.
+		w.Close()
+	}
+	if c.dir != "" {
+		os.RemoveAll(c.dir)
+	}
+}
+
+func (c *streamCapturer) isStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// readLoop forwards pipe bytes until the pipe closes.
+func (c *streamCapturer) readLoop(f *os.File) {
+	_ = readBatches(f, func(b []byte) {
+		c.emitMu.Lock()
+		emitOutput(c.sessionID, c.seq, c.lastAct, c.outCh, c.replay, c.recorder, string(b))
+		c.emitMu.Unlock()
+	})
+	if !c.isStopped() && c.onBroken != nil {
+		c.onBroken()
+	}
+}
+
+// snapshot captures the pane between two batches. Output tmux has written
+// to the pipe but this capturer has not read yet may be in the snapshot and
+// still be sent after it.
+func (c *streamCapturer) snapshot() (paneSnapshot, error) {
+	c.emitMu.Lock()
+	defer c.emitMu.Unlock()
+	snap, err := captureSnapshot(c.tmux, c.tmuxName)
+	snap.nextSeq = atomic.LoadUint64(c.seq)
+	return snap, err
+}
+
+// readBatches reads f until a read fails and returns that error. What was
+// read is passed to emit in batches, coalescing reads for up to
+// streamBatchInterval and never splitting a UTF-8 sequence across batches;
+// emit must not keep the slice.
+func readBatches(f *os.File, emit func([]byte)) error {
+	buf := make([]byte, maxPayload)
+	var pending []byte
+	for {
+		_ = f.SetReadDeadline(time.Time{})
+		n, err := f.Read(buf[:maxPayload-len(pending)])
+		pending = append(pending, buf[:n]...)
+		if err == nil {
+			_ = f.SetReadDeadline(time.Now().Add(streamBatchInterval))
+			for len(pending) < maxPayload {
+				n, err = f.Read(buf[:maxPayload-len(pending)])
+				pending = append(pending, buf[:n]...)
+				if err != nil {
+					break
+				}
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = nil
+			}
+		}
+
+		complete, rest := splitIncompleteUTF8(pending)
+		if len(complete) > 0 {
+			emit(complete)
+		}
+		pending = append(pending[:0], rest...)
+
+		if err != nil {
+			if len(pending) > 0 {
+				emit(pending)
+			}
+			return err
+		}
+	}
+}
+
+func pipePaneArgs(tmuxName, fifoPath string) []string {
+	// -O pipes pane output only; exec keeps no extra shell around.
+	return []string{"pipe-pane", "-O", "-t", tmuxName, "exec cat > " + shellQuote(fifoPath)}
+}
+
+// splitIncompleteUTF8 holds back a truncated multi-byte sequence at the end of
+// b so terminal consumers decoding each frame separately do not see U+FFFD.
+func splitIncompleteUTF8(b []byte) (complete, rest []byte) {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i], b[len(b)-i:]
+			}
+			break
+		}
+	}
+	return b, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
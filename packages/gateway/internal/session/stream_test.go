@@ -0,0 +1,163 @@
+package session
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCaptureMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CaptureMode
+		wantErr bool
+	}{
+		{in: "", want: DefaultCaptureMode},
+		{in: "poll", want: CapturePoll},
+		{in: " Stream ", want: CaptureStream},
+		{in: "control", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCaptureMode(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("ParseCaptureMode(%q) error = nil, want error", tt.in)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Fatalf("ParseCaptureMode(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitIncompleteUTF8(t *testing.T) {
+	euro := []byte("€") // 3 bytes
+	tests := []struct {
+		name         string
+		in           []byte
+		wantComplete string
+		wantRest     []byte
+	}{
+		{name: "ascii", in: []byte("abc"), wantComplete: "abc"},
+		{name: "complete rune", in: append([]byte("a"), euro...), wantComplete: "a€"},
+		{name: "one byte missing", in: append([]byte("a"), euro[:2]...), wantComplete: "a", wantRest: euro[:2]},
+		{name: "lead byte only", in: append([]byte("a"), euro[0]), wantComplete: "a", wantRest: euro[:1]},
+		{name: "invalid continuation", in: []byte{'a', 0x80}, wantComplete: "a\x80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			complete, rest := splitIncompleteUTF8(tt.in)
+			if string(complete) != tt.wantComplete || !bytes.Equal(rest, tt.wantRest) {
+				t.Fatalf("split = %q, %q; want %q, %q", complete, rest, tt.wantComplete, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestPipePaneArgsQuotesFIFOPath(t *testing.T) {
+	args := pipePaneArgs("vibe-ses-1", "/tmp/it's/output")
+	want := []string{"pipe-pane", "-O", "-t", "vibe-ses-1", `exec cat > '/tmp/it'\''s/output'`}
+	if strings.Join(args, "\x00") != strings.Join(want, "\x00") {
+		t.Fatalf("pipePaneArgs = %q, want %q", args, want)
+	}
+}
+
+func TestStreamCaptureDeliversScrolledOutputAndBell(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	m.SetCaptureMode(CaptureStream)
+	outCh := make(chan OutputChunk, 256)
+	s, err := m.Create(Options{
+		SessionID: "stream-" + time.Now().Format("150405"),
+		Name:      "stream",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  outCh,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	if b := s.backend.(*tmuxBackend); b.capturer == nil {
+		t.Fatal("no capturer")
+	} else if _, ok := b.capturer.(*streamCapturer); !ok {
+		t.Fatalf("capturer = %T, want *streamCapturer", b.capturer)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	// Far more lines than the pane shows: a screen-diff capturer would lose
+	// the ones that scrolled past between polls.
+	if err := s.Input([]byte("seq 1 400; printf 'bell\\a'; echo stream_$((1+1))_done\n")); err != nil {
+		t.Fatalf("Input: %v", err)
+	}
+
+	var got bytes.Buffer
+	var lastSeq uint64
+	deadline := time.After(5 * time.Second)
+	for !strings.Contains(got.String(), "stream_2_done\r\n") {
+		select {
+		case chunk := <-outCh:
+			if got.Len() > 0 && chunk.Seq != lastSeq+1 {
+				t.Fatalf("seq gap: %d after %d", chunk.Seq, lastSeq)
+			}
+			lastSeq = chunk.Seq
+			got.Write(chunk.Data)
+		case <-deadline:
+			t.Fatalf("timed out waiting for stream output, got:\n%s", got.String())
+		}
+	}
+	var want strings.Builder
+	for i := 1; i <= 400; i++ {
+		want.WriteString(strconv.Itoa(i) + "\r\n")
+	}
+	if !strings.Contains(got.String(), want.String()) {
+		t.Fatalf("expected every line of seq output in the stream, got:\n%q", got.String())
+	}
+	if !strings.Contains(got.String(), "bell\a") {
+		t.Fatal("expected BEL to be forwarded unchanged")
+	}
+}
+
+func TestStreamCaptureFallsBackToPollWhenPipeCloses(t *testing.T) {
+	if !hasTmux() {
+		t.Skip("tmux not available")
+	}
+
+	m := NewManager(5)
+	m.SetCaptureMode(CaptureStream)
+	s, err := m.Create(Options{
+		SessionID: "fallback-" + time.Now().Format("150405"),
+		Name:      "fallback",
+		Workdir:   t.TempDir(),
+		Agent:     "none",
+		OutputCh:  make(chan OutputChunk, 256),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer m.End(s.opts.SessionID)
+	b := s.backend.(*tmuxBackend)
+
+	// Closing the pipe behind the gateway's back breaks the stream.
+	if out, err := b.server.command("pipe-pane", "-t", b.name).CombinedOutput(); err != nil {
+		t.Fatalf("tmux pipe-pane: %v: %s", err, out)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		b.captureMu.Lock()
+		_, polling := b.capturer.(*outputCapturer)
+		b.captureMu.Unlock()
+		if polling {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("expected capture to fall back to polling after the pipe closed")
+}
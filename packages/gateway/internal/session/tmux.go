@@ -0,0 +1,465 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tmuxHistoryLimitLines = 200000
+	preferredTmuxTerminal = "tmux-256color"
+	fallbackTmuxTerminal  = "screen-256color"
+	legacyTmuxTerminal    = "screen"
+)
+
+var (
+	detectedTmuxTerminal string
+	detectTmuxTermOnce   sync.Once
+)
+
+// tmuxExecs counts the tmux commands run by the gateway.
+var tmuxExecs atomic.Uint64
+
+// TmuxExecs returns the number of tmux commands run since the gateway
+// started. Each spawns a tmux client process, the main CPU cost of idle
+// sessions.
+func TmuxExecs() uint64 {
+	return tmuxExecs.Load()
+}
+
+// DefaultTmuxSocket is the socket name of the gateway's own tmux server.
+const DefaultTmuxSocket = "chatcode"
+
+// TmuxServer addresses a tmux server. The gateway runs its sessions on a
+// server of its own, so a user's tmux kill-server or ~/.tmux.conf does not
+// reach them. The zero TmuxServer is the user's default server, where older
+// gateways ran their sessions.
+type TmuxServer struct {
+	// Socket is a socket name in the tmux socket directory (-L) or, when it
+	// contains a slash, a socket path (-S).
+	Socket string
+	// ConfigFile is loaded when the server starts, in place of
+	// ~/.tmux.conf. Empty loads no config on a gateway socket.
+	ConfigFile string
+}
+
+// legacyTmux is the server gateways ran their sessions on before they had
+// their own.
+var legacyTmux = TmuxServer{}
+
+func (sv TmuxServer) legacy() bool {
+	return sv.Socket == ""
+}
+
+// args prefixes a tmux command with the flags selecting the server.
+func (sv TmuxServer) args(args []string) []string {
+	if sv.legacy() {
+		return args
+	}
+	flag := "-L"
+	if strings.Contains(sv.Socket, "/") {
+		flag = "-S"
+	}
+	config := sv.ConfigFile
+	if config == "" {
+		config = os.DevNull
+	}
+	return append([]string{flag, sv.Socket, "-f", config}, args...)
+}
+
+func (sv TmuxServer) command(args ...string) *exec.Cmd {
+	tmuxExecs.Add(1)
+	return exec.Command("tmux", sv.args(args)...)
+}
+
+func (sv TmuxServer) commandContext(ctx context.Context, args ...string) *exec.Cmd {
+	tmuxExecs.Add(1)
+	return exec.CommandContext(ctx, "tmux", sv.args(args)...)
+}
+
+// WriteTmuxConfig writes the config of the gateway's tmux server to path.
+// It is rewritten on every start, so edits do not survive.
+func WriteTmuxConfig(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create tmux config dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(tmuxConfig(detectTmuxDefaultTerminal())), 0o600); err != nil {
+		return fmt.Errorf("write tmux config: %w", err)
+	}
+	return nil
+}
+
+// tmuxConfig returns the config of the gateway's tmux server. Options older
+// tmux versions lack are set with -q, which ignores them.
+func tmuxConfig(term string) string {
+	return fmt.Sprintf(`# Written by the chatcode gateway on every start; edits are lost.
+set-option -g history-limit %d
+set-option -g default-terminal %s
+set-option -g status off
+set-option -g mouse on
+set-option -asq terminal-features ",xterm*:RGB:clipboard"
+set-option -asq terminal-overrides ",xterm*:Tc"
+`, tmuxHistoryLimitLines, term)
+}
+
+// tmuxBackend runs a session in its own tmux session, named after the
+// session ID so a restarted gateway can find it again.
+type tmuxBackend struct {
+	name        string
+	server      TmuxServer
+	out         paneOutput
+	captureMode CaptureMode
+
+	captureMu      sync.Mutex
+	capturer       capturer
+	captureStopped bool
+}
+
+func newTmuxBackend(server TmuxServer, out paneOutput, captureMode CaptureMode) *tmuxBackend {
+	return &tmuxBackend{
+		name:        "vibe-" + out.sessionID,
+		server:      server,
+		out:         out,
+		captureMode: captureMode,
+	}
+}
+
+func (b *tmuxBackend) start(l launch) error {
+	cmd := b.newSessionCmd(l)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux new-session: %w: %s", err, out)
+	}
+	if err := b.setup(l); err != nil {
+		// A half set up session would be recovered after a gateway restart
+		// although Create failed, so it does not outlive the error.
+		_ = b.destroy()
+		return err
+	}
+	b.startCapture()
+	return nil
+}
+
+// setup configures the tmux session new-session created.
+func (b *tmuxBackend) setup(l launch) error {
+	if err := b.ensureHistoryLimit(); err != nil {
+		return err
+	}
+	if err := b.ensureDefaultTerminal(); err != nil {
+		return err
+	}
+	if err := b.ensureRemainOnExit(); err != nil {
+		return err
+	}
+	return b.persistMetadata(l.meta)
+}
+
+// newSessionCmd returns the exec.Cmd to start the tmux session.
+func (b *tmuxBackend) newSessionCmd(l launch) *exec.Cmd {
+	args := []string{
+		"new-session",
+		"-d",         // detached
+		"-s", b.name, // session name
+		"-c", l.workdir, // start dir
+	}
+	// Session variables only go to this session's pane with -e. The client
+	// runs without them: when this call starts the tmux server, the client
+	// environment becomes the global environment of all sessions.
+	for _, name := range sortedKeys(l.explicit) {
+		args = append(args, "-e", name+"="+l.explicit[name])
+	}
+	// A pane inherits the environment of a long-running tmux server rather
+	// than cmd.Env, so the filtered variables are unset explicitly.
+	args = append(args, "--", "sh", "-c", l.paneCommand())
+	cmd := b.server.command(args...)
+	cmd.Env = append(l.clientEnv, "TERM="+detectTmuxDefaultTerminal())
+	return cmd
+}
+
+func (b *tmuxBackend) agentExitReport() string {
+	return fmt.Sprintf(`tmux set-option -q -t "$TMUX_PANE" %s "$ec" >/dev/null 2>&1`, agentExitOption)
+}
+
+// startCapture starts the configured capture mode. Stream capture falls back
+// to polling when the pipe cannot be set up.
+func (b *tmuxBackend) startCapture() {
+	b.captureMu.Lock()
+	defer b.captureMu.Unlock()
+
+	if b.captureMode == CaptureStream {
+		o := b.out
+		sc := newStreamCapturer(b.name, o.sessionID, o.seq, o.lastAct, o.ch, o.replay, o.recorder)
+		sc.tmux = b.server
+		sc.onBroken = func() { b.fallbackToPoll(sc) }
+		if err := sc.start(); err == nil {
+			b.capturer = sc
+			return
+		}
+	}
+	b.startPollCaptureLocked()
+}
+
+// fallbackToPoll replaces a broken stream capturer with the polling one while
+// the pane is still alive.
+func (b *tmuxBackend) fallbackToPoll(from capturer) {
+	b.captureMu.Lock()
+	defer b.captureMu.Unlock()
+
+	if b.captureStopped || b.capturer != from {
+		return
+	}
+	from.stop()
+	if _, l := b.status(); l == sessionLivenessGone {
+		return
+	}
+	b.startPollCaptureLocked()
+}
+
+func (b *tmuxBackend) startPollCaptureLocked() {
+	o := b.out
+	c := newOutputCapturer(b.name, o.sessionID, o.seq, o.lastAct, o.ch, o.replay, o.recorder)
+	c.tmux = b.server
+	c.watched = o.watched
+	c.start()
+	b.capturer = c
+}
+
+func (b *tmuxBackend) stop() {
+	b.captureMu.Lock()
+	defer b.captureMu.Unlock()
+
+	b.captureStopped = true
+	if b.capturer != nil {
+		b.capturer.stop()
+	}
+}
+
+func (b *tmuxBackend) wake() {
+	b.captureMu.Lock()
+	c := b.capturer
+	b.captureMu.Unlock()
+	if c != nil {
+		c.wake()
+	}
+}
+
+func (b *tmuxBackend) pollInterval() time.Duration {
+	b.captureMu.Lock()
+	defer b.captureMu.Unlock()
+	if b.capturer == nil {
+		return 0
+	}
+	return b.capturer.pollInterval()
+}
+
+// destroy kills the tmux session. remain-on-exit keeps it after its pane
+// process is gone, until the exit status has been observed.
+func (b *tmuxBackend) destroy() error {
+	cmd := b.server.command("kill-session", "-t", b.name)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if _, l := b.status(); l != sessionLivenessAlive {
+			return nil
+		}
+		return fmt.Errorf("tmux kill-session: %w: %s", err, out)
+	}
+	return nil
+}
+
+// writeInput sends data to the pane as literal keys, split into chunks tmux
+// accepts.
+func (b *tmuxBackend) writeInput(data []byte) error {
+	for len(data) > 0 {
+		n := inputChunkLen(data, maxInputWriteBytes)
+		cmd := b.server.command(literalSendKeysArgs(b.name, string(data[:n]))...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("tmux send-keys: %w: %s", err, out)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (b *tmuxBackend) sendKeys(keys []string) error {
+	cmd := b.server.command(namedKeysArgs(b.name, keys)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux send-keys: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (b *tmuxBackend) resize(cols, rows int) error {
+	cmd := b.server.command("resize-window", "-t", b.name,
+		"-x", fmt.Sprintf("%d", cols),
+		"-y", fmt.Sprintf("%d", rows))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux resize-window: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (b *tmuxBackend) snapshot() (paneSnapshot, error) {
+	b.captureMu.Lock()
+	c := b.capturer
+	b.captureMu.Unlock()
+	if c != nil {
+		return c.snapshot()
+	}
+	snap, err := captureSnapshot(b.server, b.name)
+	snap.nextSeq = atomic.LoadUint64(b.out.seq)
+	return snap, err
+}
+
+func captureSnapshot(tmux TmuxServer, tmuxName string) (paneSnapshot, error) {
+	// Capture recent pane history with ANSI escapes to preserve color output.
+	out, err := tmux.command(snapshotCaptureArgs(tmuxName)...).Output()
+	if err != nil {
+		return paneSnapshot{}, fmt.Errorf("capture-pane: %w", err)
+	}
+
+	// Get dimensions and cursor position in one tmux call.
+	var state string
+	if stateOut, err := tmux.command(snapshotStateArgs(tmuxName)...).Output(); err == nil {
+		state = string(stateOut)
+	}
+	return newPaneSnapshot(string(out), state), nil
+}
+
+// newPaneSnapshot returns the snapshot of the output of snapshotCaptureArgs
+// and snapshotStateArgs. Without a state line the geometry is a default and
+// the cursor unknown.
+func newPaneSnapshot(content, state string) paneSnapshot {
+	snap := paneSnapshot{cols: 80, rows: 24, cursorX: -1, cursorY: -1, cursorFlag: -1}
+	if state != "" {
+		var alternateInt int
+		fmt.Sscanf(state, "%d %d %d %d %d %d", &snap.cols, &snap.rows, &snap.cursorX, &snap.cursorY, &snap.cursorFlag, &alternateInt)
+		snap.alternateOn = alternateInt == 1
+	}
+	snap.content = stripOSC8Hyperlinks(content)
+	return snap
+}
+
+// status reads the pane status. A pane kept by remain-on-exit is dead.
+func (b *tmuxBackend) status() (paneStatus, sessionLiveness) {
+	out, err := b.server.command(paneStatusArgs(b.name)...).CombinedOutput()
+	if err != nil {
+		return paneStatus{}, sessionLivenessFromHasSessionOutput(out)
+	}
+	st, err := parsePaneStatusOutput(string(out))
+	if err != nil {
+		return paneStatus{}, sessionLivenessUnknown
+	}
+	if st.dead {
+		return st, sessionLivenessGone
+	}
+	return st, sessionLivenessAlive
+}
+
+// tmuxNoServer reports whether tmux failed because no server is running on
+// its socket. Before the first session the socket of a named server does not
+// exist yet.
+func tmuxNoServer(out []byte) bool {
+	lowOut := strings.ToLower(string(out))
+	return strings.Contains(lowOut, "no server running") ||
+		strings.Contains(lowOut, "failed to connect to server") ||
+		(strings.Contains(lowOut, "error connecting to") && strings.Contains(lowOut, "no such file or directory"))
+}
+
+func sessionLivenessFromHasSessionOutput(out []byte) sessionLiveness {
+	lowOut := strings.ToLower(string(out))
+	switch {
+	case strings.Contains(lowOut, "can't find session"),
+		strings.Contains(lowOut, "can't find window"):
+		return sessionLivenessGone
+	case strings.Contains(lowOut, "no server running"):
+		return sessionLivenessGone
+	case strings.Contains(lowOut, "failed to connect to server"),
+		strings.Contains(lowOut, "connection refused"),
+		strings.Contains(lowOut, "no such file or directory"):
+		return sessionLivenessUnknown
+	default:
+		return sessionLivenessUnknown
+	}
+}
+
+func (b *tmuxBackend) ensureHistoryLimit() error {
+	cmd := b.server.command(setHistoryLimitArgs(b.name)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux set history-limit: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (b *tmuxBackend) ensureDefaultTerminal() error {
+	cmd := b.server.command(setDefaultTerminalArgs(detectTmuxDefaultTerminal())...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux set default-terminal: %w: %s", err, out)
+	}
+	return nil
+}
+
+func setHistoryLimitArgs(tmuxName string) []string {
+	return []string{"set-option", "-t", tmuxName, "history-limit", fmt.Sprintf("%d", tmuxHistoryLimitLines)}
+}
+
+func setDefaultTerminalArgs(term string) []string {
+	return []string{"set-option", "-g", "default-terminal", term}
+}
+
+func literalSendKeysArgs(tmuxName, text string) []string {
+	// `--` ensures leading pasted text like `--dangerously-skip-permissions`
+	// is treated as literal input rather than tmux flags.
+	return []string{"send-keys", "-t", tmuxName, "-l", "--", text}
+}
+
+func detectTmuxDefaultTerminal() string {
+	detectTmuxTermOnce.Do(func() {
+		infocmpPath, err := exec.LookPath("infocmp")
+		if err != nil {
+			// If we cannot probe terminfo availability, use the most compatible
+			// term name to avoid unknown-terminal failures in ncurses apps.
+			detectedTmuxTerminal = legacyTmuxTerminal
+			return
+		}
+		detectedTmuxTerminal = selectTmuxDefaultTerminal(func(term string) bool {
+			return exec.Command(infocmpPath, term).Run() == nil
+		}, true)
+	})
+	return detectedTmuxTerminal
+}
+
+func selectTmuxDefaultTerminal(termExists func(string) bool, canProbe bool) string {
+	if !canProbe {
+		return legacyTmuxTerminal
+	}
+	candidates := []string{preferredTmuxTerminal, fallbackTmuxTerminal, legacyTmuxTerminal}
+	for _, term := range candidates {
+		if termExists(term) {
+			return term
+		}
+	}
+	return legacyTmuxTerminal
+}
+
+func snapshotStateArgs(tmuxName string) []string {
+	return []string{
+		"display-message", "-t", tmuxName, "-p",
+		"#{window_width} #{window_height} #{cursor_x} #{cursor_y} #{cursor_flag} #{alternate_on}",
+	}
+}
+
+func snapshotCaptureArgs(tmuxName string) []string {
+	return []string{
+		"capture-pane",
+		"-e",
+		"-N",
+		"-S", fmt.Sprintf("-%d", snapshotHistoryLines),
+		"-t", tmuxName,
+		"-p",
+	}
+}
@@ -0,0 +1,275 @@
+package vt
+
+import "strconv"
+
+// maxRunGap is the number of unchanged cells Diff rewrites to join two runs
+// of changed cells, instead of moving the cursor past them.
+const maxRunGap = 4
+
+// Blank returns an empty grid of the given size.
+func Blank(cols, rows int) Grid {
+	return New(cols, rows).Grid()
+}
+
+// Diff returns output that turns a terminal showing from into one showing
+// to. Changed cells are rewritten in place, blank row ends are erased and a
+// scroll of the whole screen is sent as line feeds, so scrolled lines reach
+// the terminal's scrollback. The cursor ends at to's position and
+// visibility. When the sizes differ the screen is cleared and to is painted
+// in full, cursor visibility included. Diff does not switch between the
+// main and alternate screen.
+//
+// Blank cells count as equal whatever their foreground color and text
+// attributes, as they look the same.
+func Diff(from, to Grid) []byte {
+	e := encoder{cols: to.Cols, x: -1}
+	lines := from.Lines
+	repaint := from.Cols != to.Cols || from.Rows != to.Rows || len(from.Lines) != to.Rows
+	if repaint {
+		e.buf = append(e.buf, "\x1b[0m\x1b[H\x1b[2J"...)
+		e.pen, e.penKnown = blankCell, true
+		e.x, e.y = 0, 0
+		lines = Blank(to.Cols, to.Rows).Lines
+	} else if k := scrollShift(lines, to.Lines); k > 0 {
+		lines = e.scroll(lines, k)
+	}
+
+	for y, row := range to.Lines {
+		e.diffRow(y, lines[y], row)
+	}
+
+	if e.x < 0 {
+		if from.CursorX != to.CursorX || from.CursorY != to.CursorY {
+			e.moveTo(to.CursorX, to.CursorY)
+		}
+	} else if e.x != to.CursorX || e.y != to.CursorY || e.wrapNext {
+		e.moveTo(to.CursorX, to.CursorY)
+	}
+	if from.CursorVisible != to.CursorVisible || repaint {
+		if to.CursorVisible {
+			e.buf = append(e.buf, "\x1b[?25h"...)
+		} else {
+			e.buf = append(e.buf, "\x1b[?25l"...)
+		}
+	}
+	return e.buf
+}
+
+// encoder tracks the terminal cursor and pen while Diff writes output.
+type encoder struct {
+	buf  []byte
+	cols int
+	// x is -1 until the cursor was first positioned: the terminal may be
+	// waiting to wrap even when the grid's cursor matches.
+	x, y     int
+	wrapNext bool
+	pen      Cell
+	penKnown bool
+}
+
+func (e *encoder) diffRow(y int, from, to []Cell) {
+	// Cells from tail on are blank and are erased rather than written.
+	tail := len(TrimRight(to))
+	for x := 0; x < tail; {
+		if sameCell(from[x], to[x]) {
+			x++
+			continue
+		}
+		start := x
+		if to[start].Char == "" && start > 0 {
+			// Rewrite the wide character this continuation belongs to.
+			start--
+		}
+		last := x
+		for i := x + 1; i < tail && i-last <= maxRunGap; i++ {
+			if !sameCell(from[i], to[i]) {
+				last = i
+			}
+		}
+		end := last + 1
+		if end < len(to) && to[end].Char == "" {
+			end++
+		}
+		e.write(y, start, to[start:end])
+		x = end
+	}
+	for x := tail; x < len(to); x++ {
+		if !sameCell(from[x], to[x]) {
+			if e.x != tail || e.y != y || e.wrapNext {
+				e.moveTo(tail, y)
+			}
+			e.resetPen()
+			e.buf = append(e.buf, "\x1b[K"...)
+			return
+		}
+	}
+}
+
+func (e *encoder) write(y, x int, cells []Cell) {
+	if e.x != x || e.y != y || e.wrapNext {
+		e.moveTo(x, y)
+	}
+	for i, c := range cells {
+		if c.Char == "" {
+			if i > 0 && cells[i-1].Wide {
+				continue
+			}
+			c.Char = " "
+		}
+		// A blank only needs a pen that draws nothing.
+		penBlank := e.penKnown && Cell{Char: " ", BG: e.pen.BG, Attrs: e.pen.Attrs}.IsBlank()
+		if !c.IsBlank() || !penBlank {
+			e.setPen(c)
+		}
+		e.buf = append(e.buf, c.Char...)
+		e.x++
+		if c.Wide {
+			e.x++
+		}
+		if e.x >= e.cols {
+			e.x = e.cols - 1
+			e.wrapNext = true
+		}
+	}
+}
+
+// scroll scrolls the whole screen up by k lines and returns from's lines as
+// the terminal now shows them.
+func (e *encoder) scroll(from [][]Cell, k int) [][]Cell {
+	e.resetPen()
+	e.moveTo(0, len(from)-1)
+	for i := 0; i < k; i++ {
+		e.buf = append(e.buf, '\n')
+	}
+	blank := Blank(e.cols, k).Lines
+	return append(append([][]Cell(nil), from[k:]...), blank...)
+}
+
+func (e *encoder) moveTo(x, y int) {
+	e.buf = append(e.buf, "\x1b["...)
+	e.buf = strconv.AppendInt(e.buf, int64(y+1), 10)
+	if x > 0 {
+		e.buf = append(e.buf, ';')
+		e.buf = strconv.AppendInt(e.buf, int64(x+1), 10)
+	}
+	e.buf = append(e.buf, 'H')
+	e.x, e.y, e.wrapNext = x, y, false
+}
+
+// resetPen makes sure erasing and scrolling fill with the default
+// background.
+func (e *encoder) resetPen() {
+	if e.penKnown && e.pen.BG.Mode == ColorDefault && e.pen.Attrs == 0 && e.pen.FG.Mode == ColorDefault {
+		return
+	}
+	e.buf = append(e.buf, "\x1b[0m"...)
+	e.pen, e.penKnown = blankCell, true
+}
+
+func (e *encoder) setPen(c Cell) {
+	if e.penKnown && e.pen.FG == c.FG && e.pen.BG == c.BG && e.pen.Attrs == c.Attrs {
+		return
+	}
+	e.buf = append(e.buf, "\x1b[0"...)
+	for i, code := range []string{"1", "2", "3", "4", "5", "7", "8", "9"} {
+		if c.Attrs&(1<<i) != 0 {
+			e.buf = append(e.buf, ';')
+			e.buf = append(e.buf, code...)
+		}
+	}
+	e.buf = appendColor(e.buf, c.FG, 30)
+	e.buf = appendColor(e.buf, c.BG, 40)
+	e.buf = append(e.buf, 'm')
+	e.pen, e.penKnown = c, true
+}
+
+// appendColor appends the SGR parameters of c, with base 30 for the
+// foreground or 40 for the background.
+func appendColor(buf []byte, c Color, base int) []byte {
+	switch {
+	case c.Mode == ColorIndexed && c.Value < 8:
+		buf = append(buf, ';')
+		buf = strconv.AppendInt(buf, int64(base+int(c.Value)), 10)
+	case c.Mode == ColorIndexed && c.Value < 16:
+		buf = append(buf, ';')
+		buf = strconv.AppendInt(buf, int64(base+60+int(c.Value)-8), 10)
+	case c.Mode == ColorIndexed:
+		buf = append(buf, ';')
+		buf = strconv.AppendInt(buf, int64(base+8), 10)
+		buf = append(buf, ";5;"...)
+		buf = strconv.AppendInt(buf, int64(c.Value), 10)
+	case c.Mode == ColorRGB:
+		buf = append(buf, ';')
+		buf = strconv.AppendInt(buf, int64(base+8), 10)
+		buf = append(buf, ";2;"...)
+		buf = strconv.AppendInt(buf, int64(c.Value>>16&0xff), 10)
+		buf = append(buf, ';')
+		buf = strconv.AppendInt(buf, int64(c.Value>>8&0xff), 10)
+		buf = append(buf, ';')
+		buf = strconv.AppendInt(buf, int64(c.Value&0xff), 10)
+	}
+	return buf
+}
+
+func sameCell(a, b Cell) bool {
+	return a == b || a.IsBlank() && b.IsBlank()
+}
+
+// scrollShift returns how many lines the screen scrolled up between from
+// and to, or 0 when scrolling would not leave more rows in place than
+// repainting does. Blank rows are not counted.
+func scrollShift(from, to [][]Cell) int {
+	rows := len(to)
+	if rows == 0 {
+		return 0
+	}
+	hf, ht := make([]uint64, rows), make([]uint64, rows)
+	for i := 0; i < rows; i++ {
+		hf[i], ht[i] = rowHash(from[i]), rowHash(to[i])
+	}
+	blank := rowHash(Blank(len(to[0]), 1).Lines[0])
+	matches := func(k int) int {
+		n := 0
+		for i := 0; i+k < rows; i++ {
+			if ht[i] == hf[i+k] && ht[i] != blank {
+				n++
+			}
+		}
+		return n
+	}
+	best, bestN := 0, matches(0)
+	for k := 1; k < rows; k++ {
+		if n := matches(k); n > bestN {
+			best, bestN = k, n
+		}
+	}
+	return best
+}
+
+// rowHash is an FNV-1a hash of how a row looks.
+func rowHash(row []Cell) uint64 {
+	h := uint64(14695981039346656037)
+	add := func(b byte) {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	for _, c := range row {
+		if c.IsBlank() {
+			add(0)
+			continue
+		}
+		for i := 0; i < len(c.Char); i++ {
+			add(c.Char[i])
+		}
+		add(1)
+		for _, v := range []uint32{uint32(c.FG.Mode), c.FG.Value, uint32(c.BG.Mode), c.BG.Value, uint32(c.Attrs)} {
+			add(byte(v))
+			add(byte(v >> 8))
+			add(byte(v >> 16))
+		}
+		if c.Wide {
+			add(2)
+		}
+	}
+	return h
+}
@@ -0,0 +1,139 @@
+package vt
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// checkDiff writes Diff(from, to) to a screen showing from and checks that
+// it then shows to. It returns the diff.
+func checkDiff(t *testing.T, fromOut, toOut string, cols, rows int) string {
+	t.Helper()
+	client := render(cols, rows, fromOut)
+	to := render(cols, rows, toOut).Grid()
+	delta := Diff(client.Grid(), to)
+	client.Write(delta)
+	if msg := gridMismatch(client.Grid(), to); msg != "" {
+		t.Fatalf("after diff %q from %q to %q: %s", delta, fromOut, toOut, msg)
+	}
+	return string(delta)
+}
+
+func gridMismatch(got, want Grid) string {
+	if got.Cols != want.Cols || got.Rows != want.Rows {
+		return fmt.Sprintf("size %dx%d, want %dx%d", got.Cols, got.Rows, want.Cols, want.Rows)
+	}
+	for y := range want.Lines {
+		for x := range want.Lines[y] {
+			if !sameCell(got.Lines[y][x], want.Lines[y][x]) {
+				return fmt.Sprintf("cell %d,%d = %+v, want %+v\ngot:\n%s\nwant:\n%s", x, y, got.Lines[y][x], want.Lines[y][x], got.Text(), want.Text())
+			}
+		}
+	}
+	if got.CursorX != want.CursorX || got.CursorY != want.CursorY || got.CursorVisible != want.CursorVisible {
+		return fmt.Sprintf("cursor %d,%d visible %v, want %d,%d visible %v",
+			got.CursorX, got.CursorY, got.CursorVisible, want.CursorX, want.CursorY, want.CursorVisible)
+	}
+	return ""
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "unchanged", from: "$ ls", to: "$ ls", want: ""},
+		{name: "one cell", from: "progress 10%", to: "progress 11%", want: "\x1b[1;11H\x1b[0m1\x1b[1;13H"},
+		{name: "erased tail", from: "hello world", to: "hello", want: "\x1b[1;6H\x1b[0m\x1b[K"},
+		{name: "cursor only", from: "ab", to: "ab\x1b[1;1H", want: "\x1b[1H"},
+		{name: "cursor hidden", from: "ab", to: "ab\x1b[?25l", want: "\x1b[?25l"},
+		{name: "color change", from: "ok", to: "\x1b[32mok", want: "\x1b[1H\x1b[0;32mok"},
+		{name: "scroll", from: "1\n2\n3\n4", to: "2\n3\n4\n5", want: "\x1b[0m\x1b[4H\n5"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := checkDiff(t, tc.from, tc.to, 20, 4); got != tc.want {
+				t.Fatalf("diff = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiffCases(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{name: "wide characters", from: "中文 ok", to: "x文 ok"},
+		{name: "wide over narrow", from: "abcdef", to: "a中def"},
+		{name: "narrow over continuation", from: "a中def", to: "ab def"},
+		{name: "full last column", from: "0123456789\nabc", to: "012345678x\nabd"},
+		{name: "bottom right cell", from: "\x1b[4;10Hx\x1b[1;1H", to: "\x1b[4;10Hy\x1b[1;1H"},
+		{name: "scroll with static footer", from: "a\nb\nc\n--", to: "b\nc\nd\n--"},
+		{name: "scroll by several lines", from: "1\n2\n3\n4", to: "4\n5\n\n"},
+		{name: "background to erase", from: "\x1b[41mred   \x1b[0m", to: "red"},
+		{name: "colored blanks", from: "ab", to: "\x1b[44m    \x1b[0m"},
+		{name: "clear screen", from: "a\nb\nc\nd", to: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			checkDiff(t, tc.from, tc.to, 10, 4)
+		})
+	}
+}
+
+func TestDiffResize(t *testing.T) {
+	from := render(10, 4, "hello").Grid()
+	to := render(12, 3, "hi\nthere").Grid()
+	client := render(12, 3, "garbage")
+	client.Write(Diff(from, to))
+	if msg := gridMismatch(client.Grid(), to); msg != "" {
+		t.Fatal(msg)
+	}
+}
+
+func TestDiffScrollUsesLineFeeds(t *testing.T) {
+	var from, to strings.Builder
+	for i := 0; i < 24; i++ {
+		fmt.Fprintf(&from, "line %d of the build output\n", i)
+		fmt.Fprintf(&to, "line %d of the build output\n", i+2)
+	}
+	delta := checkDiff(t, strings.TrimSuffix(from.String(), "\n"), strings.TrimSuffix(to.String(), "\n"), 40, 24)
+	if !strings.Contains(delta, "\n\n") || len(delta) > 100 {
+		t.Fatalf("diff = %q, want two line feeds and the new lines", delta)
+	}
+}
+
+// TestDiffRandom checks Diff against random screens built from text, colors,
+// wide characters, cursor moves and erases.
+func TestDiffRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	pieces := []string{
+		"a", "bc", "xyz ", " ", "中", "文字", "é", "\n", "\r", "\t",
+		"\x1b[31m", "\x1b[1;44m", "\x1b[0m", "\x1b[38;5;208m", "\x1b[7m", "\x1b[4m",
+		"\x1b[K", "\x1b[1K", "\x1b[J", "\x1b[2P", "\x1b[3@", "\x1b[L", "\x1b[M", "\x1b[2X",
+		"\x1b[?25l", "\x1b[?25h",
+	}
+	randomOutput := func() string {
+		var b strings.Builder
+		for i := rng.Intn(40); i > 0; i-- {
+			if rng.Intn(6) == 0 {
+				fmt.Fprintf(&b, "\x1b[%d;%dH", rng.Intn(7)+1, rng.Intn(13)+1)
+				continue
+			}
+			b.WriteString(pieces[rng.Intn(len(pieces))])
+		}
+		return b.String()
+	}
+	for i := 0; i < 2000; i++ {
+		from := randomOutput()
+		to := from + randomOutput()
+		if rng.Intn(3) == 0 {
+			to = randomOutput()
+		}
+		checkDiff(t, from, to, 12, 6)
+	}
+}
@@ -0,0 +1,429 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type parserState uint8
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateCSI
+	// stateString skips OSC, DCS, SOS, PM and APC strings.
+	stateString
+	stateStringEscape
+)
+
+// maxCSIParamBytes bounds the parameter bytes kept for one CSI sequence;
+// longer sequences are parsed with the excess dropped.
+const maxCSIParamBytes = 256
+
+type parser struct {
+	state        parserState
+	params       []byte
+	private      byte
+	intermediate bool
+	// partial holds the start of a UTF-8 sequence split across writes.
+	partial []byte
+}
+
+// Write feeds terminal output to the screen. It never fails; invalid UTF-8
+// is rendered as U+FFFD.
+func (s *Screen) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(s.parser.partial) > 0 {
+		p = append(s.parser.partial, p...)
+		s.parser.partial = nil
+	}
+	for i := 0; i < len(p); {
+		b := p[i]
+		if b < utf8.RuneSelf || s.parser.state != stateGround {
+			s.step(b)
+			i++
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			s.parser.partial = append([]byte(nil), p[i:]...)
+			break
+		}
+		r, size := utf8.DecodeRune(p[i:])
+		s.print(r)
+		i += size
+	}
+	return n, nil
+}
+
+// WriteString is Write for a string.
+func (s *Screen) WriteString(str string) (int, error) {
+	return s.Write([]byte(str))
+}
+
+func (s *Screen) step(b byte) {
+	p := &s.parser
+	switch b {
+	case 0x18, 0x1a: // CAN, SUB
+		p.state = stateGround
+		return
+	case 0x1b:
+		if p.state == stateString {
+			p.state = stateStringEscape
+		} else {
+			p.state = stateEscape
+			p.intermediate = false
+		}
+		return
+	}
+	if b < 0x20 && p.state != stateString && p.state != stateStringEscape {
+		s.control(b)
+		return
+	}
+
+	switch p.state {
+	case stateGround:
+		if b != 0x7f {
+			s.print(rune(b))
+		}
+	case stateEscape:
+		s.escape(b)
+	case stateEscapeIntermediate:
+		if b >= 0x30 && b <= 0x7e {
+			// Character set designations and the like; not rendered.
+			p.state = stateGround
+		}
+	case stateCSI:
+		switch {
+		case b >= 0x30 && b <= 0x3f:
+			if len(p.params) == 0 && !p.intermediate && p.private == 0 && b >= '<' {
+				p.private = b
+			} else if len(p.params) < maxCSIParamBytes {
+				p.params = append(p.params, b)
+			}
+		case b >= 0x20 && b <= 0x2f:
+			p.intermediate = true
+		case b >= 0x40 && b <= 0x7e:
+			p.state = stateGround
+			if !p.intermediate {
+				s.csi(b, parseParams(p.params), p.private)
+			}
+		}
+	case stateString:
+		if b == 0x07 {
+			// BEL ends OSC strings.
+			p.state = stateGround
+		}
+	case stateStringEscape:
+		if b == '\\' {
+			p.state = stateGround
+			return
+		}
+		// ESC aborts the string and starts a new sequence.
+		p.state = stateEscape
+		p.intermediate = false
+		s.step(b)
+	}
+}
+
+func (s *Screen) control(b byte) {
+	switch b {
+	case '\b':
+		if s.wrapNext {
+			s.wrapNext = false
+		} else if s.x > 0 {
+			s.x--
+		}
+	case '\t':
+		s.tab()
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\r':
+		s.wrapNext = false
+		s.x = 0
+	}
+}
+
+func (s *Screen) escape(b byte) {
+	p := &s.parser
+	p.state = stateGround
+	switch {
+	case b >= 0x20 && b <= 0x2f:
+		p.state = stateEscapeIntermediate
+	case b == '[':
+		p.state = stateCSI
+		p.params = p.params[:0]
+		p.private = 0
+		p.intermediate = false
+	case b == ']', b == 'P', b == 'X', b == '^', b == '_':
+		p.state = stateString
+	case b == '7':
+		s.saveCursor()
+	case b == '8':
+		s.restoreCursor()
+	case b == 'D':
+		s.wrapNext = false
+		s.index()
+	case b == 'E':
+		s.wrapNext = false
+		s.x = 0
+		s.index()
+	case b == 'M':
+		s.reverseIndex()
+	case b == 'c':
+		s.reset()
+	}
+}
+
+// parseParams splits CSI parameters into fields separated by ';', each a
+// list of ':'-separated sub-parameters. Omitted values are -1.
+func parseParams(raw []byte) [][]int {
+	if len(raw) == 0 {
+		return nil
+	}
+	fields := strings.Split(string(raw), ";")
+	params := make([][]int, len(fields))
+	for i, f := range fields {
+		subs := strings.Split(f, ":")
+		params[i] = make([]int, len(subs))
+		for j, sub := range subs {
+			n, err := strconv.Atoi(sub)
+			if err != nil || n < 0 {
+				n = -1
+			}
+			params[i][j] = min(n, 1<<16)
+		}
+	}
+	return params
+}
+
+// param returns the i'th parameter, or def when it is omitted or zero.
+func param(params [][]int, i, def int) int {
+	if i >= len(params) || params[i][0] <= 0 {
+		return def
+	}
+	return params[i][0]
+}
+
+func (s *Screen) csi(final byte, params [][]int, private byte) {
+	if private == '?' {
+		if final == 'h' || final == 'l' {
+			for _, f := range params {
+				s.setMode(f[0], final == 'h')
+			}
+		}
+		return
+	}
+	if private != 0 {
+		return
+	}
+
+	n := param(params, 0, 1)
+	switch final {
+	case '@':
+		s.insertChars(n)
+	case 'A':
+		s.moveRows(-n)
+	case 'B', 'e':
+		s.moveRows(n)
+	case 'C', 'a':
+		s.moveTo(s.x+n, s.y)
+	case 'D':
+		s.moveTo(s.x-n, s.y)
+	case 'E':
+		s.moveRows(n)
+		s.x = 0
+	case 'F':
+		s.moveRows(-n)
+		s.x = 0
+	case 'G', '`':
+		s.moveTo(n-1, s.y)
+	case 'H', 'f':
+		s.moveTo(param(params, 1, 1)-1, n-1)
+	case 'J':
+		s.wrapNext = false
+		s.eraseInDisplay(param(params, 0, 0))
+	case 'K':
+		s.wrapNext = false
+		s.eraseInLine(param(params, 0, 0))
+	case 'L':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.insertLines(s.y, n)
+			s.moveTo(0, s.y)
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.deleteLines(s.y, n)
+			s.moveTo(0, s.y)
+		}
+	case 'P':
+		s.wrapNext = false
+		s.deleteChars(n)
+	case 'S':
+		s.scrollUp(n)
+	case 'T':
+		s.scrollDown(n)
+	case 'X':
+		s.wrapNext = false
+		s.erase(s.y, s.x, s.x+n)
+	case 'd':
+		s.moveTo(s.x, n-1)
+	case 'm':
+		s.sgr(params)
+	case 'r':
+		s.setScrollRegion(param(params, 0, 1)-1, param(params, 1, s.rows)-1)
+	case 's':
+		s.saveCursor()
+	case 'u':
+		s.restoreCursor()
+	case 'c':
+		if param(params, 0, 0) == 0 {
+			// Primary device attributes: a VT100 with advanced video, as
+			// tmux answers.
+			s.respond("\x1b[?1;2c")
+		}
+	case 'n':
+		switch param(params, 0, 0) {
+		case 5:
+			s.respond("\x1b[0n")
+		case 6:
+			s.respond("\x1b[" + strconv.Itoa(s.y+1) + ";" + strconv.Itoa(s.x+1) + "R")
+		}
+	}
+}
+
+func (s *Screen) setMode(mode int, on bool) {
+	switch mode {
+	case 1:
+		s.appCursor = on
+	case 7:
+		s.noAutowrap = !on
+		if !on {
+			s.wrapNext = false
+		}
+	case 25:
+		s.cursorHidden = !on
+	case 47, 1047:
+		s.setAlternate(on)
+	case 1049:
+		if on {
+			s.saveCursor()
+			s.setAlternate(true)
+		} else {
+			s.setAlternate(false)
+			s.restoreCursor()
+		}
+	case 2004:
+		s.bracketedPaste = on
+	}
+}
+
+func (s *Screen) sgr(params [][]int) {
+	if len(params) == 0 {
+		params = [][]int{{0}}
+	}
+	pen := &s.pen
+	for i := 0; i < len(params); i++ {
+		f := params[i]
+		switch code := max(f[0], 0); {
+		case code == 0:
+			*pen = blankCell
+		case code == 1:
+			pen.Attrs |= Bold
+		case code == 2:
+			pen.Attrs |= Dim
+		case code == 3:
+			pen.Attrs |= Italic
+		case code == 4:
+			if len(f) > 1 && f[1] == 0 {
+				pen.Attrs &^= Underline
+			} else {
+				pen.Attrs |= Underline
+			}
+		case code == 5, code == 6:
+			pen.Attrs |= Blink
+		case code == 7:
+			pen.Attrs |= Inverse
+		case code == 8:
+			pen.Attrs |= Hidden
+		case code == 9:
+			pen.Attrs |= Strikethrough
+		case code == 21:
+			pen.Attrs |= Underline
+		case code == 22:
+			pen.Attrs &^= Bold | Dim
+		case code == 23:
+			pen.Attrs &^= Italic
+		case code == 24:
+			pen.Attrs &^= Underline
+		case code == 25:
+			pen.Attrs &^= Blink
+		case code == 27:
+			pen.Attrs &^= Inverse
+		case code == 28:
+			pen.Attrs &^= Hidden
+		case code == 29:
+			pen.Attrs &^= Strikethrough
+		case code >= 30 && code <= 37:
+			pen.FG = Indexed(uint8(code - 30))
+		case code == 38:
+			var c Color
+			c, i = extendedColor(params, i)
+			pen.FG = c
+		case code == 39:
+			pen.FG = Color{}
+		case code >= 40 && code <= 47:
+			pen.BG = Indexed(uint8(code - 40))
+		case code == 48:
+			var c Color
+			c, i = extendedColor(params, i)
+			pen.BG = c
+		case code == 49:
+			pen.BG = Color{}
+		case code >= 90 && code <= 97:
+			pen.FG = Indexed(uint8(code - 90 + 8))
+		case code >= 100 && code <= 107:
+			pen.BG = Indexed(uint8(code - 100 + 8))
+		}
+	}
+}
+
+// extendedColor parses the color of an SGR 38 or 48 field at params[i] in
+// either the colon form (38:5:n, 38:2::r:g:b, 38:2:r:g:b) or the semicolon
+// form (38;5;n, 38;2;r;g;b). It returns the color and the index of the last
+// field consumed.
+func extendedColor(params [][]int, i int) (Color, int) {
+	var args []int
+	next := i
+	if f := params[i]; len(f) > 1 {
+		args = f[1:]
+		if len(args) >= 5 && args[0] == 2 {
+			// Skip the color space id.
+			args = append([]int{2}, args[2:]...)
+		}
+	} else {
+		for j := i + 1; j < len(params) && j <= i+4; j++ {
+			args = append(args, params[j][0])
+		}
+		switch {
+		case len(args) >= 2 && args[0] == 5:
+			next = i + 2
+		case len(args) >= 4 && args[0] == 2:
+			next = i + 4
+		default:
+			return Color{}, len(params)
+		}
+	}
+	switch {
+	case len(args) >= 2 && args[0] == 5:
+		return Indexed(uint8(clampByte(args[1]))), next
+	case len(args) >= 4 && args[0] == 2:
+		return RGB(uint8(clampByte(args[1])), uint8(clampByte(args[2])), uint8(clampByte(args[3]))), next
+	}
+	return Color{}, next
+}
+
+func clampByte(n int) int {
+	return min(max(n, 0), 255)
+}
@@ -0,0 +1,36 @@
+package vt
+
+import "strings"
+
+// Render returns lines as text, one line per row terminated by "\n" with
+// trailing blanks removed, like tmux capture-pane -p. With ansi, colors and
+// attributes are written as SGR sequences and reset at the end of each
+// line.
+func Render(lines [][]Cell, ansi bool) string {
+	var b strings.Builder
+	e := encoder{pen: blankCell, penKnown: true}
+	for _, row := range lines {
+		row = TrimRight(row)
+		for i, c := range row {
+			if c.Char == "" {
+				if i > 0 && row[i-1].Wide {
+					continue
+				}
+				c.Char = " "
+			}
+			if ansi {
+				e.setPen(c)
+				b.Write(e.buf)
+				e.buf = e.buf[:0]
+			}
+			b.WriteString(c.Char)
+		}
+		if ansi {
+			e.resetPen()
+			b.Write(e.buf)
+			e.buf = e.buf[:0]
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
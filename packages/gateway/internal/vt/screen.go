@@ -0,0 +1,661 @@
+// Package vt is a small terminal emulator that renders ANSI output into a
+// grid of cells.
+//
+// It understands the subset of VT100/xterm sequences that tmux emits in
+// capture-pane output and that the gateway sends to clients: cursor motion,
+// erasing, insert/delete, scroll regions, SGR colors and attributes, the
+// alternate screen and cursor visibility. It also keeps scrollback, tracks
+// the input modes programs enable and answers status queries, enough to
+// stand in for tmux as the screen of a pane. Everything else is parsed and
+// ignored. Output is expected to be UTF-8.
+package vt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Attrs is a set of SGR text attributes.
+type Attrs uint8
+
+const (
+	Bold Attrs = 1 << iota
+	Dim
+	Italic
+	Underline
+	Blink
+	Inverse
+	Hidden
+	Strikethrough
+)
+
+// ColorMode says how a Color's value is interpreted.
+type ColorMode uint8
+
+const (
+	ColorDefault ColorMode = iota
+	// ColorIndexed is one of the 256 palette colors.
+	ColorIndexed
+	// ColorRGB is a 24-bit color stored as 0xRRGGBB.
+	ColorRGB
+)
+
+// Color is a foreground or background color.
+type Color struct {
+	Mode  ColorMode
+	Value uint32
+}
+
+// Indexed returns palette color n.
+func Indexed(n uint8) Color { return Color{Mode: ColorIndexed, Value: uint32(n)} }
+
+// RGB returns a 24-bit color.
+func RGB(r, g, b uint8) Color {
+	return Color{Mode: ColorRGB, Value: uint32(r)<<16 | uint32(g)<<8 | uint32(b)}
+}
+
+// jsonValue is the color's JSON form: nil for the default color, the
+// palette index for indexed colors and "#rrggbb" for RGB colors.
+func (c Color) jsonValue() any {
+	switch c.Mode {
+	case ColorIndexed:
+		return c.Value
+	case ColorRGB:
+		return fmt.Sprintf("#%06x", c.Value)
+	}
+	return nil
+}
+
+// Cell is one character cell. A wide character occupies its cell, marked
+// Wide, and a continuation cell to its right whose Char is empty.
+type Cell struct {
+	Char  string
+	FG    Color
+	BG    Color
+	Attrs Attrs
+	Wide  bool
+}
+
+var blankCell = Cell{Char: " "}
+
+// IsBlank reports whether the cell renders as an empty, unstyled space.
+func (c Cell) IsBlank() bool {
+	return c.Char == " " && c.BG.Mode == ColorDefault && c.Attrs&(Inverse|Underline|Strikethrough) == 0
+}
+
+// MarshalJSON encodes the cell compactly: default colors, unset attributes
+// and the wide flag are omitted.
+func (c Cell) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Char          string `json:"ch"`
+		FG            any    `json:"fg,omitempty"`
+		BG            any    `json:"bg,omitempty"`
+		Bold          bool   `json:"bold,omitempty"`
+		Dim           bool   `json:"dim,omitempty"`
+		Italic        bool   `json:"italic,omitempty"`
+		Underline     bool   `json:"underline,omitempty"`
+		Blink         bool   `json:"blink,omitempty"`
+		Inverse       bool   `json:"inverse,omitempty"`
+		Hidden        bool   `json:"hidden,omitempty"`
+		Strikethrough bool   `json:"strikethrough,omitempty"`
+		Wide          bool   `json:"wide,omitempty"`
+	}{
+		Char:          c.Char,
+		FG:            c.FG.jsonValue(),
+		BG:            c.BG.jsonValue(),
+		Bold:          c.Attrs&Bold != 0,
+		Dim:           c.Attrs&Dim != 0,
+		Italic:        c.Attrs&Italic != 0,
+		Underline:     c.Attrs&Underline != 0,
+		Blink:         c.Attrs&Blink != 0,
+		Inverse:       c.Attrs&Inverse != 0,
+		Hidden:        c.Attrs&Hidden != 0,
+		Strikethrough: c.Attrs&Strikethrough != 0,
+		Wide:          c.Wide,
+	})
+}
+
+// Grid is a copy of the visible screen.
+type Grid struct {
+	Cols, Rows    int
+	Lines         [][]Cell
+	CursorX       int
+	CursorY       int
+	CursorVisible bool
+	AlternateOn   bool
+}
+
+// Text returns the grid as plain text, one line per row with trailing
+// spaces removed.
+func (g Grid) Text() string {
+	lines := make([]string, len(g.Lines))
+	for i, row := range g.Lines {
+		var b strings.Builder
+		for _, c := range row {
+			b.WriteString(c.Char)
+		}
+		lines[i] = strings.TrimRight(b.String(), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// TrimRight returns row without its trailing blank cells.
+func TrimRight(row []Cell) []Cell {
+	n := len(row)
+	for n > 0 && row[n-1].IsBlank() {
+		n--
+	}
+	return row[:n]
+}
+
+type savedCursor struct {
+	x, y     int
+	pen      Cell
+	wrapNext bool
+}
+
+// Screen is a terminal screen. Write feeds it output; Grid reads it back.
+// A Screen is not safe for concurrent use.
+type Screen struct {
+	// ConvertEOL makes line feeds also return the cursor to the first
+	// column, like the convertEol option of the web terminal.
+	ConvertEOL bool
+	// HistoryLimit is the number of lines scrolled off the top of the main
+	// screen that are kept as scrollback. Zero keeps none.
+	HistoryLimit int
+	// Respond, if set, receives the replies to device status and attribute
+	// queries, to be written back to the program.
+	Respond func([]byte)
+
+	cols, rows int
+	main, alt  [][]Cell
+	lines      [][]Cell // main or alt
+	history    [][]Cell
+
+	x, y int
+	// wrapNext is set after printing in the last column: the next printed
+	// character wraps to a new line first.
+	wrapNext     bool
+	pen          Cell
+	saved        savedCursor
+	top, bottom  int // scroll region, inclusive
+	cursorHidden bool
+	noAutowrap   bool
+	alternate    bool
+	// appCursor and bracketedPaste are the input modes set by DECCKM and
+	// mode 2004.
+	appCursor      bool
+	bracketedPaste bool
+
+	parser parser
+}
+
+// New returns a blank screen of the given size.
+func New(cols, rows int) *Screen {
+	cols, rows = max(cols, 1), max(rows, 1)
+	s := &Screen{cols: cols, rows: rows, pen: blankCell}
+	s.saved.pen = blankCell
+	s.main = s.newLines()
+	s.alt = s.newLines()
+	s.lines = s.main
+	s.bottom = rows - 1
+	return s
+}
+
+func (s *Screen) newLines() [][]Cell {
+	lines := make([][]Cell, s.rows)
+	for i := range lines {
+		lines[i] = s.blankLine()
+	}
+	return lines
+}
+
+func (s *Screen) blankLine() []Cell {
+	line := make([]Cell, s.cols)
+	for i := range line {
+		line[i] = s.blank()
+	}
+	return line
+}
+
+// blank is an erased cell. Like xterm, erasing keeps the current background.
+func (s *Screen) blank() Cell {
+	return Cell{Char: " ", BG: s.pen.BG}
+}
+
+// Grid returns a copy of the visible screen and cursor state.
+func (s *Screen) Grid() Grid {
+	g := Grid{
+		Cols:          s.cols,
+		Rows:          s.rows,
+		Lines:         make([][]Cell, s.rows),
+		CursorX:       s.x,
+		CursorY:       s.y,
+		CursorVisible: !s.cursorHidden,
+		AlternateOn:   s.alternate,
+	}
+	for i, line := range s.lines {
+		g.Lines[i] = append([]Cell(nil), line...)
+	}
+	return g
+}
+
+// Size returns the screen size.
+func (s *Screen) Size() (cols, rows int) {
+	return s.cols, s.rows
+}
+
+// HistorySize returns the number of scrollback lines.
+func (s *Screen) HistorySize() int {
+	return len(s.history)
+}
+
+// Lines returns copies of lines start through end (inclusive). Line numbers
+// follow tmux: 0 is the top row of the screen and negative numbers go back
+// into the scrollback. The range is clamped to the lines there are.
+// Scrollback lines keep the width the screen had when they scrolled off.
+func (s *Screen) Lines(start, end int) [][]Cell {
+	start, end = max(start, -len(s.history)), min(end, s.rows-1)
+	if end < start {
+		return nil
+	}
+	out := make([][]Cell, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		line := s.lines[max(i, 0)]
+		if i < 0 {
+			line = s.history[len(s.history)+i]
+		}
+		out = append(out, append([]Cell(nil), line...))
+	}
+	return out
+}
+
+// AppCursorKeys reports whether the program asked for application cursor
+// keys (DECCKM), which send SS3 instead of CSI sequences.
+func (s *Screen) AppCursorKeys() bool {
+	return s.appCursor
+}
+
+// BracketedPaste reports whether the program enabled bracketed paste.
+func (s *Screen) BracketedPaste() bool {
+	return s.bracketedPaste
+}
+
+// Resize changes the screen size. Lines are cut or padded at the right.
+// When the main screen gets shorter, lines above the cursor move into the
+// scrollback first, and when it gets taller they come back from it, as in
+// tmux; other lines are cut or added at the bottom. The scroll region is
+// reset.
+func (s *Screen) Resize(cols, rows int) {
+	cols, rows = max(cols, 1), max(rows, 1)
+	if cols == s.cols && rows == s.rows {
+		return
+	}
+	for i, line := range s.main {
+		s.main[i] = fitLine(line, cols)
+	}
+	for i, line := range s.alt {
+		s.alt[i] = fitLine(line, cols)
+	}
+
+	y := s.y
+	if !s.alternate {
+		switch {
+		case rows < s.rows:
+			n := max(y-(rows-1), 0)
+			s.pushHistory(s.main[:n])
+			s.main = s.main[n:]
+			y -= n
+		case rows > s.rows && s.HistoryLimit > 0:
+			n := min(rows-s.rows, len(s.history))
+			back := make([][]Cell, 0, n+len(s.main))
+			for _, line := range s.history[len(s.history)-n:] {
+				back = append(back, fitLine(line, cols))
+			}
+			clear(s.history[len(s.history)-n:])
+			s.history = s.history[:len(s.history)-n]
+			s.main = append(back, s.main...)
+			y += n
+		}
+	}
+	s.cols = cols
+	s.main = s.fitRows(s.main, rows)
+	s.alt = s.fitRows(s.alt, rows)
+	s.rows = rows
+	s.lines = s.main
+	if s.alternate {
+		s.lines = s.alt
+	}
+
+	s.top, s.bottom = 0, rows-1
+	s.moveTo(s.x, y)
+	s.saved.x = min(s.saved.x, cols-1)
+	s.saved.y = min(s.saved.y, rows-1)
+}
+
+// fitLine returns line cut or padded to cols cells. A wide character cut in
+// half is blanked.
+func fitLine(line []Cell, cols int) []Cell {
+	if len(line) >= cols {
+		line = line[:cols:cols]
+		if line[cols-1].Wide {
+			line[cols-1] = blankCell
+		}
+		return line
+	}
+	for len(line) < cols {
+		line = append(line, blankCell)
+	}
+	return line
+}
+
+// fitRows returns lines cut or padded with blank lines to rows lines.
+func (s *Screen) fitRows(lines [][]Cell, rows int) [][]Cell {
+	if len(lines) >= rows {
+		return lines[:rows:rows]
+	}
+	for len(lines) < rows {
+		line := make([]Cell, s.cols)
+		for i := range line {
+			line[i] = blankCell
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (s *Screen) print(r rune) {
+	w := runeWidth(r)
+	if w == 0 {
+		s.combine(r)
+		return
+	}
+	if w == 2 && s.cols < 2 {
+		w = 1
+	}
+	if s.wrapNext {
+		s.wrapNext = false
+		s.x = 0
+		s.index()
+	}
+	if w == 2 && s.x == s.cols-1 {
+		// A wide character does not fit in the last column.
+		if s.noAutowrap {
+			return
+		}
+		s.clearWide(s.x)
+		s.lines[s.y][s.x] = s.blank()
+		s.x = 0
+		s.index()
+	}
+
+	s.clearWide(s.x)
+	c := s.pen
+	c.Char = string(r)
+	c.Wide = w == 2
+	s.lines[s.y][s.x] = c
+	if w == 2 {
+		s.clearWide(s.x + 1)
+		c.Char, c.Wide = "", false
+		s.lines[s.y][s.x+1] = c
+	}
+
+	s.x += w
+	if s.x >= s.cols {
+		s.x = s.cols - 1
+		s.wrapNext = !s.noAutowrap
+	}
+}
+
+// combine appends a zero-width rune to the previously printed character.
+func (s *Screen) combine(r rune) {
+	x := s.x - 1
+	if s.wrapNext {
+		x = s.x
+	}
+	if x >= 0 && s.lines[s.y][x].Char == "" {
+		x--
+	}
+	if x < 0 {
+		return
+	}
+	s.lines[s.y][x].Char += string(r)
+}
+
+// clearWide blanks the other half of a wide character at column x, which is
+// about to be overwritten.
+func (s *Screen) clearWide(x int) {
+	if x < 0 || x >= s.cols {
+		return
+	}
+	line := s.lines[s.y]
+	if line[x].Wide && x+1 < s.cols {
+		line[x+1] = s.blank()
+	}
+	if line[x].Char == "" && x > 0 {
+		line[x-1] = s.blank()
+	}
+}
+
+// index moves the cursor down, scrolling at the bottom of the scroll region.
+func (s *Screen) index() {
+	switch {
+	case s.y == s.bottom:
+		s.scrollUp(1)
+	case s.y < s.rows-1:
+		s.y++
+	}
+}
+
+// reverseIndex moves the cursor up, scrolling at the top of the scroll region.
+func (s *Screen) reverseIndex() {
+	s.wrapNext = false
+	switch {
+	case s.y == s.top:
+		s.scrollDown(1)
+	case s.y > 0:
+		s.y--
+	}
+}
+
+func (s *Screen) lineFeed() {
+	s.wrapNext = false
+	if s.ConvertEOL {
+		s.x = 0
+	}
+	s.index()
+}
+
+// scrollUp moves the lines of the scroll region up by n, adding blank lines
+// at the bottom. Like tmux, lines scrolled off a full-screen region of the
+// main screen go to the scrollback.
+func (s *Screen) scrollUp(n int) {
+	if !s.alternate && s.top == 0 && s.bottom == s.rows-1 {
+		s.pushHistory(s.lines[:min(n, s.rows)])
+	}
+	s.deleteLines(s.top, n)
+}
+
+// pushHistory appends lines to the scrollback, dropping the oldest beyond
+// HistoryLimit. The lines are kept, not copied.
+func (s *Screen) pushHistory(lines [][]Cell) {
+	if s.HistoryLimit <= 0 {
+		return
+	}
+	s.history = append(s.history, lines...)
+	if over := len(s.history) - s.HistoryLimit; over > 0 {
+		clear(s.history[:over])
+		s.history = s.history[over:]
+	}
+}
+
+// scrollDown moves the lines of the scroll region down by n, adding blank
+// lines at the top.
+func (s *Screen) scrollDown(n int) {
+	s.insertLines(s.top, n)
+}
+
+// insertLines inserts n blank lines at row y, pushing lines below it down
+// and off the bottom of the scroll region.
+func (s *Screen) insertLines(y, n int) {
+	n = min(n, s.bottom-y+1)
+	copy(s.lines[y+n:s.bottom+1], s.lines[y:s.bottom+1-n])
+	for i := y; i < y+n; i++ {
+		s.lines[i] = s.blankLine()
+	}
+}
+
+// deleteLines removes n lines at row y, pulling lines below it up and
+// adding blank lines at the bottom of the scroll region.
+func (s *Screen) deleteLines(y, n int) {
+	n = min(n, s.bottom-y+1)
+	copy(s.lines[y:s.bottom+1-n], s.lines[y+n:s.bottom+1])
+	for i := s.bottom + 1 - n; i <= s.bottom; i++ {
+		s.lines[i] = s.blankLine()
+	}
+}
+
+// erase blanks columns from..to-1 of row y.
+func (s *Screen) erase(y, from, to int) {
+	from, to = max(from, 0), min(to, s.cols)
+	line := s.lines[y]
+	if from < to {
+		if from > 0 && line[from].Char == "" {
+			line[from-1] = s.blank()
+		}
+		if to < s.cols && line[to].Char == "" {
+			line[to] = s.blank()
+		}
+	}
+	for x := from; x < to; x++ {
+		line[x] = s.blank()
+	}
+}
+
+func (s *Screen) eraseInDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.erase(s.y, s.x, s.cols)
+		for y := s.y + 1; y < s.rows; y++ {
+			s.erase(y, 0, s.cols)
+		}
+	case 1:
+		for y := 0; y < s.y; y++ {
+			s.erase(y, 0, s.cols)
+		}
+		s.erase(s.y, 0, s.x+1)
+	case 2:
+		for y := 0; y < s.rows; y++ {
+			s.erase(y, 0, s.cols)
+		}
+	case 3:
+		s.history = nil
+	}
+}
+
+func (s *Screen) eraseInLine(mode int) {
+	switch mode {
+	case 0:
+		s.erase(s.y, s.x, s.cols)
+	case 1:
+		s.erase(s.y, 0, s.x+1)
+	case 2:
+		s.erase(s.y, 0, s.cols)
+	}
+}
+
+// insertChars shifts the rest of the line right by n blank cells.
+func (s *Screen) insertChars(n int) {
+	line := s.lines[s.y]
+	n = min(n, s.cols-s.x)
+	if line[s.x].Char == "" && s.x > 0 {
+		// Inserting inside a wide character splits it.
+		line[s.x-1], line[s.x] = s.blank(), s.blank()
+	}
+	copy(line[s.x+n:], line[s.x:s.cols-n])
+	s.erase(s.y, s.x, s.x+n)
+	if line[s.cols-1].Wide {
+		line[s.cols-1] = s.blank()
+	}
+}
+
+// deleteChars removes n cells at the cursor, shifting the rest of the line
+// left and filling the end with blanks.
+func (s *Screen) deleteChars(n int) {
+	line := s.lines[s.y]
+	n = min(n, s.cols-s.x)
+	s.clearWide(s.x)
+	s.clearWide(s.x + n - 1)
+	copy(line[s.x:], line[s.x+n:])
+	s.erase(s.y, s.cols-n, s.cols)
+}
+
+func (s *Screen) moveTo(x, y int) {
+	s.wrapNext = false
+	s.x = min(max(x, 0), s.cols-1)
+	s.y = min(max(y, 0), s.rows-1)
+}
+
+// moveRows moves the cursor n rows down (up when negative), stopping at the
+// scroll region margins when the cursor starts inside the region.
+func (s *Screen) moveRows(n int) {
+	minY, maxY := 0, s.rows-1
+	if s.y >= s.top && s.y <= s.bottom {
+		minY, maxY = s.top, s.bottom
+	}
+	s.moveTo(s.x, min(max(s.y+n, minY), maxY))
+}
+
+func (s *Screen) tab() {
+	s.wrapNext = false
+	s.x = min((s.x/8+1)*8, s.cols-1)
+}
+
+func (s *Screen) saveCursor() {
+	s.saved = savedCursor{x: s.x, y: s.y, pen: s.pen, wrapNext: s.wrapNext}
+}
+
+func (s *Screen) restoreCursor() {
+	s.moveTo(s.saved.x, s.saved.y)
+	s.pen = s.saved.pen
+	s.wrapNext = s.saved.wrapNext
+}
+
+// setAlternate switches between the main and alternate screen. Entering the
+// alternate screen clears it.
+func (s *Screen) setAlternate(on bool) {
+	if on == s.alternate {
+		return
+	}
+	s.alternate = on
+	if on {
+		s.alt = s.newLines()
+		s.lines = s.alt
+	} else {
+		s.lines = s.main
+	}
+}
+
+func (s *Screen) setScrollRegion(top, bottom int) {
+	if top >= bottom || bottom >= s.rows {
+		return
+	}
+	s.top, s.bottom = top, bottom
+	s.moveTo(0, 0)
+}
+
+// reset is RIS. The scrollback and the caller's settings survive it.
+func (s *Screen) reset() {
+	old := *s
+	*s = *New(old.cols, old.rows)
+	s.ConvertEOL, s.HistoryLimit, s.Respond = old.ConvertEOL, old.HistoryLimit, old.Respond
+	s.history = old.history
+}
+
+// respond sends a reply to a query, if anyone listens.
+func (s *Screen) respond(reply string) {
+	if s.Respond != nil {
+		s.Respond([]byte(reply))
+	}
+}
@@ -0,0 +1,254 @@
+package vt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func render(cols, rows int, out string) *Screen {
+	s := New(cols, rows)
+	s.ConvertEOL = true
+	s.WriteString(out)
+	return s
+}
+
+func TestScreenText(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+	}{
+		{name: "lines", out: "one\ntwo", want: "one\ntwo\n\n"},
+		{name: "autowrap", out: "abcdefg", want: "abcde\nfg\n\n"},
+		{name: "scroll", out: "1\n2\n3\n4\n5\n6", want: "3\n4\n5\n6"},
+		{name: "carriage return", out: "hello\rj", want: "jello\n\n\n"},
+		{name: "backspace and tab", out: "ab\bc\td", want: "ac  d\n\n\n"},
+		{name: "cursor position", out: "\x1b[2;3Hx\x1b[1;1Hy", want: "y\n  x\n\n"},
+		{name: "erase line", out: "abcde\x1b[1;3H\x1b[K", want: "ab\n\n\n"},
+		{name: "erase display", out: "a\nb\nc\x1b[2;1H\x1b[J", want: "a\n\n\n"},
+		{name: "insert and delete chars", out: "abcd\x1b[1;2H\x1b[2@\x1b[1;5H\x1b[P", want: "a  b\n\n\n"},
+		{name: "erase chars", out: "abcde\x1b[1;2H\x1b[2X", want: "a  de\n\n\n"},
+		{name: "insert and delete lines", out: "a\nb\nc\x1b[2;1H\x1b[L\x1b[4;1H\x1b[M", want: "a\n\nb\n"},
+		{name: "scroll region", out: "top\x1b[2;3r\x1b[2;1Ha\nb\nc\x1b[r\x1b[4;1Hbot", want: "top\nb\nc\nbot"},
+		{name: "reverse index", out: "a\nb\x1b[1;1H\x1bMz", want: "z\na\nb\n"},
+		{name: "osc and charset skipped", out: "\x1b]0;title\x07\x1b]8;;http://x\x1b\\a\x1b(Bb", want: "ab\n\n\n"},
+		{name: "save and restore cursor", out: "ab\x1b7\ncd\x1b8e", want: "abe\ncd\n\n"},
+		{name: "pending wrap", out: "abcde\rX", want: "Xbcde\n\n\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := render(5, 4, tc.out).Grid().Text(); got != tc.want {
+				t.Fatalf("text = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScreenWithoutConvertEOL(t *testing.T) {
+	s := New(5, 2)
+	s.WriteString("ab\ncd")
+	if got := s.Grid().Text(); got != "ab\n  cd" {
+		t.Fatalf("text = %q", got)
+	}
+}
+
+func TestScreenWideCharacters(t *testing.T) {
+	s := render(5, 2, "中文x")
+	line := s.Grid().Lines[0]
+	if line[0].Char != "中" || !line[0].Wide || line[1].Char != "" || line[2].Char != "文" || line[4].Char != "x" {
+		t.Fatalf("line = %+v", line)
+	}
+
+	// A wide character that does not fit in the last column wraps.
+	s = render(5, 2, "abcd中")
+	if got := s.Grid().Text(); got != "abcd\n中" {
+		t.Fatalf("text = %q", got)
+	}
+
+	// Overwriting half of a wide character blanks the other half.
+	s = render(5, 2, "中文\x1b[1;2Hx")
+	if got := s.Grid().Text(); got != " x文\n" {
+		t.Fatalf("text = %q", got)
+	}
+
+	// Combining marks join the previous character.
+	s = render(5, 2, "é!")
+	line = s.Grid().Lines[0]
+	if line[0].Char != "é" || line[1].Char != "!" {
+		t.Fatalf("line = %+v", line)
+	}
+}
+
+func TestScreenSplitUTF8Writes(t *testing.T) {
+	s := New(5, 1)
+	b := []byte("é中")
+	for i := range b {
+		s.Write(b[i : i+1])
+	}
+	if got := s.Grid().Text(); got != "é中" {
+		t.Fatalf("text = %q", got)
+	}
+}
+
+func TestScreenSGR(t *testing.T) {
+	s := render(10, 1, "\x1b[1;31;44ma\x1b[22;39mb\x1b[38;5;208;48;2;1;2;3mc\x1b[38:2::10:20:30;4md\x1b[0me\x1b[7;93mf")
+	line := s.Grid().Lines[0]
+	tests := []struct {
+		cell Cell
+		want Cell
+	}{
+		{line[0], Cell{Char: "a", FG: Indexed(1), BG: Indexed(4), Attrs: Bold}},
+		{line[1], Cell{Char: "b", BG: Indexed(4)}},
+		{line[2], Cell{Char: "c", FG: Indexed(208), BG: RGB(1, 2, 3)}},
+		{line[3], Cell{Char: "d", FG: RGB(10, 20, 30), BG: RGB(1, 2, 3), Attrs: Underline}},
+		{line[4], Cell{Char: "e"}},
+		{line[5], Cell{Char: "f", FG: Indexed(11), Attrs: Inverse}},
+	}
+	for i, tc := range tests {
+		if tc.cell != tc.want {
+			t.Errorf("cell %d = %+v, want %+v", i, tc.cell, tc.want)
+		}
+	}
+}
+
+func TestScreenEraseKeepsBackground(t *testing.T) {
+	s := render(4, 1, "\x1b[42m\x1b[K")
+	if bg := s.Grid().Lines[0][3].BG; bg != Indexed(2) {
+		t.Fatalf("erased cell bg = %+v, want green", bg)
+	}
+}
+
+func TestScreenModes(t *testing.T) {
+	s := render(5, 2, "main\x1b[?1049h\x1b[H\x1b[?25lalt")
+	g := s.Grid()
+	if !g.AlternateOn || g.CursorVisible || g.Text() != "alt\n" {
+		t.Fatalf("alternate grid = %+v", g)
+	}
+	s.WriteString("\x1b[?1049l\x1b[?25h")
+	g = s.Grid()
+	if g.AlternateOn || !g.CursorVisible || g.Text() != "main\n" || g.CursorX != 4 || g.CursorY != 0 {
+		t.Fatalf("main grid = %+v", g)
+	}
+
+	s = render(5, 2, "\x1b[?7labcdefg")
+	if got := s.Grid().Text(); got != "abcdg\n" {
+		t.Fatalf("no-autowrap text = %q", got)
+	}
+}
+
+func TestScreenReset(t *testing.T) {
+	s := render(5, 2, "\x1b[31mab\x1b[?25l\x1bc")
+	g := s.Grid()
+	if g.Text() != "\n" || !g.CursorVisible || g.CursorX != 0 || !s.ConvertEOL {
+		t.Fatalf("grid after reset = %+v", g)
+	}
+}
+
+func TestScreenScrollback(t *testing.T) {
+	s := New(5, 2)
+	s.ConvertEOL = true
+	s.HistoryLimit = 3
+	s.WriteString("1\n2\n3\n4\n5\n6")
+	if got := s.HistorySize(); got != 3 {
+		t.Fatalf("HistorySize = %d, want 3", got)
+	}
+	if got := Render(s.Lines(-10, 10), false); got != "2\n3\n4\n5\n6\n" {
+		t.Fatalf("lines = %q", got)
+	}
+
+	// Scroll regions and the alternate screen do not feed the scrollback.
+	s.WriteString("\x1b[1;1r\n\x1b[r\x1b[?1049h\n\n\n\x1b[?1049l")
+	if got := s.HistorySize(); got != 3 {
+		t.Fatalf("HistorySize after region and alternate scrolls = %d, want 3", got)
+	}
+	s.WriteString("\x1b[3J")
+	if got := s.HistorySize(); got != 0 {
+		t.Fatalf("HistorySize after ED 3 = %d, want 0", got)
+	}
+}
+
+func TestScreenResize(t *testing.T) {
+	s := New(5, 3)
+	s.ConvertEOL = true
+	s.HistoryLimit = 10
+	s.WriteString("a\nb\n中c")
+
+	// Shrinking keeps the cursor line, moving the lines above it into the
+	// scrollback, and blanks a cut wide character.
+	s.Resize(1, 2)
+	g := s.Grid()
+	if g.Text() != "b\n" || g.Cols != 1 || g.Rows != 2 || g.CursorX != 0 || g.CursorY != 1 {
+		t.Fatalf("shrunk grid = %q %+v", g.Text(), g)
+	}
+	if got := s.HistorySize(); got != 1 {
+		t.Fatalf("HistorySize = %d, want 1", got)
+	}
+
+	// Growing brings the lines back.
+	s.Resize(4, 4)
+	g = s.Grid()
+	if g.Text() != "a\nb\n\n" || g.CursorY != 2 || s.HistorySize() != 0 {
+		t.Fatalf("grown grid = %q %+v", g.Text(), g)
+	}
+	s.WriteString("\r\nxyzw")
+	if got := s.Grid().Text(); got != "a\nb\n\nxyzw" {
+		t.Fatalf("text after resize = %q", got)
+	}
+}
+
+func TestScreenInputModesAndReplies(t *testing.T) {
+	var replies []string
+	s := New(10, 3)
+	s.Respond = func(b []byte) { replies = append(replies, string(b)) }
+	s.WriteString("\x1b[?1h\x1b[?2004h\x1b[2;4H\x1b[6n\x1b[5n\x1b[c\x1b[>c")
+	if !s.AppCursorKeys() || !s.BracketedPaste() {
+		t.Fatalf("modes = %v %v, want both on", s.AppCursorKeys(), s.BracketedPaste())
+	}
+	want := []string{"\x1b[2;4R", "\x1b[0n", "\x1b[?1;2c"}
+	if strings.Join(replies, "|") != strings.Join(want, "|") {
+		t.Fatalf("replies = %q, want %q", replies, want)
+	}
+	s.WriteString("\x1b[?1l\x1b[?2004l")
+	if s.AppCursorKeys() || s.BracketedPaste() {
+		t.Fatal("modes still on after reset")
+	}
+}
+
+func TestRender(t *testing.T) {
+	s := render(6, 2, "\x1b[31mab\x1b[0m 中\n\x1b[44m  \x1b[0m")
+	lines := s.Lines(0, 1)
+	if got := Render(lines, false); got != "ab 中\n  \n" {
+		t.Fatalf("plain = %q", got)
+	}
+	if got := Render(lines, true); got != "\x1b[0;31mab\x1b[0m 中\n\x1b[0;44m  \x1b[0m\n" {
+		t.Fatalf("ansi = %q", got)
+	}
+}
+
+func TestCellJSON(t *testing.T) {
+	row := []Cell{
+		{Char: "a", FG: Indexed(1), Attrs: Bold | Underline},
+		{Char: "中", BG: RGB(0x12, 0x34, 0x56), Wide: true},
+		{Char: ""},
+		blankCell,
+		blankCell,
+	}
+	raw, err := json.Marshal(TrimRight(row))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `[{"ch":"a","fg":1,"bold":true,"underline":true},{"ch":"中","bg":"#123456","wide":true},{"ch":""}]`
+	if string(raw) != want {
+		t.Fatalf("json = %s, want %s", raw, want)
+	}
+}
+
+func TestScreenIgnoresGarbage(t *testing.T) {
+	s := New(10, 3)
+	s.WriteString("\x1b[" + strings.Repeat("9;", 1000) + "m\x1b[99999;99999H\x1b[99999@\x1b[99999M\x1b[?99999hok\xff")
+	g := s.Grid()
+	if got := g.Text(); got != "\n\nok\ufffd" {
+		t.Fatalf("grid = %q", g.Text())
+	}
+}
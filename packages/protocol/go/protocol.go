@@ -25,10 +25,24 @@ const (
 	// Commands (CP → gateway)
 	CmdSessionCreate   CommandType = "session.create"
 	CmdSessionInput    CommandType = "session.input"
+	CmdSessionPaste    CommandType = "session.paste"
+	CmdSessionKeys     CommandType = "session.keys"
 	CmdSessionResize   CommandType = "session.resize"
 	CmdSessionEnd      CommandType = "session.end"
+	CmdSessionRestart  CommandType = "session.restart"
 	CmdSessionAck      CommandType = "session.ack"
+	CmdSubscribe       CommandType = "session.subscribe"
+	CmdUnsubscribe     CommandType = "session.unsubscribe"
 	CmdSessionSnapshot CommandType = "session.snapshot"
+	CmdSessionHistory  CommandType = "session.history"
+	CmdSessionSearch   CommandType = "session.search"
+	CmdSessionList     CommandType = "session.list"
+	CmdRecordingList   CommandType = "session.recording.list"
+	CmdRecordingGet    CommandType = "session.recording.download"
+	CmdRecordingDelete CommandType = "session.recording.delete"
+	CmdProfileSave     CommandType = "profile.save"
+	CmdProfileList     CommandType = "profile.list"
+	CmdProfileDelete   CommandType = "profile.delete"
 	CmdSSHAuthorize    CommandType = "ssh.authorize"
 	CmdSSHRevoke       CommandType = "ssh.revoke"
 	CmdSSHList         CommandType = "ssh.list"
@@ -43,21 +57,31 @@ const (
 	CmdGatewayUpdate   CommandType = "gateway.update"
 
 	// Events (gateway → CP)
-	EvtAck              EventType = "ack"
-	EvtGatewayHello     EventType = "gateway.hello"
-	EvtGatewayHealth    EventType = "gateway.health"
-	EvtSessionStarted   EventType = "session.started"
-	EvtSessionEnded     EventType = "session.ended"
-	EvtSessionError     EventType = "session.error"
-	EvtSessionSnapshot  EventType = "session.snapshot"
-	EvtSSHKeys          EventType = "ssh.keys"
-	EvtFileContentBegin EventType = "file.content.begin"
-	EvtFileContentChunk EventType = "file.content.chunk"
-	EvtFileContentEnd   EventType = "file.content.end"
-	EvtAgentInstalled   EventType = "agent.installed"
-	EvtAgentsStatus     EventType = "agents.status"
-	EvtWorkspaceFolders EventType = "workspace.folders"
-	EvtGatewayUpdated   EventType = "gateway.updated"
+	EvtAck                EventType = "ack"
+	EvtGatewayHello       EventType = "gateway.hello"
+	EvtGatewayHealth      EventType = "gateway.health"
+	EvtSessionStarted     EventType = "session.started"
+	EvtSessionEnded       EventType = "session.ended"
+	EvtSessionRestarted   EventType = "session.restarted"
+	EvtSessionAgentExited EventType = "session.agent_exited"
+	EvtSessionExpiring    EventType = "session.expiring"
+	EvtSessionInputAck    EventType = "session.input_ack"
+	EvtSessionResized     EventType = "session.resized"
+	EvtSessionError       EventType = "session.error"
+	EvtSessionSnapshot    EventType = "session.snapshot"
+	EvtSessionHistory     EventType = "session.history"
+	EvtSessionSearch      EventType = "session.search"
+	EvtSessionList        EventType = "session.list"
+	EvtRecordingList      EventType = "session.recording.list"
+	EvtProfileList        EventType = "profile.list"
+	EvtSSHKeys            EventType = "ssh.keys"
+	EvtFileContentBegin   EventType = "file.content.begin"
+	EvtFileContentChunk   EventType = "file.content.chunk"
+	EvtFileContentEnd     EventType = "file.content.end"
+	EvtAgentInstalled     EventType = "agent.installed"
+	EvtAgentsStatus       EventType = "agents.status"
+	EvtWorkspaceFolders   EventType = "workspace.folders"
+	EvtGatewayUpdated     EventType = "gateway.updated"
 )
 
 // ---------------------------------------------------------------------------
@@ -92,10 +116,24 @@ const (
 	AgentNone       AgentType = "none"
 )
 
-// AgentConfig allows the CP to override default agent instructions.
+// AgentConfig allows the CP to override default agent instructions and
+// select how the agent is launched.
 type AgentConfig struct {
 	ClaudeMD string `json:"claude_md,omitempty"`
 	AgentsMD string `json:"agents_md,omitempty"`
+	// InstructionsPolicy is "keep", "overwrite" or "managed"; empty uses the
+	// gateway default.
+	InstructionsPolicy string `json:"instructions_policy,omitempty"`
+	// Model is passed to the agent's model flag.
+	Model string `json:"model,omitempty"`
+	// PermissionMode is "default", "plan", "accept-edits" or "bypass"; not
+	// every agent supports every mode.
+	PermissionMode string `json:"permission_mode,omitempty"`
+	// Resume continues the agent's last conversation in the workdir.
+	Resume bool `json:"resume,omitempty"`
+	// ExtraArgs are further agent flags; the gateway only accepts flags on
+	// its per-agent allowlist.
+	ExtraArgs []string `json:"extra_args,omitempty"`
 }
 
 // SessionCreate starts a new tmux/PTY session.
@@ -109,6 +147,33 @@ type SessionCreate struct {
 	Agent         AgentType         `json:"agent,omitempty"`
 	AgentConfig   *AgentConfig      `json:"agent_config,omitempty"`
 	Env           map[string]string `json:"env,omitempty"`
+	// Record overrides the gateway's record_sessions default.
+	Record *bool `json:"record,omitempty"`
+	// Policy overrides the gateway's session lifetime policy.
+	Policy *SessionPolicy `json:"policy,omitempty"`
+	// Limits caps the session's resources via cgroup v2.
+	Limits *SessionLimits `json:"limits,omitempty"`
+	// Backend is "tmux" or "native"; empty uses the gateway default.
+	Backend string `json:"backend,omitempty"`
+	// Profile names a saved profile providing agent, workdir, env and
+	// agent_config; fields set in the command override it.
+	Profile string `json:"profile,omitempty"`
+}
+
+// SessionLimits caps a session's resources. Omitted or zero fields mean no
+// limit.
+type SessionLimits struct {
+	CPUCores    float64 `json:"cpu_cores,omitempty"`
+	MemoryBytes int64   `json:"memory_bytes,omitempty"`
+	PIDs        int64   `json:"pids,omitempty"`
+}
+
+// SessionPolicy bounds a session's lifetime. Omitted fields keep the gateway
+// default; 0 disables a limit.
+type SessionPolicy struct {
+	IdleTimeoutSeconds *int64 `json:"idle_timeout_seconds,omitempty"`
+	MaxLifetimeSeconds *int64 `json:"max_lifetime_seconds,omitempty"`
+	EndOnAgentExit     *bool  `json:"end_on_agent_exit,omitempty"`
 }
 
 // SessionInput injects keystrokes into a tmux pane.
@@ -121,12 +186,43 @@ type SessionInput struct {
 	Data string `json:"data"`
 }
 
-// SessionResize resizes a tmux window.
+// SessionKeys sends tmux named keys to a session, e.g. "C-c", "Escape",
+// "S-Tab" or "PageUp". A key is a named key (Enter, Escape, Tab, BTab,
+// BSpace, Space, arrows, Home, End, PageUp/PageDown, Insert, Delete, F1-F12)
+// or a single printable ASCII character, optionally prefixed with C-, M- and
+// S- modifiers. Up to 64 keys per command; any other key fails the command.
+type SessionKeys struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id"`
+	Keys          []string    `json:"keys"`
+}
+
+// SessionPaste pastes text into a session through a tmux paste buffer, so
+// large content is not limited by command arguments. With Bracketed (the
+// default) the content is wrapped in bracketed-paste sequences when the
+// application in the pane enabled that mode. Content over 64 KiB is pasted
+// in chunks; a failed chunk fails the command with an error naming it.
+type SessionPaste struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id"`
+	// Data is base64-encoded text.
+	Data      string `json:"data"`
+	Bracketed *bool  `json:"bracketed,omitempty"`
+}
+
+// SessionResize reports the viewport of a client. The gateway sizes the tmux
+// window from the viewports of all clients per its resize policy; commands
+// without ClientID share one viewport.
 type SessionResize struct {
 	Type          CommandType `json:"type"`
 	SchemaVersion string      `json:"schema_version,omitempty"`
 	RequestID     string      `json:"request_id"`
 	SessionID     string      `json:"session_id"`
+	ClientID      string      `json:"client_id,omitempty"`
 	Cols          int         `json:"cols"`
 	Rows          int         `json:"rows"`
 }
@@ -139,7 +235,23 @@ type SessionEnd struct {
 	SessionID     string      `json:"session_id"`
 }
 
+// SessionRestart kills the session's pane process and starts the agent again
+// in the same session, keeping its ID and pane history. Agent replaces the
+// session's agent ("none" for a plain shell); empty keeps it. Resume passes
+// the agent its flags to continue the last conversation (claude --continue,
+// codex resume --last, gemini --resume latest, opencode --continue).
+type SessionRestart struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id"`
+	Agent         AgentType   `json:"agent,omitempty"`
+	Resume        bool        `json:"resume,omitempty"`
+}
+
 // SessionAck is forwarded client ack state for binary stream sequencing.
+// Seq is the last frame received; on reconnect the gateway replays the frames
+// after it instead of sending a snapshot.
 type SessionAck struct {
 	Type          CommandType `json:"type"`
 	SchemaVersion string      `json:"schema_version,omitempty"`
@@ -148,12 +260,126 @@ type SessionAck struct {
 	Seq           uint64      `json:"seq"`
 }
 
-// SessionSnapshotCmd requests a terminal snapshot.
+// SessionSubscribe registers a client watching the session output. The
+// gateway streams binary frames only for sessions with subscribers; the first
+// subscriber gets a replay after the last ack, or a snapshot.
+type SessionSubscribe struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id"`
+}
+
+// SessionUnsubscribe drops one subscriber registered by SessionSubscribe,
+// and the viewport of ClientID when set.
+type SessionUnsubscribe struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id"`
+	ClientID      string      `json:"client_id,omitempty"`
+}
+
+// SessionSnapshotCmd requests a terminal snapshot. Format "ansi" (the
+// default) returns the pane with its recent scrollback as ANSI text; "grid"
+// returns the visible pane as rows of cells.
 type SessionSnapshotCmd struct {
 	Type          CommandType `json:"type"`
 	SchemaVersion string      `json:"schema_version,omitempty"`
 	RequestID     string      `json:"request_id"`
 	SessionID     string      `json:"session_id"`
+	Format        string      `json:"format,omitempty"`
+}
+
+// SessionHistoryCmd requests scrollback lines Start through End (inclusive).
+// Line numbers follow tmux: 0 is the top line of the visible pane and
+// negative numbers go back into the scrollback. Without a range the 1000
+// most recent scrollback lines are returned. StripANSI returns plain text
+// instead of keeping colors as escape sequences.
+type SessionHistoryCmd struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id"`
+	Start         *int        `json:"start,omitempty"`
+	End           *int        `json:"end,omitempty"`
+	StripANSI     bool        `json:"strip_ansi,omitempty"`
+}
+
+// SessionSearchCmd searches a session's whole scrollback. Query is literal
+// text unless Regex is set (Go RE2 syntax); matching ignores case unless
+// CaseSensitive is set. Context lines (default 2, max 10) are returned
+// around each match, and MaxResults (default 100, max 1000) caps the
+// matches. The search is cancelled after 10 seconds.
+type SessionSearchCmd struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id"`
+	Query         string      `json:"query"`
+	Regex         bool        `json:"regex,omitempty"`
+	CaseSensitive bool        `json:"case_sensitive,omitempty"`
+	Context       *int        `json:"context,omitempty"`
+	MaxResults    int         `json:"max_results,omitempty"`
+}
+
+// SessionListCmd requests details of all active sessions.
+type SessionListCmd struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+}
+
+// SessionRecordingList requests stored recordings, optionally of one session.
+type SessionRecordingList struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	SessionID     string      `json:"session_id,omitempty"`
+}
+
+// SessionRecordingDownload requests a recording as file.content.* events.
+type SessionRecordingDownload struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	TransferID    string      `json:"transfer_id"`
+	RecordingID   string      `json:"recording_id"`
+}
+
+// SessionRecordingDelete deletes a finished recording.
+type SessionRecordingDelete struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	RecordingID   string      `json:"recording_id"`
+}
+
+// ProfileSave stores a session profile, replacing one of the same name.
+type ProfileSave struct {
+	Type          CommandType       `json:"type"`
+	SchemaVersion string            `json:"schema_version,omitempty"`
+	RequestID     string            `json:"request_id"`
+	Name          string            `json:"name"`
+	Agent         AgentType         `json:"agent,omitempty"`
+	Workdir       string            `json:"workdir,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	AgentConfig   *AgentConfig      `json:"agent_config,omitempty"`
+}
+
+// ProfileList requests the stored session profiles.
+type ProfileList struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+}
+
+// ProfileDelete deletes a session profile.
+type ProfileDelete struct {
+	Type          CommandType `json:"type"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	Name          string      `json:"name"`
 }
 
 // SSHAuthorize adds a public key to authorized_keys.
@@ -287,8 +513,27 @@ type SystemInfo struct {
 
 // ActiveSession summarises an active session for health reports.
 type ActiveSession struct {
-	SessionID      string    `json:"session_id"`
-	LastActivityAt time.Time `json:"last_activity_at"`
+	SessionID      string     `json:"session_id"`
+	Name           string     `json:"name,omitempty"`
+	Agent          string     `json:"agent,omitempty"`
+	Workdir        string     `json:"workdir,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	LastActivityAt time.Time  `json:"last_activity_at"`
+	// Usage is set when the session runs in its own cgroup.
+	Usage *SessionUsage `json:"usage,omitempty"`
+	// PollRateHz is the current pane capture rate; zero for stream capture.
+	PollRateHz float64 `json:"poll_rate_hz,omitempty"`
+	// Subscribers counts the clients subscribed to the session output.
+	Subscribers int `json:"subscribers,omitempty"`
+	// Backend is what runs the session, "tmux" or "native".
+	Backend string `json:"backend,omitempty"`
+}
+
+// SessionUsage is a session's resource usage read from its cgroup.
+type SessionUsage struct {
+	CPUUsageUsec uint64 `json:"cpu_usage_usec"`
+	MemoryBytes  uint64 `json:"memory_bytes"`
+	PIDs         uint64 `json:"pids"`
 }
 
 // GatewayHealth is sent on a 30s interval.
@@ -303,24 +548,97 @@ type GatewayHealth struct {
 	DiskUsedBytes  uint64          `json:"disk_used_bytes,omitempty"`
 	DiskTotalBytes uint64          `json:"disk_total_bytes,omitempty"`
 	UptimeSeconds  int64           `json:"uptime_seconds,omitempty"`
+	TmuxExecs      uint64          `json:"tmux_execs,omitempty"`
 	ActiveSessions []ActiveSession `json:"active_sessions"`
 }
 
+// InstructionFile reports how an agent instruction file was materialized.
+type InstructionFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Action is one of created, overwritten, merged, unchanged, skipped.
+	Action string `json:"action"`
+}
+
 // SessionStarted confirms a session was created.
 type SessionStarted struct {
+	Type             EventType         `json:"type"`
+	SchemaVersion    string            `json:"schema_version,omitempty"`
+	RequestID        string            `json:"request_id"`
+	SessionID        string            `json:"session_id"`
+	PID              int               `json:"pid,omitempty"`
+	InstructionFiles []InstructionFile `json:"instruction_files,omitempty"`
+	Recording        bool              `json:"recording,omitempty"`
+	// LimitsApplied is set when session.create carried limits; false means
+	// the gateway has no cgroup v2 delegation and the session runs
+	// unconfined.
+	LimitsApplied *bool `json:"limits_applied,omitempty"`
+}
+
+// SessionRestarted answers session.restart with the agent now running and
+// the process ID of the new pane process.
+type SessionRestarted struct {
 	Type          EventType `json:"type"`
 	SchemaVersion string    `json:"schema_version,omitempty"`
 	RequestID     string    `json:"request_id"`
 	SessionID     string    `json:"session_id"`
-	PID           int       `json:"pid,omitempty"`
+	Agent         AgentType `json:"agent,omitempty"`
+	PID           int       `json:"pid"`
 }
 
-// SessionEnded reports that a session has terminated.
+// SessionEnded reports that a session has terminated. ExitCode is the exit
+// status of the pane process (128+signal when killed by a signal) and is
+// omitted when it is unknown. Reason is one of "exited", "requested",
+// "idle_timeout", "max_lifetime" or "agent_exited".
 type SessionEnded struct {
 	Type          EventType `json:"type"`
 	SchemaVersion string    `json:"schema_version,omitempty"`
 	SessionID     string    `json:"session_id"`
-	ExitCode      int       `json:"exit_code,omitempty"`
+	ExitCode      *int      `json:"exit_code,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+// SessionExpiring warns that a session policy will end the session at
+// ExpiresAt. Input postpones an idle_timeout expiry.
+type SessionExpiring struct {
+	Type          EventType `json:"type"`
+	SchemaVersion string    `json:"schema_version,omitempty"`
+	SessionID     string    `json:"session_id"`
+	Reason        string    `json:"reason"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// SessionInputAck reports the terminal input frames written to a session.
+// Seq is the highest input seq written; Dropped counts frames lost since the
+// previous ack (skipped seqs or frames rejected by a full input queue).
+type SessionInputAck struct {
+	Type          EventType `json:"type"`
+	SchemaVersion string    `json:"schema_version,omitempty"`
+	SessionID     string    `json:"session_id"`
+	Seq           uint64    `json:"seq"`
+	Dropped       uint64    `json:"dropped,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// SessionResized reports the window size the resize policy settled on, after
+// a client viewport changed or went away. Policy is "latest-active",
+// "smallest", "largest" or a pinned COLSxROWS.
+type SessionResized struct {
+	Type          EventType `json:"type"`
+	SchemaVersion string    `json:"schema_version,omitempty"`
+	SessionID     string    `json:"session_id"`
+	Cols          int       `json:"cols"`
+	Rows          int       `json:"rows"`
+	Policy        string    `json:"policy"`
+}
+
+// SessionAgentExited reports that a session's agent exited while the fallback
+// shell keeps the session running.
+type SessionAgentExited struct {
+	Type          EventType `json:"type"`
+	SchemaVersion string    `json:"schema_version,omitempty"`
+	SessionID     string    `json:"session_id"`
+	ExitCode      int       `json:"exit_code"`
 }
 
 // SessionErrorEvent reports a session-level error.
@@ -331,7 +649,122 @@ type SessionErrorEvent struct {
 	Error         string    `json:"error"`
 }
 
-// SessionSnapshotEvent carries terminal content.
+// SessionInfo is the full per-session state reported by session.list. Cols,
+// Rows, PanePIDs and CurrentCommand are omitted when tmux could not be queried.
+type SessionInfo struct {
+	ActiveSession
+	Cols           int    `json:"cols,omitempty"`
+	Rows           int    `json:"rows,omitempty"`
+	PanePIDs       []int  `json:"pane_pids,omitempty"`
+	CurrentCommand string `json:"current_command,omitempty"`
+	Recovered      bool   `json:"recovered"`
+	Recording      bool   `json:"recording,omitempty"`
+}
+
+// SessionListEvent answers session.list.
+type SessionListEvent struct {
+	Type          EventType     `json:"type"`
+	SchemaVersion string        `json:"schema_version,omitempty"`
+	RequestID     string        `json:"request_id"`
+	Sessions      []SessionInfo `json:"sessions"`
+}
+
+// RecordingInfo describes one stored asciicast recording file. Long
+// recordings are split into numbered parts.
+type RecordingInfo struct {
+	RecordingID string    `json:"recording_id"`
+	SessionID   string    `json:"session_id"`
+	StartedAt   time.Time `json:"started_at"`
+	Part        int       `json:"part"`
+	Size        int64     `json:"size"`
+	Active      bool      `json:"active"`
+}
+
+// SessionRecordingListEvent answers session.recording.list.
+type SessionRecordingListEvent struct {
+	Type          EventType       `json:"type"`
+	SchemaVersion string          `json:"schema_version,omitempty"`
+	RequestID     string          `json:"request_id"`
+	Recordings    []RecordingInfo `json:"recordings"`
+}
+
+// Profile is a stored session profile.
+type Profile struct {
+	Name        string            `json:"name"`
+	Agent       AgentType         `json:"agent,omitempty"`
+	Workdir     string            `json:"workdir,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	AgentConfig *AgentConfig      `json:"agent_config,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// ProfileListEvent answers profile.list.
+type ProfileListEvent struct {
+	Type          EventType `json:"type"`
+	SchemaVersion string    `json:"schema_version,omitempty"`
+	RequestID     string    `json:"request_id"`
+	Profiles      []Profile `json:"profiles"`
+}
+
+// SessionHistoryEvent carries a page of scrollback. Start and End are the
+// lines actually returned, clamped to the scrollback, the visible pane and
+// at most 10000 lines; HistorySize is the number of scrollback lines above
+// the visible pane. Truncated is set when the oldest lines of the page were
+// dropped to stay below the payload size limit.
+type SessionHistoryEvent struct {
+	Type          EventType `json:"type"`
+	SchemaVersion string    `json:"schema_version,omitempty"`
+	RequestID     string    `json:"request_id"`
+	SessionID     string    `json:"session_id"`
+	Start         int       `json:"start"`
+	End           int       `json:"end"`
+	HistorySize   int       `json:"history_size"`
+	Content       string    `json:"content"`
+	Truncated     bool      `json:"truncated,omitempty"`
+}
+
+// SearchMatch is one matching line of a scrollback search. Line uses the
+// numbering of session.history.
+type SearchMatch struct {
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// SessionSearchEvent carries the matches of a scrollback search, oldest
+// first. Truncated is set when more lines matched than were returned.
+type SessionSearchEvent struct {
+	Type          EventType     `json:"type"`
+	SchemaVersion string        `json:"schema_version,omitempty"`
+	RequestID     string        `json:"request_id"`
+	SessionID     string        `json:"session_id"`
+	HistorySize   int           `json:"history_size"`
+	Matches       []SearchMatch `json:"matches"`
+	Truncated     bool          `json:"truncated,omitempty"`
+}
+
+// GridCell is one cell of a grid snapshot. FG and BG are omitted for the
+// default color, a palette index (0-255) or an "#rrggbb" string. A wide
+// character is followed by a continuation cell with an empty Char.
+type GridCell struct {
+	Char          string `json:"ch"`
+	FG            any    `json:"fg,omitempty"`
+	BG            any    `json:"bg,omitempty"`
+	Bold          bool   `json:"bold,omitempty"`
+	Dim           bool   `json:"dim,omitempty"`
+	Italic        bool   `json:"italic,omitempty"`
+	Underline     bool   `json:"underline,omitempty"`
+	Blink         bool   `json:"blink,omitempty"`
+	Inverse       bool   `json:"inverse,omitempty"`
+	Hidden        bool   `json:"hidden,omitempty"`
+	Strikethrough bool   `json:"strikethrough,omitempty"`
+	Wide          bool   `json:"wide,omitempty"`
+}
+
+// SessionSnapshotEvent carries terminal content. In the grid format Content
+// is the visible pane as plain text and Grid holds one row of cells per pane
+// line, without trailing blank cells.
 type SessionSnapshotEvent struct {
 	Type          EventType `json:"type"`
 	SchemaVersion string    `json:"schema_version,omitempty"`
@@ -344,6 +777,11 @@ type SessionSnapshotEvent struct {
 	CursorY       int       `json:"cursor_y,omitempty"`
 	CursorVisible *bool     `json:"cursor_visible,omitempty"`
 	AlternateOn   *bool     `json:"alternate_on,omitempty"`
+	// ReplayOverrun is set when unacked output no longer fit the replay
+	// buffer and this snapshot replaces the replay.
+	ReplayOverrun bool         `json:"replay_overrun,omitempty"`
+	Format        string       `json:"format,omitempty"`
+	Grid          [][]GridCell `json:"grid,omitempty"`
 }
 
 // SSHKey describes an entry in authorized_keys.
@@ -440,23 +878,49 @@ type GatewayUpdateFailed struct {
 }
 
 // ---------------------------------------------------------------------------
-// Binary frame encoding (terminal output)
+// Binary frame encoding (terminal output and input)
 // ---------------------------------------------------------------------------
 
 // FrameKindTerminalOutput is the kind byte for PTY output frames.
 const FrameKindTerminalOutput byte = 0x01
 
+// FrameKindTerminalInput is the kind byte for terminal input frames (CP →
+// gateway). Seq 0 starts a new input stream; each following frame carries the
+// previous seq plus one. The gateway ignores repeated seqs and reports
+// skipped ones as dropped in session.input_ack.
+const FrameKindTerminalInput byte = 0x02
+
 // EncodeTerminalFrame builds a binary frame for PTY output.
 //
 // Layout: [kind:1][session_id_len:1][session_id:N][seq:8][payload:M]
 func EncodeTerminalFrame(sessionID string, seq uint64, payload []byte) ([]byte, error) {
+	return encodeFrame(FrameKindTerminalOutput, sessionID, seq, payload)
+}
+
+// DecodeTerminalFrame parses a binary terminal output frame.
+func DecodeTerminalFrame(buf []byte) (sessionID string, seq uint64, payload []byte, err error) {
+	return decodeFrame(FrameKindTerminalOutput, buf)
+}
+
+// EncodeTerminalInputFrame builds a binary frame for terminal input. The
+// layout is the same as for output frames.
+func EncodeTerminalInputFrame(sessionID string, seq uint64, payload []byte) ([]byte, error) {
+	return encodeFrame(FrameKindTerminalInput, sessionID, seq, payload)
+}
+
+// DecodeTerminalInputFrame parses a binary terminal input frame.
+func DecodeTerminalInputFrame(buf []byte) (sessionID string, seq uint64, payload []byte, err error) {
+	return decodeFrame(FrameKindTerminalInput, buf)
+}
+
+func encodeFrame(kind byte, sessionID string, seq uint64, payload []byte) ([]byte, error) {
 	idBytes := []byte(sessionID)
 	if len(idBytes) > 255 {
 		return nil, fmt.Errorf("session_id too long: %d bytes", len(idBytes))
 	}
 	buf := make([]byte, 1+1+len(idBytes)+8+len(payload))
 	offset := 0
-	buf[offset] = FrameKindTerminalOutput
+	buf[offset] = kind
 	offset++
 	buf[offset] = byte(len(idBytes))
 	offset++
@@ -468,12 +932,11 @@ func EncodeTerminalFrame(sessionID string, seq uint64, payload []byte) ([]byte,
 	return buf, nil
 }
 
-// DecodeTerminalFrame parses a binary terminal output frame.
-func DecodeTerminalFrame(buf []byte) (sessionID string, seq uint64, payload []byte, err error) {
+func decodeFrame(kind byte, buf []byte) (sessionID string, seq uint64, payload []byte, err error) {
 	if len(buf) < 2 {
 		return "", 0, nil, fmt.Errorf("frame too short")
 	}
-	if buf[0] != FrameKindTerminalOutput {
+	if buf[0] != kind {
 		return "", 0, nil, fmt.Errorf("unexpected frame kind: %d", buf[0])
 	}
 	idLen := int(buf[1])
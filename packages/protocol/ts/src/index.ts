@@ -39,14 +39,49 @@ export interface SessionCreate extends BaseCommand {
   session_id: string;
   name: string;
   workdir: string;
-  agent?: "claude-code" | "codex" | "gemini" | "opencode" | "none";
-  agent_config?: {
-    claude_md?: string;
-    agents_md?: string;
-  };
+  agent?: AgentType;
+  agent_config?: AgentConfig;
   env?: Record<string, string>;
+  /** Record the session to an asciicast v2 file; defaults to the gateway setting. */
+  record?: boolean;
+  /** Lifetime policy; omitted fields keep the gateway default, 0 disables a limit. */
+  policy?: {
+    idle_timeout_seconds?: number;
+    max_lifetime_seconds?: number;
+    end_on_agent_exit?: boolean;
+  };
+  /** cgroup v2 resource limits; omitted or 0 means no limit. */
+  limits?: {
+    cpu_cores?: number;
+    memory_bytes?: number;
+    pids?: number;
+  };
+  /** "tmux" (survives gateway restarts) or "native" (gateway-owned PTY); omitted uses the gateway default. */
+  backend?: SessionBackend;
+  /** Saved profile providing agent, workdir, env and agent_config; fields set here override it. */
+  profile?: string;
 }
 
+export type AgentType = "claude-code" | "codex" | "gemini" | "opencode" | "none";
+
+export interface AgentConfig {
+  claude_md?: string;
+  agents_md?: string;
+  instructions_policy?: "keep" | "overwrite" | "managed";
+  /** Passed to the agent's model flag. */
+  model?: string;
+  /** Not every agent supports every mode. */
+  permission_mode?: PermissionMode;
+  /** Continue the agent's last conversation in the workdir. */
+  resume?: boolean;
+  /** Further agent flags; only flags on the gateway's per-agent allowlist are accepted. */
+  extra_args?: string[];
+}
+
+export type SessionBackend = "tmux" | "native";
+
+export type PermissionMode = "default" | "plan" | "accept-edits" | "bypass";
+
 export interface SessionInput extends BaseCommand {
   type: "session.input";
   session_id: string;
@@ -54,9 +89,38 @@ export interface SessionInput extends BaseCommand {
   data: string;
 }
 
+/**
+ * Sends tmux named keys, e.g. "C-c", "Escape", "S-Tab" or "PageUp". Keys
+ * outside the gateway's allowlist fail the whole command.
+ */
+export interface SessionKeys extends BaseCommand {
+  type: "session.keys";
+  session_id: string;
+  keys: string[];
+}
+
+/**
+ * Pastes text through a tmux paste buffer; content over 64 KiB is pasted in
+ * chunks.
+ */
+export interface SessionPaste extends BaseCommand {
+  type: "session.paste";
+  session_id: string;
+  /** Base64-encoded text */
+  data: string;
+  /** Wrap in bracketed-paste sequences when the pane enabled that mode (default true) */
+  bracketed?: boolean;
+}
+
+/**
+ * Reports a client viewport. The gateway sizes the window from all client
+ * viewports per its resize policy and answers with session.resized.
+ */
 export interface SessionResize extends BaseCommand {
   type: "session.resize";
   session_id: string;
+  /** Set by the control plane per browser connection. */
+  client_id?: string;
   cols: number;
   rows: number;
 }
@@ -66,15 +130,109 @@ export interface SessionEnd extends BaseCommand {
   session_id: string;
 }
 
+/** Starts the agent again in the same session, keeping its ID and pane history. */
+export interface SessionRestart extends BaseCommand {
+  type: "session.restart";
+  session_id: string;
+  /** Agent to start instead; omitted keeps the session's agent. */
+  agent?: AgentType;
+  /** Pass the agent its flags to continue the last conversation. */
+  resume?: boolean;
+}
+
 export interface SessionAck extends BaseCommand {
   type: "session.ack";
   session_id: string;
   seq: number;
 }
 
+/**
+ * Registers a client watching the session output. The gateway streams
+ * binary frames only for sessions with subscribers.
+ */
+export interface SessionSubscribe extends BaseCommand {
+  type: "session.subscribe";
+  session_id: string;
+}
+
+export interface SessionUnsubscribe extends BaseCommand {
+  type: "session.unsubscribe";
+  session_id: string;
+  /** Client whose viewport is dropped. */
+  client_id?: string;
+}
+
 export interface SessionSnapshot extends BaseCommand {
   type: "session.snapshot";
   session_id: string;
+  /** "ansi" (default) returns ANSI text; "grid" returns the visible pane as cells. */
+  format?: "ansi" | "grid";
+}
+
+/**
+ * Pages through scrollback. Line numbers follow tmux: 0 is the top line of
+ * the visible pane, negative numbers go back into the scrollback.
+ */
+export interface SessionHistory extends BaseCommand {
+  type: "session.history";
+  session_id: string;
+  /** First line (default end - 999) */
+  start?: number;
+  /** Last line, inclusive (default -1, the newest scrollback line) */
+  end?: number;
+  strip_ansi?: boolean;
+}
+
+/** Searches the whole scrollback of a session; cancelled after 10 seconds. */
+export interface SessionSearch extends BaseCommand {
+  type: "session.search";
+  session_id: string;
+  query: string;
+  /** Treat query as a regular expression (RE2 syntax) */
+  regex?: boolean;
+  case_sensitive?: boolean;
+  /** Lines around each match (default 2, max 10) */
+  context?: number;
+  /** Default 100, max 1000 */
+  max_results?: number;
+}
+
+export interface SessionList extends BaseCommand {
+  type: "session.list";
+}
+
+export interface SessionRecordingList extends BaseCommand {
+  type: "session.recording.list";
+  session_id?: string;
+}
+
+export interface SessionRecordingDownload extends BaseCommand {
+  type: "session.recording.download";
+  transfer_id: string;
+  recording_id: string;
+}
+
+export interface SessionRecordingDelete extends BaseCommand {
+  type: "session.recording.delete";
+  recording_id: string;
+}
+
+export interface ProfileSave extends BaseCommand {
+  type: "profile.save";
+  name: string;
+  agent?: AgentType;
+  workdir?: string;
+  env?: Record<string, string>;
+  agent_config?: AgentConfig;
+}
+
+export interface ProfileList extends BaseCommand {
+  type: "profile.list";
+}
+
+export interface ProfileDelete extends BaseCommand {
+  type: "profile.delete";
+  name: string;
 }
 
 export interface SSHAuthorize extends BaseCommand {
@@ -149,10 +307,24 @@ export interface GatewayUpdate extends BaseCommand {
 export type Command =
   | SessionCreate
   | SessionInput
+  | SessionPaste
+  | SessionKeys
   | SessionResize
   | SessionEnd
+  | SessionRestart
   | SessionAck
+  | SessionSubscribe
+  | SessionUnsubscribe
   | SessionSnapshot
+  | SessionHistory
+  | SessionSearch
+  | SessionList
+  | SessionRecordingList
+  | SessionRecordingDownload
+  | SessionRecordingDelete
+  | ProfileSave
+  | ProfileList
+  | ProfileDelete
   | SSHAuthorize
   | SSHRevoke
   | SSHList
@@ -190,7 +362,31 @@ export interface SystemInfo {
 
 export interface ActiveSession {
   session_id: string;
+  name?: string;
+  agent?: string;
+  workdir?: string;
+  created_at?: string;
   last_activity_at: string;
+  /** Resource usage from the session cgroup, when the gateway has one. */
+  usage?: {
+    cpu_usage_usec: number;
+    memory_bytes: number;
+    pids: number;
+  };
+  /** Current pane capture rate; omitted for stream capture. */
+  poll_rate_hz?: number;
+  /** Clients subscribed to the session output; omitted when none. */
+  subscribers?: number;
+  backend?: SessionBackend;
+}
+
+export interface SessionInfo extends ActiveSession {
+  cols?: number;
+  rows?: number;
+  pane_pids?: number[];
+  current_command?: string;
+  recovered: boolean;
+  recording?: boolean;
 }
 
 export interface GatewayHealth extends BaseEvent {
@@ -203,20 +399,80 @@ export interface GatewayHealth extends BaseEvent {
   disk_used_bytes?: number;
   disk_total_bytes?: number;
   uptime_seconds?: number;
+  /** tmux commands run since the gateway started. */
+  tmux_execs?: number;
   active_sessions: ActiveSession[];
 }
 
+export interface InstructionFile {
+  name: string;
+  path: string;
+  action: "created" | "overwritten" | "merged" | "unchanged" | "skipped";
+}
+
 export interface SessionStarted extends BaseEvent {
   type: "session.started";
   request_id: string;
   session_id: string;
   pid?: number;
+  instruction_files?: InstructionFile[];
+  recording?: boolean;
+  /** Set when limits were requested; false when they could not be enforced. */
+  limits_applied?: boolean;
 }
 
+export interface SessionRestarted extends BaseEvent {
+  type: "session.restarted";
+  request_id: string;
+  session_id: string;
+  agent?: string;
+  /** Process ID of the new pane process. */
+  pid: number;
+}
+
+export type EndReason = "exited" | "requested" | "idle_timeout" | "max_lifetime" | "agent_exited";
+
 export interface SessionEnded extends BaseEvent {
   type: "session.ended";
   session_id: string;
   exit_code?: number;
+  reason?: EndReason;
+}
+
+/** A session policy will end the session at expires_at; activity postpones idle_timeout. */
+export interface SessionExpiring extends BaseEvent {
+  type: "session.expiring";
+  session_id: string;
+  reason: EndReason;
+  expires_at: string;
+}
+
+/**
+ * Terminal input frames up to seq were written. dropped counts input frames
+ * lost since the previous ack (skipped seqs or a full input queue).
+ */
+export interface SessionInputAck extends BaseEvent {
+  type: "session.input_ack";
+  session_id: string;
+  seq: number;
+  dropped?: number;
+  error?: string;
+}
+
+/** The window size the resize policy settled on. */
+export interface SessionResized extends BaseEvent {
+  type: "session.resized";
+  session_id: string;
+  cols: number;
+  rows: number;
+  /** "latest-active", "smallest", "largest", or a pinned "COLSxROWS". */
+  policy: string;
+}
+
+export interface SessionAgentExited extends BaseEvent {
+  type: "session.agent_exited";
+  session_id: string;
+  exit_code: number;
 }
 
 export interface SessionError extends BaseEvent {
@@ -225,6 +481,26 @@ export interface SessionError extends BaseEvent {
   error: string;
 }
 
+/**
+ * A cell of a grid snapshot. Colors are a palette index or "#rrggbb" and are
+ * omitted for the default color. A wide character is followed by a
+ * continuation cell with an empty `ch`.
+ */
+export interface GridCell {
+  ch: string;
+  fg?: number | string;
+  bg?: number | string;
+  bold?: boolean;
+  dim?: boolean;
+  italic?: boolean;
+  underline?: boolean;
+  blink?: boolean;
+  inverse?: boolean;
+  hidden?: boolean;
+  strikethrough?: boolean;
+  wide?: boolean;
+}
+
 export interface SessionSnapshotEvent extends BaseEvent {
   type: "session.snapshot";
   request_id?: string;
@@ -236,6 +512,80 @@ export interface SessionSnapshotEvent extends BaseEvent {
   cursor_y?: number;
   cursor_visible?: boolean;
   alternate_on?: boolean;
+  /** Set when a reconnect could not replay unacked output and fell back to this snapshot. */
+  replay_overrun?: boolean;
+  /** "grid" when requested in the grid format; content is then plain text. */
+  format?: "ansi" | "grid";
+  /** One row of cells per visible pane line, without trailing blank cells. */
+  grid?: GridCell[][];
+}
+
+/** A page of scrollback; the oldest available line is -history_size. */
+export interface SessionHistoryEvent extends BaseEvent {
+  type: "session.history";
+  request_id: string;
+  session_id: string;
+  start: number;
+  end: number;
+  history_size: number;
+  content: string;
+  /** Set when the oldest lines were dropped to fit the payload limit. */
+  truncated?: boolean;
+}
+
+export interface SearchMatch {
+  /** Line number as used by session.history */
+  line: number;
+  text: string;
+  before?: string[];
+  after?: string[];
+}
+
+export interface SessionSearchEvent extends BaseEvent {
+  type: "session.search";
+  request_id: string;
+  session_id: string;
+  history_size: number;
+  matches: SearchMatch[];
+  /** Set when more lines matched than were returned. */
+  truncated?: boolean;
+}
+
+export interface SessionListEvent extends BaseEvent {
+  type: "session.list";
+  request_id: string;
+  sessions: SessionInfo[];
+}
+
+export interface RecordingInfo {
+  recording_id: string;
+  session_id: string;
+  started_at: string;
+  /** Long recordings continue in numbered parts, each a complete asciicast v2 file. */
+  part: number;
+  size: number;
+  active: boolean;
+}
+
+export interface SessionRecordingListEvent extends BaseEvent {
+  type: "session.recording.list";
+  request_id: string;
+  recordings: RecordingInfo[];
+}
+
+export interface Profile {
+  name: string;
+  agent?: AgentType;
+  workdir?: string;
+  env?: Record<string, string>;
+  agent_config?: AgentConfig;
+  updated_at: string;
+}
+
+export interface ProfileListEvent extends BaseEvent {
+  type: "profile.list";
+  request_id: string;
+  profiles: Profile[];
 }
 
 export interface SSHKey {
@@ -316,9 +666,19 @@ export type Event =
   | GatewayHello
   | GatewayHealth
   | SessionStarted
+  | SessionRestarted
   | SessionEnded
+  | SessionAgentExited
+  | SessionExpiring
+  | SessionInputAck
+  | SessionResized
   | SessionError
   | SessionSnapshotEvent
+  | SessionHistoryEvent
+  | SessionSearchEvent
+  | SessionListEvent
+  | SessionRecordingListEvent
+  | ProfileListEvent
   | SSHKeyList
   | AgentsStatus
   | WorkspaceFolders
@@ -330,9 +690,12 @@ export type Event =
   | GatewayUpdateFailed;
 
 // ---------------------------------------------------------------------------
-// Binary frames (terminal output)
+// Binary frames (terminal output and input)
 // ---------------------------------------------------------------------------
 
+export const FRAME_KIND_TERMINAL_OUTPUT = 0x01;
+export const FRAME_KIND_TERMINAL_INPUT = 0x02;
+
 /**
  * Encodes a terminal output binary frame.
  * Layout: [kind:1][session_id_len:1][session_id:N][seq:8][payload:M]
@@ -341,12 +704,33 @@ export function encodeTerminalFrame(
   sessionId: string,
   seq: bigint,
   payload: Uint8Array
+): Uint8Array {
+  return encodeFrame(FRAME_KIND_TERMINAL_OUTPUT, sessionId, seq, payload);
+}
+
+/**
+ * Encodes a terminal input binary frame (CP → gateway). seq 0 starts a new
+ * input stream; each following frame carries the previous seq plus one.
+ */
+export function encodeTerminalInputFrame(
+  sessionId: string,
+  seq: bigint,
+  payload: Uint8Array
+): Uint8Array {
+  return encodeFrame(FRAME_KIND_TERMINAL_INPUT, sessionId, seq, payload);
+}
+
+function encodeFrame(
+  kind: number,
+  sessionId: string,
+  seq: bigint,
+  payload: Uint8Array
 ): Uint8Array {
   const sessionIdBytes = new TextEncoder().encode(sessionId);
   const buf = new Uint8Array(1 + 1 + sessionIdBytes.length + 8 + payload.length);
   const view = new DataView(buf.buffer);
   let offset = 0;
-  buf[offset++] = 0x01; // kind: terminal_output
+  buf[offset++] = kind;
   buf[offset++] = sessionIdBytes.length;
   buf.set(sessionIdBytes, offset);
   offset += sessionIdBytes.length;
@@ -357,7 +741,7 @@ export function encodeTerminalFrame(
 }
 
 /**
- * Decodes a terminal output binary frame.
+ * Decodes a terminal output or input binary frame.
  */
 export function decodeTerminalFrame(buf: Uint8Array): {
   kind: number;
@@ -77,6 +77,7 @@ export function createTerminalHandle(opts: UseTerminalOptions): TerminalHandle {
   let lastRows = 0;
   let pendingAckSessionId: string | null = null;
   let pendingAckSeq: number | null = null;
+  let lastFrameSeq: number | null = null;
   let reconnectAttempts = 0;
   let sessionEnded = false;
   let disposed = false;
@@ -352,8 +353,12 @@ export function createTerminalHandle(opts: UseTerminalOptions): TerminalHandle {
         if (
           msg.type === "session.snapshot" &&
           msg.session_id === sessionId &&
+          msg.format !== "grid" &&
           typeof msg.content === "string"
         ) {
+          // A snapshot restarts the stream; seq may also restart after a
+          // gateway restart.
+          lastFrameSeq = null;
           const snapshotCols = typeof msg.cols === "number" ? msg.cols : null;
           const snapshotRows =
             typeof msg.rows === "number" && msg.rows > 0 ? msg.rows : null;
@@ -446,6 +451,16 @@ export function createTerminalHandle(opts: UseTerminalOptions): TerminalHandle {
           return;
         }
 
+        if (msg.type === "session.resized" && msg.session_id === sessionId) {
+          // Another client's viewport (or a pinned size) decides the window;
+          // redraw from a snapshot at the size the pane now has.
+          debugLog("recv-resized", { cols: msg.cols, rows: msg.rows, policy: msg.policy });
+          if (msg.cols !== term.cols || msg.rows !== term.rows) {
+            schedulePostResizeSnapshot("session-resized");
+          }
+          return;
+        }
+
         if (msg.type === "session.error" && msg.session_id === sessionId) {
           term.writeln(`\r\n[session error] ${msg.error ?? "unknown"}`);
           onSessionError?.(sessionId, String(msg.error ?? "unknown"));
@@ -467,14 +482,19 @@ export function createTerminalHandle(opts: UseTerminalOptions): TerminalHandle {
         const frame = decodeTerminalFrame(buf);
         if (!frame || frame.kind !== 0x01) return;
         if (frame.sessionId !== sessionId) return;
+        // Gateway replay after a reconnect can resend frames received before
+        // the last ack was flushed.
+        const frameSeq = Number(frame.seq);
+        if (lastFrameSeq !== null && frameSeq <= lastFrameSeq) return;
+        lastFrameSeq = frameSeq;
 
         if (awaitingInitialSnapshot) {
-          queueAck(frame.sessionId, Number(frame.seq));
+          queueAck(frame.sessionId, frameSeq);
           return;
         }
         const text = new TextDecoder().decode(frame.payload);
         term.write(text);
-        queueAck(frame.sessionId, Number(frame.seq));
+        queueAck(frame.sessionId, frameSeq);
       }
     });
 